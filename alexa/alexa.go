@@ -0,0 +1,242 @@
+// 2017.09.08 rjj: Alexa Skills Kit custom skill requests and responses.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package alexa adapts Alexa custom skill requests to the intent handlers
+// shared with the API.AI webhook.
+//
+// Slot names can't contain hyphens, so a slot given_name is the intent
+// parameter given-name. The conversational contexts that API.AI keeps for us
+// are kept in the session attributes instead, with the same lifespans.
+//
+// See https://developer.amazon.com/docs/custom-skills/request-and-response-json-reference.html
+package alexa
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rjj-work/yum-contacts/intent"
+)
+
+// Version of the response format.
+const Version = "1.0"
+
+// MaxTimestampSkew is how far the timestamp of a request may be from now,
+// older requests could be replayed.
+const MaxTimestampSkew = 150 * time.Second
+
+// Request types.
+const (
+	LaunchRequest       = "LaunchRequest"
+	IntentRequest       = "IntentRequest"
+	SessionEndedRequest = "SessionEndedRequest"
+)
+
+// Built in intents the skill handles itself.
+const (
+	HelpIntent     = "AMAZON.HelpIntent"
+	StopIntent     = "AMAZON.StopIntent"
+	CancelIntent   = "AMAZON.CancelIntent"
+	FallbackIntent = "AMAZON.FallbackIntent"
+)
+
+// Errors returned by RequestEnvelope.Verify.
+var (
+	ErrTimestamp   = errors.New("alexa: request timestamp missing or too far from now")
+	ErrApplication = errors.New("alexa: request is for another skill")
+)
+
+// RequestEnvelope is the body Alexa posts to the skill's endpoint.
+type RequestEnvelope struct {
+	Version string  `json:"version"`
+	Session Session `json:"session"`
+	Context struct {
+		System struct {
+			Application Application `json:"application"`
+			User        User        `json:"user"`
+		} `json:"System"`
+	} `json:"context"`
+	Request Request `json:"request"`
+}
+
+// Session is the conversation, only LaunchRequest and IntentRequest have one.
+type Session struct {
+	New         bool        `json:"new"`
+	SessionID   string      `json:"sessionId"`
+	Application Application `json:"application"`
+	Attributes  Attributes  `json:"attributes"`
+	User        User        `json:"user"`
+}
+
+// Application identifies the skill.
+type Application struct {
+	ApplicationID string `json:"applicationId"`
+}
+
+// User is the Amazon account, with the access token once they linked it.
+type User struct {
+	UserID      string `json:"userId"`
+	AccessToken string `json:"accessToken,omitempty"`
+}
+
+// Request is what the user did: open the skill, say an intent or leave.
+type Request struct {
+	Type      string    `json:"type"`
+	RequestID string    `json:"requestId"`
+	Timestamp time.Time `json:"timestamp"`
+	Locale    string    `json:"locale"`
+	Intent    Intent    `json:"intent"`
+	// Reason and Error say why a session ended.
+	Reason string `json:"reason,omitempty"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+// Intent is the intent the user said, with its slot values.
+type Intent struct {
+	Name  string          `json:"name"`
+	Slots map[string]Slot `json:"slots,omitempty"`
+}
+
+// Slot is one slot of an intent, Value is empty when the user didn't fill it.
+type Slot struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// Error is why a session ended with Reason ERROR.
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Attributes are the session attributes, returned with every response and
+// sent back with the next request of the session.
+type Attributes struct {
+	Contexts []Context `json:"contexts,omitempty"`
+}
+
+// Context is an intent.Context kept in the session attributes.
+type Context struct {
+	Name       string            `json:"name"`
+	Lifespan   int               `json:"lifespan"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// ResponseEnvelope is the skill's answer.
+type ResponseEnvelope struct {
+	Version           string      `json:"version"`
+	SessionAttributes *Attributes `json:"sessionAttributes,omitempty"`
+	Response          Response    `json:"response"`
+}
+
+// Response is what Alexa says, and whether the session ends.
+type Response struct {
+	OutputSpeech     *OutputSpeech `json:"outputSpeech,omitempty"`
+	ShouldEndSession bool          `json:"shouldEndSession"`
+}
+
+// OutputSpeech is PlainText or SSML.
+type OutputSpeech struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	SSML string `json:"ssml,omitempty"`
+}
+
+// ApplicationID returns the skill the request is for.
+func (e *RequestEnvelope) ApplicationID() string {
+	if id := e.Context.System.Application.ApplicationID; id != "" {
+		return id
+	}
+	return e.Session.Application.ApplicationID
+}
+
+// AccessToken returns the token of the user's linked account, "" if they
+// have not linked it.
+func (e *RequestEnvelope) AccessToken() string {
+	if t := e.Context.System.User.AccessToken; t != "" {
+		return t
+	}
+	return e.Session.User.AccessToken
+}
+
+// Verify checks the request timestamp is within MaxTimestampSkew of now and
+// that the request is for one of skillIDs, none when it is empty. The
+// signature of the request is checked by Config.Verify.
+func (e *RequestEnvelope) Verify(now time.Time, skillIDs []string) error {
+	if e.Request.Timestamp.IsZero() {
+		return ErrTimestamp
+	}
+	if d := now.Sub(e.Request.Timestamp); d > MaxTimestampSkew || d < -MaxTimestampSkew {
+		return ErrTimestamp
+	}
+	for _, id := range skillIDs {
+		if id == e.ApplicationID() {
+			return nil
+		}
+	}
+	return ErrApplication
+}
+
+// IntentRequest converts the request to the platform neutral intent.Request,
+// with the contexts of the session attributes. A LaunchRequest has no intent.
+func (e *RequestEnvelope) IntentRequest() *intent.Request {
+	req := &intent.Request{
+		Intent:    e.Request.Intent.Name,
+		Params:    map[string]string{},
+		Lang:      e.Request.Locale,
+		SessionID: e.Session.SessionID,
+		Raw:       e,
+	}
+	for name, s := range e.Request.Intent.Slots {
+		if s.Value != "" {
+			req.Params[strings.Replace(name, "_", "-", -1)] = s.Value
+		}
+	}
+	for _, c := range e.Session.Attributes.Contexts {
+		ic := intent.Context{Name: c.Name, Lifespan: c.Lifespan, Parameters: map[string]string{}}
+		for k, v := range c.Parameters {
+			ic.Parameters[k] = v
+		}
+		req.Contexts = append(req.Contexts, ic)
+	}
+	return req
+}
+
+// NewResponse converts resp to an Alexa response to e, speaking resp.Speech.
+// Like API.AI, the contexts of e count one more turn and those of resp
+// replace them, a lifespan of 0 ending one.
+func (e *RequestEnvelope) NewResponse(resp *intent.Response, end bool) *ResponseEnvelope {
+	active := map[string]Context{}
+	var names []string
+	keep := func(c Context) {
+		name := strings.ToLower(c.Name)
+		if _, ok := active[name]; !ok {
+			names = append(names, name)
+		}
+		active[name] = c
+	}
+	for _, c := range e.Session.Attributes.Contexts {
+		if c.Lifespan--; c.Lifespan > 0 {
+			keep(c)
+		}
+	}
+	for _, c := range resp.Contexts {
+		keep(Context{Name: c.Name, Lifespan: c.Lifespan, Parameters: c.Parameters})
+	}
+
+	attrs := &Attributes{}
+	for _, name := range names {
+		if c := active[name]; c.Lifespan > 0 {
+			attrs.Contexts = append(attrs.Contexts, c)
+		}
+	}
+
+	out := &ResponseEnvelope{Version: Version, SessionAttributes: attrs}
+	out.Response.ShouldEndSession = end
+	if resp.Speech != "" {
+		out.Response.OutputSpeech = &OutputSpeech{Type: "SSML", SSML: resp.SSMLSpeech()}
+	}
+	return out
+}
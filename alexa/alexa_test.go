@@ -0,0 +1,131 @@
+// 2017.09.08 rjj: Tests for the Alexa adapter.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package alexa
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rjj-work/yum-contacts/intent"
+)
+
+const intentRequestJSON = `{
+  "version": "1.0",
+  "session": {
+    "new": false,
+    "sessionId": "amzn1.echo-api.session.1",
+    "application": {"applicationId": "amzn1.ask.skill.contacts"},
+    "attributes": {"contexts": [
+      {"name": "current_contact", "lifespan": 5, "parameters": {"id": "1"}},
+      {"name": "contact_draft", "lifespan": 1, "parameters": {"given-name": "Ralph"}}
+    ]},
+    "user": {"userId": "amzn1.ask.account.1", "accessToken": "token"}
+  },
+  "context": {"System": {
+    "application": {"applicationId": "amzn1.ask.skill.contacts"},
+    "user": {"userId": "amzn1.ask.account.1", "accessToken": "token"}
+  }},
+  "request": {
+    "type": "IntentRequest",
+    "requestId": "amzn1.echo-api.request.1",
+    "timestamp": "2017-09-01T12:00:00Z",
+    "locale": "en-US",
+    "intent": {"name": "find_contact", "slots": {
+      "given_name": {"name": "given_name", "value": "Homer"},
+      "last_name": {"name": "last_name"}
+    }}
+  }
+}`
+
+var now = time.Date(2017, time.September, 1, 12, 1, 0, 0, time.UTC)
+
+func decode(t *testing.T) *RequestEnvelope {
+	var e RequestEnvelope
+	if err := json.Unmarshal([]byte(intentRequestJSON), &e); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestVerify(t *testing.T) {
+	e := decode(t)
+	skills := []string{"amzn1.ask.skill.contacts"}
+	if err := e.Verify(now, skills); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+	if err := e.Verify(now, []string{"amzn1.ask.skill.other", "amzn1.ask.skill.contacts"}); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+	if err := e.Verify(now, []string{"amzn1.ask.skill.other"}); err != ErrApplication {
+		t.Errorf("got %v, want %v", err, ErrApplication)
+	}
+	if err := e.Verify(now, nil); err != ErrApplication {
+		t.Errorf("no skills: got %v, want %v", err, ErrApplication)
+	}
+	for _, late := range []time.Time{now.Add(2 * time.Minute), now.Add(-4 * time.Minute)} {
+		if err := e.Verify(late, skills); err != ErrTimestamp {
+			t.Errorf("at %v: got %v, want %v", late, err, ErrTimestamp)
+		}
+	}
+	e.Request.Timestamp = time.Time{}
+	if err := e.Verify(now, skills); err != ErrTimestamp {
+		t.Errorf("no timestamp: got %v, want %v", err, ErrTimestamp)
+	}
+}
+
+func TestIntentRequest(t *testing.T) {
+	e := decode(t)
+	req := e.IntentRequest()
+	if got, want := req.Intent, "find_contact"; got != want {
+		t.Errorf("got intent %q, want %q", got, want)
+	}
+	if got, want := req.Param("given-name"), "Homer"; got != want {
+		t.Errorf("got given-name %q, want %q", got, want)
+	}
+	if _, ok := req.Params["last-name"]; ok {
+		t.Error("got an empty last-name parameter, want none")
+	}
+	if got, want := req.Lang, "en-US"; got != want {
+		t.Errorf("got lang %q, want %q", got, want)
+	}
+	if c := req.Context("current_contact"); c == nil || c.Parameters["id"] != "1" {
+		t.Errorf("got current_contact %+v, want id 1", c)
+	}
+	if got, want := e.AccessToken(), "token"; got != want {
+		t.Errorf("got token %q, want %q", got, want)
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+	e := decode(t)
+	resp := &intent.Response{Speech: "Found Homer & Marge"}
+	resp.SetContext(intent.Context{Name: "select_contact", Lifespan: 2, Parameters: map[string]string{"ids": "1,2"}})
+	resp.SetContext(intent.Context{Name: "current_contact", Lifespan: 0})
+
+	out := e.NewResponse(resp, false)
+	if got, want := out.Response.OutputSpeech.SSML, "<speak>Found Homer &amp; Marge</speak>"; got != want {
+		t.Errorf("got ssml %q, want %q", got, want)
+	}
+	if out.Response.ShouldEndSession {
+		t.Error("got the session ended, want it open")
+	}
+	// current_contact ended, contact_draft ran out
+	var names []string
+	for _, c := range out.SessionAttributes.Contexts {
+		names = append(names, c.Name)
+	}
+	if got, want := len(names), 1; got != want || names[0] != "select_contact" {
+		t.Errorf("got contexts %q, want [select_contact]", names)
+	}
+
+	out = e.NewResponse(&intent.Response{}, true)
+	if out.Response.OutputSpeech != nil || !out.Response.ShouldEndSession {
+		t.Errorf("got %+v, want no speech and the session ended", out.Response)
+	}
+	if c := out.SessionAttributes.Contexts; len(c) != 1 || c[0].Name != "current_contact" || c[0].Lifespan != 4 {
+		t.Errorf("got contexts %+v, want current_contact with lifespan 4", c)
+	}
+}
@@ -0,0 +1,205 @@
+// 2017.09.08 rjj: Signatures of the requests Alexa sends to the skill.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package alexa
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request headers of the signature.
+const (
+	SignatureHeader    = "Signature"
+	CertChainURLHeader = "SignatureCertChainUrl"
+)
+
+// Where the certificate chains are, and the name of the certificates.
+const (
+	certChainHost       = "s3.amazonaws.com"
+	certChainPathPrefix = "/echo.api/"
+	certSubject         = "echo-api.amazon.com"
+)
+
+// maxCertChain bounds the size of a certificate chain.
+const maxCertChain = 64 << 10
+
+// Errors returned by Config.Verify.
+var (
+	ErrCertChainURL = errors.New("alexa: missing or untrusted SignatureCertChainUrl")
+	ErrCertChain    = errors.New("alexa: bad signing certificate chain")
+	ErrSignature    = errors.New("alexa: missing or wrong request signature")
+)
+
+// Config is how the app checks the requests of its Alexa skills.
+//
+// See https://developer.amazon.com/docs/custom-skills/host-a-custom-skill-as-a-web-service.html
+type Config struct {
+	// SkillIDs are the skills answered, RequestEnvelope.Verify refuses the
+	// requests for the others.
+	SkillIDs []string
+
+	// Client fetches the certificate chains, http.DefaultClient when nil.
+	Client *http.Client
+
+	// roots are the trusted CAs, the system ones when nil.
+	roots *x509.CertPool
+	// now is the clock, time.Now when nil.
+	now func() time.Time
+
+	mu sync.Mutex
+	// chains are the certificate chains fetched, by URL.
+	chains map[string][]*x509.Certificate
+}
+
+// Verify checks that r was signed by Alexa: the certificate chain of the
+// SignatureCertChainUrl is Amazon's, and its certificate signed the body.
+// The body is read and replaced, so handlers can still read it.
+func (c *Config) Verify(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return ErrSignature
+	}
+	cert, err := c.signingCert(r.Header.Get(CertChainURLHeader))
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrCertChain
+	}
+	sum := sha1.Sum(body)
+	if rsa.VerifyPKCS1v15(key, crypto.SHA1, sum[:], sig) != nil {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Wrap returns a handler that responds 401 Unauthorized to requests that
+// fail Verify, and passes the others on to h.
+func (c *Config) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Verify(r); err != nil {
+			log.Printf("Alexa call from %s rejected: %v", r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkCertChainURL checks that rawurl is where Amazon keeps the
+// certificate chains: https://s3.amazonaws.com/echo.api/... on port 443.
+func checkCertChainURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") ||
+		!strings.EqualFold(u.Hostname(), certChainHost) || u.Port() != "" && u.Port() != "443" ||
+		!strings.HasPrefix(path.Clean(u.Path), certChainPathPrefix) {
+		return ErrCertChainURL
+	}
+	return nil
+}
+
+// signingCert returns the certificate of the chain at rawurl, once it is
+// checked to be a valid certificate of certSubject from a trusted CA.
+func (c *Config) signingCert(rawurl string) (*x509.Certificate, error) {
+	if err := checkCertChainURL(rawurl); err != nil {
+		return nil, err
+	}
+	chain, err := c.certChain(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       certSubject,
+		Roots:         c.roots,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now(),
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(opts); err != nil {
+		log.Printf("Alexa certificate chain %s refused: %v", rawurl, err)
+		return nil, ErrCertChain
+	}
+	return chain[0], nil
+}
+
+// certChain returns the certificates at rawurl, fetched once.
+func (c *Config) certChain(rawurl string) ([]*x509.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if chain, ok := c.chains[rawurl]; ok {
+		return chain, nil
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("alexa: could not get the certificate chain: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("alexa: could not get the certificate chain: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCertChain))
+	if err != nil {
+		return nil, fmt.Errorf("alexa: could not get the certificate chain: %v", err)
+	}
+
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ErrCertChain
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, ErrCertChain
+	}
+	if c.chains == nil {
+		c.chains = map[string][]*x509.Certificate{}
+	}
+	c.chains[rawurl] = chain
+	return chain, nil
+}
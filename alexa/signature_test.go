@@ -0,0 +1,215 @@
+// 2017.09.08 rjj: Tests of the signatures of Alexa requests.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package alexa
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const certChainURL = "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"
+
+// roundTripFunc serves the requests of an http.Client.
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// testCA is a CA and the keys of the certificates it issued.
+type testCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns the PEM chain of a new certificate of name valid until
+// notAfter, and its key.
+func (ca *testCA) issue(t *testing.T, name string, notAfter time.Time) ([]byte, *rsa.PrivateKey) {
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	return b.Bytes(), key
+}
+
+// config returns a Config trusting ca, that gets the chains of chains.
+func (ca *testCA) config(chains map[string][]byte) *Config {
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		chain, ok := chains[r.URL.String()]
+		if !ok {
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found",
+				Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Status: "200 OK",
+			Body: ioutil.NopCloser(bytes.NewReader(chain)), Request: r}, nil
+	})}
+	return &Config{
+		SkillIDs: []string{"amzn1.ask.skill.contacts"},
+		Client:   client,
+		roots:    ca.pool,
+		now:      func() time.Time { return now },
+	}
+}
+
+// signedRequest returns a request of body signed with key, with the chain
+// at chainURL.
+func signedRequest(t *testing.T, key *rsa.PrivateKey, chainURL, body string) *http.Request {
+	sum := sha1.Sum([]byte(body))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/alexa", strings.NewReader(body))
+	r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	r.Header.Set(CertChainURLHeader, chainURL)
+	return r
+}
+
+func TestConfigVerify(t *testing.T) {
+	ca := newTestCA(t)
+	chain, key := ca.issue(t, certSubject, now.Add(30*24*time.Hour))
+	otherChain, otherKey := ca.issue(t, "example.com", now.Add(30*24*time.Hour))
+	oldChain, oldKey := ca.issue(t, certSubject, now.Add(-time.Minute))
+	const otherURL = "https://s3.amazonaws.com/echo.api/other.pem"
+	const oldURL = "https://s3.amazonaws.com/echo.api/old.pem"
+	c := ca.config(map[string][]byte{certChainURL: chain, otherURL: otherChain, oldURL: oldChain})
+
+	r := signedRequest(t, key, certChainURL, intentRequestJSON)
+	if err := c.Verify(r); err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	// The body is still there for the handler
+	if b, _ := ioutil.ReadAll(r.Body); string(b) != intentRequestJSON {
+		t.Errorf("got body %q, want %q", b, intentRequestJSON)
+	}
+	// The case of the scheme and host doesn't matter, the path is cleaned
+	for _, u := range []string{
+		"HTTPS://S3.AMAZONAWS.COM/echo.api/echo-api-cert.pem",
+		"https://s3.amazonaws.com:443/echo.api/echo-api-cert.pem",
+		"https://s3.amazonaws.com/echo.api/../echo.api/echo-api-cert.pem",
+	} {
+		if err := checkCertChainURL(u); err != nil {
+			t.Errorf("%q: got %v, want no error", u, err)
+		}
+	}
+
+	r = signedRequest(t, key, certChainURL, intentRequestJSON)
+	r.Body = ioutil.NopCloser(strings.NewReader(strings.Replace(intentRequestJSON, "Homer", "Marge", 1)))
+	if err := c.Verify(r); err != ErrSignature {
+		t.Errorf("changed body: got %v, want %v", err, ErrSignature)
+	}
+	r = signedRequest(t, key, certChainURL, "{}")
+	r.Header.Del(SignatureHeader)
+	if err := c.Verify(r); err != ErrSignature {
+		t.Errorf("no signature: got %v, want %v", err, ErrSignature)
+	}
+	if err := c.Verify(signedRequest(t, otherKey, certChainURL, "{}")); err != ErrSignature {
+		t.Errorf("other key: got %v, want %v", err, ErrSignature)
+	}
+
+	for _, u := range []string{
+		"",
+		"http://s3.amazonaws.com/echo.api/echo-api-cert.pem",
+		"https://notamazon.com/echo.api/echo-api-cert.pem",
+		"https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem",
+		"https://s3.amazonaws.com/invalid.path/echo-api-cert.pem",
+		"https://s3.amazonaws.com/echo.api/../invalid.path/echo-api-cert.pem",
+		"https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem",
+	} {
+		if err := c.Verify(signedRequest(t, key, u, "{}")); err != ErrCertChainURL {
+			t.Errorf("%q: got %v, want %v", u, err, ErrCertChainURL)
+		}
+	}
+
+	if err := c.Verify(signedRequest(t, otherKey, otherURL, "{}")); err != ErrCertChain {
+		t.Errorf("certificate of example.com: got %v, want %v", err, ErrCertChain)
+	}
+	if err := c.Verify(signedRequest(t, oldKey, oldURL, "{}")); err != ErrCertChain {
+		t.Errorf("expired certificate: got %v, want %v", err, ErrCertChain)
+	}
+	untrusted := newTestCA(t).config(map[string][]byte{certChainURL: chain})
+	if err := untrusted.Verify(signedRequest(t, key, certChainURL, "{}")); err != ErrCertChain {
+		t.Errorf("untrusted CA: got %v, want %v", err, ErrCertChain)
+	}
+	if err := c.Verify(signedRequest(t, key, "https://s3.amazonaws.com/echo.api/missing.pem", "{}")); err == nil {
+		t.Errorf("missing chain: got no error")
+	}
+}
+
+func TestConfigWrap(t *testing.T) {
+	ca := newTestCA(t)
+	chain, key := ca.issue(t, certSubject, now.Add(30*24*time.Hour))
+	c := ca.config(map[string][]byte{certChainURL: chain})
+	h := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/alexa", strings.NewReader("{}")))
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("got status %d, want %d", got, want)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest(t, key, certChainURL, "{}"))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got status %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+// 2017.08.27 rjj: Actions on Google rich responses.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package aog contains the Actions on Google rich response payload that an
+// API.AI webhook returns under data.google.
+//
+// See https://developers.google.com/actions/reference/rest/conversation-webhook
+package aog
+
+import (
+	"strings"
+)
+
+// CapabilityScreenOutput is the surface capability of devices with a screen.
+// Voice-only devices (e.g. Google Home) only understand the plain speech.
+const CapabilityScreenOutput = "actions.capability.SCREEN_OUTPUT"
+
+// Option selection as used by SelectList and SelectCarousel.
+const (
+	OptionIntent    = "actions.intent.OPTION"
+	optionValueSpec = "type.googleapis.com/google.actions.v2.OptionValueSpec"
+)
+
+// Payload is the value of data.google in an API.AI webhook response.
+type Payload struct {
+	ExpectUserResponse bool          `json:"expectUserResponse"`
+	RichResponse       *RichResponse `json:"richResponse,omitempty"`
+	SystemIntent       *SystemIntent `json:"systemIntent,omitempty"`
+}
+
+// RichResponse is a sequence of visual items, which must start with a
+// SimpleResponse, followed by optional suggestion chips.
+type RichResponse struct {
+	Items             []Item             `json:"items"`
+	Suggestions       []Suggestion       `json:"suggestions,omitempty"`
+	LinkOutSuggestion *LinkOutSuggestion `json:"linkOutSuggestion,omitempty"`
+}
+
+// Item is one entry of a RichResponse, only one field should be set.
+type Item struct {
+	SimpleResponse *SimpleResponse `json:"simpleResponse,omitempty"`
+	BasicCard      *BasicCard      `json:"basicCard,omitempty"`
+}
+
+// SimpleResponse is a chat bubble, spoken as TextToSpeech (or SSML).
+type SimpleResponse struct {
+	TextToSpeech string `json:"textToSpeech,omitempty"`
+	SSML         string `json:"ssml,omitempty"`
+	DisplayText  string `json:"displayText,omitempty"`
+}
+
+// BasicCard shows a title and some text, with an optional button.
+type BasicCard struct {
+	Title         string   `json:"title,omitempty"`
+	Subtitle      string   `json:"subtitle,omitempty"`
+	FormattedText string   `json:"formattedText,omitempty"`
+	Buttons       []Button `json:"buttons,omitempty"`
+}
+
+// Button opens URL when tapped.
+type Button struct {
+	Title         string        `json:"title"`
+	OpenURLAction OpenURLAction `json:"openUrlAction"`
+}
+
+// OpenURLAction is the action behind a Button.
+type OpenURLAction struct {
+	URL string `json:"url"`
+}
+
+// Suggestion is a chip the user can tap instead of saying Title.
+type Suggestion struct {
+	Title string `json:"title"`
+}
+
+// LinkOutSuggestion is a chip that opens URL.
+type LinkOutSuggestion struct {
+	DestinationName string `json:"destinationName"`
+	URL             string `json:"url"`
+}
+
+// SystemIntent asks the assistant to run one of its built in intents, e.g.
+// OptionIntent to let the user pick from a list.
+type SystemIntent struct {
+	Intent string      `json:"intent"`
+	Data   interface{} `json:"data"`
+}
+
+// OptionValueSpec is the SystemIntent data for OptionIntent.
+type OptionValueSpec struct {
+	Type           string          `json:"@type"`
+	ListSelect     *ListSelect     `json:"listSelect,omitempty"`
+	CarouselSelect *CarouselSelect `json:"carouselSelect,omitempty"`
+}
+
+// ListSelect is a vertical list of 2 to 30 options.
+type ListSelect struct {
+	Title string       `json:"title,omitempty"`
+	Items []OptionItem `json:"items"`
+}
+
+// CarouselSelect is a horizontal carousel of 2 to 10 options.
+type CarouselSelect struct {
+	Items []OptionItem `json:"items"`
+}
+
+// OptionItem is one selectable entry of a list or carousel.
+// When picked, Key comes back as the OPTION argument of the request.
+type OptionItem struct {
+	OptionInfo  OptionInfo `json:"optionInfo"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+}
+
+// OptionInfo identifies an OptionItem.
+type OptionInfo struct {
+	Key      string   `json:"key"`
+	Synonyms []string `json:"synonyms,omitempty"`
+}
+
+// NewRichResponse returns a RichResponse starting with a SimpleResponse
+// speaking the plain text speech.
+func NewRichResponse(speech, displayText string) *RichResponse {
+	sr := &SimpleResponse{TextToSpeech: speech, DisplayText: displayText}
+	return &RichResponse{Items: []Item{{SimpleResponse: sr}}}
+}
+
+// NewSSMLRichResponse returns a RichResponse starting with a SimpleResponse
+// speaking the SSML <speak> document speech.
+func NewSSMLRichResponse(speech, displayText string) *RichResponse {
+	sr := &SimpleResponse{SSML: speech, DisplayText: displayText}
+	return &RichResponse{Items: []Item{{SimpleResponse: sr}}}
+}
+
+// AddBasicCard appends a basic card to the response.
+func (r *RichResponse) AddBasicCard(c BasicCard) *RichResponse {
+	r.Items = append(r.Items, Item{BasicCard: &c})
+	return r
+}
+
+// AddSuggestions appends suggestion chips to the response.
+func (r *RichResponse) AddSuggestions(titles ...string) *RichResponse {
+	for _, t := range titles {
+		r.Suggestions = append(r.Suggestions, Suggestion{Title: t})
+	}
+	return r
+}
+
+// SetLinkOut sets the chip that opens url.
+func (r *RichResponse) SetLinkOut(name, url string) *RichResponse {
+	r.LinkOutSuggestion = &LinkOutSuggestion{DestinationName: name, URL: url}
+	return r
+}
+
+// SelectList returns a SystemIntent asking the user to pick one of items.
+func SelectList(title string, items []OptionItem) *SystemIntent {
+	return &SystemIntent{
+		Intent: OptionIntent,
+		Data: OptionValueSpec{
+			Type:       optionValueSpec,
+			ListSelect: &ListSelect{Title: title, Items: items},
+		},
+	}
+}
+
+// SelectCarousel returns a SystemIntent asking the user to pick one of items.
+func SelectCarousel(items []OptionItem) *SystemIntent {
+	return &SystemIntent{
+		Intent: OptionIntent,
+		Data: OptionValueSpec{
+			Type:           optionValueSpec,
+			CarouselSelect: &CarouselSelect{Items: items},
+		},
+	}
+}
+
+// TelURL returns a tel: URL for phone, keeping only the characters a dialer
+// understands.
+func TelURL(phone string) string {
+	num := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '+' {
+			return r
+		}
+		return -1
+	}, phone)
+	return "tel:" + num
+}
+
+// MailtoURL returns a mailto: URL for email.
+func MailtoURL(email string) string {
+	return "mailto:" + strings.TrimSpace(email)
+}
@@ -0,0 +1,92 @@
+// 2017.08.27 rjj: Tests for the Actions on Google payload.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package aog
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBasicCardPayload(t *testing.T) {
+	rr := NewRichResponse("Found Homer Simpson", "Homer Simpson").
+		AddBasicCard(BasicCard{
+			Title: "Homer Simpson",
+			Buttons: []Button{{
+				Title:         "Call",
+				OpenURLAction: OpenURLAction{URL: TelURL("555-123-4567")},
+			}},
+		}).
+		AddSuggestions("Call", "Email")
+
+	b, err := json.Marshal(&Payload{ExpectUserResponse: true, RichResponse: rr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(b)
+	for _, want := range []string{
+		`"expectUserResponse":true`,
+		`{"simpleResponse":{"textToSpeech":"Found Homer Simpson","displayText":"Homer Simpson"}}`,
+		`"openUrlAction":{"url":"tel:5551234567"}`,
+		`"suggestions":[{"title":"Call"},{"title":"Email"}]`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %s to contain %s", got, want)
+		}
+	}
+	if strings.Contains(got, "systemIntent") {
+		t.Errorf("want %s to omit systemIntent", got)
+	}
+}
+
+func TestSelectList(t *testing.T) {
+	si := SelectList("Contacts", []OptionItem{
+		{OptionInfo: OptionInfo{Key: "1"}, Title: "Homer Simpson"},
+		{OptionInfo: OptionInfo{Key: "2"}, Title: "Homer Simpson"},
+	})
+
+	b, err := json.Marshal(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(b)
+	for _, want := range []string{
+		`"intent":"actions.intent.OPTION"`,
+		`"@type":"type.googleapis.com/google.actions.v2.OptionValueSpec"`,
+		`"listSelect":{"title":"Contacts","items":[{"optionInfo":{"key":"1"}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %s to contain %s", got, want)
+		}
+	}
+}
+
+func TestURLs(t *testing.T) {
+	if got, want := TelURL("+1 (555) 123-4567"), "tel:+15551234567"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := MailtoURL(" homer@simpsons.guru "), "mailto:homer@simpsons.guru"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSSMLSpeech(t *testing.T) {
+	rr := NewSSMLRichResponse("<speak>Found Homer</speak>", "Found Homer")
+	sr := rr.Items[0].SimpleResponse
+	if got, want := sr.SSML, "<speak>Found Homer</speak>"; got != want {
+		t.Errorf("SSML: got %q, want %q", got, want)
+	}
+	if sr.TextToSpeech != "" {
+		t.Errorf("TextToSpeech: got %q, want empty", sr.TextToSpeech)
+	}
+
+	// Plain text that looks like SSML is still plain text
+	sr = NewRichResponse("<speak>Found Homer</speak>", "Found Homer").Items[0].SimpleResponse
+	if sr.SSML != "" || sr.TextToSpeech != "<speak>Found Homer</speak>" {
+		t.Errorf("plain text: got SSML %q, TextToSpeech %q", sr.SSML, sr.TextToSpeech)
+	}
+}
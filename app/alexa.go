@@ -0,0 +1,89 @@
+// 2017.09.08 rjj.work@gmail.com: Alexa custom skill endpoint
+//	The skill's intents have the names of the API.AI ones (number_of_contacts, find_contact, ...) and slots
+//	named like their parameters with _ for - (given_name, last_name, phone_number, ...), see package alexa.
+//	Requests go to the same intent handlers as /contactsWebhook, the contexts live in the session attributes.
+//	Requests not signed by Alexa, older than alexa.MaxTimestampSkew, or for another skill (ALEXA_SKILL_IDS),
+//	are refused, and all of them when ALEXA_SKILL_IDS is not set.
+//	Account linking uses the same authorization server, so the access token gives the same user.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/alexa"
+	"github.com/rjj-work/yum-contacts/intent"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// requireAlexa passes on the calls signed by Alexa when the skill is
+// configured, see contacts.Alexa.
+func requireAlexa(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contacts.Alexa == nil {
+			http.NotFound(w, r)
+			return
+		}
+		contacts.Alexa.Wrap(h).ServeHTTP(w, r)
+	})
+}
+
+func alexaHandler(w http.ResponseWriter, r *http.Request) *appError {
+	var e alexa.RequestEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		return appErrorf(err, "Decode of Alexa request failed: %v", err)
+	}
+	if err := e.Verify(timeNow(), contacts.Alexa.SkillIDs); err != nil {
+		return &appError{err, "Invalid Alexa request", http.StatusBadRequest}
+	}
+
+	req := e.IntentRequest()
+	msgs := messages(req)
+	resp := intent.Response{}
+	end := false
+
+	switch e.Request.Type {
+	case alexa.LaunchRequest:
+		resp.Say("%s", msgs.Sprintf("alexa.welcome"))
+	case alexa.SessionEndedRequest:
+		// No speech is allowed, Alexa only wants to know we got it
+		if e.Request.Error != nil {
+			log.Printf("Alexa session %s ended: %s, %s: %s", e.Session.SessionID, e.Request.Reason,
+				e.Request.Error.Type, e.Request.Error.Message)
+		}
+		end = true
+	case alexa.IntentRequest:
+		switch req.Intent {
+		case alexa.StopIntent, alexa.CancelIntent:
+			resp.Say("%s", msgs.Sprintf("alexa.goodbye"))
+			end = true
+		case alexa.HelpIntent:
+			resp.Say("%s", msgs.Sprintf("alexa.help"))
+		default:
+			// Same as /contactsWebhook from here, AMAZON.FallbackIntent ends up in unhandledIntent
+			if p, err := userFromToken(e.AccessToken()); err == oauthserver.ErrInvalidToken {
+				return &appError{err, "Invalid access token", http.StatusUnauthorized}
+			} else if err != nil {
+				// Not anonymous, the user would miss their contacts, or add them as anyone's
+				return appErrorf(err, "Could not get profile of Alexa user: %v", err)
+			} else if p != nil {
+				req.UserID = p.ID
+				req.UserName = p.DisplayName
+			}
+			if err := intent.Dispatch(req, &resp); err != nil {
+				return appErrorf(err, "Processing of INTENT: %s failed: %v", req.Intent, err)
+			}
+		}
+	default:
+		err := fmt.Errorf("unknown Alexa request type %q", e.Request.Type)
+		return &appError{err, "Unknown Alexa request type", http.StatusBadRequest}
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	json.NewEncoder(w).Encode(e.NewResponse(&resp, end))
+	return nil
+}
@@ -0,0 +1,375 @@
+// 2017.09.11 rjj.work@gmail.com: JSON REST API for our scripts and the mobile app
+//	GET	/api/v1/contacts		the user's contacts, filtered by ?name= tag= city= emailDomain=
+//						phonePrefix= phoneSuffix= missing=email|phone added=2017-08-01[/2017-08-31]
+//	POST	/api/v1/contacts		201 Created with the contact, Location: /api/v1/contacts/{id}
+//	GET	/api/v1/contacts/{id}
+//	PUT	/api/v1/contacts/{id}		replaces the fields people fill in, absent ones are emptied
+//	PATCH	/api/v1/contacts/{id}		changes only the fields in the body
+//	DELETE	/api/v1/contacts/{id}		204 No Content
+//	Calls carry one of our access tokens (Authorization: Bearer, see oauth_server.go) or the session
+//	cookie of the web login, and only see the contacts of that user, the others are 404 Not Found.
+//	Bodies are checked by contacts.Contact.Validate like the web forms, refused ones get a 422 listing
+//	the fields. Errors are JSON too: {"error": {"code": 422, "message": "...", "fields": [...]}}
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/daterange"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// apiContactsPath is the collection of the contacts resource.
+const apiContactsPath = "/api/v1/contacts"
+
+// maxAPIBody is the largest request body read, a contact is a few hundred bytes.
+const maxAPIBody = 64 << 10
+
+// registerAPIHandlers adds the routes of the API to r.
+func registerAPIHandlers(r *mux.Router) {
+	r.Methods("GET").Path(apiContactsPath).
+		Handler(apiHandler(apiListHandler))
+	r.Methods("POST").Path(apiContactsPath).
+		Handler(apiHandler(apiCreateHandler))
+	r.Methods("GET").Path(apiContactsPath + "/{id:[0-9]+}").
+		Handler(apiHandler(apiGetHandler))
+	r.Methods("PUT", "PATCH").Path(apiContactsPath + "/{id:[0-9]+}").
+		Handler(apiHandler(apiUpdateHandler))
+	r.Methods("DELETE").Path(apiContactsPath + "/{id:[0-9]+}").
+		Handler(apiHandler(apiDeleteHandler))
+}
+
+// apiContact is a contact as the API returns it.
+type apiContact struct {
+	ID          int64    `json:"id"`
+	FirstName   string   `json:"firstName"`
+	LastName    string   `json:"lastName"`
+	Address     string   `json:"address"`
+	Email       string   `json:"email"`
+	Phone       string   `json:"phone"`
+	Tags        []string `json:"tags"`
+	CreatedBy   string   `json:"createdBy"`
+	CreatedDate string   `json:"createdDate,omitempty"`
+	LastEdited  string   `json:"lastEdited,omitempty"`
+}
+
+// apiContactBody is the body of POST, PUT and PATCH, with nil for the
+// fields it doesn't have. Other fields, e.g. the id of a contact read with
+// GET, are ignored.
+type apiContactBody struct {
+	FirstName *string   `json:"firstName"`
+	LastName  *string   `json:"lastName"`
+	Address   *string   `json:"address"`
+	Email     *string   `json:"email"`
+	Phone     *string   `json:"phone"`
+	Tags      *[]string `json:"tags"`
+}
+
+// apiError is the body of error responses.
+type apiError struct {
+	Code    int                   `json:"code"`
+	Message string                `json:"message"`
+	Fields  []contacts.FieldError `json:"fields,omitempty"`
+}
+
+// apiHandler is an appHandler for the user the call is authenticated as,
+// that responds with JSON errors.
+type apiHandler func(w http.ResponseWriter, r *http.Request, user *Profile) *appError
+
+func (fn apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, err := apiUser(r)
+	if err != nil && err != oauthserver.ErrInvalidToken && err != errNoBearerToken {
+		// The token store or Google is down, the token may well be good
+		log.Printf("Could not check an API access token: %v", err)
+		writeAPIError(w, appErrorf(err, "could not check the access token"))
+		return
+	}
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="contacts"`)
+		writeAPIError(w, &appError{err, "an access token or a login session is required", http.StatusUnauthorized})
+		return
+	}
+	if e := fn(w, r, user); e != nil {
+		log.Printf("API error: status code: %d, message: %s, underlying err: %#v",
+			e.Code, e.Message, e.Error)
+		writeAPIError(w, e)
+	}
+}
+
+// errNoBearerToken is the error of apiUser for an Authorization header of
+// another scheme than Bearer.
+var errNoBearerToken = errors.New("the authorization is not a bearer token")
+
+// apiUser returns the user of the bearer token of r, or else of the web
+// login session, nil for neither. Unknown tokens give oauthserver.ErrInvalidToken.
+func apiUser(r *http.Request) (*Profile, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, errNoBearerToken
+		}
+		return userFromToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	}
+	return profileFromSession(r), nil
+}
+
+// writeAPIError writes e as JSON, with the fields of a ValidationError.
+func writeAPIError(w http.ResponseWriter, e *appError) {
+	body := apiError{Code: e.Code, Message: e.Message}
+	if errs, ok := e.Error.(contacts.ValidationError); ok {
+		body.Fields = errs
+	}
+	writeAPIJSON(w, e.Code, struct {
+		Error apiError `json:"error"`
+	}{body})
+}
+
+// writeAPIJSON writes v as the JSON body of a response with the status code.
+func writeAPIJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Could not write API response: %v", err)
+	}
+}
+
+// newAPIContact returns c as the API returns it.
+func newAPIContact(c *contacts.Contact) apiContact {
+	tags := []string{}
+	if c.Tags != "" {
+		tags = strings.Split(c.Tags, ",")
+	}
+	return apiContact{
+		ID:          c.ID,
+		FirstName:   c.FirstName,
+		LastName:    c.LastName,
+		Address:     c.Address,
+		Email:       c.Email,
+		Phone:       c.Phone,
+		Tags:        tags,
+		CreatedBy:   c.CreatedByDisplayName(),
+		CreatedDate: apiTime(c.CreatedDate),
+		LastEdited:  apiTime(c.LastEdited),
+	}
+}
+
+// apiTime returns a database datetime (UTC) in RFC 3339, e.g.
+// "2017-09-01T12:00:00Z", or as it is if it isn't one.
+func apiTime(s string) string {
+	t, err := time.Parse(contacts.CreatedDateLayout, s)
+	if err != nil {
+		return s
+	}
+	return t.Format(time.RFC3339)
+}
+
+// apply copies the fields of b to c. Fields b doesn't have are emptied, or
+// with patch left as they are.
+func (b *apiContactBody) apply(c *contacts.Contact, patch bool) {
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&c.FirstName, b.FirstName},
+		{&c.LastName, b.LastName},
+		{&c.Address, b.Address},
+		{&c.Email, b.Email},
+		{&c.Phone, b.Phone},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		} else if !patch {
+			*f.dst = ""
+		}
+	}
+	if b.Tags != nil {
+		c.Tags = strings.Join(*b.Tags, ",")
+	} else if !patch {
+		c.Tags = ""
+	}
+}
+
+// apiContactBodyFromRequest decodes the JSON body of r. Only JSON is
+// accepted, which also keeps other sites from posting forms with the
+// session cookie of the web login.
+func apiContactBodyFromRequest(w http.ResponseWriter, r *http.Request) (*apiContactBody, *appError) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return nil, &appError{err, "the body must be application/json", http.StatusUnsupportedMediaType}
+	}
+	body := &apiContactBody{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody)).Decode(body); err != nil {
+		return nil, &appError{err, fmt.Sprintf("bad JSON body: %v", err), http.StatusBadRequest}
+	}
+	return body, nil
+}
+
+// apiContactFromRequest returns the contact of the ID in the URL's path,
+// if user created it.
+func apiContactFromRequest(r *http.Request, user *Profile) (*contacts.Contact, *appError) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, &appError{err, "no such contact", http.StatusNotFound}
+	}
+	c, err := contacts.GetContactOf(contacts.DB, user.ID, id)
+	if err != nil {
+		return nil, appErrorf(err, "could not get contact: %v", err)
+	}
+	if c == nil {
+		return nil, &appError{nil, "no such contact", http.StatusNotFound}
+	}
+	return c, nil
+}
+
+// apiCriteria returns the criteria of the query string of r, for user's contacts.
+func apiCriteria(r *http.Request, user *Profile) (contacts.ContactCriteria, *appError) {
+	criteria, e := queryCriteria(r.URL.Query())
+	criteria.CreatedByID = user.ID
+	return criteria, e
+}
+
+// queryCriteria returns the criteria of a query string, of every user's
+// contacts. The export takes the same ones, see export.go.
+func queryCriteria(q url.Values) (contacts.ContactCriteria, *appError) {
+	criteria := contacts.ContactCriteria{
+		Name:        q.Get("name"),
+		Tag:         q.Get("tag"),
+		City:        q.Get("city"),
+		EmailDomain: q.Get("emailDomain"),
+		PhonePrefix: q.Get("phonePrefix"),
+		PhoneSuffix: q.Get("phoneSuffix"),
+	}
+	for _, field := range q["missing"] {
+		switch field {
+		case "email":
+			criteria.MissingEmail = true
+		case "phone":
+			criteria.MissingPhone = true
+		default:
+			return criteria, &appError{nil, fmt.Sprintf("missing must be email or phone, not %q", field), http.StatusBadRequest}
+		}
+	}
+	if added := q.Get("added"); added != "" {
+		period, err := daterange.ParsePeriod(added, time.UTC)
+		if err != nil {
+			return criteria, &appError{err, fmt.Sprintf("added must be a date or dates like 2017-08-01/2017-08-31, not %q", added), http.StatusBadRequest}
+		}
+		criteria.CreatedSince, criteria.CreatedBefore = period.Start, period.End
+	}
+	return criteria, nil
+}
+
+// apiListHandler returns the user's contacts matching the query string.
+func apiListHandler(w http.ResponseWriter, r *http.Request, user *Profile) *appError {
+	criteria, e := apiCriteria(r, user)
+	if e != nil {
+		return e
+	}
+	var cts []*contacts.Contact
+	var err error
+	if criteria.Empty() {
+		cts, err = contacts.DB.ListContactsCreatedBy(user.ID)
+	} else {
+		cts, err = contacts.DB.FindContacts(criteria)
+	}
+	if err != nil {
+		return appErrorf(err, "could not list contacts: %v", err)
+	}
+
+	list := make([]apiContact, len(cts))
+	for i, c := range cts {
+		list[i] = newAPIContact(c)
+	}
+	writeAPIJSON(w, http.StatusOK, struct {
+		Contacts []apiContact `json:"contacts"`
+	}{list})
+	return nil
+}
+
+// apiGetHandler returns a contact.
+func apiGetHandler(w http.ResponseWriter, r *http.Request, user *Profile) *appError {
+	c, e := apiContactFromRequest(r, user)
+	if e != nil {
+		return e
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIContact(c))
+	return nil
+}
+
+// apiCreateHandler adds a contact of the user, and returns it with its URL.
+func apiCreateHandler(w http.ResponseWriter, r *http.Request, user *Profile) *appError {
+	body, e := apiContactBodyFromRequest(w, r)
+	if e != nil {
+		return e
+	}
+	c := &contacts.Contact{CreatedBy: user.DisplayName, CreatedByID: user.ID}
+	body.apply(c, false)
+	if err := c.Validate(); err != nil {
+		return &appError{err, "invalid contact", http.StatusUnprocessableEntity}
+	}
+
+	id, err := contacts.DB.AddContact(c)
+	if err != nil {
+		return appErrorf(err, "could not save contact: %v", err)
+	}
+	// Read back for the dates the database sets
+	if saved, err := contacts.DB.GetContact(id); err == nil {
+		c = saved
+	} else {
+		c.ID = id
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", apiContactsPath, id))
+	writeAPIJSON(w, http.StatusCreated, newAPIContact(c))
+	return nil
+}
+
+// apiUpdateHandler replaces (PUT) or changes (PATCH) the fields of a
+// contact, and returns it.
+func apiUpdateHandler(w http.ResponseWriter, r *http.Request, user *Profile) *appError {
+	c, e := apiContactFromRequest(r, user)
+	if e != nil {
+		return e
+	}
+	body, e := apiContactBodyFromRequest(w, r)
+	if e != nil {
+		return e
+	}
+	body.apply(c, r.Method == "PATCH")
+	if err := c.Validate(); err != nil {
+		return &appError{err, "invalid contact", http.StatusUnprocessableEntity}
+	}
+
+	if err := contacts.DB.UpdateContact(c); err != nil {
+		return appErrorf(err, "could not update contact: %v", err)
+	}
+	if saved, err := contacts.DB.GetContact(c.ID); err == nil {
+		c = saved
+	}
+	writeAPIJSON(w, http.StatusOK, newAPIContact(c))
+	return nil
+}
+
+// apiDeleteHandler deletes a contact.
+func apiDeleteHandler(w http.ResponseWriter, r *http.Request, user *Profile) *appError {
+	c, e := apiContactFromRequest(r, user)
+	if e != nil {
+		return e
+	}
+	if err := contacts.DB.DeleteContact(c.ID); err != nil {
+		return appErrorf(err, "could not delete contact: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
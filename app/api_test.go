@@ -0,0 +1,220 @@
+// 2017.09.11 rjj.work@gmail.com: Tests of the JSON REST API, see api.go
+//	CONTACTS_DB=memory go test -run TestAPI
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// apiTestServer returns the API on the seed contacts, and an access token of
+// Jane Doe (user 1234, contact 5). Close it when done.
+func apiTestServer(t *testing.T) (*apiServer, string) {
+	if os.Getenv("CONTACTS_DB") != "memory" {
+		t.Skip("set CONTACTS_DB=memory to test the API")
+	}
+	srv := &apiServer{db: contacts.DB, oauthServer: contacts.OAuthServer}
+	contacts.DB = contacts.NewMemoryDB()
+	if err := seedContacts(filepath.Join("testdata", "contacts.json")); err != nil {
+		contacts.DB = srv.db
+		t.Fatal(err)
+	}
+
+	store := oauthserver.NewMemoryStore()
+	store.Put(&oauthserver.Token{
+		Hash:  oauthserver.Hash("jane-token"),
+		Kind:  oauthserver.KindAccess,
+		Grant: oauthserver.Grant{ClientID: "test", UserID: "1234", UserName: "Jane Doe"},
+	})
+	contacts.OAuthServer = &oauthserver.Server{Store: store}
+	r := mux.NewRouter()
+	registerAPIHandlers(r)
+	registerGraphQLHandler(r)
+	srv.Server = httptest.NewServer(r)
+	return srv, "jane-token"
+}
+
+// apiServer puts back contacts.DB and contacts.OAuthServer when closed.
+type apiServer struct {
+	*httptest.Server
+	db          contacts.ContactDatabase
+	oauthServer *oauthserver.Server
+}
+
+func (srv *apiServer) Close() {
+	srv.Server.Close()
+	contacts.DB = srv.db
+	contacts.OAuthServer = srv.oauthServer
+}
+
+// apiCall sends body (JSON, or none for "") and decodes the response into v,
+// unless v is nil.
+func apiCall(t *testing.T, srv *apiServer, token, method, path, body string, v interface{}) *http.Response {
+	var req *http.Request
+	var err error
+	if body == "" {
+		req, err = http.NewRequest(method, srv.URL+path, nil)
+	} else {
+		req, err = http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	srv, _ := apiTestServer(t)
+	defer srv.Close()
+	for _, token := range []string{"", "wrong-token"} {
+		var body struct{ Error apiError }
+		resp := apiCall(t, srv, token, "GET", "/api/v1/contacts", "", &body)
+		if resp.StatusCode != http.StatusUnauthorized || body.Error.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d %+v, want 401", token, resp.StatusCode, body)
+		}
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: no WWW-Authenticate header", token)
+		}
+	}
+}
+
+func TestAPITokenStoreDown(t *testing.T) {
+	srv, token := apiTestServer(t)
+	defer srv.Close()
+	contacts.OAuthServer = &oauthserver.Server{Store: brokenTokenStore{oauthserver.NewMemoryStore()}}
+	var body struct{ Error apiError }
+	resp := apiCall(t, srv, token, "GET", "/api/v1/contacts", "", &body)
+	if resp.StatusCode != http.StatusInternalServerError || body.Error.Code != http.StatusInternalServerError {
+		t.Errorf("got %d %+v, want 500", resp.StatusCode, body)
+	}
+}
+
+func TestAPIList(t *testing.T) {
+	srv, token := apiTestServer(t)
+	defer srv.Close()
+	var list struct{ Contacts []apiContact }
+	if resp := apiCall(t, srv, token, "GET", "/api/v1/contacts", "", &list); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want 200", resp.StatusCode)
+	}
+	if len(list.Contacts) != 1 || list.Contacts[0].ID != 5 {
+		t.Fatalf("got %+v, want Jane's contact 5 only", list.Contacts)
+	}
+	if got, want := list.Contacts[0].CreatedDate, "2017-08-24T12:00:00Z"; got != want {
+		t.Errorf("got created date %q, want %q", got, want)
+	}
+
+	list.Contacts = nil
+	apiCall(t, srv, token, "GET", "/api/v1/contacts?missing=phone&emailDomain=example.com", "", &list)
+	if len(list.Contacts) != 1 {
+		t.Errorf("missing phone: got %+v, want contact 5", list.Contacts)
+	}
+	list.Contacts = nil
+	apiCall(t, srv, token, "GET", "/api/v1/contacts?added=2017-09-01", "", &list)
+	if len(list.Contacts) != 0 {
+		t.Errorf("added today: got %+v, want none", list.Contacts)
+	}
+	if resp := apiCall(t, srv, token, "GET", "/api/v1/contacts?missing=name", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing name: got %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestAPICRUD(t *testing.T) {
+	srv, token := apiTestServer(t)
+	defer srv.Close()
+
+	var c apiContact
+	resp := apiCall(t, srv, token, "POST", "/api/v1/contacts",
+		`{"firstName": "Ralph", "lastName": "Jack", "phone": "(407) 555 0100", "tags": [" work", "work"]}`, &c)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST: got %d, want 201", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Location"), "/api/v1/contacts/6"; got != want {
+		t.Errorf("POST: got location %q, want %q", got, want)
+	}
+	if c.ID != 6 || c.Phone != "407-555-0100" || c.CreatedBy != "Jane Doe" || len(c.Tags) != 1 || c.Tags[0] != "work" {
+		t.Errorf("POST: got %+v", c)
+	}
+
+	c = apiContact{}
+	if resp := apiCall(t, srv, token, "PATCH", "/api/v1/contacts/6", `{"email": "ralph@example.com"}`, &c); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH: got %d, want 200", resp.StatusCode)
+	}
+	if c.Email != "ralph@example.com" || c.Phone != "407-555-0100" {
+		t.Errorf("PATCH: got %+v, want the email added and the phone kept", c)
+	}
+
+	c = apiContact{}
+	apiCall(t, srv, token, "PUT", "/api/v1/contacts/6", `{"firstName": "Ralph", "lastName": "Jack"}`, &c)
+	if c.Email != "" || c.Phone != "" || len(c.Tags) != 0 {
+		t.Errorf("PUT: got %+v, want the other fields emptied", c)
+	}
+
+	if resp := apiCall(t, srv, token, "DELETE", "/api/v1/contacts/6", "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE: got %d, want 204", resp.StatusCode)
+	}
+	if resp := apiCall(t, srv, token, "GET", "/api/v1/contacts/6", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET deleted: got %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	srv, token := apiTestServer(t)
+	defer srv.Close()
+
+	var body struct{ Error apiError }
+	resp := apiCall(t, srv, token, "POST", "/api/v1/contacts", `{"lastName": " ", "email": "ralph"}`, &body)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("invalid: got %d, want 422", resp.StatusCode)
+	}
+	if len(body.Error.Fields) != 2 || body.Error.Fields[0].Field != "firstName" || body.Error.Fields[1].Field != "email" {
+		t.Errorf("invalid: got fields %+v, want firstName and email", body.Error.Fields)
+	}
+
+	if resp := apiCall(t, srv, token, "POST", "/api/v1/contacts", `{"firstName": `, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad JSON: got %d, want 400", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/api/v1/contacts", strings.NewReader("firstname=Ralph"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Error(err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("form post: got %d, want 415", resp.StatusCode)
+	}
+
+	// Homer is anonymous's, not Jane's
+	for _, method := range []string{"GET", "DELETE"} {
+		if resp := apiCall(t, srv, token, method, "/api/v1/contacts/1", "", nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s other user's: got %d, want 404", method, resp.StatusCode)
+		}
+	}
+	if resp := apiCall(t, srv, token, "PATCH", "/api/v1/contacts/1", `{"email": "x@example.com"}`, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("PATCH other user's: got %d, want 404", resp.StatusCode)
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"google.golang.org/appengine"
 
 	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/carddavsync"
 )
 
 var (
@@ -40,11 +41,48 @@ var (
 )
 
 func main() {
+	// "simulate" replays webhook conversations instead of serving, see simulate.go
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(simulateMain(os.Args[2:]))
+	}
+
 	registerHandlers()
 	appengine.Main()
 }
 
 func registerHandlers() {
+	// [START request_logging]
+	// Delegate all of the HTTP routing and serving to the gorilla/mux router.
+	// Log all requests using the standard Apache format.
+	http.Handle("/", handlers.CombinedLoggingHandler(os.Stderr, newRouter()))
+	// [END request_logging]
+
+	// [START grpc]
+	// The gRPC ContactService, on its own port, see grpc.go.
+	if "" != contacts.GRPCAddr {
+		go serveGRPC( contacts.GRPCAddr )
+	}
+	// [END grpc]
+
+	// [START ldap]
+	// The read-only LDAP directory, on its own port, see ldap.go.
+	if "" != contacts.LDAP.Addr {
+		go serveLDAP( contacts.LDAP )
+	}
+	// [END ldap]
+
+	// [START carddav_sync]
+	// Two-way sync with the remote CardDAV address book, on a schedule.
+	if nil != contacts.CardDAVSync {
+		syncer := carddavsync.New( contacts.DB, contacts.CardDAVSync )
+		go syncer.Run( contacts.CardDAVSync.Interval, nil )
+	}
+	// [END carddav_sync]
+}
+
+// newRouter returns the routes of the app, all of them are described in
+// openapi.json (see openapi.go).
+func newRouter() *mux.Router {
 	// Use gorilla/mux for rich routing.
 	// See http://www.gorillatoolkit.org/pkg/mux
 	r := mux.NewRouter()
@@ -69,6 +107,40 @@ func registerHandlers() {
 	r.Methods("POST").Path("/contacts/{id:[0-9]+}:delete").
 		Handler(appHandler(deleteHandler)).Name("delete")
 
+	// vCard export and import, see vcard.go
+	r.Methods("GET").Path("/contacts.vcf").
+		Handler(appHandler(vcardListHandler))
+	r.Methods("GET").Path("/contacts/mine.vcf").
+		Handler(appHandler(vcardListMineHandler))
+	r.Methods("GET").Path("/contacts/{id:[0-9]+}.vcf").
+		Handler(appHandler(vcardHandler))
+	r.Methods("GET").Path("/contacts/import").
+		Handler(appHandler(importFormHandler))
+	r.Methods("POST").Path("/contacts/import").
+		Handler(appHandler(importHandler))
+	// LDIF export, see ldap.go
+	r.Methods("GET").Path("/contacts.ldif").
+		Handler(appHandler(ldifListHandler))
+	r.Methods("GET").Path("/contacts/mine.ldif").
+		Handler(appHandler(ldifListMineHandler))
+	// CSV and NDJSON export, see export.go
+	r.Methods("GET").Path("/contacts/export").
+		Handler(appHandler(exportHandler))
+	r.Methods("GET").Path("/contacts/mine/export").
+		Handler(appHandler(exportMineHandler))
+	// CSV import, see csvimport.go
+	r.Methods("GET").Path("/contacts/import/csv").
+		Handler(appHandler(importCSVFormHandler))
+	r.Methods("POST").Path("/contacts/import/csv").
+		Handler(appHandler(importCSVHandler))
+
+	// JSON REST API for scripts and the mobile app, see api.go
+	registerAPIHandlers(r)
+	// GraphQL API for the frontend, see graphql.go
+	registerGraphQLHandler(r)
+	// CardDAV address books of the users, see carddav.go
+	registerCardDAVHandlers(r)
+
 	// The following handlers are defined in auth.go and used in the
 	// "Authenticating Users" part of the Getting Started guide.
 	r.Methods("GET").Path("/login").
@@ -78,6 +150,17 @@ func registerHandlers() {
 	r.Methods("GET").Path("/oauth2callback").
 		Handler(appHandler(oauthCallbackHandler))
 
+	// The following handlers are defined in oauth_server.go, the OAuth2
+	// authorization server for Actions on Google account linking.
+	r.Methods("GET").Path("/oauth/authorize").
+		Handler(appHandler(authorizeFormHandler))
+	r.Methods("POST").Path("/oauth/authorize").
+		Handler(appHandler(authorizeHandler))
+	r.Methods("POST").Path("/oauth/token").
+		HandlerFunc(tokenHandler)
+	r.Methods("POST").Path("/oauth/revoke").
+		HandlerFunc(revokeHandler)
+
 	// Respond to App Engine and Compute Engine health checks.
 	// Indicate the server is healthy.
 	r.Methods("GET").Path("/_ah/health").HandlerFunc(
@@ -87,14 +170,33 @@ func registerHandlers() {
 
 	// Following handlers are defined in webhook.go
 	// support for API.AI fulfillment
+	// Calls that fail the configured authentication get a 401, see contacts.WebhookAuth
 	r.Methods("POST").Path("/contactsWebhook").
-		Handler( appHandler(webhookHandler) )
-
-	// [START request_logging]
-	// Delegate all of the HTTP routing and serving to the gorilla/mux router.
-	// Log all requests using the standard Apache format.
-	http.Handle("/", handlers.CombinedLoggingHandler(os.Stderr, r))
-	// [END request_logging]
+		Handler( requireWebhookAuth( appHandler(webhookHandler) ) )
+
+	// Alexa custom skill, see alexa.go, signed by Alexa
+	r.Methods("POST").Path("/alexa").
+		Handler(requireAlexa(appHandler(alexaHandler)))
+
+	// Slack slash command and button clicks, see slack.go, signed by Slack
+	r.Methods("POST").Path("/slack/command").
+		Handler(requireSlack(appHandler(slackCommandHandler)))
+	r.Methods("POST").Path("/slack/interactive").
+		Handler(requireSlack(appHandler(slackInteractionHandler)))
+
+	// SMS and voice lookups through Twilio, see twilio.go, signed by Twilio
+	r.Methods("POST").Path("/twilio/sms").
+		Handler(requireTwilio(appHandler(twilioSMSHandler)))
+	r.Methods("POST").Path("/twilio/voice").
+		Handler(requireTwilio(appHandler(twilioVoiceHandler)))
+
+	// OpenAPI document and its viewer, see openapi.go
+	r.Methods("GET").Path("/openapi.json").
+		HandlerFunc(openAPIHandler)
+	r.Methods("GET").Path("/api/docs").
+		HandlerFunc(apiDocsHandler)
+
+	return r
 }
 
 // listHandler displays a list with summaries of contacts in the database.
@@ -104,7 +206,7 @@ func listHandler(w http.ResponseWriter, r *http.Request) *appError {
 		return appErrorf(err, "could not list contacts: %v", err)
 	}
 
-	return listTmpl.Execute(w, r, contacts)
+	return listTmpl.Execute(w, r, contactList{contacts, "/contacts.vcf", "/contacts/export"})
 }
 
 // listMineHandler displays a list of contacts created by the currently
@@ -121,7 +223,14 @@ func listMineHandler(w http.ResponseWriter, r *http.Request) *appError {
 		return appErrorf(err, "could not list contacts: %v", err)
 	}
 
-	return listTmpl.Execute(w, r, contacts)
+	return listTmpl.Execute(w, r, contactList{contacts, "/contacts/mine.vcf", "/contacts/mine/export"})
+}
+
+// contactList is the data of list.html, VCardURL and ExportURL download the list.
+type contactList struct {
+	Contacts  []*contacts.Contact
+	VCardURL  string
+	ExportURL string
 }
 
 // contactFromRequest retrieves a contact from the database given a contact ID in the
@@ -166,7 +275,7 @@ func editFormHandler(w http.ResponseWriter, r *http.Request) *appError {
 }
 
 // contactFromForm populates the fields of a Contact from form values
-// (see templates/edit.html).
+// (see templates/edit.html), checked like the JSON API does (see api.go).
 func contactFromForm(r *http.Request) (*contacts.Contact, error) {
 	/* imageURL, err := uploadFileFromForm(r)
 	if err != nil {
@@ -182,6 +291,7 @@ func contactFromForm(r *http.Request) (*contacts.Contact, error) {
 		Address:        r.FormValue("address"),
 		Email:          r.FormValue("email"),
 		Phone:          r.FormValue("phone"),
+		Tags:           r.FormValue("tags"),
 		CreatedBy:      r.FormValue("createdBy"),
 		CreatedByID:    r.FormValue("createdByID"),
 	}
@@ -200,6 +310,9 @@ func contactFromForm(r *http.Request) (*contacts.Contact, error) {
 		}
 	}
 
+	if err := contact.Validate(); err != nil {
+		return nil, err
+	}
 	return contact, nil
 }
 
@@ -207,7 +320,7 @@ func contactFromForm(r *http.Request) (*contacts.Contact, error) {
 func createHandler(w http.ResponseWriter, r *http.Request) *appError {
 	contact, err := contactFromForm(r)
 	if err != nil {
-		return appErrorf(err, "could not parse contact from form: %v", err)
+		return &appError{err, fmt.Sprintf("could not parse contact from form: %v", err), http.StatusBadRequest}
 	}
 	id, err := contacts.DB.AddContact(contact)
 	if err != nil {
@@ -227,7 +340,7 @@ func updateHandler(w http.ResponseWriter, r *http.Request) *appError {
 
 	contact, err := contactFromForm(r)
 	if err != nil {
-		return appErrorf(err, "could not parse contact from form: %v", err)
+		return &appError{err, fmt.Sprintf("could not parse contact from form: %v", err), http.StatusBadRequest}
 	}
 	contact.ID = id
 
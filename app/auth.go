@@ -180,6 +180,11 @@ type Profile struct {
 	ID, DisplayName, ImageURL string
 }
 
+// apiUser returns p as the caller of the APIs, see contacts.NewContext.
+func (p *Profile) apiUser() *contacts.User {
+	return &contacts.User{ID: p.ID, Name: p.DisplayName, ImageURL: p.ImageURL}
+}
+
 // stripProfile returns a subset of a plus.Person.
 func stripProfile(p *plus.Person) *Profile {
 	return &Profile{
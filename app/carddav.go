@@ -0,0 +1,211 @@
+// 2017.09.18 rjj.work@gmail.com: CardDAV address book of package carddav, for phones and desktops
+//	/carddav/		the account of the user, /.well-known/carddav redirects there
+//	/carddav/contacts/	the contacts the user created, a card each
+//	Address books only do HTTP Basic authentication: the password is an app password
+//	the user gets at /account/carddav, any user name goes. Bearer tokens work too.
+//	/account/carddav lists the app passwords by the labels the user gave them, to revoke them.
+//	Its forms post back a nonce of the session, like the consent form of oauth_server.go.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/satori/go.uuid"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/carddav"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// cardDAVPrefix is where the address books are served.
+const cardDAVPrefix = "/carddav"
+
+// cardDAVNonceSessionKey is the default session key of the nonce the app
+// password forms must post back, like the consent form of oauth_server.go.
+const cardDAVNonceSessionKey = "carddav_nonce"
+
+var cardDAVTmpl = parseTemplate("carddav.html")
+
+// registerCardDAVHandlers adds the CardDAV server of contacts.DB to r, and
+// the page of the app passwords.
+func registerCardDAVHandlers(r *mux.Router) {
+	h := cardDAVAuth(carddav.NewHandler(contacts.DB, contacts.CardDAVNames, cardDAVPrefix))
+
+	// RFC 6764, whatever the method
+	r.Path("/.well-known/carddav").
+		Handler(http.RedirectHandler(cardDAVPrefix+"/", http.StatusMovedPermanently))
+	r.Methods("OPTIONS", "PROPFIND").Path(cardDAVPrefix + "/").
+		Handler(h)
+	r.Methods("OPTIONS", "PROPFIND", "REPORT").Path(cardDAVPrefix + "/contacts/").
+		Handler(h)
+	r.Methods("OPTIONS", "PROPFIND", "GET", "HEAD", "PUT", "DELETE").Path(cardDAVPrefix + "/contacts/{card}").
+		Handler(h)
+
+	r.Methods("GET").Path("/account/carddav").
+		Handler(appHandler(cardDAVAccountHandler))
+	r.Methods("POST").Path("/account/carddav").
+		Handler(appHandler(cardDAVPasswordHandler))
+	r.Methods("POST").Path("/account/carddav/revoke").
+		Handler(appHandler(cardDAVRevokeHandler))
+}
+
+// cardDAVAuth serves h for the user of an app password or of a bearer token.
+func cardDAVAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := cardDAVUser(r)
+		if user == nil {
+			if err != nil {
+				log.Printf("CardDAV: authentication failed: %v", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="contacts"`)
+			http.Error(w, "an app password is required, see /account/carddav", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(contacts.NewContext(r.Context(), user.apiUser())))
+	})
+}
+
+// cardDAVUser returns the user of the app password of r, or of its bearer
+// token, nil for neither.
+func cardDAVUser(r *http.Request) (*Profile, error) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return userFromToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	}
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if contacts.OAuthServer == nil {
+		return nil, errNoOAuthServer
+	}
+	g, err := contacts.OAuthServer.ValidateAppPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &Profile{ID: g.UserID, DisplayName: g.UserName}, nil
+}
+
+// cardDAVAccount is the data of carddav.html.
+type cardDAVAccount struct {
+	// URL is the server to set up the address book with.
+	URL, UserName string
+	// Password is an app password just issued, shown once.
+	Password string
+	// AppPasswords are those of the user, nil without an authorization server.
+	AppPasswords []*oauthserver.Token
+	// Nonce is posted back by the forms, see checkCardDAVNonce.
+	Nonce string
+}
+
+// newCardDAVAccount returns the account of user on the server of r, with
+// their app passwords and a new nonce for the forms.
+func newCardDAVAccount(w http.ResponseWriter, r *http.Request, user *Profile) (*cardDAVAccount, error) {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	account := &cardDAVAccount{URL: scheme + "://" + r.Host + cardDAVPrefix + "/", UserName: user.ID}
+	if contacts.OAuthServer != nil {
+		var err error
+		if account.AppPasswords, err = contacts.OAuthServer.AppPasswords(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	session, err := contacts.SessionStore.Get(r, defaultSessionID)
+	if err != nil {
+		return nil, err
+	}
+	account.Nonce = uuid.NewV4().String()
+	session.Values[cardDAVNonceSessionKey] = account.Nonce
+	if err := session.Save(r, w); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// checkCardDAVNonce makes sure r posts the nonce of the page the user was
+// shown, so other sites can't issue or revoke app passwords on their behalf.
+// The nonce stays good until the next page, every form posts to one.
+func checkCardDAVNonce(r *http.Request) *appError {
+	session, err := contacts.SessionStore.Get(r, defaultSessionID)
+	if err != nil {
+		return appErrorf(err, "could not get default session: %v", err)
+	}
+	nonce, ok := session.Values[cardDAVNonceSessionKey].(string)
+	if !ok || nonce == "" || nonce != r.PostFormValue("nonce") {
+		return &appError{errors.New("app password nonce mismatch"), "Invalid form, please try again.", http.StatusForbidden}
+	}
+	return nil
+}
+
+// cardDAVAccountHandler shows how to set up the address book.
+func cardDAVAccountHandler(w http.ResponseWriter, r *http.Request) *appError {
+	user := profileFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect="+r.URL.RequestURI(), http.StatusFound)
+		return nil
+	}
+	account, err := newCardDAVAccount(w, r, user)
+	if err != nil {
+		return appErrorf(err, "could not list app passwords: %v", err)
+	}
+	return cardDAVTmpl.Execute(w, r, account)
+}
+
+// cardDAVPasswordHandler issues an app password to the logged in user.
+func cardDAVPasswordHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if contacts.OAuthServer == nil {
+		return &appError{errNoOAuthServer, "Not Found", http.StatusNotFound}
+	}
+	user := profileFromSession(r)
+	if user == nil {
+		return &appError{errors.New("not logged in"), "Please log in and try again.", http.StatusForbidden}
+	}
+	if e := checkCardDAVNonce(r); e != nil {
+		return e
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = "CardDAV"
+	}
+	password, err := contacts.OAuthServer.IssueAppPassword(oauthserver.Grant{
+		ClientID: "carddav",
+		UserID:   user.ID,
+		UserName: user.DisplayName,
+	}, label)
+	if err != nil {
+		return appErrorf(err, "could not issue app password: %v", err)
+	}
+	account, err := newCardDAVAccount(w, r, user)
+	if err != nil {
+		return appErrorf(err, "could not list app passwords: %v", err)
+	}
+	account.Password = password
+	return cardDAVTmpl.Execute(w, r, account)
+}
+
+// cardDAVRevokeHandler revokes the app password of the logged in user with
+// the ID of the form, and goes back to the list.
+func cardDAVRevokeHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if contacts.OAuthServer == nil {
+		return &appError{errNoOAuthServer, "Not Found", http.StatusNotFound}
+	}
+	user := profileFromSession(r)
+	if user == nil {
+		return &appError{errors.New("not logged in"), "Please log in and try again.", http.StatusForbidden}
+	}
+	if e := checkCardDAVNonce(r); e != nil {
+		return e
+	}
+	if err := contacts.OAuthServer.RevokeAppPassword(user.ID, r.FormValue("id")); err != nil {
+		return appErrorf(err, "could not revoke app password: %v", err)
+	}
+	http.Redirect(w, r, "/account/carddav", http.StatusFound)
+	return nil
+}
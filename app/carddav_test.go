@@ -0,0 +1,154 @@
+// 2017.09.18 rjj.work@gmail.com: Tests of the CardDAV authentication and routes, see carddav.go
+//	CONTACTS_DB=memory go test -run TestCardDAV
+//	The address books themselves are tested in package carddav.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+func TestCardDAV(t *testing.T) {
+	h := vcardTestRouter(t)
+	defer func(s *oauthserver.Server) { contacts.OAuthServer = s }(contacts.OAuthServer)
+	contacts.OAuthServer = &oauthserver.Server{Store: oauthserver.NewMemoryStore()}
+	password, err := contacts.OAuthServer.IssueAppPassword(oauthserver.Grant{ClientID: "carddav", UserID: "1234", UserName: "Jane Doe"}, "Phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PROPFIND", "/.well-known/carddav", nil))
+	if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != "/carddav/" {
+		t.Errorf("well-known: got %d %q, want a redirect to /carddav/", w.Code, got)
+	}
+
+	propfind := func(password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PROPFIND", "/carddav/contacts/", nil)
+		r.Header.Set("Depth", "1")
+		if password != "" {
+			r.SetBasicAuth("jane", password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for _, p := range []string{"", "wrong"} {
+		w := propfind(p)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("password %q: got %d, want 401", p, w.Code)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="contacts"` {
+			t.Errorf("password %q: got WWW-Authenticate %q", p, got)
+		}
+	}
+	w = propfind(password)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("got %d, want 207: %s", w.Code, w.Body)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<d:href>/carddav/contacts/5.vcf</d:href>") ||
+		strings.Contains(body, "/carddav/contacts/1.vcf") {
+		t.Errorf("got %s, want only the card of contact 5", body)
+	}
+
+	list, err := contacts.OAuthServer.AppPasswords("1234")
+	if err != nil || len(list) != 1 || list[0].Label != "Phone" {
+		t.Fatalf("got %+v %v, want the Phone app password", list, err)
+	}
+	contacts.OAuthServer.RevokeAppPassword("1234", list[0].ID)
+	if w := propfind(password); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked: got %d, want 401", w.Code)
+	}
+	if w := get(h, "/account/carddav"); w.Code != http.StatusFound {
+		t.Errorf("account: got %d, want a redirect to log in", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/account/carddav/revoke", strings.NewReader("id="+list[0].ID)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("revoke: got %d, want 403 to log in", w.Code)
+	}
+}
+
+// nonceRE finds the nonce of the app password forms.
+var nonceRE = regexp.MustCompile(`name="nonce" value="([^"]*)"`)
+
+func TestCardDAVAppPasswordForms(t *testing.T) {
+	h := vcardTestRouter(t)
+	defer func(s *oauthserver.Server) { contacts.OAuthServer = s }(contacts.OAuthServer)
+	contacts.OAuthServer = &oauthserver.Server{Store: oauthserver.NewMemoryStore()}
+
+	// Jane Doe logged in
+	r := httptest.NewRequest("GET", "/", nil)
+	session, err := contacts.SessionStore.New(r, defaultSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values[oauthTokenSessionKey] = &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}
+	session.Values[googleProfileSessionKey] = &Profile{ID: "1234", DisplayName: "Jane Doe"}
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+
+	// do sends the request with the session cookies, and keeps those of the
+	// response, returning the nonce of the page.
+	do := func(method, path, form string) (*httptest.ResponseRecorder, string) {
+		r := httptest.NewRequest(method, path, strings.NewReader(form))
+		if form != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if c := w.Result().Cookies(); len(c) > 0 {
+			cookies = c
+		}
+		var nonce string
+		if m := nonceRE.FindStringSubmatch(w.Body.String()); m != nil {
+			nonce = m[1]
+		}
+		return w, nonce
+	}
+
+	w, nonce := do("GET", "/account/carddav", "")
+	if w.Code != http.StatusOK || nonce == "" {
+		t.Fatalf("account: got %d, nonce %q, want 200 and a nonce: %s", w.Code, nonce, w.Body)
+	}
+	// Another site can post the form, but not with the nonce
+	for _, form := range []string{"label=Phone", "label=Phone&nonce=wrong"} {
+		if w, _ := do("POST", "/account/carddav", form); w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", form, w.Code)
+		}
+	}
+	w, nonce = do("POST", "/account/carddav", "label=Phone&nonce="+nonce)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Your new app password") {
+		t.Fatalf("new app password: got %d: %s", w.Code, w.Body)
+	}
+	list, err := contacts.OAuthServer.AppPasswords("1234")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("got %+v %v, want the Phone app password", list, err)
+	}
+
+	if w, _ := do("POST", "/account/carddav/revoke", "id="+list[0].ID); w.Code != http.StatusForbidden {
+		t.Errorf("revoke without nonce: got %d, want 403", w.Code)
+	}
+	if w, _ := do("POST", "/account/carddav/revoke", "id="+list[0].ID+"&nonce="+nonce); w.Code != http.StatusFound {
+		t.Errorf("revoke: got %d, want a redirect to the list: %s", w.Code, w.Body)
+	}
+	if list, err := contacts.OAuthServer.AppPasswords("1234"); err != nil || len(list) != 0 {
+		t.Errorf("revoked: got %+v %v, want none", list, err)
+	}
+}
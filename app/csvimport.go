@@ -0,0 +1,155 @@
+// 2017.09.16 rjj.work@gmail.com: CSV import of contacts, see package contactscsv
+//	GET /contacts/import/csv	the upload form
+//	POST /contacts/import/csv	with the file: its columns, mapped by the header, and a preview of the rows as contacts
+//		with action=preview and the columns remapped by the user: the preview again, nothing is added
+//		with action=import: the rows without a problem are added, csvBatch at a time
+//	The file is sent back and forth in the form, nothing is kept between the steps.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/contactscsv"
+)
+
+const (
+	// csvBatch is how many contacts are added at a time.
+	csvBatch = 100
+	// csvPreviewRows is how many rows the preview shows, besides those
+	// with a problem, which are all shown.
+	csvPreviewRows = 20
+)
+
+var importCSVTmpl = parseTemplate("import_csv.html")
+
+// csvPreview is the data of import_csv.html.
+type csvPreview struct {
+	Filename string
+	// Data is the file, for the next step
+	Data string
+	// Layout is the export detected, "" if none
+	Layout  string
+	Header  []string
+	Mapping contactscsv.Mapping
+	Fields  []string
+	// MappingErr is why the mapping can't be used
+	MappingErr string
+
+	Rows     []contactscsv.Row
+	Valid    int
+	Invalid  int
+	NotShown int
+	// After action=import
+	Imported bool
+	Added    int
+	// ImportErr is why the rest of the rows weren't added
+	ImportErr string
+}
+
+// importCSVFormHandler displays the upload form.
+func importCSVFormHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return importCSVTmpl.Execute(w, r, nil)
+}
+
+// importCSVHandler previews the contacts of a CSV file, uploaded or sent
+// back by the preview, and adds them with action=import.
+func importCSVHandler(w http.ResponseWriter, r *http.Request) *appError {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImport)
+	if err := r.ParseMultipartForm(maxImport); err != nil && err != http.ErrNotMultipart {
+		return &appError{err, fmt.Sprintf("could not read the form: %v", err), http.StatusBadRequest}
+	}
+
+	p := &csvPreview{Fields: contactscsv.Fields}
+	var remapped contactscsv.Mapping
+	if file, header, err := r.FormFile("csv"); err == nil {
+		defer file.Close()
+		b, err := ioutil.ReadAll(file)
+		if err != nil {
+			return &appError{err, fmt.Sprintf("could not read the uploaded file: %v", err), http.StatusBadRequest}
+		}
+		p.Filename, p.Data = header.Filename, string(b)
+	} else {
+		p.Filename, p.Data = r.FormValue("filename"), r.FormValue("data")
+		remapped = contactscsv.Mapping(r.Form["column"])
+	}
+
+	f, err := contactscsv.Read(strings.NewReader(p.Data))
+	if err != nil {
+		return &appError{err, fmt.Sprintf("could not read %s: %v", p.Filename, err), http.StatusBadRequest}
+	}
+	layout, mapping := contactscsv.Detect(f.Header)
+	if layout != nil {
+		p.Layout = layout.Name
+	}
+	if remapped != nil {
+		mapping = remapped
+	}
+	p.Header, p.Mapping = f.Header, mapping
+	if err := mapping.Check(len(f.Header)); err != nil {
+		p.MappingErr = strings.TrimPrefix(err.Error(), "contactscsv: ")
+		return importCSVTmpl.Execute(w, r, p)
+	}
+
+	rows := f.Contacts(mapping)
+	for i, row := range rows {
+		if row.Err != nil {
+			p.Invalid++
+		} else {
+			p.Valid++
+		}
+		if row.Err != nil || i < csvPreviewRows {
+			p.Rows = append(p.Rows, row)
+		}
+	}
+	p.NotShown = len(rows) - len(p.Rows)
+
+	if r.FormValue("action") == "import" {
+		p.Imported = true
+		p.Added, err = addRows(rows, r)
+		if err != nil {
+			p.ImportErr = err.Error()
+		}
+	}
+	return importCSVTmpl.Execute(w, r, p)
+}
+
+// addRows adds the contacts of the rows without a problem, for the logged in
+// user, csvBatch at a time. It returns how many were added before an error.
+func addRows(rows []contactscsv.Row, r *http.Request) (int, error) {
+	user := profileFromSession(r)
+	var batch []*contacts.Contact
+	added := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := contacts.DB.AddContacts(batch); err != nil {
+			return fmt.Errorf("could not save the rows after the first %d added: %v", added, err)
+		}
+		added += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for _, row := range rows {
+		if row.Err != nil {
+			continue
+		}
+		c := row.Contact
+		if user != nil {
+			c.CreatedBy, c.CreatedByID = user.DisplayName, user.ID
+		} else {
+			c.SetCreatorAnonymous()
+		}
+		if batch = append(batch, c); len(batch) == csvBatch {
+			if err := flush(); err != nil {
+				return added, err
+			}
+		}
+	}
+	return added, flush()
+}
@@ -0,0 +1,78 @@
+// 2017.09.16 rjj.work@gmail.com: Tests of the CSV import, see csvimport.go
+//	CONTACTS_DB=memory go test -run TestCSV
+//	The mapping of the columns is tested in package contactscsv.
+
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+const importCSV = "Surname,Name,E-mail,Nickname\n" +
+	"Flanders,Ned,ned@example.com,Neddie\n" +
+	"Flanders,Rod,rod,\n" +
+	"Flanders,Todd,,\n"
+
+func postCSV(h http.Handler, r *http.Request) string {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		return w.Result().Status
+	}
+	return w.Body.String()
+}
+
+func TestCSVImport(t *testing.T) {
+	h := vcardTestRouter(t)
+
+	// The upload previews the rows, "Name" is no known column
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("csv", "flanders.csv")
+	fw.Write([]byte(importCSV))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/contacts/import/csv", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	page := postCSV(h, r)
+	for _, want := range []string{
+		"Preview: 2 contacts, 1 rows with a problem",
+		"<td>Flanders</td>",
+		"invalid contact: email: not an email address",
+		`<option selected>LastName</option>`,
+		`name="data" value="Surname,Name,`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("preview: got %s, want %q", page, want)
+		}
+	}
+
+	form := func(action string, columns ...string) *http.Request {
+		v := url.Values{"filename": {"flanders.csv"}, "data": {importCSV}, "column": columns, "action": {action}}
+		r := httptest.NewRequest("POST", "/contacts/import/csv", strings.NewReader(v.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	if page := postCSV(h, form("preview", "", "", "Email", "")); !strings.Contains(page, "map a column onto FirstName or LastName") {
+		t.Errorf("no name: got %s", page)
+	}
+	if n, _ := contacts.DB.TallyContacts(); n != 5 {
+		t.Fatalf("previews added contacts, got %d, want 5", n)
+	}
+
+	page = postCSV(h, form("import", "LastName", "FirstName", "", ""))
+	if want := "flanders.csv: 3 added, 0 rows with a problem left out"; !strings.Contains(page, want) {
+		t.Errorf("import: got %s, want %q", page, want)
+	}
+	found, err := contacts.DB.FindContactByName("Rod", "Flanders")
+	if err != nil || len(found) != 1 || found[0].Email != "" || found[0].CreatedByID != "anonymous" {
+		t.Errorf("got %v %v, want Rod without his email", found, err)
+	}
+}
@@ -0,0 +1,223 @@
+// 2017.09.17 rjj.work@gmail.com: Export of the contacts as CSV or newline delimited JSON
+//	GET /contacts/export		all the contacts
+//	GET /contacts/mine/export	those of the logged in user
+//	?format=csv (the default) or ndjson, one JSON object a line, named like the REST API names them
+//	?columns=firstName,lastName,email picks and orders the columns, all of exportColumns by default
+//	?name= tag= city= ... filter like the REST API does, see queryCriteria, e.g. a search result or a tag
+//	The contacts are written as the database reads them (ContactDatabase.ForEachContact), not listed first.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/contactscsv"
+)
+
+// exportColumn is a column of the export, of a contact as the REST API returns it.
+type exportColumn struct {
+	name  string
+	value func(c *apiContact) interface{}
+}
+
+// exportColumns are the columns of the export, in their default order.
+var exportColumns = []exportColumn{
+	{"id", func(c *apiContact) interface{} { return c.ID }},
+	{"firstName", func(c *apiContact) interface{} { return c.FirstName }},
+	{"lastName", func(c *apiContact) interface{} { return c.LastName }},
+	{"address", func(c *apiContact) interface{} { return c.Address }},
+	{"email", func(c *apiContact) interface{} { return c.Email }},
+	{"phone", func(c *apiContact) interface{} { return c.Phone }},
+	{"tags", func(c *apiContact) interface{} { return c.Tags }},
+	{"createdBy", func(c *apiContact) interface{} { return c.CreatedBy }},
+	{"createdDate", func(c *apiContact) interface{} { return c.CreatedDate }},
+	{"lastEdited", func(c *apiContact) interface{} { return c.LastEdited }},
+}
+
+// exportColumnsOf returns the columns named in a comma separated list, all
+// of them for "".
+func exportColumnsOf(list string) ([]exportColumn, *appError) {
+	if list == "" {
+		return exportColumns, nil
+	}
+	var columns []exportColumn
+next:
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		for _, col := range exportColumns {
+			if col.name == name {
+				columns = append(columns, col)
+				continue next
+			}
+		}
+		names := make([]string, len(exportColumns))
+		for i, col := range exportColumns {
+			names[i] = col.name
+		}
+		return nil, &appError{nil, fmt.Sprintf("unknown column %q, the columns are %s", name, strings.Join(names, ",")), http.StatusBadRequest}
+	}
+	return columns, nil
+}
+
+// exportWriter writes contacts in a format of the export.
+type exportWriter interface {
+	write(c *apiContact) error
+	// flush writes what is buffered, and returns the first error of a write.
+	flush() error
+}
+
+// csvExportWriter writes a header, then a row a contact. The tags are comma
+// separated, like the web form has them.
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []exportColumn
+	row     []string
+}
+
+func newCSVExportWriter(w io.Writer, columns []exportColumn) *csvExportWriter {
+	ew := &csvExportWriter{w: csv.NewWriter(w), columns: columns, row: make([]string, len(columns))}
+	for i, col := range columns {
+		ew.row[i] = col.name
+	}
+	ew.w.Write(ew.row)
+	return ew
+}
+
+func (ew *csvExportWriter) write(c *apiContact) error {
+	for i, col := range ew.columns {
+		switch v := col.value(c).(type) {
+		case string:
+			ew.row[i] = contactscsv.EscapeFormula(v)
+		case int64:
+			ew.row[i] = strconv.FormatInt(v, 10)
+		case []string:
+			ew.row[i] = contactscsv.EscapeFormula(strings.Join(v, ","))
+		}
+	}
+	return ew.w.Write(ew.row)
+}
+
+func (ew *csvExportWriter) flush() error {
+	ew.w.Flush()
+	return ew.w.Error()
+}
+
+// ndjsonExportWriter writes a JSON object a line, its fields in the order of
+// the columns.
+type ndjsonExportWriter struct {
+	w       *bufio.Writer
+	columns []exportColumn
+	names   [][]byte
+	err     error
+}
+
+func newNDJSONExportWriter(w io.Writer, columns []exportColumn) *ndjsonExportWriter {
+	ew := &ndjsonExportWriter{w: bufio.NewWriter(w), columns: columns, names: make([][]byte, len(columns))}
+	for i, col := range columns {
+		ew.names[i], _ = json.Marshal(col.name)
+	}
+	return ew
+}
+
+func (ew *ndjsonExportWriter) write(c *apiContact) error {
+	if ew.err != nil {
+		return ew.err
+	}
+	ew.w.WriteByte('{')
+	for i, col := range ew.columns {
+		if i > 0 {
+			ew.w.WriteByte(',')
+		}
+		v, err := json.Marshal(col.value(c))
+		if err != nil {
+			ew.err = err
+			return err
+		}
+		ew.w.Write(ew.names[i])
+		ew.w.WriteByte(':')
+		ew.w.Write(v)
+	}
+	_, ew.err = ew.w.WriteString("}\n")
+	return ew.err
+}
+
+func (ew *ndjsonExportWriter) flush() error {
+	if ew.err != nil {
+		return ew.err
+	}
+	return ew.w.Flush()
+}
+
+// exportHandler exports all the contacts.
+func exportHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return export(w, r, "", "contacts")
+}
+
+// exportMineHandler exports the contacts of the logged in user.
+func exportMineHandler(w http.ResponseWriter, r *http.Request) *appError {
+	user := profileFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect="+r.URL.RequestURI(), http.StatusFound)
+		return nil
+	}
+	return export(w, r, user.ID, "my-contacts")
+}
+
+// export writes the contacts of userID, or of all users for "", matching the
+// query string of r, in the file name.csv or name.ndjson.
+func export(w http.ResponseWriter, r *http.Request, userID, name string) *appError {
+	q := r.URL.Query()
+	criteria, e := queryCriteria(q)
+	if e != nil {
+		return e
+	}
+	criteria.CreatedByID, criteria.AllUsers = userID, userID == ""
+	columns, e := exportColumnsOf(q.Get("columns"))
+	if e != nil {
+		return e
+	}
+
+	var ew exportWriter
+	switch format := q.Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		name += ".csv"
+		ew = newCSVExportWriter(w, columns)
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		name += ".ndjson"
+		ew = newNDJSONExportWriter(w, columns)
+	default:
+		return &appError{nil, fmt.Sprintf("unsupported format %q, use csv or ndjson", format), http.StatusBadRequest}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	n := 0
+	err := contacts.DB.ForEachContact(criteria, func(c *contacts.Contact) error {
+		n++
+		ac := newAPIContact(c)
+		return ew.write(&ac)
+	})
+	if err != nil && n == 0 {
+		// Nothing was sent yet, the writers buffer more than the CSV header
+		w.Header().Del("Content-Disposition")
+		return appErrorf(err, "could not export contacts: %v", err)
+	}
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		// Too late for an error page, the file is cut short
+		log.Printf("Could not export contacts after %d: %v", n, err)
+	}
+	return nil
+}
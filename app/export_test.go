@@ -0,0 +1,71 @@
+// 2017.09.17 rjj.work@gmail.com: Tests of the CSV and NDJSON export, see export.go
+//	CONTACTS_DB=memory go test -run TestExport
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	h := vcardTestRouter(t)
+
+	tests := []struct {
+		path, contentType, disposition, body string
+	}{
+		{"/contacts/export?columns=firstName,lastName,tags&name=john",
+			"text/csv; charset=utf-8", `attachment; filename="contacts.csv"`,
+			"firstName,lastName,tags\nJohn,Smith,work\nJohn,Smith,\n"},
+		{"/contacts/export?format=ndjson&columns=lastName,id,tags&name=simpson",
+			"application/x-ndjson", `attachment; filename="contacts.ndjson"`,
+			`{"lastName":"Simpson","id":1,"tags":["family","work"]}` + "\n" + `{"lastName":"Simpson","id":2,"tags":["family"]}` + "\n"},
+		{"/contacts/export?format=ndjson&columns=id&tag=nobody",
+			"application/x-ndjson", `attachment; filename="contacts.ndjson"`, ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got %d %s", tt.path, w.Code, w.Body)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.contentType)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.disposition)
+		}
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.body)
+		}
+	}
+
+	for _, path := range []string{
+		"/contacts/export?format=xml",
+		"/contacts/export?columns=firstName,createdById",
+		"/contacts/export?missing=address",
+	} {
+		if w := get(h, path); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", path, w.Code)
+		}
+	}
+	if w := get(h, "/contacts/mine/export?format=ndjson"); w.Code != http.StatusFound {
+		t.Errorf("mine: got %d, want a redirect to log in", w.Code)
+	}
+}
+
+func TestExportCSVFormula(t *testing.T) {
+	var b bytes.Buffer
+	ew := newCSVExportWriter(&b, exportColumns[:7])
+	ew.write(&apiContact{ID: 7, FirstName: "=HYPERLINK(\"http://example.com\")", LastName: "@SUM(A1)",
+		Address: "-2+3", Phone: "+1 407 555 0100", Email: "bart@example.com", Tags: []string{"=1+1"}})
+	if err := ew.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,firstName,lastName,address,email,phone,tags\n" +
+		`7,"'=HYPERLINK(""http://example.com"")",'@SUM(A1),'-2+3,bart@example.com,+1 407 555 0100,'=1+1` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
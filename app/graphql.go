@@ -0,0 +1,28 @@
+// 2017.09.14 rjj.work@gmail.com: GraphQL API of package contactsgraphql, for the frontend
+//	POST /graphql	{"query", "operationName", "variables"}, see contactsgraphql.Schema
+//	Calls are authenticated like the REST API (api.go), with an access token or the login session.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/contactsgraphql"
+)
+
+// registerGraphQLHandler adds the GraphQL API of contacts.DB to r.
+func registerGraphQLHandler(r *mux.Router) {
+	r.Methods("POST").Path("/graphql").
+		Handler(apiHandler(graphQLHandler(contactsgraphql.NewHandler(contacts.DB))))
+}
+
+// graphQLHandler serves the GraphQL API h for the user of the call.
+func graphQLHandler(h http.Handler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request, user *Profile) *appError {
+		h.ServeHTTP(w, r.WithContext(contacts.NewContext(r.Context(), user.apiUser())))
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+// 2017.09.14 rjj.work@gmail.com: Tests of the GraphQL API route, see graphql.go
+//	CONTACTS_DB=memory go test -run TestGraphQL
+//	The queries themselves are tested in package contactsgraphql.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGraphQL(t *testing.T) {
+	srv, token := apiTestServer(t)
+	defer srv.Close()
+
+	const query = `{"query": "{ me { displayName } contacts { totalCount nodes { id firstName } } }"}`
+	var body struct{ Error apiError }
+	if resp := apiCall(t, srv, "", "POST", "/graphql", query, &body); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: got %d %+v, want 401", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Data struct {
+			Me       struct{ DisplayName string }
+			Contacts struct {
+				TotalCount int
+				Nodes      []struct{ ID, FirstName string }
+			}
+		}
+		Errors []struct{ Message string }
+	}
+	if resp := apiCall(t, srv, token, "POST", "/graphql", query, &result); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want 200", resp.StatusCode)
+	}
+	if len(result.Errors) != 0 {
+		t.Fatal(result.Errors)
+	}
+	if got, want := result.Data.Me.DisplayName, "Jane Doe"; got != want {
+		t.Errorf("me: got %q, want %q", got, want)
+	}
+	if c := result.Data.Contacts; c.TotalCount != 1 || len(c.Nodes) != 1 || c.Nodes[0].ID != "5" {
+		t.Errorf("got %+v, want Jane's contact 5 only", c)
+	}
+}
@@ -0,0 +1,36 @@
+// 2017.09.13 rjj.work@gmail.com: gRPC ContactService of package contactsgrpc, for the backend services
+//	Served on GRPC_ADDR (see config.go), next to the HTTP routes of registerHandlers.
+//	Callers send the access tokens of linked accounts, like the webhooks, as "authorization: Bearer <token>".
+
+package main
+
+import (
+	"log"
+	"net"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/contactsgrpc"
+)
+
+// serveGRPC serves the ContactService on addr, the app stops when it can't.
+func serveGRPC(addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("gRPC: %v", err)
+	}
+	s := contactsgrpc.NewServer(&contactsgrpc.Service{DB: contacts.DB}, grpcUser)
+	log.Printf("gRPC ContactService listening on %s", addr)
+	log.Fatalf("gRPC: %v", s.Serve(lis))
+}
+
+// grpcUser is the contactsgrpc.Authenticator of the access tokens userFromToken knows.
+func grpcUser(token string) (*contacts.User, error) {
+	profile, err := userFromToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, nil
+	}
+	return profile.apiUser(), nil
+}
@@ -0,0 +1,76 @@
+// 2017.09.20 rjj.work@gmail.com: The contacts as an LDAP directory, for office printers and VoIP phones, see package contactsldap
+//	Served read-only on LDAP_ADDR (see config.go), next to the HTTP routes of registerHandlers,
+//	to the clients that bind as LDAP_BIND_DN, with the contacts of LDAP_USER only.
+//	GET /contacts.ldif, /contacts/mine.ldif	the same entries as LDIF, ?name= tag= city= ... filter like the export
+//	The entries are inetOrgPerson, under LDAP_BASE_DN: uid=<id>,ou=contacts,dc=example,dc=com by default.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/contactsldap"
+)
+
+// serveLDAP serves the directory on cfg.Addr, the app stops when it can't.
+func serveLDAP(cfg *contacts.LDAPConfig) {
+	s := &contactsldap.Server{
+		DB:           contacts.DB,
+		BaseDN:       cfg.BaseDN,
+		BindDN:       cfg.BindDN,
+		BindPassword: cfg.BindPassword,
+		UserID:       cfg.UserID,
+	}
+	log.Printf("LDAP directory %s listening on %s", s.BaseDN, cfg.Addr)
+	log.Fatalf("LDAP: %v", s.ListenAndServe(cfg.Addr))
+}
+
+// ldifListHandler downloads all the contacts as LDIF.
+func ldifListHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return writeLDIF(w, r, "", "contacts.ldif")
+}
+
+// ldifListMineHandler downloads the contacts of the logged in user as LDIF.
+func ldifListMineHandler(w http.ResponseWriter, r *http.Request) *appError {
+	user := profileFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect="+r.URL.RequestURI(), http.StatusFound)
+		return nil
+	}
+	return writeLDIF(w, r, user.ID, "my-contacts.ldif")
+}
+
+// writeLDIF writes the contacts of userID, or of all users for "", matching
+// the query string of r, as the LDIF attachment filename.
+func writeLDIF(w http.ResponseWriter, r *http.Request, userID, filename string) *appError {
+	criteria, e := queryCriteria(r.URL.Query())
+	if e != nil {
+		return e
+	}
+	criteria.CreatedByID, criteria.AllUsers = userID, userID == ""
+
+	w.Header().Set("Content-Type", "text/x-ldif; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	enc := contactsldap.NewEncoder(w)
+	n := 0
+	err := contacts.DB.ForEachContact(criteria, func(c *contacts.Contact) error {
+		n++
+		return enc.Encode(contactsldap.FromContact(c, contacts.LDAP.BaseDN))
+	})
+	if err != nil && n == 0 {
+		// Nothing was sent yet
+		w.Header().Del("Content-Disposition")
+		return appErrorf(err, "could not export contacts: %v", err)
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		// Too late for an error page, the file is cut short
+		log.Printf("Could not export contacts as LDIF after %d: %v", n, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// 2017.09.20 rjj.work@gmail.com: Tests of the LDIF export, the LDAP server is tested in package contactsldap
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+func TestLDIFExport(t *testing.T) {
+	h := vcardTestRouter(t)
+
+	w := get(h, "/contacts.ldif")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/x-ldif; charset=utf-8" {
+		t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="contacts.ldif"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "version: 1\n") {
+		t.Errorf("got %q, want the version first", body)
+	}
+	if got := strings.Count(body, "\ndn: "); got != 5 {
+		t.Errorf("all contacts: got %d entries, want 5", got)
+	}
+	homer := "dn: uid=1," + contacts.LDAP.BaseDN + "\n" +
+		"objectClass: top\n" +
+		"objectClass: person\n" +
+		"objectClass: organizationalPerson\n" +
+		"objectClass: inetOrgPerson\n" +
+		"uid: 1\n" +
+		"cn: Homer Simpson\n" +
+		"sn: Simpson\n" +
+		"givenName: Homer\n" +
+		"mail: homer.simpson@example.com\n" +
+		"telephoneNumber: 555-636-7890\n" +
+		"postalAddress: 742 Evergreen Terrace$Springfield\n"
+	if !strings.Contains(body, homer) {
+		t.Errorf("got %q, want %q", body, homer)
+	}
+
+	w = get(h, "/contacts.ldif?tag=family")
+	if got := strings.Count(w.Body.String(), "\ndn: "); got != 2 {
+		t.Errorf("tag=family: got %d entries, want 2", got)
+	}
+	if w = get(h, "/contacts.ldif?missing=fax"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad filter: got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w = get(h, "/contacts/mine.ldif"); w.Code != http.StatusFound {
+		t.Errorf("mine: got %d, want a redirect to log in", w.Code)
+	}
+}
@@ -0,0 +1,277 @@
+// 2017.08.29 rjj.work@gmail.com: Messages for the webhook responses, per language
+//	Keys are <intent>.<outcome>, or shared pieces like contact.* and card.*
+//	To add a language, register it the same way in init(), missing messages fall back to English
+
+package main
+
+import (
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+	"github.com/rjj-work/yum-contacts/ssml"
+)
+
+func init() {
+	catalog.Register("en", catalog.OneOther, map[string]catalog.Message{
+		"format.datetime": {Other: "January 2, 2006 at 3:04 PM"},
+		"format.date":     {Other: "January 2, 2006"},
+		"format.month":    {Other: "January 2006"},
+
+		"number_of_contacts.error":      {Other: "Error: tallying contacts, %v"},
+		"number_of_contacts.tally":      {One: "You have %d contact as of %s", Other: "You have %d contacts as of %s"},
+		"number_of_contacts.matching":   {One: "You have %d contact %s", Other: "You have %d contacts %s"},
+		"number_of_contacts.added":      {One: "You added %d contact %s", Other: "You added %d contacts %s"},
+		"number_of_contacts.bad_period": {Other: "Sorry, I didn't understand when. Try \"last month\" or \"since August 1\"."},
+
+		"period.today":      {Other: "today"},
+		"period.yesterday":  {Other: "yesterday"},
+		"period.this_week":  {Other: "this week"},
+		"period.last_week":  {Other: "last week"},
+		"period.this_month": {Other: "this month"},
+		"period.last_month": {Other: "last month"},
+		"period.this_year":  {Other: "this year"},
+		"period.last_year":  {Other: "last year"},
+		"period.day":        {Other: "on %s"},
+		"period.since":      {Other: "since %s"},
+		"period.month":      {Other: "in %s"},
+		"period.year":       {Other: "in %d"},
+		"period.range":      {Other: "between %s and %s"},
+
+		"find_contact.error":     {Other: "Error looking up contact %s %s, %v"},
+		"find_contact.not_found": {Other: "No contact found for first name %s, last name: %s"},
+		"find_contact.found":     {Other: "Found:"},
+		"find_contact.first_of":  {One: "I found %d contact named %s %s:", Other: "I found %d contacts named %s %s. The first one:"},
+		"find_contact.which":     {Other: "I found %d contacts named %s %s, which one?"},
+
+		"select_contact.not_understood": {Other: "Sorry, I didn't catch which contact you meant"},
+		"select_contact.error":          {Other: "Error looking up contact %d, %v"},
+
+		"unimplemented": {One: "You have %d contact as of %s", Other: "You have %d contacts as of %s"},
+
+		"alexa.welcome": {Other: "Welcome to your contacts. Ask me how many contacts you have, or to find one by name."},
+		"alexa.help":    {Other: "You can ask how many contacts you have, find, add, update or delete a contact, or ask for someone's phone or email."},
+		"alexa.goodbye": {Other: "Goodbye"},
+
+		"slack.help": {Other: "Try %[1]s homer simpson, %[1]s homer, %[1]s count, %[1]s count tagged family or %[1]s add Ralph Jack ralph@example.com 407-555-0100"},
+		"slack.open": {Other: "Open"},
+
+		"phone.help":           {Other: "Text find and a name, e.g. find homer simpson, or count"},
+		"phone.unknown_number": {Other: "Sorry, this phone number can't look up contacts. Ask the office to add it."},
+		"phone.not_found":      {Other: "No contact named %s"},
+		"phone.more":           {Other: "and %d more, try the full name"},
+		"phone.error":          {Other: "Sorry, the contacts can't be looked up right now"},
+		"phone.voice_prompt":   {Other: "Say find and a name, or count."},
+		"phone.voice_again":    {Other: "Anything else?"},
+		"phone.goodbye":        {Other: "Goodbye"},
+
+		"add_contact.missing_name":      {Other: "What is the first name of the contact?"},
+		"add_contact.missing_last_name": {Other: "What is the last name of %s?"},
+		"add_contact.error":             {Other: "Error adding contact %s %s, %v"},
+		"add_contact.added":             {Other: "Added %s %s to your contacts"},
+
+		"update_contact.which":            {Other: "Which contact do you want to update?"},
+		"update_contact.nothing":          {Other: "What do you want to change for %s %s?"},
+		"update_contact.ask_phone-number": {Other: "What is the new phone number of %s %s?"},
+		"update_contact.ask_email":        {Other: "What is the new email of %s %s?"},
+		"update_contact.ask_address":      {Other: "What is the new address of %s %s?"},
+		"update_contact.error":            {Other: "Error updating contact, %v"},
+		"update_contact.updated":          {Other: "Updated %s %s"},
+
+		"slot.invalid_email": {Other: "%s doesn't look like an email address. What is the email?"},
+		"slot.invalid_phone": {Other: "%s doesn't look like a phone number. What is the phone number?"},
+
+		"delete_contact.which":   {Other: "Which contact do you want to delete?"},
+		"delete_contact.error":   {Other: "Error deleting contact, %v"},
+		"delete_contact.deleted": {Other: "Deleted %s %s from your contacts"},
+
+		"search_contacts.no_criteria": {Other: "Tell me a city, an email domain, part of a phone number or name, or a tag to look for"},
+		"search_contacts.error":       {Other: "Error searching contacts, %v"},
+		"search_contacts.none":        {Other: "I didn't find any contacts %s"},
+		"search_contacts.one":         {Other: "Your only contact %s:"},
+		"search_contacts.found":       {One: "You have %d contact %s: %s", Other: "You have %d contacts %s: %s"},
+
+		"criteria.name":          {Other: "whose name contains %s"},
+		"criteria.city":          {Other: "in %s"},
+		"criteria.email_domain":  {Other: "with an email at %s"},
+		"criteria.phone_prefix":  {Other: "with a phone number starting with %s"},
+		"criteria.phone_suffix":  {Other: "with a phone number ending in %s"},
+		"criteria.tag":           {Other: "tagged %s"},
+		"criteria.missing_email": {Other: "without an email"},
+		"criteria.missing_phone": {Other: "without a phone number"},
+
+		"list.and":  {Other: "%s and %s"},
+		"list.more": {Other: "%d more"},
+
+		"contact_field.which":           {Other: "Which contact do you mean?"},
+		"contact_field.which_field":     {Other: "What would you like to know about %s %s?"},
+		"contact_field.error":           {Other: "Error looking up contact, %v"},
+		"contact_field.phone":           {Other: "%s %s's phone number is"},
+		"contact_field.phone.unknown":   {Other: "I don't have a phone number for %s %s"},
+		"contact_field.email":           {Other: "%s %s's email is"},
+		"contact_field.email.unknown":   {Other: "I don't have an email for %s %s"},
+		"contact_field.address":         {Other: "%s %s lives at"},
+		"contact_field.address.unknown": {Other: "I don't have an address for %s %s"},
+		"contact_field.created":         {Other: "You added %s %s on %s"},
+		"contact_field.created.unknown": {Other: "I don't know when you added %s %s"},
+
+		"contact.details": {Other: "%s %s %s at address: %s, with phone number: %s and email: %s"},
+		"contact.address": {Other: "at address:"},
+		"contact.phone":   {Other: "with phone number:"},
+		"contact.email":   {Other: "and email:"},
+
+		"card.address":    {Other: "Address"},
+		"card.phone":      {Other: "Phone"},
+		"card.email":      {Other: "Email"},
+		"card.email_link": {Other: "Email %s"},
+		"card.list_title": {Other: "Contacts"},
+
+		"chip.call":       {Other: "Call"},
+		"chip.email":      {Other: "Email"},
+		"chip.edit_phone": {Other: "Edit phone"},
+
+		"ssml.at":         {Other: "at"},
+		"ssml.dot":        {Other: "dot"},
+		"ssml.dash":       {Other: "dash"},
+		"ssml.underscore": {Other: "underscore"},
+		"ssml.plus":       {Other: "plus"},
+	})
+
+	catalog.Register("fr", catalog.ZeroOneOther, map[string]catalog.Message{
+		"format.datetime": {Other: "02/01/2006 à 15h04"},
+		"format.date":     {Other: "02/01/2006"},
+		"format.month":    {Other: "01/2006"},
+
+		"number_of_contacts.error":      {Other: "Erreur lors du comptage des contacts, %v"},
+		"number_of_contacts.tally":      {One: "Vous avez %d contact au %s", Other: "Vous avez %d contacts au %s"},
+		"number_of_contacts.matching":   {One: "Vous avez %d contact %s", Other: "Vous avez %d contacts %s"},
+		"number_of_contacts.added":      {One: "Vous avez ajouté %d contact %s", Other: "Vous avez ajouté %d contacts %s"},
+		"number_of_contacts.bad_period": {Other: "Désolé, je n'ai pas compris quand. Essayez \"le mois dernier\" ou \"depuis le 1er août\"."},
+
+		"period.today":      {Other: "aujourd'hui"},
+		"period.yesterday":  {Other: "hier"},
+		"period.this_week":  {Other: "cette semaine"},
+		"period.last_week":  {Other: "la semaine dernière"},
+		"period.this_month": {Other: "ce mois-ci"},
+		"period.last_month": {Other: "le mois dernier"},
+		"period.this_year":  {Other: "cette année"},
+		"period.last_year":  {Other: "l'année dernière"},
+		"period.day":        {Other: "le %s"},
+		"period.since":      {Other: "depuis le %s"},
+		"period.month":      {Other: "en %s"},
+		"period.year":       {Other: "en %d"},
+		"period.range":      {Other: "entre le %s et le %s"},
+
+		"find_contact.error":     {Other: "Erreur lors de la recherche du contact %s %s, %v"},
+		"find_contact.not_found": {Other: "Aucun contact trouvé pour le prénom %s, nom : %s"},
+		"find_contact.found":     {Other: "Trouvé :"},
+		"find_contact.first_of":  {One: "J'ai trouvé %d contact nommé %s %s :", Other: "J'ai trouvé %d contacts nommés %s %s. Le premier :"},
+		"find_contact.which":     {Other: "J'ai trouvé %d contacts nommés %s %s, lequel ?"},
+
+		"select_contact.not_understood": {Other: "Désolé, je n'ai pas compris de quel contact il s'agit"},
+		"select_contact.error":          {Other: "Erreur lors de la recherche du contact %d, %v"},
+
+		"unimplemented": {One: "Vous avez %d contact au %s", Other: "Vous avez %d contacts au %s"},
+
+		"alexa.welcome": {Other: "Bienvenue dans vos contacts. Demandez-moi combien de contacts vous avez, ou d'en trouver un par son nom."},
+		"alexa.help":    {Other: "Vous pouvez demander combien de contacts vous avez, trouver, ajouter, modifier ou supprimer un contact, ou demander le téléphone ou l'e-mail de quelqu'un."},
+		"alexa.goodbye": {Other: "Au revoir"},
+
+		"slack.help": {Other: "Essayez %[1]s homer simpson, %[1]s homer, %[1]s count, %[1]s count tagged family ou %[1]s add Ralph Jack ralph@example.com 407-555-0100"},
+		"slack.open": {Other: "Ouvrir"},
+
+		"phone.help":           {Other: "Envoyez find et un nom, par exemple find homer simpson, ou count"},
+		"phone.unknown_number": {Other: "Désolé, ce numéro ne peut pas consulter les contacts. Demandez au bureau de l'ajouter."},
+		"phone.not_found":      {Other: "Aucun contact nommé %s"},
+		"phone.more":           {Other: "et %d autres, essayez le nom complet"},
+		"phone.error":          {Other: "Désolé, les contacts ne peuvent pas être consultés pour le moment"},
+		"phone.voice_prompt":   {Other: "Dites find et un nom, ou count."},
+		"phone.voice_again":    {Other: "Autre chose ?"},
+		"phone.goodbye":        {Other: "Au revoir"},
+
+		"add_contact.missing_name":      {Other: "Quel est le prénom du contact ?"},
+		"add_contact.missing_last_name": {Other: "Quel est le nom de famille de %s ?"},
+		"add_contact.error":             {Other: "Erreur lors de l'ajout du contact %s %s, %v"},
+		"add_contact.added":             {Other: "%s %s a été ajouté à vos contacts"},
+
+		"update_contact.which":            {Other: "Quel contact voulez-vous modifier ?"},
+		"update_contact.nothing":          {Other: "Que voulez-vous changer pour %s %s ?"},
+		"update_contact.ask_phone-number": {Other: "Quel est le nouveau numéro de téléphone de %s %s ?"},
+		"update_contact.ask_email":        {Other: "Quel est le nouvel e-mail de %s %s ?"},
+		"update_contact.ask_address":      {Other: "Quelle est la nouvelle adresse de %s %s ?"},
+		"update_contact.error":            {Other: "Erreur lors de la modification du contact, %v"},
+		"update_contact.updated":          {Other: "%s %s a été modifié"},
+
+		"slot.invalid_email": {Other: "%s ne ressemble pas à une adresse e-mail. Quel est l'e-mail ?"},
+		"slot.invalid_phone": {Other: "%s ne ressemble pas à un numéro de téléphone. Quel est le numéro ?"},
+
+		"delete_contact.which":   {Other: "Quel contact voulez-vous supprimer ?"},
+		"delete_contact.error":   {Other: "Erreur lors de la suppression du contact, %v"},
+		"delete_contact.deleted": {Other: "%s %s a été supprimé de vos contacts"},
+
+		"search_contacts.no_criteria": {Other: "Dites-moi une ville, un domaine d'e-mail, une partie d'un numéro ou d'un nom, ou une étiquette à chercher"},
+		"search_contacts.error":       {Other: "Erreur lors de la recherche des contacts, %v"},
+		"search_contacts.none":        {Other: "Je n'ai trouvé aucun contact %s"},
+		"search_contacts.one":         {Other: "Votre seul contact %s :"},
+		"search_contacts.found":       {One: "Vous avez %d contact %s : %s", Other: "Vous avez %d contacts %s : %s"},
+
+		"criteria.name":          {Other: "dont le nom contient %s"},
+		"criteria.city":          {Other: "à %s"},
+		"criteria.email_domain":  {Other: "avec un e-mail chez %s"},
+		"criteria.phone_prefix":  {Other: "dont le numéro commence par %s"},
+		"criteria.phone_suffix":  {Other: "dont le numéro se termine par %s"},
+		"criteria.tag":           {Other: "avec l'étiquette %s"},
+		"criteria.missing_email": {Other: "sans e-mail"},
+		"criteria.missing_phone": {Other: "sans numéro de téléphone"},
+
+		"list.and":  {Other: "%s et %s"},
+		"list.more": {Other: "%d autres"},
+
+		"contact_field.which":           {Other: "De quel contact parlez-vous ?"},
+		"contact_field.which_field":     {Other: "Que voulez-vous savoir sur %s %s ?"},
+		"contact_field.error":           {Other: "Erreur lors de la recherche du contact, %v"},
+		"contact_field.phone":           {Other: "Le numéro de téléphone de %s %s est le"},
+		"contact_field.phone.unknown":   {Other: "Je n'ai pas de numéro de téléphone pour %s %s"},
+		"contact_field.email":           {Other: "L'e-mail de %s %s est"},
+		"contact_field.email.unknown":   {Other: "Je n'ai pas d'e-mail pour %s %s"},
+		"contact_field.address":         {Other: "%s %s habite au"},
+		"contact_field.address.unknown": {Other: "Je n'ai pas d'adresse pour %s %s"},
+		"contact_field.created":         {Other: "Vous avez ajouté %s %s le %s"},
+		"contact_field.created.unknown": {Other: "Je ne sais pas quand vous avez ajouté %s %s"},
+
+		"contact.details": {Other: "%s %s %s, adresse : %s, numéro de téléphone : %s et e-mail : %s"},
+		"contact.address": {Other: "adresse :"},
+		"contact.phone":   {Other: "numéro de téléphone :"},
+		"contact.email":   {Other: "et e-mail :"},
+
+		"card.address":    {Other: "Adresse"},
+		"card.phone":      {Other: "Téléphone"},
+		"card.email":      {Other: "E-mail"},
+		"card.email_link": {Other: "Écrire à %s"},
+		"card.list_title": {Other: "Contacts"},
+
+		"chip.call":       {Other: "Appeler"},
+		"chip.email":      {Other: "E-mail"},
+		"chip.edit_phone": {Other: "Modifier le téléphone"},
+
+		"ssml.at":         {Other: "arobase"},
+		"ssml.dot":        {Other: "point"},
+		"ssml.dash":       {Other: "tiret"},
+		"ssml.underscore": {Other: "tiret bas"},
+		"ssml.plus":       {Other: "plus"},
+	})
+}
+
+// messages returns the catalog for the language of the conversation
+func messages(req *intent.Request) *catalog.Catalog {
+	return catalog.Lookup(req.Lang)
+}
+
+// speechWords returns the words used to read out emails and phone numbers in the language of msgs
+func speechWords(msgs *catalog.Catalog) ssml.Words {
+	return ssml.Words{
+		At:         msgs.Sprintf("ssml.at"),
+		Dot:        msgs.Sprintf("ssml.dot"),
+		Dash:       msgs.Sprintf("ssml.dash"),
+		Underscore: msgs.Sprintf("ssml.underscore"),
+		Plus:       msgs.Sprintf("ssml.plus"),
+	}
+}
@@ -0,0 +1,138 @@
+// 2017.09.01 rjj.work@gmail.com: OAuth2 authorization server endpoints
+//	Actions on Google account linking (authorization code flow) sends the user to /oauth/authorize,
+//	where they log in with the existing session login (see auth.go) and consent. Actions on Google
+//	then exchanges the code at /oauth/token, and sends the access token with every webhook call,
+//	see webhookUser. The token logic lives in the oauthserver package, see contacts.OAuthServer.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// consentNonceSessionKey is the default session key of the nonce the consent
+// form must post back, so other sites can't approve on the user's behalf.
+const consentNonceSessionKey = "oauth_consent_nonce"
+
+var (
+	// See template.go
+	consentTmpl = parseTemplate("consent.html")
+
+	errNoOAuthServer = errors.New("account linking is not configured")
+)
+
+// authorizeFormHandler shows the consent page for a valid authorize request,
+// after making sure the user is logged in.
+func authorizeFormHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if contacts.OAuthServer == nil {
+		return &appError{errNoOAuthServer, "Not Found", http.StatusNotFound}
+	}
+	ar, err := contacts.OAuthServer.ParseAuthorize(r.URL.Query())
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest}
+	}
+
+	user := profileFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return nil
+	}
+
+	session, err := contacts.SessionStore.Get(r, defaultSessionID)
+	if err != nil {
+		return appErrorf(err, "could not get default session: %v", err)
+	}
+	nonce := uuid.NewV4().String()
+	session.Values[consentNonceSessionKey] = nonce
+	if err := session.Save(r, w); err != nil {
+		return appErrorf(err, "could not save session: %v", err)
+	}
+
+	return consentTmpl.Execute(w, r, struct {
+		ClientName, UserName, Nonce string
+		Params                      url.Values
+	}{
+		ClientName: ar.Client.Name,
+		UserName:   user.DisplayName,
+		Nonce:      nonce,
+		Params:     ar.Values(),
+	})
+}
+
+// authorizeHandler handles the consent form, redirecting back to the client
+// with an authorization code, or with access_denied.
+func authorizeHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if contacts.OAuthServer == nil {
+		return &appError{errNoOAuthServer, "Not Found", http.StatusNotFound}
+	}
+	if err := r.ParseForm(); err != nil {
+		return &appError{err, "could not parse form", http.StatusBadRequest}
+	}
+	ar, err := contacts.OAuthServer.ParseAuthorize(r.PostForm)
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest}
+	}
+
+	user := profileFromSession(r)
+	if user == nil {
+		return &appError{errors.New("not logged in"), "Please log in and try again.", http.StatusForbidden}
+	}
+
+	session, err := contacts.SessionStore.Get(r, defaultSessionID)
+	if err != nil {
+		return appErrorf(err, "could not get default session: %v", err)
+	}
+	nonce, ok := session.Values[consentNonceSessionKey].(string)
+	if !ok || nonce == "" || nonce != r.PostForm.Get("nonce") {
+		return &appError{errors.New("consent nonce mismatch"), "Invalid consent form, please try again.", http.StatusForbidden}
+	}
+	// One consent per form.
+	delete(session.Values, consentNonceSessionKey)
+	if err := session.Save(r, w); err != nil {
+		return appErrorf(err, "could not save session: %v", err)
+	}
+
+	redirectURL := contacts.OAuthServer.Deny(ar)
+	if r.PostForm.Get("approve") != "" {
+		if redirectURL, err = contacts.OAuthServer.Approve(ar, user.ID, user.DisplayName); err != nil {
+			return appErrorf(err, "could not issue authorization code: %v", err)
+		}
+	}
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	return nil
+}
+
+// tokenHandler is the token endpoint, exchanging authorization codes and
+// refresh tokens for access tokens.
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if contacts.OAuthServer == nil {
+		http.NotFound(w, r)
+		return
+	}
+	contacts.OAuthServer.ServeToken(w, r)
+}
+
+// revokeHandler is the token revocation endpoint.
+func revokeHandler(w http.ResponseWriter, r *http.Request) {
+	if contacts.OAuthServer == nil {
+		http.NotFound(w, r)
+		return
+	}
+	contacts.OAuthServer.ServeRevoke(w, r)
+}
+
+// profileFromBearerToken returns the profile of the user an access token of
+// contacts.OAuthServer was issued to, or oauthserver.ErrInvalidToken.
+func profileFromBearerToken(token string) (*Profile, error) {
+	g, err := contacts.OAuthServer.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Profile{ID: g.UserID, DisplayName: g.UserName}, nil
+}
@@ -0,0 +1,54 @@
+// 2017.09.12 rjj.work@gmail.com: OpenAPI 3 description of the app, see openapi.json
+//	GET /openapi.json	the document, for code generators and API clients
+//	GET /api/docs		Swagger UI on it, to read and try the API in a browser
+//	openapi.json lists every route of registerHandlers, TestOpenAPIRoutes fails when they drift apart.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	openAPISpec = readOpenAPISpec("openapi.json")
+	apiDocsPage = readStatic("templates/api_docs.html")
+)
+
+// readOpenAPISpec returns the document at path, checked to be JSON.
+func readOpenAPISpec(path string) []byte {
+	b := readStatic(path)
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil || doc.OpenAPI == "" {
+		panic(fmt.Errorf("%s is not an OpenAPI document: %v", path, err))
+	}
+	return b
+}
+
+// readStatic returns the file at path, relative to the app directory like
+// the templates.
+func readStatic(path string) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(fmt.Errorf("could not read %s: %v", path, err))
+	}
+	return b
+}
+
+// openAPIHandler serves the OpenAPI document.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// Swagger UI and generators on other sites may read it
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+// apiDocsHandler serves the viewer of the OpenAPI document.
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(apiDocsPage)
+}
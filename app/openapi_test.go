@@ -0,0 +1,151 @@
+// 2017.09.12 rjj.work@gmail.com: The OpenAPI document must describe the routes of newRouter, and only them
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// muxVariable matches the pattern of a path variable, {id:[0-9]+} is {id} in OpenAPI.
+var muxVariable = regexp.MustCompile(`\{([^}:]+):[^}]+\}`)
+
+// routeOperations returns the "METHOD /path" of the routes of r, routes for
+// any method count as GET. WebDAV methods, e.g. PROPFIND, which OpenAPI
+// can't describe, are left out.
+func routeOperations(t *testing.T, r *mux.Router) map[string]bool {
+	ops := map[string]bool{}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path = muxVariable.ReplaceAllString(path, "{$1}")
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"}
+		}
+		for _, m := range methods {
+			if !openAPIMethods[m] {
+				continue
+			}
+			ops[m+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+// openAPIMethods are the methods of the operations of OpenAPI.
+var openAPIMethods = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true,
+	"OPTIONS": true, "HEAD": true, "PATCH": true, "TRACE": true,
+}
+
+// specOperations returns the "METHOD /path" of the operations of the OpenAPI document.
+func specOperations(doc map[string]interface{}) map[string]bool {
+	ops := map[string]bool{}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for path, item := range paths {
+		for key := range item.(map[string]interface{}) {
+			switch key {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				ops[strings.ToUpper(key)+" "+path] = true
+			}
+		}
+	}
+	return ops
+}
+
+func missingFrom(ops, from map[string]bool) []string {
+	var missing []string
+	for op := range ops {
+		if !from[op] {
+			missing = append(missing, op)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	routes, spec := routeOperations(t, newRouter()), specOperations(doc)
+	if len(routes) == 0 {
+		t.Fatal("no routes")
+	}
+	for _, op := range missingFrom(routes, spec) {
+		t.Errorf("%s is routed but not in openapi.json", op)
+	}
+	for _, op := range missingFrom(spec, routes) {
+		t.Errorf("%s is in openapi.json but not routed", op)
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok && resolveRef(doc, ref) == nil {
+				t.Errorf("%s does not resolve", ref)
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// resolveRef returns what a local reference, e.g. "#/components/schemas/Contact",
+// points to in doc, or nil.
+func resolveRef(doc map[string]interface{}, ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var v interface{} = doc
+	for _, name := range strings.Split(ref[2:], "/") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	if got, want := w.Header().Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+		t.Errorf("got content type %q, want %q", got, want)
+	}
+	if !json.Valid(w.Body.Bytes()) {
+		t.Error("got invalid JSON")
+	}
+}
@@ -0,0 +1,116 @@
+// 2017.09.02 rjj.work@gmail.com: Webhook conversation simulator
+//	"app simulate" replays scripts of webhook requests through webhookHandler in-process, against an
+//	in-memory contacts database seeded for each script, and compares the responses with golden files.
+//	No App Engine, API.AI or Cloud SQL needed, see package webhooksim for the script format.
+//
+//	cd app
+//	CONTACTS_DB=memory go run $(ls *.go | grep -v _test.go) simulate [-update] [-v] [script.json ...]
+//
+//	Without scripts it runs testdata/webhook/*.json, the same ones as TestWebhookGolden.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/webhooksim"
+)
+
+// simulationTime is the clock of scripts that don't set one.
+var simulationTime = time.Date(2017, time.September, 1, 12, 0, 0, 0, time.UTC)
+
+// simulateMain runs the simulate command and returns the exit status.
+func simulateMain(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	update := fs.Bool("update", false, "write the golden files instead of comparing with them")
+	verbose := fs.Bool("v", false, "print the transcripts")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// The scripts replace contacts.DB, never do that to the real one
+	if os.Getenv("CONTACTS_DB") != "memory" {
+		fmt.Fprintln(os.Stderr, "simulate: set CONTACTS_DB=memory")
+		return 2
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths, _ = filepath.Glob(filepath.Join("testdata", "webhook", "*.json"))
+	}
+
+	failed := 0
+	for _, path := range paths {
+		sc, out, err := runScript(path)
+		if err == nil {
+			if *verbose {
+				os.Stdout.Write(out)
+			}
+			err = webhooksim.CompareGolden(sc.GoldenPath(), out, *update)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", path)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runScript replays the script at path against a fresh contacts database,
+// seeded from the script's seed file, and returns its transcript.
+func runScript(path string) (*webhooksim.Script, []byte, error) {
+	sc, err := webhooksim.Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func(db contacts.ContactDatabase) { contacts.DB = db }(contacts.DB)
+	contacts.DB = contacts.NewMemoryDB()
+	if sc.Seed != "" {
+		if err := seedContacts(sc.Path(sc.Seed)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	clock := sc.Time
+	if clock.IsZero() {
+		clock = simulationTime
+	}
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	sim := &webhooksim.Simulator{Handler: appHandler(webhookHandler)}
+	out, err := sim.Run(sc)
+	return sc, out, err
+}
+
+// seedContacts adds the contacts of a JSON file (an array of contacts.Contact)
+// to contacts.DB, in order, so they get IDs 1, 2, ...
+func seedContacts(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cts []*contacts.Contact
+	if err := json.Unmarshal(b, &cts); err != nil {
+		return fmt.Errorf("seed %s: %v", path, err)
+	}
+	for _, c := range cts {
+		if _, err := contacts.DB.AddContact(c); err != nil {
+			return fmt.Errorf("seed %s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// 2017.09.02 rjj.work@gmail.com: Golden file tests of the webhook intents, see simulate.go
+//	CONTACTS_DB=memory go test -run TestWebhookGolden [-update]
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts/webhooksim"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of testdata/webhook")
+
+func TestWebhookGolden(t *testing.T) {
+	if os.Getenv("CONTACTS_DB") != "memory" {
+		t.Skip("set CONTACTS_DB=memory to replay testdata/webhook")
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "webhook", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no scripts in testdata/webhook")
+	}
+	for _, path := range paths {
+		sc, out, err := runScript(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if err := webhooksim.CompareGolden(sc.GoldenPath(), out, *updateGolden); err != nil {
+			t.Error(err)
+		}
+	}
+}
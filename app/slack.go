@@ -0,0 +1,231 @@
+// 2017.09.09 rjj.work@gmail.com: Slack slash command and interactive messages
+//	/contact homer simpson				find_contact, a contact card with a button to /contacts/{id}
+//	/contact homer					search_contacts by part of a name
+//	/contact count [tagged family]			number_of_contacts
+//	/contact add Ralph Jack [email] [phone] [address]	add_contact
+//	The text is turned into an intent.Request for the same handlers as /contactsWebhook, their answer
+//	into Block Kit. Slack users are mapped to ours with SLACK_USERS, the others are anonymous.
+//	Every call is signed with the app's signing secret, see package slack and contacts.Slack.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/aog"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+	"github.com/rjj-work/yum-contacts/slack"
+)
+
+// requireSlack passes calls signed by the configured Slack app on to h, the
+// others get a 401, and all of them a 404 when Slack is not configured.
+func requireSlack(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contacts.Slack == nil {
+			http.NotFound(w, r)
+			return
+		}
+		contacts.Slack.Wrap(h).ServeHTTP(w, r)
+	})
+}
+
+func slackCommandHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if err := r.ParseForm(); err != nil {
+		return &appError{err, "Bad Slack command", http.StatusBadRequest}
+	}
+	cmd := slack.ParseCommand(r.PostForm)
+
+	req := slackIntent(cmd.Text)
+	req.SessionID = "slack:" + cmd.TeamID + ":" + cmd.UserID
+	req.UserID = contacts.Slack.Users[cmd.UserID]
+	req.UserName = cmd.UserName
+	msgs := messages(req)
+
+	var msg slack.Message
+	if req.Intent == "" {
+		help := msgs.Sprintf("slack.help", cmd.Command)
+		msg = slack.Message{ResponseType: slack.Ephemeral, Text: help, Blocks: []slack.Block{slack.Section(slack.Escape(help))}}
+	} else {
+		resp := intent.Response{}
+		if err := intent.Dispatch(req, &resp); err != nil {
+			return appErrorf(err, "Processing of INTENT: %s failed: %v", req.Intent, err)
+		}
+		msg = slackMessage(r, msgs, &resp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+	return nil
+}
+
+// slackInteractionHandler acknowledges the clicks on the buttons of our
+// messages, they only open links.
+func slackInteractionHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if err := r.ParseForm(); err != nil {
+		return &appError{err, "Bad Slack interaction", http.StatusBadRequest}
+	}
+	in, err := slack.ParseInteraction(r.PostForm)
+	if err != nil {
+		return &appError{err, "Bad Slack interaction", http.StatusBadRequest}
+	}
+	for _, a := range in.Actions {
+		log.Printf("Slack user %s clicked %s %s", in.User.ID, a.ActionID, a.Value)
+	}
+	return nil
+}
+
+// slackIntent returns the intent request for the text of a slash command,
+// with no intent for help.
+func slackIntent(text string) *intent.Request {
+	req := &intent.Request{
+		Params: map[string]string{},
+		Lang:   "en",
+		Query:  text,
+		// Slack shows lists and cards
+		Capabilities: []string{aog.CapabilityScreenOutput},
+	}
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return req
+	}
+
+	switch strings.ToLower(words[0]) {
+	case "help":
+		return req
+	case "count", "tally", "how":
+		req.Intent = "number_of_contacts"
+		for i, w := range words {
+			if strings.ToLower(w) == "tagged" && i+1 < len(words) {
+				req.Params["tag"] = words[i+1]
+			}
+		}
+		return req
+	case "add":
+		req.Intent = "add_contact"
+		words = words[1:]
+		if len(words) > 0 {
+			req.Params["given-name"], words = words[0], words[1:]
+		}
+		if len(words) > 0 {
+			req.Params["last-name"], words = words[0], words[1:]
+		}
+		var address []string
+		for _, w := range words {
+			if _, ok := contacts.NormalizePhone(w); strings.Contains(w, "@") {
+				req.Params["email"] = w
+			} else if ok && req.Params["phone-number"] == "" {
+				req.Params["phone-number"] = w
+			} else {
+				address = append(address, w)
+			}
+		}
+		if len(address) > 0 {
+			req.Params["address"] = strings.Join(address, " ")
+		}
+		return req
+	case "find", "search":
+		words = words[1:]
+	}
+
+	switch len(words) {
+	case 0:
+	case 1:
+		req.Intent = "search_contacts"
+		req.Params["name"] = words[0]
+	default:
+		req.Intent = "find_contact"
+		req.Params["given-name"] = words[0]
+		req.Params["last-name"] = strings.Join(words[1:], " ")
+	}
+	return req
+}
+
+// slackMessage returns the answer of an intent handler as Block Kit: its
+// text, then the contacts it listed or the contact it is about, with buttons
+// to their pages.
+func slackMessage(r *http.Request, msgs *catalog.Catalog, resp *intent.Response) slack.Message {
+	msg := slack.Message{
+		ResponseType: slack.Ephemeral,
+		Text:         resp.DisplayText,
+		Blocks:       []slack.Block{slack.Section(slack.Escape(resp.DisplayText))},
+	}
+
+	if items := listedContacts(resp); len(items) > 0 {
+		for _, item := range items {
+			b := slack.Section("*" + slack.Escape(item.Title) + "*\n" + slack.Escape(item.Description))
+			open := slack.LinkButton(msgs.Sprintf("slack.open"), "open_contact", contactURL(r, item.OptionInfo.Key))
+			open.Value = item.OptionInfo.Key
+			b.Accessory = &open
+			msg.Blocks = append(msg.Blocks, b)
+		}
+		return msg
+	}
+
+	for _, ctx := range resp.Contexts {
+		if ctx.Name != "current_contact" || ctx.Lifespan <= 0 {
+			continue
+		}
+		id, err := strconv.ParseInt(ctx.Parameters["id"], 10, 64)
+		if err != nil {
+			break
+		}
+		c, err := contacts.DB.GetContact(id)
+		if err != nil {
+			log.Printf("Could not get contact %d for Slack: %v", id, err)
+			break
+		}
+		msg.Blocks = append(msg.Blocks, slack.Divider(), slackContactCard(msgs, c))
+		open := slack.LinkButton(msgs.Sprintf("slack.open"), "open_contact", contactURL(r, ctx.Parameters["id"]))
+		open.Value = ctx.Parameters["id"]
+		msg.Blocks = append(msg.Blocks, slack.Actions(open))
+	}
+	return msg
+}
+
+// slackContactCard returns a section with the name and details of c.
+func slackContactCard(msgs *catalog.Catalog, c *contacts.Contact) slack.Block {
+	b := slack.Section("*" + slack.Escape(strings.TrimSpace(c.FirstName+" "+c.LastName)) + "*")
+	for _, f := range []struct{ label, value string }{
+		{"card.address", c.Address},
+		{"card.phone", c.Phone},
+		{"card.email", c.Email},
+	} {
+		if f.value != "" {
+			b.Fields = append(b.Fields, *slack.Markdown("*" + msgs.Sprintf(f.label) + "*\n" + slack.Escape(f.value)))
+		}
+	}
+	return b
+}
+
+// listedContacts returns the contacts of the list the handler offered to
+// pick from on a screen, see googleContactList.
+func listedContacts(resp *intent.Response) []aog.OptionItem {
+	p, ok := resp.Data["google"].(*aog.Payload)
+	if !ok || p.SystemIntent == nil {
+		return nil
+	}
+	spec, ok := p.SystemIntent.Data.(aog.OptionValueSpec)
+	if !ok || spec.ListSelect == nil {
+		return nil
+	}
+	return spec.ListSelect.Items
+}
+
+// contactURL returns the absolute URL of the page of the contact id, as
+// Slack opens it.
+func contactURL(r *http.Request, id string) string {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host + "/contacts/" + id
+}
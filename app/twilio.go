@@ -0,0 +1,158 @@
+// 2017.09.10 rjj.work@gmail.com: Contact lookups by SMS or phone call, through Twilio
+//	Text "find homer simpson" (or just "homer simpson"), "homer" or "count" to the Twilio number,
+//	or call it and say the same. The reply is TwiML: a Message for SMS, Say for calls.
+//	Only the phone numbers of TWILIO_USERS can look up contacts, each sees their user's own contacts.
+//	Every call is signed with the account's auth token, see package twilio and contacts.Twilio.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/twilio"
+)
+
+// maxPhoneContacts is how many contacts one reply lists, an SMS is short.
+const maxPhoneContacts = 3
+
+// requireTwilio passes calls signed by the configured Twilio account on to
+// h, the others get a 403, and all of them a 404 when Twilio is not configured.
+func requireTwilio(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contacts.Twilio == nil {
+			http.NotFound(w, r)
+			return
+		}
+		contacts.Twilio.Wrap(h).ServeHTTP(w, r)
+	})
+}
+
+// twilioSMSHandler answers a text message.
+func twilioSMSHandler(w http.ResponseWriter, r *http.Request) *appError {
+	msgs := catalog.Lookup("en")
+	userID, ok := contacts.Twilio.Users[r.PostForm.Get("From")]
+	reply := msgs.Sprintf("phone.unknown_number")
+	if ok {
+		reply = phoneCommand(msgs, userID, r.PostForm.Get("Body"), false)
+	}
+	resp := &twilio.Response{Verbs: []interface{}{&twilio.Message{Body: reply}}}
+	if err := resp.Write(w); err != nil {
+		return appErrorf(err, "could not write TwiML: %v", err)
+	}
+	return nil
+}
+
+// twilioVoiceHandler answers a call, then what the caller said, which Twilio
+// posts back here as SpeechResult.
+func twilioVoiceHandler(w http.ResponseWriter, r *http.Request) *appError {
+	msgs := catalog.Lookup("en")
+	userID, ok := contacts.Twilio.Users[r.PostForm.Get("From")]
+
+	resp := &twilio.Response{}
+	if !ok {
+		resp.Verbs = append(resp.Verbs, &twilio.Say{Text: msgs.Sprintf("phone.unknown_number")}, &twilio.Hangup{})
+	} else {
+		prompt := msgs.Sprintf("phone.voice_prompt")
+		if said := r.PostForm.Get("SpeechResult"); said != "" {
+			resp.Verbs = append(resp.Verbs, &twilio.Say{Text: phoneCommand(msgs, userID, said, true)})
+			prompt = msgs.Sprintf("phone.voice_again")
+		}
+		// Listen for the next one, hang up when they don't say anything
+		resp.Verbs = append(resp.Verbs,
+			&twilio.Gather{Input: "speech", Action: r.URL.Path, Verbs: []interface{}{&twilio.Say{Text: prompt}}},
+			&twilio.Say{Text: msgs.Sprintf("phone.goodbye")},
+			&twilio.Hangup{})
+	}
+	if err := resp.Write(w); err != nil {
+		return appErrorf(err, "could not write TwiML: %v", err)
+	}
+	return nil
+}
+
+// phoneCommand runs the command in text, for the contacts of userID, and
+// returns the reply, to be said rather than read when voice is set.
+func phoneCommand(msgs *catalog.Catalog, userID, text string, voice bool) string {
+	// Speech comes with punctuation, "Find Homer Simpson."
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '\''
+	})
+	if len(words) > 0 && (words[0] == "find" || words[0] == "lookup" || words[0] == "search") {
+		words = words[1:]
+	}
+	if len(words) == 0 || words[0] == "help" {
+		return msgs.Sprintf("phone.help")
+	}
+
+	if words[0] == "count" || (words[0] == "how" && len(words) > 1 && words[1] == "many") {
+		tally, err := contacts.DB.TallyContactsCreatedBy(userID)
+		if err != nil {
+			log.Printf("Could not tally contacts for a phone command: %v", err)
+			return msgs.Sprintf("phone.error")
+		}
+		return msgs.Plural("number_of_contacts.tally", int(tally), tally, now(msgs))
+	}
+
+	var cts []*contacts.Contact
+	var err error
+	name := strings.Join(words, " ")
+	if len(words) == 1 {
+		cts, err = contacts.DB.FindContacts(contacts.ContactCriteria{CreatedByID: userID, Name: words[0]})
+	} else {
+		cts, err = contacts.DB.FindContactByNameCreatedBy(userID, words[0], strings.Join(words[1:], " "))
+	}
+	if err != nil {
+		log.Printf("Could not find contacts for a phone command: %v", err)
+		return msgs.Sprintf("phone.error")
+	}
+	if len(cts) == 0 {
+		return msgs.Sprintf("phone.not_found", name)
+	}
+
+	var lines []string
+	for i, c := range cts {
+		if i == maxPhoneContacts {
+			lines = append(lines, msgs.Sprintf("phone.more", len(cts)-maxPhoneContacts))
+			break
+		}
+		lines = append(lines, phoneContactLine(msgs, c, voice))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// phoneContactLine returns c on one line: name, phone, email and address.
+func phoneContactLine(msgs *catalog.Catalog, c *contacts.Contact, voice bool) string {
+	phone := c.Phone
+	if voice && phone != "" {
+		phone = spokenPhone(msgs, phone)
+	}
+	var parts []string
+	for _, s := range []string{phone, c.Email, c.Address} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return fmt.Sprintf("%s %s: %s", c.FirstName, c.LastName, strings.Join(parts, ", "))
+}
+
+// spokenPhone returns phone for a <Say>, read digit by digit like
+// ssml.Builder.Phone does for the webhook, rather than as one big number:
+// "555-123-4567" gives "5 5 5, 1 2 3, 4 5 6 7".
+func spokenPhone(msgs *catalog.Catalog, phone string) string {
+	groups := strings.FieldsFunc(phone, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+	for i, g := range groups {
+		groups[i] = strings.Join(strings.Split(g, ""), " ")
+	}
+	spoken := strings.Join(groups, ", ")
+	if strings.HasPrefix(strings.TrimSpace(phone), "+") {
+		spoken = msgs.Sprintf("ssml.plus") + " " + spoken
+	}
+	return spoken
+}
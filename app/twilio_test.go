@@ -0,0 +1,27 @@
+// 2017.09.10 rjj.work@gmail.com: Tests of the Twilio replies, see twilio.go
+//	go test -run TestPhone
+
+package main
+
+import (
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/catalog"
+)
+
+func TestPhoneContactLine(t *testing.T) {
+	msgs := catalog.Lookup("en")
+	c := &contacts.Contact{FirstName: "Homer", LastName: "Simpson", Phone: "555-636-7890", Email: "homer@example.com"}
+
+	if got, want := phoneContactLine(msgs, c, false), "Homer Simpson: 555-636-7890, homer@example.com"; got != want {
+		t.Errorf("SMS: got %q, want %q", got, want)
+	}
+	// Said digit by digit, not as five hundred fifty five
+	if got, want := phoneContactLine(msgs, c, true), "Homer Simpson: 5 5 5, 6 3 6, 7 8 9 0, homer@example.com"; got != want {
+		t.Errorf("voice: got %q, want %q", got, want)
+	}
+	if got, want := spokenPhone(msgs, "+1 (407) 555-0100"), "plus 1, 4 0 7, 5 5 5, 0 1 0 0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
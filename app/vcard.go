@@ -0,0 +1,185 @@
+// 2017.09.15 rjj.work@gmail.com: vCard export and import of the contacts, see package vcard
+//	GET /contacts/{id}.vcf		one contact, the "Download vCard" button of detail.html
+//	GET /contacts.vcf, /contacts/mine.vcf	the lists, ?version=4.0 for vCard 4.0 instead of 3.0
+//	GET, POST /contacts/import	upload a .vcf of one or more cards, each one is reported added or why not
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/vcard"
+)
+
+// maxImport bounds the size of the uploaded files.
+const maxImport = 4 << 20
+
+var importTmpl = parseTemplate("import.html")
+
+// vcardHandler downloads a contact as a vCard.
+func vcardHandler(w http.ResponseWriter, r *http.Request) *appError {
+	version, e := vcardVersion(r)
+	if e != nil {
+		return e
+	}
+	contact, err := contactFromRequest(r)
+	if err != nil {
+		return appErrorf(err, "%v", err)
+	}
+	return writeVCards(w, vcardFilename(contact), version, []*contacts.Contact{contact})
+}
+
+// vcardListHandler downloads all the contacts as vCards.
+func vcardListHandler(w http.ResponseWriter, r *http.Request) *appError {
+	version, e := vcardVersion(r)
+	if e != nil {
+		return e
+	}
+	cts, err := contacts.DB.ListContacts()
+	if err != nil {
+		return appErrorf(err, "could not list contacts: %v", err)
+	}
+	return writeVCards(w, "contacts.vcf", version, cts)
+}
+
+// vcardListMineHandler downloads the contacts of the logged in user as vCards.
+func vcardListMineHandler(w http.ResponseWriter, r *http.Request) *appError {
+	user := profileFromSession(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?redirect=/contacts/mine.vcf", http.StatusFound)
+		return nil
+	}
+	version, e := vcardVersion(r)
+	if e != nil {
+		return e
+	}
+	cts, err := contacts.DB.ListContactsCreatedBy(user.ID)
+	if err != nil {
+		return appErrorf(err, "could not list contacts: %v", err)
+	}
+	return writeVCards(w, "my-contacts.vcf", version, cts)
+}
+
+// vcardVersion returns the vCard version of the ?version= of r, 3.0 by
+// default, which more address books read.
+func vcardVersion(r *http.Request) (string, *appError) {
+	switch v := r.FormValue("version"); v {
+	case "", vcard.Version3:
+		return vcard.Version3, nil
+	case vcard.Version4:
+		return v, nil
+	default:
+		return "", &appError{nil, fmt.Sprintf("unsupported vCard version %q, use 3.0 or 4.0", v), http.StatusBadRequest}
+	}
+}
+
+// writeVCards writes the contacts as the vCards of an attachment.
+func writeVCards(w http.ResponseWriter, filename, version string, cts []*contacts.Contact) *appError {
+	w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	enc := vcard.NewEncoder(w)
+	for _, c := range cts {
+		if err := enc.Encode(vcard.FromContact(c, version)); err != nil {
+			// Too late for an error page
+			return nil
+		}
+	}
+	return nil
+}
+
+// vcardFilename returns the name of the file of c, e.g. Homer-Simpson.vcf.
+func vcardFilename(c *contacts.Contact) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(c.FirstName+" "+c.LastName))
+	if name = strings.Trim(name, "-"); name == "" {
+		name = "contact-" + strconv.FormatInt(c.ID, 10)
+	}
+	return name + ".vcf"
+}
+
+// importResult is what became of a card of an uploaded file.
+type importResult struct {
+	// Card is the number of the card in the file, from 1, and Line where it starts.
+	Card, Line int
+	Name       string
+	// ID is the contact added, 0 when Err says why not.
+	ID  int64
+	Err string
+}
+
+// importReport is the data of import.html after an upload.
+type importReport struct {
+	Filename       string
+	Results        []importResult
+	Added, Refused int
+}
+
+// importFormHandler displays the upload form.
+func importFormHandler(w http.ResponseWriter, r *http.Request) *appError {
+	return importTmpl.Execute(w, r, nil)
+}
+
+// importHandler adds the contacts of the cards of an uploaded file, for the
+// logged in user, and reports on every card.
+func importHandler(w http.ResponseWriter, r *http.Request) *appError {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImport)
+	f, header, err := r.FormFile("vcf")
+	if err != nil {
+		return &appError{err, fmt.Sprintf("could not read the uploaded file: %v", err), http.StatusBadRequest}
+	}
+	defer f.Close()
+
+	creator := &contacts.Contact{}
+	if user := profileFromSession(r); user != nil {
+		creator.CreatedBy, creator.CreatedByID = user.DisplayName, user.ID
+	} else {
+		creator.SetCreatorAnonymous()
+	}
+
+	report := &importReport{Filename: header.Filename}
+	d := vcard.NewDecoder(f)
+	for n := 1; ; n++ {
+		card, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		res := importResult{Card: n}
+		if serr, ok := err.(*vcard.SyntaxError); ok {
+			res.Line, res.Err = serr.Line, serr.Msg
+		} else if err != nil {
+			res.Err = fmt.Sprintf("could not read the rest of the file: %v", err)
+			report.add(res)
+			break
+		} else {
+			res.Line = card.Line
+			c := card.Contact()
+			c.CreatedBy, c.CreatedByID = creator.CreatedBy, creator.CreatedByID
+			res.Name = strings.TrimSpace(c.FirstName + " " + c.LastName)
+			if err := c.Validate(); err != nil {
+				res.Err = err.Error()
+			} else if res.ID, err = contacts.DB.AddContact(c); err != nil {
+				res.Err = fmt.Sprintf("could not save contact: %v", err)
+			}
+		}
+		report.add(res)
+	}
+	return importTmpl.Execute(w, r, report)
+}
+
+func (r *importReport) add(res importResult) {
+	r.Results = append(r.Results, res)
+	if res.Err == "" {
+		r.Added++
+	} else {
+		r.Refused++
+	}
+}
@@ -0,0 +1,116 @@
+// 2017.09.15 rjj.work@gmail.com: Tests of the vCard export and import, see vcard.go
+//	CONTACTS_DB=memory go test -run TestVCard
+//	The cards themselves are tested in package vcard.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/vcard"
+)
+
+// vcardTestRouter returns the routes on the seed contacts, contacts.DB is
+// put back when t is done.
+func vcardTestRouter(t *testing.T) http.Handler {
+	if os.Getenv("CONTACTS_DB") != "memory" {
+		t.Skip("set CONTACTS_DB=memory to test the vCards")
+	}
+	db := contacts.DB
+	t.Cleanup(func() { contacts.DB = db })
+	contacts.DB = contacts.NewMemoryDB()
+	if err := seedContacts(filepath.Join("testdata", "contacts.json")); err != nil {
+		t.Fatal(err)
+	}
+	return newRouter()
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestVCardExport(t *testing.T) {
+	h := vcardTestRouter(t)
+
+	w := get(h, "/contacts/1.vcf")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/vcard; charset=utf-8" {
+		t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="Homer-Simpson.vcf"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !strings.Contains(w.Body.String(), "\r\nVERSION:3.0\r\n") || !strings.Contains(w.Body.String(), "\r\nFN:Homer Simpson\r\n") {
+		t.Errorf("got %q, want Homer in vCard 3.0", w.Body)
+	}
+
+	w = get(h, "/contacts.vcf?version=4.0")
+	if got := strings.Count(w.Body.String(), "BEGIN:VCARD"); got != 5 {
+		t.Errorf("all contacts: got %d cards, want 5", got)
+	}
+	if !strings.Contains(w.Body.String(), "\r\nVERSION:4.0\r\n") {
+		t.Errorf("got %q, want vCard 4.0", w.Body)
+	}
+
+	if w = get(h, "/contacts.vcf?version=2.1"); w.Code != http.StatusBadRequest {
+		t.Errorf("version 2.1: got %d, want 400", w.Code)
+	}
+	// Not logged in
+	if w = get(h, "/contacts/mine.vcf"); w.Code != http.StatusFound {
+		t.Errorf("mine: got %d, want a redirect to log in", w.Code)
+	}
+}
+
+func TestVCardImport(t *testing.T) {
+	h := vcardTestRouter(t)
+
+	// The export of a contact, and cards that are refused
+	vcf := get(h, "/contacts/5.vcf").Body.String() +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Ned Flanders\r\nEMAIL:ned\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN Rod\r\nEND:VCARD\r\n"
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("vcf", "cards.vcf")
+	fw.Write([]byte(vcf))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/contacts/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	page, _ := ioutil.ReadAll(w.Body)
+	for _, want := range []string{
+		"cards.vcf: 1 added, 2 refused",
+		`<a href="/contacts/6">added</a>`,
+		"invalid contact: email: ",
+		"no &#39;:&#39; in &#34;FN Rod&#34;",
+	} {
+		if !bytes.Contains(page, []byte(want)) {
+			t.Errorf("got %s, want %q", page, want)
+		}
+	}
+
+	c, err := contacts.DB.GetContact(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FirstName != "Cali" || c.Email != "cali@example.com" || c.CreatedByID != "anonymous" {
+		t.Errorf("got %+v, want Cali added anonymously", c)
+	}
+	if vcard.UID(6) == vcard.UID(5) {
+		t.Error("the copy has the UID of the original")
+	}
+}
@@ -5,12 +5,19 @@ package main
 
 import(
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/aog"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+	"github.com/rjj-work/yum-contacts/ssml"
 )
 
 // =====================================================================================================
@@ -19,32 +26,75 @@ import(
 
 //APIAIRequest : Incoming request format from APIAI
 type APIAIRequest struct {
-	ID        string    `json:"id"`
-	Timestamp time.Time `json:"timestamp"`
-	Result    struct {
-		Parameters map[string]string `json:"parameters"`
-		Contexts   []interface{}     `json:"contexts"`
-		Metadata   struct {
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Lang         string    `json:"lang"`
+	LanguageCode string    `json:"languageCode"`
+	Result       struct {
+		ResolvedQuery string            `json:"resolvedQuery"`
+		Action        string            `json:"action"`
+		Parameters    map[string]string `json:"parameters"`
+		Contexts      []APIAIContext    `json:"contexts"`
+		Metadata      struct {
 			IntentID                  string `json:"intentId"`
 			WebhookUsed               string `json:"webhookUsed"`
 			WebhookForSlotFillingUsed string `json:"webhookForSlotFillingUsed"`
 			IntentName                string `json:"intentName"`
 		} `json:"metadata"`
 		Score float32 `json:"score"`
+
+		// Set while API.AI is still prompting for required parameters
+		ActionIncomplete bool `json:"actionIncomplete"`
 	} `json:"result"`
 	Status struct {
 		Code      int    `json:"code"`
 		ErrorType string `json:"errorType"`
 	} `json:"status"`
-	SessionID       string      `json:"sessionId"`
-	OriginalRequest interface{} `json:"originalRequest"`
+	SessionID       string               `json:"sessionId"`
+	OriginalRequest APIAIOriginalRequest `json:"originalRequest"`
+}
+
+//APIAIOriginalRequest : The platform request (e.g. from Actions on Google) passed through by APIAI
+//	Actions on Google v1 uses snake_case names (see manual-testing/*.json), v2 uses camelCase
+type APIAIOriginalRequest struct {
+	Source string `json:"source"`
+	Data   struct {
+		Inputs []struct {
+			Intent    string `json:"intent"`
+			Arguments []struct {
+				Name        string `json:"name"`
+				TextValue   string `json:"text_value"`
+				TextValueV2 string `json:"textValue"`
+			} `json:"arguments"`
+		} `json:"inputs"`
+		User struct {
+			UserID        string `json:"user_id"`
+			AccessToken   string `json:"access_token"`
+			AccessTokenV2 string `json:"accessToken"`
+			Locale        string `json:"locale"`
+		} `json:"user"`
+		Surface struct {
+			Capabilities []struct {
+				Name string `json:"name"`
+			} `json:"capabilities"`
+		} `json:"surface"`
+	} `json:"data"`
+}
+
+//APIAIContext : Input and output context format from APIAI
+type APIAIContext struct {
+	Name       string                 `json:"name"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Lifespan   int                    `json:"lifespan"`
 }
 
 //APIAIMessage : Response Message Structure
 type APIAIMessage struct {
-	Speech      string `json:"speech"`
-	DisplayText string `json:"displayText"`
-	Source      string `json:"source"`
+	Speech      string                 `json:"speech"`
+	DisplayText string                 `json:"displayText"`
+	Source      string                 `json:"source"`
+	ContextOut  []APIAIContext         `json:"contextOut,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
 }
 // =====================================================================================================
 
@@ -58,6 +108,14 @@ type APIAIContact struct {
 }
 
 
+// requireWebhookAuth rejects webhook calls that fail contacts.WebhookAuth, before any intent is processed
+func requireWebhookAuth( h http.Handler ) http.Handler {
+	if !contacts.WebhookAuth.Enabled() {
+		return h
+	}
+	return contacts.WebhookAuth.Wrap( h )
+}
+
 // This handler is to be used by the filfullment for the my_contacts api.aid
 // Basic flow
 //	- hook invoked, verify POST
@@ -80,150 +138,399 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) *appError {
 		return appErrorf( err, "Decode of request failed: %v", err )
 	}
 
-	log.Printf( "Contact Params: %+v", extractContactFromAPIAIRequest(&ar) )
+	// Hand the request off to whichever handler is registered for the INTENT
+	//	See init() below, and package intent
+	req := ar.intentRequest()
+
+	// Who is speaking, so the intents only work on the user's own contacts, see webhook_user.go
+	//	Without a token the user is anonymous, which only sees anonymous contacts
+	if p, err := webhookUser( &ar ); oauthserver.ErrInvalidToken == err {
+		// Expired or revoked, Actions on Google asks the user to link again
+		return &appError{ err, "Invalid access token", http.StatusUnauthorized }
+	} else if nil != err {
+		// Not anonymous, the user would miss their contacts, or add them as anyone's
+		return appErrorf( err, "Could not get profile of webhook user: %v", err )
+	} else if nil != p {
+		req.UserID = p.ID
+		req.UserName = p.DisplayName
+	}
 
-	// Extract the INTENT, not sure where Action is at or if included
-	// 	Use INTENT to process correctly
-	respJson := APIAIMessage{
+	resp := intent.Response{
 		Speech: "Unprocessed Speech value",
 		DisplayText: "Unprocessed DisplayText value",
-		Source: "rjj-work@gmail.com yum-contacts programming exercise",
 		}
 
-	intent := ar.Result.Metadata.IntentName
-	switch intent {
-		case "number_of_contacts" : err = tallyContacts( &ar, &respJson )
-		case "find_contact"       : err = findContact( &ar, &respJson )
-		case "add_contact"        : err = addContact( &ar, &respJson )
-		case "update_contact"     : err = updateContact( &ar, &respJson )
-		case "delete_contact"     : err = deleteContact( &ar, &respJson )
-		default                   : err = unhandledIntent( &ar, &respJson )
-	}
+	err = intent.Dispatch( req, &resp )
 
 	// Hopefully no errors, but check anyway
 	if nil != err {
-		return appErrorf( err, "Processing of INTENT: %s failed: %v", intent, err )
+		return appErrorf( err, "Processing of INTENT: %s failed: %v", req.Intent, err )
 	}
 
 	// Encode the response and done
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode( respJson )
+	json.NewEncoder(w).Encode( apiaiMessage( &resp ) )
 
 	return nil
 }
 
-func tallyContacts( ar *APIAIRequest, rj *APIAIMessage ) error {
+// intentStats counts calls and errors per intent, published on /debug/vars
+var intentStats = expvar.NewMap( "intents" )
+
+// The intents handled here. Other files (or packages imported for their side
+// effects) can register more intents the same way, without touching this one.
+func init() {
+	intent.Use( intent.Logging( log.Printf ), intent.Metrics( intentStats ) )
+
+	intent.HandleFunc( "number_of_contacts", tallyContacts )
+	intent.HandleFunc( "find_contact", findContact )
+	intent.HandleFunc( "select_contact", selectContact )
+	intent.HandleFunc( "add_contact", addContact )
+	intent.HandleFunc( "update_contact", updateContact )
+	intent.HandleFunc( "delete_contact", deleteContact )
+	intent.HandleFallback( intent.HandlerFunc( unhandledIntent ) )
+}
+
+// intentRequest converts the APIAI wire format to the platform neutral intent.Request
+func ( ar *APIAIRequest ) intentRequest() *intent.Request {
+	req := &intent.Request{
+		Intent: ar.Result.Metadata.IntentName,
+		Action: ar.Result.Action,
+		Query: ar.Result.ResolvedQuery,
+		Params: ar.Result.Parameters,
+		Lang: ar.Lang,
+		SessionID: ar.SessionID,
+		// Still prompting for required parameters, see webhook_slots.go
+		SlotFilling: "true" == ar.Result.Metadata.WebhookForSlotFillingUsed && ar.Result.ActionIncomplete,
+		Raw: ar,
+	}
+	for _, c := range ar.Result.Contexts {
+		ic := intent.Context{ Name: c.Name, Lifespan: c.Lifespan, Parameters: map[string]string{} }
+		for k, v := range c.Parameters {
+			ic.Parameters[k] = fmt.Sprint( v )
+		}
+		req.Contexts = append( req.Contexts, ic )
+	}
+
+	od := &ar.OriginalRequest.Data
+	// Language: APIAI lang, then languageCode as sent by newer agents, then the Actions on Google locale
+	if "" == req.Lang {
+		req.Lang = ar.LanguageCode
+	}
+	if "" == req.Lang {
+		req.Lang = od.User.Locale
+	}
+	for _, c := range od.Surface.Capabilities {
+		req.Capabilities = append( req.Capabilities, c.Name )
+	}
+	req.Arguments = map[string]string{}
+	for _, in := range od.Inputs {
+		for _, a := range in.Arguments {
+			if "" != a.TextValue {
+				req.Arguments[a.Name] = a.TextValue
+			} else {
+				req.Arguments[a.Name] = a.TextValueV2
+			}
+		}
+	}
+	return req
+}
+
+// apiaiMessage converts an intent.Response back to the APIAI wire format
+func apiaiMessage( resp *intent.Response ) APIAIMessage {
+	msg := APIAIMessage{
+		// Always SSML, handlers that build their own keep it as is, see respondWithContact
+		Speech: resp.SSMLSpeech(),
+		DisplayText: resp.DisplayText,
+		Source: "rjj-work@gmail.com yum-contacts programming exercise",
+		Data: resp.Data,
+	}
+	for _, c := range resp.Contexts {
+		ac := APIAIContext{ Name: c.Name, Lifespan: c.Lifespan, Parameters: map[string]interface{}{} }
+		for k, v := range c.Parameters {
+			ac.Parameters[k] = v
+		}
+		msg.ContextOut = append( msg.ContextOut, ac )
+	}
+	return msg
+}
+
+func tallyContacts( req *intent.Request, resp *intent.Response ) error {
 	// Hit the DB and get the count
-	tally, err := contacts.DB.TallyContacts()
+	msgs := messages( req )
+	// Counting some of them, see webhook_tally.go
+	criteria := criteriaFromIntent( req )
+	period, hasPeriod, err := tallyPeriod( req )
 	if nil != err {
-		rj.Speech = fmt.Sprintf( "Error: tallying contacts, %v", err )
-		rj.DisplayText = rj.Speech
+		resp.Say( "%s", msgs.Sprintf( "number_of_contacts.bad_period" ) )
+		return nil
+	}
+	if hasPeriod || !criteria.Empty() {
+		return tallyMatching( req, resp, criteria, period, hasPeriod )
+	}
+
+	tally, err := contacts.DB.TallyContactsCreatedBy( ownerID( req ) )
+	if nil != err {
+		resp.Say( "%s", msgs.Sprintf( "number_of_contacts.error", err ) )
 		return err
 	}
 	// Should have an actual count
-	rj.Speech = fmt.Sprintf( "Tally %d for contacts as of %v", tally, time.Now() )
-	rj.DisplayText = rj.Speech
+	resp.Say( "%s", msgs.Plural( "number_of_contacts.tally", int(tally), tally, now( msgs ) ) )
 
 	return err
 }
 
 // A more sophisticated implementation would supprt a find using a combination of contract attributes
 //	For now we will just use first and last name
-func findContact( ar *APIAIRequest, rj *APIAIMessage ) error {
+func findContact( req *intent.Request, resp *intent.Response ) error {
 	var err error
 	var cts []*contacts.Contact
-	t := extractContactFromAPIAIRequest( ar )
+	msgs := messages( req )
+	t := extractContactFromIntent( req )
 
-	cts, err = contacts.DB.FindContactByName( t.GivenName, t.LastName )
+	cts, err = contacts.DB.FindContactByNameCreatedBy( ownerID( req ), t.GivenName, t.LastName )
 	if nil != err {
-		rj.Speech = fmt.Sprintf( "Error looking up contact %s %s, %v", t.GivenName, t.LastName, err )
-		rj.DisplayText = rj.Speech
+		resp.Say( "%s", msgs.Sprintf( "find_contact.error", t.GivenName, t.LastName, err ) )
 		return err
 	}
-	// HACK, we will just use the first contact found.
-	if 0 == len(cts) {
+
+	switch len(cts) {
+	case 0:
 		// No contacts found
-		rj.Speech =  fmt.Sprintf( "No contact found for first name %s, last name: %s", t.GivenName, t.LastName )
-		rj.DisplayText = rj.Speech
+		resp.Say( "%s", msgs.Sprintf( "find_contact.not_found", t.GivenName, t.LastName ) )
+	case 1:
+		respondWithContact( req, resp, cts[0], msgs.Sprintf( "find_contact.found" ) )
+	default:
+		// Several contacts with the same name, let the user pick on a screen,
+		// otherwise read back the first one.
+		if req.HasCapability( aog.CapabilityScreenOutput ) {
+			resp.Say( "%s", msgs.Plural( "find_contact.which", len(cts), len(cts), t.GivenName, t.LastName ) )
+			resp.Data = googleContactList( msgs, resp, cts )
+			return nil
+		}
+		respondWithContact( req, resp, cts[0],
+				msgs.Plural( "find_contact.first_of", len(cts), len(cts), t.GivenName, t.LastName ) )
+	}
+
+	return err
+}
+
+// selectContact handles the user picking a contact from the list sent by findContact.
+//	The APIAI intent needs the event actions_intent_OPTION
+func selectContact( req *intent.Request, resp *intent.Response ) error {
+	msgs := messages( req )
+	id, err := strconv.ParseInt( req.Arguments["OPTION"], 10, 64 )
+	if nil != err {
+		resp.Say( "%s", msgs.Sprintf( "select_contact.not_understood" ) )
 		return nil
 	}
-	fn := cts[0].FirstName
-	ln := cts[0].LastName
-	address := cts[0].Address
-	email := cts[0].Email
-	phone := cts[0].Phone
 
-	// Assume all there for now
-	rj.Speech =  fmt.Sprintf( "Found: %s %s at address: %s, with phone number: %s and email: %s",
-			fn, ln, address, phone, email )
-	rj.DisplayText = rj.Speech
+	// Only the user's own contacts, one deleted since it was listed is gone
+	c, err := contacts.GetContactOf( contacts.DB, ownerID( req ), id )
+	if nil != err {
+		resp.Say( "%s", msgs.Sprintf( "select_contact.error", id, err ) )
+		return err
+	}
+	if nil == c {
+		resp.Say( "%s", msgs.Sprintf( "select_contact.not_understood" ) )
+		return nil
+	}
+	respondWithContact( req, resp, c, msgs.Sprintf( "find_contact.found" ) )
 
-	// TODO:
-	//	- [ ] Set outgoing context with atleast the current contacts DB ID
-	//	- [ ] Allow for multiple contats found, and some indication of that condition
-	return err
+	return nil
 }
 
-func addContact( ar *APIAIRequest, rj *APIAIMessage ) error {
-	var err error
-	numContacts := 0
+// respondWithContact reads back the details of c after intro, and makes it the current_contact for follow up intents
+//	Speech is SSML so the phone number is read digit by digit and the email as words, DisplayText stays plain
+func respondWithContact( req *intent.Request, resp *intent.Response, c *contacts.Contact, intro string ) {
+	msgs := messages( req )
+	resp.DisplayText = msgs.Sprintf( "contact.details", intro, c.FirstName, c.LastName, c.Address, c.Phone, c.Email )
 
-	// Do something to get this value
+	sp := ssml.New().SetWords( speechWords( msgs ) )
+	sp.Text( intro ).Textf( "%s %s", c.FirstName, c.LastName ).Pause( ssml.Long )
+	if "" != c.Address {
+		sp.Text( msgs.Sprintf( "contact.address" ) ).Text( c.Address ).Pause( ssml.Long )
+	}
+	if "" != c.Phone {
+		sp.Text( msgs.Sprintf( "contact.phone" ) ).Phone( c.Phone ).Pause( ssml.Long )
+	}
+	if "" != c.Email {
+		sp.Text( msgs.Sprintf( "contact.email" ) ).Email( c.Email )
+	}
+	resp.Speech, resp.SSML = sp.String(), true
 
-	rj.Speech = fmt.Sprintf( "You have %d contacts as of %v", numContacts, time.Now() )
-	rj.DisplayText = rj.Speech
+	setCurrentContact( resp, c )
 
-	return err
+	if req.HasCapability( aog.CapabilityScreenOutput ) {
+		resp.Data = googleContactCard( msgs, resp, c )
+	}
 }
 
-func updateContact( ar *APIAIRequest, rj *APIAIMessage ) error {
-	var err error
-	numContacts := 0
+// setCurrentContact makes c the current_contact, so follow up intents don't need to repeat the name
+func setCurrentContact( resp *intent.Response, c *contacts.Contact ) {
+	resp.SetContext( intent.Context{
+		Name: "current_contact",
+		Lifespan: 5,
+		Parameters: map[string]string{
+			"id": strconv.FormatInt( c.ID, 10 ),
+			"first_name": c.FirstName,
+			"last_name": c.LastName,
+		},
+	})
+}
 
-	// Do something to get this value
+// targetContact returns the contact an update or delete is about, nil if there is none.
+//	A name in the parameters wins, otherwise the current_contact context is used.
+//	Only the user's own contacts are found.
+func targetContact( req *intent.Request ) (*contacts.Contact, error) {
+	t := extractContactFromIntent( req )
+	if "" != t.GivenName || "" != t.LastName {
+		cts, err := contacts.DB.FindContactByNameCreatedBy( ownerID( req ), t.GivenName, t.LastName )
+		if nil != err || 0 == len(cts) {
+			return nil, err
+		}
+		// HACK, same as find_contact on a voice only device, use the first one
+		return cts[0], nil
+	}
 
-	rj.Speech = fmt.Sprintf( "You have %d contacts as of %v", numContacts, time.Now() )
-	rj.DisplayText = rj.Speech
+	cc := req.Context( "current_contact" )
+	if nil == cc {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt( cc.Parameters["id"], 10, 64 )
+	if nil != err {
+		return nil, nil
+	}
+	return contacts.GetContactOf( contacts.DB, ownerID( req ), id )
+}
 
-	return err
+func addContact( req *intent.Request, resp *intent.Response ) error {
+	msgs := messages( req )
+	// Only add the contact once it has a full name and the details given are valid
+	req = withDraft( req )
+	if askForSlot( req, resp, addContactSlots ) {
+		return nil
+	}
+	t := extractContactFromIntent( req )
+
+	c := &contacts.Contact{
+		FirstName: t.GivenName,
+		LastName: t.LastName,
+		Address: t.Address,
+		Email: t.Email,
+		Phone: t.Phone,
+	}
+	setCreator( req, c )
+
+	id, err := contacts.DB.AddContact( c )
+	if nil != err {
+		resp.Say( "%s", msgs.Sprintf( "add_contact.error", c.FirstName, c.LastName, err ) )
+		return err
+	}
+	c.ID = id
+
+	resp.Say( "%s", msgs.Sprintf( "add_contact.added", c.FirstName, c.LastName ) )
+	setCurrentContact( resp, c )
+
+	return nil
 }
 
-func deleteContact( ar *APIAIRequest, rj *APIAIMessage ) error {
-	var err error
-	numContacts := 0
+func updateContact( req *intent.Request, resp *intent.Response ) error {
+	msgs := messages( req )
+	// The details given so far carry over until there's a contact and a valid change
+	req = withDraft( req )
+	if askForSlot( req, resp, contactDetailSlots ) {
+		return nil
+	}
+	c, err := targetContact( req )
+	if nil != err {
+		resp.Say( "%s", msgs.Sprintf( "update_contact.error", err ) )
+		return err
+	}
+	if nil == c {
+		resp.Say( "%s", msgs.Sprintf( "update_contact.which" ) )
+		saveDraft( req, resp, "" )
+		return nil
+	}
 
-	// Do something to get this value
+	// The name identifies the contact, so only the other fields can change
+	t := extractContactFromIntent( req )
+	changed := false
+	for _, f := range []struct{ from string; to *string }{
+		{ t.Address, &c.Address },
+		{ t.Email, &c.Email },
+		{ t.Phone, &c.Phone },
+	} {
+		if "" != f.from {
+			*f.to = f.from
+			changed = true
+		}
+	}
+	if !changed {
+		askForChange( req, resp, c )
+		setCurrentContact( resp, c )
+		return nil
+	}
 
-	rj.Speech = fmt.Sprintf( "You have %d contacts as of %v", numContacts, time.Now() )
-	rj.DisplayText = rj.Speech
+	if err = contacts.DB.UpdateContact( c ); nil != err {
+		resp.Say( "%s", msgs.Sprintf( "update_contact.error", err ) )
+		return err
+	}
+	resp.Say( "%s", msgs.Sprintf( "update_contact.updated", c.FirstName, c.LastName ) )
+	setCurrentContact( resp, c )
 
-	return err
+	return nil
+}
+
+func deleteContact( req *intent.Request, resp *intent.Response ) error {
+	msgs := messages( req )
+	c, err := targetContact( req )
+	if nil != err {
+		resp.Say( "%s", msgs.Sprintf( "delete_contact.error", err ) )
+		return err
+	}
+	if nil == c {
+		resp.Say( "%s", msgs.Sprintf( "delete_contact.which" ) )
+		return nil
+	}
+
+	if err = contacts.DB.DeleteContact( c.ID ); nil != err {
+		resp.Say( "%s", msgs.Sprintf( "delete_contact.error", err ) )
+		return err
+	}
+	resp.Say( "%s", msgs.Sprintf( "delete_contact.deleted", c.FirstName, c.LastName ) )
+	// It's gone, so no longer the current_contact
+	resp.SetContext( intent.Context{ Name: "current_contact", Lifespan: 0 } )
+
+	return nil
 }
 
-func unhandledIntent( ar *APIAIRequest, rj *APIAIMessage ) error {
+func unhandledIntent( req *intent.Request, resp *intent.Response ) error {
 	var err error
 	numContacts := 0
 
 	// Do something to get this value
 
-	rj.Speech = fmt.Sprintf( "You have %d contacts as of %v", numContacts, time.Now() )
-	rj.DisplayText = rj.Speech
+	msgs := messages( req )
+	resp.Say( "%s", msgs.Plural( "unimplemented", numContacts, numContacts, now( msgs ) ) )
 
 	return err
 }
 
-func extractContactFromAPIAIRequest( ar *APIAIRequest ) *APIAIContact {
-	if nil == ar {
-		// HACK: this a) should never happen, and b) should probably throw and exception if it does
-		appErrorf( nil, "Missing APIAIRequest", ar )
-		return nil
-	}
+// timeNow is the clock of the webhook responses, the simulator fixes it for repeatable transcripts (see simulate.go)
+var timeNow = time.Now
+
+// now returns the current date and time formatted for the language of msgs
+func now( msgs *catalog.Catalog ) string {
+	return timeNow().Format( msgs.Sprintf( "format.datetime" ) )
+}
 
+func extractContactFromIntent( req *intent.Request ) *APIAIContact {
 	return &APIAIContact{
-		GivenName: ar.Result.Parameters["given-name"],
-		LastName: ar.Result.Parameters["last-name"],
-		Address: ar.Result.Parameters["address"],
-		Email: ar.Result.Parameters["email"],
-		Phone: ar.Result.Parameters["phone-number"],
+		GivenName: req.Param( "given-name" ),
+		LastName: req.Param( "last-name" ),
+		Address: req.Param( "address" ),
+		Email: req.Param( "email" ),
+		Phone: req.Param( "phone-number" ),
 	}
 }
@@ -0,0 +1,102 @@
+// 2017.09.04 rjj.work@gmail.com: Questions about one detail of a contact
+//	"what's Homer Simpson's email?", then "and his phone?" or "when did I add him?"
+//	The API.AI intent contact_field has the parameter contact-field (phone, email, address or created)
+//	and optionally given-name and last-name, without a name the current_contact context is used,
+//	see targetContact. The answer makes the contact the current_contact, so follow ups keep working.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+	"github.com/rjj-work/yum-contacts/ssml"
+)
+
+// contactFields maps the values of the contact-field parameter, and their
+// common synonyms, to the field they ask about.
+var contactFields = map[string]string{
+	"phone":        "phone",
+	"phone-number": "phone",
+	"phone number": "phone",
+	"number":       "phone",
+	"telephone":    "phone",
+	"email":        "email",
+	"e-mail":       "email",
+	"mail":         "email",
+	"address":      "address",
+	"where":        "address",
+	"created":      "created",
+	"added":        "created",
+	"date":         "created",
+	"when":         "created",
+}
+
+func init() {
+	intent.HandleFunc("contact_field", contactField)
+}
+
+func contactField(req *intent.Request, resp *intent.Response) error {
+	msgs := messages(req)
+	c, err := targetContact(req)
+	if err != nil {
+		resp.Say("%s", msgs.Sprintf("contact_field.error", err))
+		return err
+	}
+	if c == nil {
+		if t := extractContactFromIntent(req); t.GivenName != "" || t.LastName != "" {
+			resp.Say("%s", msgs.Sprintf("find_contact.not_found", t.GivenName, t.LastName))
+			return nil
+		}
+		resp.Say("%s", msgs.Sprintf("contact_field.which"))
+		return nil
+	}
+	// Follow ups are about this one now
+	setCurrentContact(resp, c)
+
+	field := contactFields[strings.ToLower(strings.TrimSpace(req.Param("contact-field")))]
+	if field == "" {
+		resp.Say("%s", msgs.Sprintf("contact_field.which_field", c.FirstName, c.LastName))
+		return nil
+	}
+
+	if field == "created" {
+		created, err := time.Parse(contacts.CreatedDateLayout, c.CreatedDate)
+		if err != nil {
+			resp.Say("%s", msgs.Sprintf("contact_field.created.unknown", c.FirstName, c.LastName))
+			return nil
+		}
+		resp.Say("%s", msgs.Sprintf("contact_field.created", c.FirstName, c.LastName,
+			created.Format(msgs.Sprintf("format.date"))))
+		return nil
+	}
+
+	value := map[string]string{"phone": c.Phone, "email": c.Email, "address": c.Address}[field]
+	if value == "" {
+		resp.Say("%s", msgs.Sprintf("contact_field."+field+".unknown", c.FirstName, c.LastName))
+		return nil
+	}
+	answerField(msgs, resp, c, field, value)
+	return nil
+}
+
+// answerField says the value of one field of c, phone numbers and emails
+// spelled out like respondWithContact does.
+func answerField(msgs *catalog.Catalog, resp *intent.Response, c *contacts.Contact, field, value string) {
+	intro := msgs.Sprintf("contact_field."+field, c.FirstName, c.LastName)
+	resp.DisplayText = intro + " " + value
+
+	sp := ssml.New().SetWords(speechWords(msgs)).Text(intro)
+	switch field {
+	case "phone":
+		sp.Phone(value)
+	case "email":
+		sp.Email(value)
+	default:
+		sp.Text(value)
+	}
+	resp.Speech, resp.SSML = sp.String(), true
+}
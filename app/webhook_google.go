@@ -0,0 +1,96 @@
+// 2017.08.27 rjj.work@gmail.com: Actions on Google rich responses for the webhook
+//	Only used when the device has a screen, voice only devices get the plain speech.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/aog"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+)
+
+// googleContactCard returns the response data showing c as a basic card,
+// with tappable tel:/mailto: links and suggestion chips for follow ups.
+func googleContactCard(msgs *catalog.Catalog, resp *intent.Response, c *contacts.Contact) map[string]interface{} {
+	name := strings.TrimSpace(c.FirstName + " " + c.LastName)
+	rr := richResponse(resp, name)
+
+	var lines []string
+	if c.Address != "" {
+		lines = append(lines, "**"+msgs.Sprintf("card.address")+":** "+c.Address)
+	}
+	if c.Phone != "" {
+		lines = append(lines, "**"+msgs.Sprintf("card.phone")+":** "+c.Phone)
+	}
+	if c.Email != "" {
+		lines = append(lines, "**"+msgs.Sprintf("card.email")+":** "+c.Email)
+	}
+	card := aog.BasicCard{
+		Title:         name,
+		FormattedText: strings.Join(lines, "  \n"),
+	}
+
+	// A basic card can only have one button, so email goes in a link out chip.
+	if c.Phone != "" {
+		card.Buttons = []aog.Button{{
+			Title:         msgs.Sprintf("chip.call"),
+			OpenURLAction: aog.OpenURLAction{URL: aog.TelURL(c.Phone)},
+		}}
+		rr.AddSuggestions(msgs.Sprintf("chip.call"))
+	}
+	if c.Email != "" {
+		rr.SetLinkOut(msgs.Sprintf("card.email_link", c.FirstName), aog.MailtoURL(c.Email))
+		rr.AddSuggestions(msgs.Sprintf("chip.email"))
+	}
+	rr.AddBasicCard(card).AddSuggestions(msgs.Sprintf("chip.edit_phone"))
+
+	return map[string]interface{}{
+		"google": &aog.Payload{ExpectUserResponse: true, RichResponse: rr},
+	}
+}
+
+// googleContactList returns the response data asking the user to pick one of
+// cts. The picked contact ID comes back to the select_contact intent.
+func googleContactList(msgs *catalog.Catalog, resp *intent.Response, cts []*contacts.Contact) map[string]interface{} {
+	// A list holds at most 30 items.
+	if len(cts) > 30 {
+		cts = cts[:30]
+	}
+
+	var items []aog.OptionItem
+	for _, c := range cts {
+		item := aog.OptionItem{
+			OptionInfo:  aog.OptionInfo{Key: strconv.FormatInt(c.ID, 10)},
+			Title:       strings.TrimSpace(c.FirstName + " " + c.LastName),
+			Description: c.Address,
+		}
+		// Let the user pick by saying the address or email too.
+		for _, s := range []string{c.Address, c.Email} {
+			if s != "" {
+				item.OptionInfo.Synonyms = append(item.OptionInfo.Synonyms, s)
+			}
+		}
+		items = append(items, item)
+	}
+
+	return map[string]interface{}{
+		"google": &aog.Payload{
+			ExpectUserResponse: true,
+			RichResponse:       richResponse(resp, resp.DisplayText),
+			SystemIntent:       aog.SelectList(msgs.Sprintf("card.list_title"), items),
+		},
+	}
+}
+
+// richResponse returns a RichResponse speaking the Speech of resp, SSML or
+// plain text, and showing displayText.
+func richResponse(resp *intent.Response, displayText string) *aog.RichResponse {
+	if resp.SSML {
+		return aog.NewSSMLRichResponse(resp.Speech, displayText)
+	}
+	return aog.NewRichResponse(resp.Speech, displayText)
+}
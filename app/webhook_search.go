@@ -0,0 +1,112 @@
+// 2017.09.03 rjj.work@gmail.com: Finding contacts by their attributes
+//	"who do I know in Springfield", "find the contact with phone ending 4567", "who is tagged family"
+//	The API.AI intent search_contacts has optional parameters, any combination of them is used:
+//		geo-city, email-domain, phone-prefix, phone-suffix, name (part of a name), tag,
+//		missing (email or phone, for contacts without one)
+
+package main
+
+import (
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/aog"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+)
+
+// maxSpokenNames is how many names a search summary reads out before "and N more".
+const maxSpokenNames = 5
+
+func init() {
+	intent.HandleFunc("search_contacts", searchContacts)
+}
+
+// criteriaFromIntent returns the search criteria in the parameters of req,
+// limited to the user's own contacts.
+func criteriaFromIntent(req *intent.Request) contacts.ContactCriteria {
+	missing := contactFields[strings.ToLower(strings.TrimSpace(req.Param("missing")))]
+	return contacts.ContactCriteria{
+		CreatedByID: ownerID(req),
+		City:        req.Param("geo-city"),
+		EmailDomain: req.Param("email-domain"),
+		PhonePrefix: req.Param("phone-prefix"),
+		PhoneSuffix: req.Param("phone-suffix"),
+		Name:        req.Param("name"),
+		Tag:         req.Param("tag"),
+
+		MissingEmail: missing == "email",
+		MissingPhone: missing == "phone",
+	}
+}
+
+func searchContacts(req *intent.Request, resp *intent.Response) error {
+	msgs := messages(req)
+	criteria := criteriaFromIntent(req)
+	if criteria.Empty() {
+		resp.Say("%s", msgs.Sprintf("search_contacts.no_criteria"))
+		return nil
+	}
+
+	cts, err := contacts.DB.FindContacts(criteria)
+	if err != nil {
+		resp.Say("%s", msgs.Sprintf("search_contacts.error", err))
+		return err
+	}
+
+	described := describeCriteria(msgs, criteria)
+	switch len(cts) {
+	case 0:
+		resp.Say("%s", msgs.Sprintf("search_contacts.none", described))
+	case 1:
+		respondWithContact(req, resp, cts[0], msgs.Sprintf("search_contacts.one", described))
+	default:
+		var names []string
+		for _, c := range cts {
+			names = append(names, c.FirstName+" "+c.LastName)
+		}
+		resp.Say("%s", msgs.Plural("search_contacts.found", len(cts), len(cts), described, joinNames(msgs, names)))
+		// On a screen they can pick one, see selectContact
+		if req.HasCapability(aog.CapabilityScreenOutput) {
+			resp.Data = googleContactList(msgs, resp, cts)
+		}
+	}
+	return nil
+}
+
+// describeCriteria returns the criteria as words, e.g. "in Springfield tagged family".
+func describeCriteria(msgs *catalog.Catalog, c contacts.ContactCriteria) string {
+	var parts []string
+	for _, p := range []struct{ key, value string }{
+		{"criteria.name", c.Name},
+		{"criteria.city", c.City},
+		{"criteria.email_domain", c.EmailDomain},
+		{"criteria.phone_prefix", c.PhonePrefix},
+		{"criteria.phone_suffix", c.PhoneSuffix},
+		{"criteria.tag", c.Tag},
+	} {
+		if p.value != "" {
+			parts = append(parts, msgs.Sprintf(p.key, p.value))
+		}
+	}
+	if c.MissingEmail {
+		parts = append(parts, msgs.Sprintf("criteria.missing_email"))
+	}
+	if c.MissingPhone {
+		parts = append(parts, msgs.Sprintf("criteria.missing_phone"))
+	}
+	return strings.Join(parts, " ")
+}
+
+// joinNames lists names for speech, e.g. "A, B and C", reading out at most
+// maxSpokenNames of them followed by "and N more".
+func joinNames(msgs *catalog.Catalog, names []string) string {
+	if len(names) > maxSpokenNames {
+		more := msgs.Sprintf("list.more", len(names)-maxSpokenNames)
+		return msgs.Sprintf("list.and", strings.Join(names[:maxSpokenNames], ", "), more)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return msgs.Sprintf("list.and", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
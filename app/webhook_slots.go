@@ -0,0 +1,147 @@
+// 2017.09.07 rjj.work@gmail.com: Slot filling for add_contact and update_contact
+//	"add a contact" - "What is the first name of the contact?" - "Ralph" - "What is the last name of Ralph?" - ...
+//	Each prompt leaves the answers so far in the contact_draft context, the next add_contact or update_contact
+//	carries them over (see withDraft), so the contact is only added or changed once all the required
+//	parameters are there. Emails and phone numbers are checked as they arrive, a bad one is asked again.
+//	With webhookForSlotFillingUsed API.AI calls us for every prompt, an answer it could not match to the
+//	parameter's entity (e.g. "ralph at example" for @sys.email) comes as the raw query and is checked the same way.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/intent"
+)
+
+const (
+	// draftContext holds the parameters of an incomplete add or update.
+	draftContext = "contact_draft"
+	// draftIntent and draftAwaiting are the intent the draft is for, and the
+	// parameter its last prompt asked for.
+	draftIntent   = "intent"
+	draftAwaiting = "awaiting"
+)
+
+// draftParams are the intent parameters a draft carries over.
+var draftParams = []string{"given-name", "last-name", "address", "email", "phone-number", "contact-field"}
+
+// A slot is a parameter that is required, or checked, before a contact is saved.
+type slot struct {
+	param string
+	// prompt asks for a missing required parameter, nil if it is optional.
+	prompt func(msgs *catalog.Catalog, params map[string]string) string
+	// check returns the value normalized, or false if it is not valid, then
+	// the invalid message asks again.
+	check   func(value string) (string, bool)
+	invalid string
+}
+
+// contactDetailSlots check the email and phone number, when given.
+var contactDetailSlots = []slot{
+	{param: "email", check: checkEmail, invalid: "slot.invalid_email"},
+	{param: "phone-number", check: contacts.NormalizePhone, invalid: "slot.invalid_phone"},
+}
+
+// fieldParams are the intent parameters of the contactFields.
+var fieldParams = map[string]string{"phone": "phone-number", "email": "email", "address": "address"}
+
+// addContactSlots also require the full name.
+var addContactSlots = append([]slot{
+	{param: "given-name", prompt: func(msgs *catalog.Catalog, params map[string]string) string {
+		return msgs.Sprintf("add_contact.missing_name")
+	}},
+	{param: "last-name", prompt: func(msgs *catalog.Catalog, params map[string]string) string {
+		return msgs.Sprintf("add_contact.missing_last_name", params["given-name"])
+	}},
+}, contactDetailSlots...)
+
+func checkEmail(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	return value, contacts.ValidEmail(value)
+}
+
+// withDraft returns req with the parameters of the contact_draft context
+// for the same intent added, where req doesn't have them. While slot filling
+// the query answers the parameter last asked for.
+func withDraft(req *intent.Request) *intent.Request {
+	params := map[string]string{}
+	for k, v := range req.Params {
+		params[k] = v
+	}
+	r := *req
+	r.Params = params
+
+	for _, c := range req.Contexts {
+		if !strings.EqualFold(c.Name, draftContext) || c.Parameters[draftIntent] != req.Intent {
+			continue
+		}
+		for _, p := range draftParams {
+			if params[p] == "" {
+				params[p] = c.Parameters[p]
+			}
+		}
+		if awaiting := c.Parameters[draftAwaiting]; awaiting != "" && params[awaiting] == "" && req.SlotFilling {
+			params[awaiting] = strings.TrimSpace(req.Query)
+		}
+	}
+	return &r
+}
+
+// askForSlot checks the slots of req, normalizing their values in place.
+// It says the prompt and saves the draft, then returns true, for the first
+// bad value, or else the first missing required one.
+func askForSlot(req *intent.Request, resp *intent.Response, slots []slot) bool {
+	msgs := messages(req)
+	for _, s := range slots {
+		v := req.Params[s.param]
+		if v == "" || s.check == nil {
+			continue
+		}
+		checked, ok := s.check(v)
+		if !ok {
+			delete(req.Params, s.param)
+			resp.Say("%s", msgs.Sprintf(s.invalid, v))
+			saveDraft(req, resp, s.param)
+			return true
+		}
+		req.Params[s.param] = checked
+	}
+	for _, s := range slots {
+		if s.prompt != nil && strings.TrimSpace(req.Params[s.param]) == "" {
+			resp.Say("%s", s.prompt(msgs, req.Params))
+			saveDraft(req, resp, s.param)
+			return true
+		}
+	}
+	return false
+}
+
+// askForChange prompts for the new value of the contact-field of an update,
+// or for what to change at all.
+func askForChange(req *intent.Request, resp *intent.Response, c *contacts.Contact) {
+	msgs := messages(req)
+	param := fieldParams[contactFields[strings.ToLower(strings.TrimSpace(req.Param("contact-field")))]]
+	if param == "" {
+		resp.Say("%s", msgs.Sprintf("update_contact.nothing", c.FirstName, c.LastName))
+	} else {
+		resp.Say("%s", msgs.Sprintf("update_contact.ask_"+param, c.FirstName, c.LastName))
+	}
+	saveDraft(req, resp, param)
+}
+
+// saveDraft keeps the parameters of req for the answer to the prompt about
+// the awaiting parameter, "" if the prompt isn't about one. The draft lasts
+// for that answer only, so it is dropped once the contact is saved or the
+// user moves on.
+func saveDraft(req *intent.Request, resp *intent.Response, awaiting string) {
+	params := map[string]string{draftIntent: req.Intent, draftAwaiting: awaiting}
+	for _, p := range draftParams {
+		if v := req.Params[p]; v != "" {
+			params[p] = v
+		}
+	}
+	resp.SetContext(intent.Context{Name: draftContext, Lifespan: 1, Parameters: params})
+}
@@ -0,0 +1,92 @@
+// 2017.09.06 rjj.work@gmail.com: Counting some of the contacts
+//	"how many contacts did I add last month", "how many contacts tagged work have no email"
+//	The API.AI intent number_of_contacts has optional parameters, besides the ones of search_contacts:
+//		date-period (sys.date-period), date (sys.date) or since (sys.date) for when contacts were added
+//	Without any, tallyContacts answers with the total as before.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/catalog"
+	"github.com/rjj-work/yum-contacts/daterange"
+	"github.com/rjj-work/yum-contacts/intent"
+)
+
+// tallyPeriod returns when the contacts to count were added, from the date
+// parameters of req, and whether there is one. Dates are days in UTC, like
+// the created dates in the database.
+func tallyPeriod(req *intent.Request) (daterange.Range, bool, error) {
+	if p := req.Param("date-period"); p != "" {
+		r, err := daterange.ParsePeriod(p, time.UTC)
+		return r, true, err
+	}
+	if d := req.Param("date"); d != "" {
+		r, err := daterange.ParseDate(d, time.UTC)
+		return r, true, err
+	}
+	if d := req.Param("since"); d != "" {
+		r, err := daterange.ParseDate(d, time.UTC)
+		return daterange.Since(r.Start), true, err
+	}
+	return daterange.Range{}, false, nil
+}
+
+// tallyMatching answers with the number of the user's contacts matching the
+// criteria of req, added during period if any.
+func tallyMatching(req *intent.Request, resp *intent.Response, criteria contacts.ContactCriteria, period daterange.Range, hasPeriod bool) error {
+	msgs := messages(req)
+	if hasPeriod {
+		criteria.CreatedSince, criteria.CreatedBefore = period.Start, period.End
+	}
+	tally, err := contacts.DB.TallyContactsMatching(criteria)
+	if err != nil {
+		resp.Say("%s", msgs.Sprintf("number_of_contacts.error", err))
+		return err
+	}
+
+	described := describeCriteria(msgs, criteria)
+	if !hasPeriod {
+		resp.Say("%s", msgs.Plural("number_of_contacts.matching", int(tally), tally, described))
+		return nil
+	}
+	when := describePeriod(msgs, period, timeNow().UTC())
+	resp.Say("%s", msgs.Plural("number_of_contacts.added", int(tally), tally, strings.TrimSpace(described+" "+when)))
+	return nil
+}
+
+// describePeriod returns r as words relative to now, e.g. "last month" or
+// "between August 3, 2017 and August 9, 2017".
+func describePeriod(msgs *catalog.Catalog, r daterange.Range, now time.Time) string {
+	date := func(t time.Time) string { return t.Format(msgs.Sprintf("format.date")) }
+	switch r.Kind(now) {
+	case daterange.Today:
+		return msgs.Sprintf("period.today")
+	case daterange.Yesterday:
+		return msgs.Sprintf("period.yesterday")
+	case daterange.ThisWeek:
+		return msgs.Sprintf("period.this_week")
+	case daterange.LastWeek:
+		return msgs.Sprintf("period.last_week")
+	case daterange.ThisMonth:
+		return msgs.Sprintf("period.this_month")
+	case daterange.LastMonth:
+		return msgs.Sprintf("period.last_month")
+	case daterange.ThisYear:
+		return msgs.Sprintf("period.this_year")
+	case daterange.LastYear:
+		return msgs.Sprintf("period.last_year")
+	case daterange.Day:
+		return msgs.Sprintf("period.day", date(r.Start))
+	case daterange.Open:
+		return msgs.Sprintf("period.since", date(r.Start))
+	case daterange.Month:
+		return msgs.Sprintf("period.month", r.Start.Format(msgs.Sprintf("format.month")))
+	case daterange.Year:
+		return msgs.Sprintf("period.year", r.Start.Year())
+	}
+	return msgs.Sprintf("period.range", date(r.Start), date(r.Last()))
+}
@@ -0,0 +1,120 @@
+// 2017.08.31 rjj.work@gmail.com: Who is speaking to the webhook
+//	With account linking (Google Sign-In as the provider), Actions on Google passes the user's
+//	access token in the original request. The token gets the same Google profile as the web
+//	login (see auth.go), so the profile ID matches the createdById of the user's contacts.
+//	Users who have not linked their account are anonymous, like logged out users of the web app.
+// 2017.09.01 rjj.work@gmail.com: With our own authorization server (see oauth_server.go) the token
+//	is one of ours, validated locally instead of asking Google.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/plus/v1"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/intent"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// profileCacheTTL is how long a token to profile lookup is reused, it saves a
+// round trip to Google on every turn of a conversation.
+const profileCacheTTL = 10 * time.Minute
+
+type cachedProfile struct {
+	profile *Profile
+	expires time.Time
+}
+
+var (
+	profileCacheMu sync.Mutex
+	profileCache   = map[string]cachedProfile{}
+)
+
+// webhookUser returns the profile of the linked account for ar, or nil if the
+// user has not linked their account. Tokens contacts.OAuthServer doesn't know
+// (any more) give oauthserver.ErrInvalidToken.
+func webhookUser(ar *APIAIRequest) (*Profile, error) {
+	token := ar.OriginalRequest.Data.User.AccessToken
+	if token == "" {
+		token = ar.OriginalRequest.Data.User.AccessTokenV2
+	}
+	return userFromToken(token)
+}
+
+// userFromToken returns the profile of the user the access token of a linked
+// account was issued to, nil for "". Alexa requests carry the same tokens,
+// see alexa.go.
+func userFromToken(token string) (*Profile, error) {
+	if token == "" {
+		return nil, nil
+	}
+	if contacts.OAuthServer != nil {
+		return profileFromBearerToken(token)
+	}
+
+	profileCacheMu.Lock()
+	c, ok := profileCache[token]
+	profileCacheMu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.profile, nil
+	}
+
+	profile, err := profileFromAccessToken(context.Background(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	profileCacheMu.Lock()
+	defer profileCacheMu.Unlock()
+	for t, c := range profileCache {
+		if time.Now().After(c.expires) {
+			delete(profileCache, t)
+		}
+	}
+	profileCache[token] = cachedProfile{profile: profile, expires: time.Now().Add(profileCacheTTL)}
+	return profile, nil
+}
+
+// profileFromAccessToken retrieves the Google+ profile of the user the access
+// token was issued to, oauthserver.ErrInvalidToken when Google refuses the token.
+func profileFromAccessToken(ctx context.Context, token string) (*Profile, error) {
+	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	plusService, err := plus.New(client)
+	if err != nil {
+		return nil, err
+	}
+	person, err := plusService.People.Get("me").Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusUnauthorized {
+		return nil, oauthserver.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stripProfile(person), nil
+}
+
+// ownerID returns the createdById the intents of req work on.
+func ownerID(req *intent.Request) string {
+	if req.UserID == "" {
+		return "anonymous"
+	}
+	return req.UserID
+}
+
+// setCreator marks c as created by the user of req.
+func setCreator(req *intent.Request, c *contacts.Contact) {
+	if req.UserID == "" {
+		c.SetCreatorAnonymous()
+		return
+	}
+	c.CreatedBy = req.UserName
+	c.CreatedByID = req.UserID
+}
@@ -0,0 +1,49 @@
+// 2017.08.31 rjj.work@gmail.com: Tests of who is speaking to the webhook, see webhook_user.go
+//	CONTACTS_DB=memory go test -run TestWebhookUser
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// brokenTokenStore is an oauthserver.Store whose database is down.
+type brokenTokenStore struct {
+	*oauthserver.MemoryStore
+}
+
+func (brokenTokenStore) Get(hash string) (*oauthserver.Token, error) {
+	return nil, errors.New("database is down")
+}
+
+func TestWebhookUser(t *testing.T) {
+	defer func(s *oauthserver.Server) { contacts.OAuthServer = s }(contacts.OAuthServer)
+	webhook := func(token string) *httptest.ResponseRecorder {
+		body := `{"result": {"metadata": {"intentName": "number_of_contacts"}},
+			"originalRequest": {"data": {"user": {"accessToken": "` + token + `"}}}}`
+		w := httptest.NewRecorder()
+		appHandler(webhookHandler).ServeHTTP(w, httptest.NewRequest("POST", "/contactsWebhook", strings.NewReader(body)))
+		return w
+	}
+
+	contacts.OAuthServer = &oauthserver.Server{Store: oauthserver.NewMemoryStore()}
+	if w := webhook("revoked"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := webhook(""); w.Code != http.StatusOK {
+		t.Errorf("anonymous: got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// Not anonymous when the token can't be checked
+	contacts.OAuthServer = &oauthserver.Server{Store: brokenTokenStore{oauthserver.NewMemoryStore()}}
+	if w := webhook("token"); w.Code != http.StatusInternalServerError {
+		t.Errorf("token store down: got %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+}
@@ -0,0 +1,255 @@
+// 2017.09.19 rjj: CardDAV client of a remote address book, for the sync of package carddavsync.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package carddav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts/vcard"
+)
+
+// ErrPreconditionFailed is returned by Put and Delete when the card isn't
+// the one of the ETag any more, or already exists.
+var ErrPreconditionFailed = errors.New("carddav: the card changed")
+
+// Client reads and writes the cards of a remote address book.
+type Client struct {
+	// URL is that of the address book, e.g. https://dav.example.com/addressbooks/team/contacts/.
+	URL string
+	// Username and Password are sent with HTTP Basic authentication, unless empty.
+	Username, Password string
+	// HTTPClient is http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// Object is a card of the address book.
+type Object struct {
+	// Href is the path of the card, as the server names it.
+	Href string
+	ETag string
+	// Card is nil from List, and from Multiget when Err says why it couldn't
+	// be read.
+	Card *vcard.Card
+	Err  error
+}
+
+// msResponse is a response of a 207 Multi-Status.
+type msResponse struct {
+	Href      string `xml:"DAV: href"`
+	Status    string `xml:"DAV: status"`
+	PropStats []struct {
+		Prop   msProp `xml:"DAV: prop"`
+		Status string `xml:"DAV: status"`
+	} `xml:"DAV: propstat"`
+}
+
+// msProp are the properties the client asks for.
+type msProp struct {
+	ETag         string `xml:"DAV: getetag"`
+	AddressData  string `xml:"urn:ietf:params:xml:ns:carddav address-data"`
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+}
+
+// found returns the properties of the 200 propstat, nil for none.
+func (r *msResponse) found() *msProp {
+	for i := range r.PropStats {
+		if strings.Contains(r.PropStats[i].Status, " 200 ") {
+			return &r.PropStats[i].Prop
+		}
+	}
+	return nil
+}
+
+// resolve returns the URL of a href of the address book.
+func (c *Client) resolve(href string) (string, error) {
+	base, err := url.Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("carddav: bad address book URL: %v", err)
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("carddav: bad href %q: %v", href, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// do sends a request for href with the headers as name and value pairs.
+func (c *Client) do(method, href string, body io.Reader, headers ...string) (*http.Response, error) {
+	u, err := c.resolve(href)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("carddav: %s %s: %v", method, u, err)
+	}
+	return resp, nil
+}
+
+// multistatus sends a PROPFIND or a REPORT and returns its responses.
+func (c *Client) multistatus(method, href, body string, headers ...string) ([]msResponse, error) {
+	headers = append(headers, "Content-Type", "application/xml; charset=utf-8")
+	resp, err := c.do(method, href, strings.NewReader(xml.Header+body), headers...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError(method, href, resp)
+	}
+	var ms struct {
+		Responses []msResponse `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("carddav: bad %s response: %v", method, err)
+	}
+	return ms.Responses, nil
+}
+
+// statusError returns the error of an unexpected status.
+func statusError(method, href string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("carddav: %s %s: %s: %s", method, href, resp.Status, bytes.TrimSpace(body))
+}
+
+// List returns the hrefs and ETags of the cards of the address book.
+func (c *Client) List() ([]Object, error) {
+	rs, err := c.multistatus("PROPFIND", c.URL,
+		`<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getetag/></d:prop></d:propfind>`,
+		"Depth", "1")
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for i := range rs {
+		p := rs[i].found()
+		// The address book itself, and any collections in it
+		if p == nil || p.ResourceType.Collection != nil {
+			continue
+		}
+		objects = append(objects, Object{Href: rs[i].Href, ETag: p.ETag})
+	}
+	return objects, nil
+}
+
+// Multiget returns the cards of hrefs, in the order of the server, without
+// those it doesn't have any more. A card that can't be read is returned
+// with its Err, the others are still read.
+func (c *Client) Multiget(hrefs []string) ([]Object, error) {
+	if len(hrefs) == 0 {
+		return nil, nil
+	}
+	var b bytes.Buffer
+	b.WriteString(`<card:addressbook-multiget xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">`)
+	b.WriteString(`<d:prop><d:getetag/><card:address-data/></d:prop>`)
+	for _, h := range hrefs {
+		b.WriteString(href(h))
+	}
+	b.WriteString(`</card:addressbook-multiget>`)
+	rs, err := c.multistatus("REPORT", c.URL, b.String(), "Depth", "1")
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for i := range rs {
+		p := rs[i].found()
+		if p == nil {
+			continue
+		}
+		o := Object{Href: rs[i].Href, ETag: p.ETag}
+		if o.Card, err = vcard.NewDecoder(strings.NewReader(p.AddressData)).Decode(); err != nil {
+			o.Card, o.Err = nil, fmt.Errorf("carddav: bad card %s: %v", rs[i].Href, err)
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// Put writes the card of href, if its ETag is still etag, or if there is no
+// such card for etag "". It returns the href of the card, which the server
+// may have named itself, and its new ETag if the server tells it.
+func (c *Client) Put(href string, card *vcard.Card, etag string) (newHref, newETag string, err error) {
+	var b bytes.Buffer
+	if err := vcard.NewEncoder(&b).Encode(card); err != nil {
+		return "", "", err
+	}
+	condition := []string{"If-None-Match", "*"}
+	if etag != "" {
+		condition = []string{"If-Match", etag}
+	}
+	resp, err := c.do("PUT", href, &b, append(condition, "Content-Type", "text/vcard; charset=utf-8")...)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent, http.StatusOK:
+	case http.StatusPreconditionFailed:
+		return "", "", ErrPreconditionFailed
+	default:
+		return "", "", statusError("PUT", href, resp)
+	}
+	newHref = href
+	if loc := resp.Header.Get("Location"); loc != "" {
+		if u, err := url.Parse(loc); err == nil {
+			newHref = u.EscapedPath()
+		}
+	}
+	return newHref, resp.Header.Get("ETag"), nil
+}
+
+// ETag returns the ETag of the card of href.
+func (c *Client) ETag(href string) (string, error) {
+	resp, err := c.do("HEAD", href, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError("HEAD", href, resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// Delete deletes the card of href, if its ETag is still etag. A card
+// already gone is not an error.
+func (c *Client) Delete(href, etag string) error {
+	resp, err := c.do("DELETE", href, nil, "If-Match", etag)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK, http.StatusNotFound:
+		return nil
+	case http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	}
+	return statusError("DELETE", href, resp)
+}
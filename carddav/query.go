@@ -0,0 +1,169 @@
+// 2017.09.18 rjj: The filters of addressbook-query reports.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package carddav
+
+import (
+	"strings"
+
+	"github.com/rjj-work/yum-contacts/vcard"
+)
+
+// filter selects cards by their properties (RFC 6352 section 10.5), e.g. the
+// cards whose EMAIL contains "@example.com". No prop-filter matches any card.
+type filter struct {
+	// Test is "anyof", the default, or "allof" the prop-filters.
+	Test        string       `xml:"test,attr"`
+	PropFilters []propFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+// propFilter matches the cards having a property, or without it
+// (is-not-defined), that meets the text-matches and param-filters.
+type propFilter struct {
+	Name         string        `xml:"name,attr"`
+	Test         string        `xml:"test,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []textMatch   `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+	ParamFilters []paramFilter `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+}
+
+// paramFilter matches a parameter of the property, e.g. TYPE=work.
+type paramFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+// textMatch compares a value with Text.
+type textMatch struct {
+	Text string `xml:",chardata"`
+	// Collation is "i;unicode-casemap", the default, or "i;ascii-casemap",
+	// both case insensitive, or "i;octet".
+	Collation string `xml:"collation,attr"`
+	// MatchType is "contains", the default, "equals", "starts-with" or "ends-with".
+	MatchType string `xml:"match-type,attr"`
+	// NegateCondition is "yes" to match the values that don't match.
+	NegateCondition string `xml:"negate-condition,attr"`
+}
+
+// supported reports whether the collations and match types are those
+// match knows.
+func (f *filter) supported() bool {
+	ok := func(tm *textMatch) bool {
+		switch tm.Collation {
+		case "", "i;unicode-casemap", "i;ascii-casemap", "i;octet":
+		default:
+			return false
+		}
+		switch tm.MatchType {
+		case "", "contains", "equals", "starts-with", "ends-with":
+			return true
+		}
+		return false
+	}
+	for _, pf := range f.PropFilters {
+		for i := range pf.TextMatches {
+			if !ok(&pf.TextMatches[i]) {
+				return false
+			}
+		}
+		for _, param := range pf.ParamFilters {
+			if param.TextMatch != nil && !ok(param.TextMatch) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// match reports whether the card passes the filter.
+func (f *filter) match(card *vcard.Card) bool {
+	if len(f.PropFilters) == 0 {
+		return true
+	}
+	for _, pf := range f.PropFilters {
+		m := pf.match(card)
+		if f.Test == "allof" && !m {
+			return false
+		}
+		if f.Test != "allof" && m {
+			return true
+		}
+	}
+	return f.Test == "allof"
+}
+
+func (pf *propFilter) match(card *vcard.Card) bool {
+	props := card.All(pf.Name)
+	if pf.IsNotDefined != nil {
+		return len(props) == 0
+	}
+	for _, p := range props {
+		if pf.matchProperty(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchProperty reports whether p meets the tests of the filter, any or all.
+func (pf *propFilter) matchProperty(p *vcard.Property) bool {
+	var tests []bool
+	for i := range pf.TextMatches {
+		tests = append(tests, pf.TextMatches[i].match(p.Text()))
+	}
+	for _, param := range pf.ParamFilters {
+		tests = append(tests, param.match(p))
+	}
+	if len(tests) == 0 {
+		return true
+	}
+	for _, t := range tests {
+		if pf.Test == "allof" && !t {
+			return false
+		}
+		if pf.Test != "allof" && t {
+			return true
+		}
+	}
+	return pf.Test == "allof"
+}
+
+func (pf *paramFilter) match(p *vcard.Property) bool {
+	values := p.Params[strings.ToUpper(pf.Name)]
+	if pf.IsNotDefined != nil {
+		return len(values) == 0
+	}
+	if pf.TextMatch == nil {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		// TYPE=work,pref in 3.0
+		for _, item := range strings.Split(v, ",") {
+			if pf.TextMatch.match(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (tm *textMatch) match(s string) bool {
+	text := tm.Text
+	if tm.Collation != "i;octet" {
+		s, text = strings.ToLower(s), strings.ToLower(text)
+	}
+	var m bool
+	switch tm.MatchType {
+	case "equals":
+		m = s == text
+	case "starts-with":
+		m = strings.HasPrefix(s, text)
+	case "ends-with":
+		m = strings.HasSuffix(s, text)
+	default:
+		m = strings.Contains(s, text)
+	}
+	return m != (tm.NegateCondition == "yes")
+}
@@ -0,0 +1,682 @@
+// 2017.09.18 rjj: CardDAV server of the contacts of each user.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package carddav serves the contacts each user created as their CardDAV
+// address book (RFC 6352), so the address books of phones and desktops show
+// and edit them. Under the prefix of NewHandler:
+//
+//	/		the principal of the user and the home of their address book
+//	/contacts/	the address book: PROPFIND, and the addressbook-multiget,
+//			addressbook-query and sync-collection (RFC 6578) REPORTs
+//	/contacts/{name}.vcf	a card: GET, PUT and DELETE, with ETags
+//
+// Cards are vCard 3.0, or 4.0 where address-data asks for it, see package
+// vcard. A card a client PUTs under a name of its own becomes a contact
+// with a new ID and stays at that name, kept in a contacts.CardDAVNameStore,
+// the other contacts are at {id}.vcf.
+package carddav
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/vcard"
+)
+
+// maxBody bounds the size of the requests, cards included.
+const maxBody = 1 << 20
+
+// syncTokenPrefix makes the sync tokens URIs, as RFC 6578 wants them.
+const syncTokenPrefix = "urn:x-yum-contacts:sync:"
+
+type handler struct {
+	db     contacts.ContactDatabase
+	names  contacts.CardDAVNameStore
+	prefix string
+}
+
+// NewHandler returns the CardDAV server of the contacts of db under prefix,
+// e.g. "/carddav", for the User of the context of the requests, with the
+// names of the cards the clients created in names. The sync-collection
+// REPORT needs the changes of a *contacts.WatchedDatabase, it isn't
+// supported on other databases.
+func NewHandler(db contacts.ContactDatabase, names contacts.CardDAVNameStore, prefix string) http.Handler {
+	return &handler{db: db, names: names, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+// The kinds of resources.
+const (
+	rootResource = iota
+	bookResource
+	cardResource
+)
+
+func (h *handler) rootPath() string { return h.prefix + "/" }
+func (h *handler) bookPath() string { return h.prefix + "/contacts/" }
+func (h *handler) cardPath(id int64) string {
+	return h.bookPath() + strconv.FormatInt(id, 10) + ".vcf"
+}
+
+// namedPath returns the path of the card of the contact id, at its name in
+// names if a client gave it one.
+func (h *handler) namedPath(id int64, names map[int64]string) string {
+	if name, ok := names[id]; ok {
+		return h.bookPath() + url.PathEscape(name)
+	}
+	return h.cardPath(id)
+}
+
+// resource returns the kind of resource of a path, and the name of a card.
+func (h *handler) resource(path string) (kind int, name string, ok bool) {
+	if !strings.HasPrefix(path, h.prefix+"/") {
+		return 0, "", false
+	}
+	rel := strings.TrimPrefix(path, h.prefix)
+	switch {
+	case rel == "/":
+		return rootResource, "", true
+	case rel == "/contacts/" || rel == "/contacts":
+		return bookResource, "", true
+	case strings.HasPrefix(rel, "/contacts/") && !strings.Contains(rel[len("/contacts/"):], "/"):
+		return cardResource, rel[len("/contacts/"):], true
+	}
+	return 0, "", false
+}
+
+var allows = map[int]string{
+	rootResource: "OPTIONS, PROPFIND",
+	bookResource: "OPTIONS, PROPFIND, REPORT",
+	cardResource: "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE",
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := contacts.UserFromContext(r.Context())
+	if u == nil || u.ID == "" {
+		http.Error(w, "carddav: no user", http.StatusUnauthorized)
+		return
+	}
+	kind, name, ok := h.resource(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+
+	switch {
+	case r.Method == "OPTIONS":
+		w.Header().Set("DAV", "1, 3, addressbook")
+		w.Header().Set("Allow", allows[kind])
+	case r.Method == "PROPFIND":
+		h.propfind(w, r, u, kind, name)
+	case r.Method == "REPORT" && kind == bookResource:
+		h.report(w, r, u)
+	case (r.Method == "GET" || r.Method == "HEAD") && kind == cardResource:
+		h.get(w, r, u, name)
+	case r.Method == "PUT" && kind == cardResource:
+		h.put(w, r, u, name)
+	case r.Method == "DELETE" && kind == cardResource:
+		h.delete(w, r, u, name)
+	default:
+		w.Header().Set("Allow", allows[kind])
+		http.Error(w, "carddav: method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// contact returns the contact of the card name of u, nil when there is none.
+func (h *handler) contact(u *contacts.User, name string) (*contacts.Contact, error) {
+	id, err := h.names.CardContact(u.ID, name)
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		id, err = strconv.ParseInt(strings.TrimSuffix(name, ".vcf"), 10, 64)
+		if err != nil || !strings.HasSuffix(name, ".vcf") {
+			return nil, nil
+		}
+	}
+	return contacts.GetContactOf(h.db, u.ID, id)
+}
+
+// encode returns c as a card of version.
+func encode(c *contacts.Contact, version string) []byte {
+	var b bytes.Buffer
+	vcard.NewEncoder(&b).Encode(vcard.FromContact(c, version))
+	return b.Bytes()
+}
+
+// etag returns the ETag of the card of c, which changes with any field.
+func etag(c *contacts.Contact) string {
+	sum := sha1.Sum(encode(c, vcard.Version3))
+	return fmt.Sprintf(`"%x"`, sum[:8])
+}
+
+// lastModified returns when c was last changed, the zero time if unknown.
+func lastModified(c *contacts.Contact) time.Time {
+	for _, s := range []string{c.LastEdited, c.CreatedDate} {
+		if t, err := time.Parse(contacts.CreatedDateLayout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// syncToken returns the sync token of a revision.
+func syncToken(rev contacts.Revision) string {
+	return syncTokenPrefix + rev.String()
+}
+
+func davName(local string) xml.Name     { return xml.Name{Space: nsDAV, Local: local} }
+func cardDAVName(local string) xml.Name { return xml.Name{Space: nsCardDAV, Local: local} }
+
+// getter returns the XML of a property of a resource, false if it hasn't it.
+type getter func(name propName) (string, bool)
+
+// respond adds the properties of href asked for to ms, all for nil names.
+func respond(ms *multistatus, href string, get getter, names []propName, all []xml.Name, namesOnly bool) {
+	if names == nil {
+		for _, n := range all {
+			names = append(names, propName{XMLName: n})
+		}
+	}
+	var found []property
+	var missing []xml.Name
+	for _, n := range names {
+		v, ok := get(n)
+		if !ok {
+			missing = append(missing, n.XMLName)
+			continue
+		}
+		if namesOnly {
+			v = ""
+		}
+		found = append(found, property{n.XMLName, v})
+	}
+	ms.response(href, found, missing)
+}
+
+// rootProps are the properties of the principal, which is also the home of
+// the address book.
+var rootProps = []xml.Name{
+	davName("resourcetype"), davName("displayname"), davName("current-user-principal"),
+	davName("principal-URL"), cardDAVName("addressbook-home-set"),
+}
+
+func (h *handler) rootGetter(u *contacts.User) getter {
+	self := href(h.rootPath())
+	return func(n propName) (string, bool) {
+		switch n.XMLName {
+		case davName("resourcetype"):
+			return "<d:collection/><d:principal/>", true
+		case davName("displayname"):
+			if u.Name == "" {
+				return escape(u.ID), true
+			}
+			return escape(u.Name), true
+		case davName("current-user-principal"), davName("principal-URL"), davName("owner"),
+			cardDAVName("addressbook-home-set"):
+			return self, true
+		case davName("current-user-privilege-set"):
+			return "<d:privilege><d:read/></d:privilege>", true
+		}
+		return "", false
+	}
+}
+
+// bookProps are the properties of the address book for allprop.
+var bookProps = []xml.Name{
+	davName("resourcetype"), davName("displayname"), cardDAVName("addressbook-description"),
+	cardDAVName("supported-address-data"), cardDAVName("max-resource-size"),
+	davName("supported-report-set"), davName("sync-token"), xml.Name{Space: nsCS, Local: "getctag"},
+}
+
+func (h *handler) bookGetter(u *contacts.User) getter {
+	watched, _ := h.db.(*contacts.WatchedDatabase)
+	return func(n propName) (string, bool) {
+		switch n.XMLName {
+		case davName("resourcetype"):
+			return "<d:collection/><card:addressbook/>", true
+		case davName("displayname"):
+			return "Contacts", true
+		case cardDAVName("addressbook-description"):
+			return escape("The contacts of " + u.Name), true
+		case cardDAVName("supported-address-data"):
+			return `<card:address-data-type content-type="text/vcard" version="3.0"/>` +
+				`<card:address-data-type content-type="text/vcard" version="4.0"/>`, true
+		case cardDAVName("max-resource-size"):
+			return strconv.Itoa(maxBody), true
+		case davName("supported-report-set"):
+			reports := []string{"card:addressbook-multiget", "card:addressbook-query"}
+			if watched != nil {
+				reports = append(reports, "d:sync-collection")
+			}
+			var b bytes.Buffer
+			for _, r := range reports {
+				fmt.Fprintf(&b, "<d:supported-report><d:report><%s/></d:report></d:supported-report>", r)
+			}
+			return b.String(), true
+		case davName("sync-token"), xml.Name{Space: nsCS, Local: "getctag"}:
+			if watched == nil {
+				return "", false
+			}
+			return escape(syncToken(watched.Revision())), true
+		case davName("current-user-principal"), davName("owner"):
+			return href(h.rootPath()), true
+		case davName("current-user-privilege-set"):
+			return "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+				"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+				"<d:privilege><d:unbind/></d:privilege>", true
+		}
+		return "", false
+	}
+}
+
+// cardProps are the properties of a card for allprop, address-data is
+// only returned when asked for.
+var cardProps = []xml.Name{
+	davName("resourcetype"), davName("getetag"), davName("getcontenttype"),
+	davName("getcontentlength"), davName("getlastmodified"),
+}
+
+func cardGetter(c *contacts.Contact) getter {
+	return func(n propName) (string, bool) {
+		switch n.XMLName {
+		case davName("resourcetype"):
+			return "", true
+		case davName("getetag"):
+			return escape(etag(c)), true
+		case davName("getcontenttype"):
+			return "text/vcard; charset=utf-8", true
+		case davName("getcontentlength"):
+			return strconv.Itoa(len(encode(c, vcard.Version3))), true
+		case davName("getlastmodified"):
+			t := lastModified(c)
+			if t.IsZero() {
+				return "", false
+			}
+			return t.UTC().Format(http.TimeFormat), true
+		case cardDAVName("address-data"):
+			version := vcard.Version3
+			if n.Version == vcard.Version4 {
+				version = vcard.Version4
+			}
+			return escape(string(encode(c, version))), true
+		}
+		return "", false
+	}
+}
+
+// depth returns the Depth header, 0 or 1: a client asking for more gets
+// the address book and its cards, there are no deeper resources.
+func depth(r *http.Request) int {
+	if r.Header.Get("Depth") == "0" {
+		return 0
+	}
+	return 1
+}
+
+// readXML decodes the body of r into v, and tells the client when it can't.
+func readXML(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := xml.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, fmt.Sprintf("carddav: bad XML body: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (h *handler) propfind(w http.ResponseWriter, r *http.Request, u *contacts.User, kind int, name string) {
+	pf := &propfind{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("carddav: could not read the body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, pf); err != nil {
+			http.Error(w, fmt.Sprintf("carddav: bad XML body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	var names []propName
+	if pf.Prop != nil && pf.AllProp == nil {
+		names = pf.Prop.Names
+	}
+	namesOnly := pf.PropName != nil
+
+	ms := newMultistatus()
+	switch kind {
+	case rootResource:
+		respond(ms, h.rootPath(), h.rootGetter(u), names, rootProps, namesOnly)
+		if depth(r) > 0 {
+			respond(ms, h.bookPath(), h.bookGetter(u), names, bookProps, namesOnly)
+		}
+	case bookResource:
+		respond(ms, h.bookPath(), h.bookGetter(u), names, bookProps, namesOnly)
+		if depth(r) > 0 {
+			cardNames, err := h.names.CardNames(u.ID)
+			if err == nil {
+				err = h.db.ForEachContact(contacts.ContactCriteria{CreatedByID: u.ID}, func(c *contacts.Contact) error {
+					respond(ms, h.namedPath(c.ID, cardNames), cardGetter(c), names, cardProps, namesOnly)
+					return nil
+				})
+			}
+			if err != nil {
+				log.Printf("CardDAV: could not list contacts: %v", err)
+				http.Error(w, "carddav: could not list contacts", http.StatusInternalServerError)
+				return
+			}
+		}
+	case cardResource:
+		c, err := h.contact(u, name)
+		if err != nil || c == nil {
+			h.notFound(w, r, err)
+			return
+		}
+		respond(ms, h.bookPath()+url.PathEscape(name), cardGetter(c), names, cardProps, namesOnly)
+	}
+	ms.write(w)
+}
+
+// notFound answers with a 404, or a 500 for an error of the database.
+func (h *handler) notFound(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		log.Printf("CardDAV: could not get contact: %v", err)
+		http.Error(w, "carddav: could not get contact", http.StatusInternalServerError)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (h *handler) report(w http.ResponseWriter, r *http.Request, u *contacts.User) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("carddav: could not read the body: %v", err), http.StatusBadRequest)
+		return
+	}
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(body, &root); err != nil {
+		http.Error(w, fmt.Sprintf("carddav: bad XML body: %v", err), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	switch root.XMLName {
+	case cardDAVName("addressbook-multiget"):
+		h.multiget(w, r, u)
+	case cardDAVName("addressbook-query"):
+		h.query(w, r, u)
+	case davName("sync-collection"):
+		h.syncCollection(w, r, u)
+	default:
+		writeError(w, http.StatusForbidden, davName("supported-report"))
+	}
+}
+
+func (h *handler) multiget(w http.ResponseWriter, r *http.Request, u *contacts.User) {
+	var mg addressbookMultiget
+	if !readXML(w, r, &mg) {
+		return
+	}
+	ms := newMultistatus()
+	for _, ref := range mg.Hrefs {
+		var c *contacts.Contact
+		var err error
+		name := ""
+		if p, perr := url.Parse(strings.TrimSpace(ref)); perr == nil {
+			if kind, n, ok := h.resource(p.Path); ok && kind == cardResource {
+				name = n
+				c, err = h.contact(u, name)
+			}
+		}
+		if err != nil {
+			log.Printf("CardDAV: could not get contact: %v", err)
+			ms.status(ref, http.StatusInternalServerError)
+		} else if c == nil {
+			ms.status(ref, http.StatusNotFound)
+		} else {
+			respond(ms, h.bookPath()+url.PathEscape(name), cardGetter(c), mg.Prop.Names, cardProps, false)
+		}
+	}
+	ms.write(w)
+}
+
+func (h *handler) query(w http.ResponseWriter, r *http.Request, u *contacts.User) {
+	var q addressbookQuery
+	if !readXML(w, r, &q) {
+		return
+	}
+	if !q.Filter.supported() {
+		writeError(w, http.StatusForbidden, cardDAVName("supported-filter"))
+		return
+	}
+	ms := newMultistatus()
+	n := 0
+	errLimit := fmt.Errorf("limit")
+	cardNames, err := h.names.CardNames(u.ID)
+	if err == nil {
+		err = h.db.ForEachContact(contacts.ContactCriteria{CreatedByID: u.ID}, func(c *contacts.Contact) error {
+			if !q.Filter.match(vcard.FromContact(c, vcard.Version3)) {
+				return nil
+			}
+			if q.Limit.NResults > 0 && n == q.Limit.NResults {
+				return errLimit
+			}
+			n++
+			respond(ms, h.namedPath(c.ID, cardNames), cardGetter(c), q.Prop.Names, cardProps, false)
+			return nil
+		})
+	}
+	if err == errLimit {
+		// More cards match than the client asked for
+		ms.status(h.bookPath(), http.StatusInsufficientStorage)
+	} else if err != nil {
+		log.Printf("CardDAV: could not list contacts: %v", err)
+		http.Error(w, "carddav: could not list contacts", http.StatusInternalServerError)
+		return
+	}
+	ms.write(w)
+}
+
+func (h *handler) syncCollection(w http.ResponseWriter, r *http.Request, u *contacts.User) {
+	watched, ok := h.db.(*contacts.WatchedDatabase)
+	if !ok {
+		writeError(w, http.StatusForbidden, davName("supported-report"))
+		return
+	}
+	var sc syncCollection
+	if !readXML(w, r, &sc) {
+		return
+	}
+	if sc.SyncLevel != "" && sc.SyncLevel != "1" && sc.SyncLevel != "infinite" {
+		http.Error(w, fmt.Sprintf("carddav: unsupported sync-level %q", sc.SyncLevel), http.StatusBadRequest)
+		return
+	}
+
+	cardNames, err := h.names.CardNames(u.ID)
+	if err != nil {
+		log.Printf("CardDAV: could not list card names: %v", err)
+		http.Error(w, "carddav: could not list contacts", http.StatusInternalServerError)
+		return
+	}
+	ms := newMultistatus()
+	if sc.SyncToken == "" {
+		// Everything, changes made while listing are sent again next time
+		rev := watched.Revision()
+		err := h.db.ForEachContact(contacts.ContactCriteria{CreatedByID: u.ID}, func(c *contacts.Contact) error {
+			respond(ms, h.namedPath(c.ID, cardNames), cardGetter(c), sc.Prop.Names, cardProps, false)
+			return nil
+		})
+		if err != nil {
+			log.Printf("CardDAV: could not list contacts: %v", err)
+			http.Error(w, "carddav: could not list contacts", http.StatusInternalServerError)
+			return
+		}
+		ms.syncToken(syncToken(rev))
+		ms.write(w)
+		return
+	}
+
+	since, err := contacts.ParseRevision(strings.TrimPrefix(sc.SyncToken, syncTokenPrefix))
+	var changes []contacts.ContactChange
+	var latest contacts.Revision
+	if err == nil && strings.HasPrefix(sc.SyncToken, syncTokenPrefix) {
+		changes, latest, ok = watched.Changes(u.ID, since)
+	}
+	if err != nil || !ok {
+		// Too old, or from before a restart: the client starts over
+		writeError(w, http.StatusForbidden, davName("valid-sync-token"))
+		return
+	}
+	// A card once, as it is now
+	seen := map[int64]bool{}
+	for i := len(changes) - 1; i >= 0; i-- {
+		id := changes[i].Contact.ID
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		c, err := h.db.GetContact(id)
+		if _, gone := err.(*contacts.NotFoundError); gone || err == nil && c.CreatedByID != u.ID {
+			ms.status(h.namedPath(id, cardNames), http.StatusNotFound)
+		} else if err != nil {
+			log.Printf("CardDAV: could not get contact: %v", err)
+			http.Error(w, "carddav: could not get contact", http.StatusInternalServerError)
+			return
+		} else {
+			respond(ms, h.namedPath(id, cardNames), cardGetter(c), sc.Prop.Names, cardProps, false)
+		}
+	}
+	ms.syncToken(syncToken(latest))
+	ms.write(w)
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request, u *contacts.User, name string) {
+	c, err := h.contact(u, name)
+	if err != nil || c == nil {
+		h.notFound(w, r, err)
+		return
+	}
+	card := encode(c, vcard.Version3)
+	w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(card)))
+	w.Header().Set("ETag", etag(c))
+	if t := lastModified(c); !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+	if r.Method == "GET" {
+		w.Write(card)
+	}
+}
+
+// preconditions checks the If-Match and If-None-Match of r on the contact
+// c, nil when there is none.
+func preconditions(r *http.Request, c *contacts.Contact) bool {
+	if m := r.Header.Get("If-Match"); m != "" {
+		if c == nil || m != "*" && !etagListed(m, etag(c)) {
+			return false
+		}
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" {
+		if c != nil && (m == "*" || etagListed(m, etag(c))) {
+			return false
+		}
+	}
+	return true
+}
+
+// etagListed reports whether tag is in the comma separated list of ETags.
+func etagListed(list, tag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) put(w http.ResponseWriter, r *http.Request, u *contacts.User, name string) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/vcard" && mediaType != "text/x-vcard" {
+		writeError(w, http.StatusUnsupportedMediaType, cardDAVName("supported-address-data"))
+		return
+	}
+	old, err := h.contact(u, name)
+	if err != nil {
+		h.notFound(w, r, err)
+		return
+	}
+	if !preconditions(r, old) {
+		http.Error(w, "carddav: the card changed", http.StatusPreconditionFailed)
+		return
+	}
+
+	card, err := vcard.NewDecoder(r.Body).Decode()
+	if err == io.EOF {
+		err = fmt.Errorf("no card")
+	}
+	var c *contacts.Contact
+	if err == nil {
+		c = card.Contact()
+		err = c.Validate()
+	}
+	if err != nil {
+		log.Printf("CardDAV: refused card of %s: %v", u.ID, err)
+		writeError(w, http.StatusForbidden, cardDAVName("valid-address-data"))
+		return
+	}
+
+	c.CreatedBy, c.CreatedByID = u.Name, u.ID
+	if old != nil {
+		c.ID, c.CreatedBy, c.CreatedDate = old.ID, old.CreatedBy, old.CreatedDate
+		if err := h.db.UpdateContact(c); err != nil {
+			log.Printf("CardDAV: could not update contact: %v", err)
+			http.Error(w, "carddav: could not save the card", http.StatusInternalServerError)
+			return
+		}
+		// No ETag, the card stored isn't the one sent
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	id, err := h.db.AddContact(c)
+	if err == nil {
+		// The card stays where the client put it
+		err = h.names.PutCardName(u.ID, name, id)
+	}
+	if err != nil {
+		log.Printf("CardDAV: could not add contact: %v", err)
+		http.Error(w, "carddav: could not save the card", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request, u *contacts.User, name string) {
+	c, err := h.contact(u, name)
+	if err != nil || c == nil {
+		h.notFound(w, r, err)
+		return
+	}
+	if !preconditions(r, c) {
+		http.Error(w, "carddav: the card changed", http.StatusPreconditionFailed)
+		return
+	}
+	if err := h.db.DeleteContact(c.ID); err != nil {
+		log.Printf("CardDAV: could not delete contact: %v", err)
+		http.Error(w, "carddav: could not delete the card", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,248 @@
+// 2017.09.18 rjj: Tests of the CardDAV server, the requests of an address book client.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package carddav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+var testUsers = map[string]*contacts.User{
+	"homer": {ID: "homer", Name: "Homer Simpson"},
+	"ned":   {ID: "ned", Name: "Ned Flanders"},
+}
+
+// newTestServer returns a server on a new database with a contact of homer
+// and one of ned.
+func newTestServer(t *testing.T) (http.Handler, *contacts.WatchedDatabase) {
+	db := contacts.NewWatchedDatabase(contacts.NewMemoryDB())
+	for _, c := range []*contacts.Contact{
+		{FirstName: "Marge", LastName: "Simpson", Email: "marge@example.com", Phone: "407-555-0100", CreatedByID: "homer"},
+		{FirstName: "Maude", LastName: "Flanders", Email: "maude@example.com", CreatedByID: "ned"},
+	} {
+		if _, err := db.AddContact(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandler(db, contacts.NewMemoryCardDAVNameStore(), "/carddav")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := testUsers[r.Header.Get("X-User")]; u != nil {
+			r = r.WithContext(contacts.NewContext(r.Context(), u))
+		}
+		h.ServeHTTP(w, r)
+	}), db
+}
+
+// do sends a request of user, with the headers as name and value pairs.
+func do(h http.Handler, user, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("X-User", user)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// want checks the status of w and that its body has the strings.
+func want(t *testing.T, name string, w *httptest.ResponseRecorder, code int, has ...string) {
+	if w.Code != code {
+		t.Errorf("%s: got %d, want %d: %s", name, w.Code, code, w.Body)
+		return
+	}
+	for _, s := range has {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("%s: %q not in %s", name, s, w.Body)
+		}
+	}
+}
+
+var syncTokenRE = regexp.MustCompile(`<d:sync-token>([^<]+)</d:sync-token>`)
+
+const margeCard = "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Simpson;Marge;;;\r\nFN:Marge Simpson\r\n" +
+	"EMAIL:marge@example.com\r\nTEL:407-555-0199\r\nEND:VCARD\r\n"
+
+func TestCardDAVDiscovery(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	if w := do(h, "", "PROPFIND", "/carddav/", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("no user: got %d, want 401", w.Code)
+	}
+	w := do(h, "homer", "OPTIONS", "/carddav/contacts/", "")
+	if got := w.Header().Get("DAV"); !strings.Contains(got, "addressbook") {
+		t.Errorf("DAV: got %q, want addressbook", got)
+	}
+
+	w = do(h, "homer", "PROPFIND", "/carddav/", `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:prop><d:current-user-principal/><card:addressbook-home-set/><d:getetag/></d:prop>
+</d:propfind>`, "Depth", "0")
+	want(t, "principal", w, http.StatusMultiStatus,
+		"<d:current-user-principal><d:href>/carddav/</d:href></d:current-user-principal>",
+		"<card:addressbook-home-set><d:href>/carddav/</d:href></card:addressbook-home-set>",
+		"<d:getetag/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>")
+	if strings.Contains(w.Body.String(), "/carddav/contacts/") {
+		t.Errorf("principal: depth 0 listed the address book: %s", w.Body)
+	}
+
+	w = do(h, "homer", "PROPFIND", "/carddav/", "", "Depth", "1")
+	want(t, "home", w, http.StatusMultiStatus,
+		"<d:href>/carddav/contacts/</d:href>",
+		"<d:resourcetype><d:collection/><card:addressbook/></d:resourcetype>",
+		"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>")
+}
+
+func TestCardDAVCards(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	w := do(h, "homer", "PROPFIND", "/carddav/contacts/", `<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`, "Depth", "1")
+	want(t, "list", w, http.StatusMultiStatus, "<d:href>/carddav/contacts/1.vcf</d:href>", "<d:getetag>&#34;")
+	if strings.Contains(w.Body.String(), "2.vcf") {
+		t.Errorf("list: the card of ned listed: %s", w.Body)
+	}
+
+	w = do(h, "homer", "GET", "/carddav/contacts/1.vcf", "")
+	want(t, "get", w, http.StatusOK, "FN:Marge Simpson\r\n", "EMAIL;TYPE=INTERNET:marge@example.com\r\n")
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("get: no ETag")
+	}
+	if w := do(h, "homer", "GET", "/carddav/contacts/2.vcf", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get of ned's card: got %d, want 404", w.Code)
+	}
+	if w := do(h, "ned", "DELETE", "/carddav/contacts/1.vcf", ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete of homer's card by ned: got %d, want 404", w.Code)
+	}
+
+	// Updates, if the card is the one the client has
+	w = do(h, "homer", "PUT", "/carddav/contacts/1.vcf", margeCard, "Content-Type", "text/vcard", "If-Match", `"stale"`)
+	want(t, "put stale", w, http.StatusPreconditionFailed)
+	w = do(h, "homer", "PUT", "/carddav/contacts/1.vcf", margeCard, "Content-Type", "text/vcard", "If-Match", etag)
+	want(t, "put", w, http.StatusNoContent)
+	w = do(h, "homer", "GET", "/carddav/contacts/1.vcf", "")
+	want(t, "get updated", w, http.StatusOK, "TEL;TYPE=VOICE:407-555-0199\r\n")
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("get updated: the ETag didn't change")
+	}
+
+	// Creates, under a new name it keeps
+	w = do(h, "homer", "PUT", "/carddav/contacts/new-card.vcf", strings.Replace(margeCard, "Marge", "Lisa", -1),
+		"Content-Type", "text/vcard; charset=utf-8", "If-None-Match", "*")
+	want(t, "put new", w, http.StatusCreated)
+	w = do(h, "homer", "GET", "/carddav/contacts/new-card.vcf", "")
+	want(t, "get new", w, http.StatusOK, "FN:Lisa Simpson\r\n")
+	etag = w.Header().Get("ETag")
+	w = do(h, "homer", "PROPFIND", "/carddav/contacts/", "", "Depth", "1")
+	want(t, "list new", w, http.StatusMultiStatus, "<d:href>/carddav/contacts/new-card.vcf</d:href>")
+	if strings.Contains(w.Body.String(), "3.vcf") {
+		t.Errorf("list new: the card listed by ID: %s", w.Body)
+	}
+	if w := do(h, "ned", "GET", "/carddav/contacts/new-card.vcf", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get of homer's new card by ned: got %d, want 404", w.Code)
+	}
+	w = do(h, "homer", "PUT", "/carddav/contacts/new-card.vcf", margeCard, "Content-Type", "text/vcard", "If-None-Match", "*")
+	want(t, "put over", w, http.StatusPreconditionFailed)
+	w = do(h, "homer", "PUT", "/carddav/contacts/new-card.vcf", strings.Replace(margeCard, "Marge", "Maggie", -1),
+		"Content-Type", "text/vcard", "If-Match", etag)
+	want(t, "put new again", w, http.StatusNoContent)
+	w = do(h, "homer", "PUT", "/carddav/contacts/4.vcf", "BEGIN:VCARD\r\nVERSION:3.0\r\nEND:VCARD\r\n", "Content-Type", "text/vcard")
+	want(t, "put invalid", w, http.StatusForbidden, "<card:valid-address-data/>")
+	w = do(h, "homer", "PUT", "/carddav/contacts/4.vcf", "{}", "Content-Type", "application/json")
+	want(t, "put json", w, http.StatusUnsupportedMediaType)
+
+	w = do(h, "homer", "DELETE", "/carddav/contacts/new-card.vcf", "")
+	want(t, "delete", w, http.StatusNoContent)
+	if w := do(h, "homer", "GET", "/carddav/contacts/new-card.vcf", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get deleted: got %d, want 404", w.Code)
+	}
+}
+
+func TestCardDAVReports(t *testing.T) {
+	h, db := newTestServer(t)
+	db.AddContact(&contacts.Contact{FirstName: "Bart", LastName: "Simpson", Email: "bart@example.org", CreatedByID: "homer"})
+
+	w := do(h, "homer", "REPORT", "/carddav/contacts/", `<card:addressbook-multiget xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:prop><d:getetag/><card:address-data version="4.0"/></d:prop>
+  <d:href>/carddav/contacts/1.vcf</d:href>
+  <d:href>/carddav/contacts/2.vcf</d:href>
+</card:addressbook-multiget>`)
+	want(t, "multiget", w, http.StatusMultiStatus, "VERSION:4.0", "FN:Marge Simpson",
+		"<d:href>/carddav/contacts/2.vcf</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", `<card:addressbook-query xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:prop><d:getetag/></d:prop>
+  <card:filter><card:prop-filter name="EMAIL"><card:text-match match-type="ends-with">.ORG</card:text-match></card:prop-filter></card:filter>
+</card:addressbook-query>`)
+	want(t, "query", w, http.StatusMultiStatus, "<d:href>/carddav/contacts/3.vcf</d:href>")
+	if strings.Contains(w.Body.String(), "1.vcf") {
+		t.Errorf("query: marge matched: %s", w.Body)
+	}
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", `<card:addressbook-query xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <card:filter><card:prop-filter name="FN"><card:text-match collation="i;klingon">x</card:text-match></card:prop-filter></card:filter>
+</card:addressbook-query>`)
+	want(t, "query collation", w, http.StatusForbidden, "<card:supported-filter/>")
+}
+
+func TestCardDAVSync(t *testing.T) {
+	h, db := newTestServer(t)
+	syncBody := func(token string) string {
+		return `<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + token +
+			`</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`
+	}
+	token := func(w *httptest.ResponseRecorder) string {
+		m := syncTokenRE.FindStringSubmatch(w.Body.String())
+		if m == nil {
+			t.Fatalf("no sync token in %s", w.Body)
+		}
+		return m[1]
+	}
+
+	w := do(h, "homer", "REPORT", "/carddav/contacts/", syncBody(""))
+	want(t, "initial", w, http.StatusMultiStatus, "<d:href>/carddav/contacts/1.vcf</d:href>")
+	first := token(w)
+
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", syncBody(first))
+	want(t, "no changes", w, http.StatusMultiStatus)
+	if strings.Contains(w.Body.String(), "<d:response>") || token(w) != first {
+		t.Errorf("no changes: got %s", w.Body)
+	}
+
+	id, _ := db.AddContact(&contacts.Contact{FirstName: "Lisa", LastName: "Simpson", CreatedByID: "homer"})
+	c, _ := db.GetContact(id)
+	c.Phone = "407-555-0142"
+	db.UpdateContact(c)
+	db.AddContact(&contacts.Contact{FirstName: "Rod", LastName: "Flanders", CreatedByID: "ned"})
+	db.DeleteContact(1)
+
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", syncBody(first))
+	want(t, "changes", w, http.StatusMultiStatus,
+		"<d:href>/carddav/contacts/1.vcf</d:href><d:status>HTTP/1.1 404 Not Found</d:status>",
+		"<d:href>/carddav/contacts/3.vcf</d:href><d:propstat>")
+	if got := strings.Count(w.Body.String(), "<d:response>"); got != 2 {
+		t.Errorf("changes: got %d responses, want 2: %s", got, w.Body)
+	}
+	if token(w) == first {
+		t.Errorf("changes: the sync token didn't change")
+	}
+
+	// A card deleted at the name the client gave it
+	do(h, "homer", "PUT", "/carddav/contacts/new-card.vcf", margeCard, "Content-Type", "text/vcard")
+	second := token(do(h, "homer", "REPORT", "/carddav/contacts/", syncBody("")))
+	do(h, "homer", "DELETE", "/carddav/contacts/new-card.vcf", "")
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", syncBody(second))
+	want(t, "deleted new card", w, http.StatusMultiStatus,
+		"<d:href>/carddav/contacts/new-card.vcf</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", syncBody(syncTokenPrefix+"1-1"))
+	want(t, "other epoch", w, http.StatusForbidden, "<d:valid-sync-token/>")
+	w = do(h, "homer", "REPORT", "/carddav/contacts/", syncBody("http://example.com/token"))
+	want(t, "foreign token", w, http.StatusForbidden, "<d:valid-sync-token/>")
+}
@@ -0,0 +1,160 @@
+// 2017.09.18 rjj: The WebDAV and CardDAV XML of the requests and responses.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package carddav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+)
+
+// The namespaces of the properties and reports.
+const (
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	// nsCS is of getctag, which Apple clients look at instead of sync-token.
+	nsCS = "http://calendarserver.org/ns/"
+)
+
+// prefixes are those of the namespaces in the responses.
+var prefixes = map[string]string{nsDAV: "d", nsCardDAV: "card", nsCS: "cs"}
+
+// propName is a property asked for.
+type propName struct {
+	XMLName xml.Name
+	// Version is the version attribute of CARDDAV:address-data, e.g. "4.0".
+	Version string `xml:"version,attr"`
+}
+
+// prop is a DAV:prop element of a request, the properties asked for.
+type prop struct {
+	Names []propName `xml:",any"`
+}
+
+// propfind is the body of a PROPFIND, an empty one being allprop.
+type propfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *prop     `xml:"DAV: prop"`
+}
+
+// addressbookMultiget is the REPORT of the cards of a list of hrefs.
+type addressbookMultiget struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav addressbook-multiget"`
+	Prop    prop     `xml:"DAV: prop"`
+	Hrefs   []string `xml:"DAV: href"`
+}
+
+// addressbookQuery is the REPORT of the cards matching a filter, see query.go.
+type addressbookQuery struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav addressbook-query"`
+	Prop    prop     `xml:"DAV: prop"`
+	Filter  filter   `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit   struct {
+		NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+	} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+// syncCollection is the REPORT of the cards changed since a sync token (RFC 6578).
+type syncCollection struct {
+	XMLName   xml.Name `xml:"DAV: sync-collection"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+	Prop      prop     `xml:"DAV: prop"`
+}
+
+// property is a property found, its value XML already escaped.
+type property struct {
+	name  xml.Name
+	value string
+}
+
+// multistatus builds the body of a 207 Multi-Status response.
+type multistatus struct {
+	buf bytes.Buffer
+}
+
+func newMultistatus() *multistatus {
+	ms := &multistatus{}
+	ms.buf.WriteString(xml.Header)
+	ms.buf.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav" xmlns:cs="http://calendarserver.org/ns/">`)
+	return ms
+}
+
+// response adds the properties of href, those found and those missing.
+func (ms *multistatus) response(href string, found []property, missing []xml.Name) {
+	ms.buf.WriteString("<d:response><d:href>")
+	xml.EscapeText(&ms.buf, []byte(href))
+	ms.buf.WriteString("</d:href>")
+	if len(found) > 0 {
+		ms.buf.WriteString("<d:propstat><d:prop>")
+		for _, p := range found {
+			ms.buf.WriteString(element(p.name, p.value))
+		}
+		ms.buf.WriteString("</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+	}
+	if len(missing) > 0 {
+		ms.buf.WriteString("<d:propstat><d:prop>")
+		for _, name := range missing {
+			ms.buf.WriteString(element(name, ""))
+		}
+		ms.buf.WriteString("</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+	}
+	ms.buf.WriteString("</d:response>")
+}
+
+// status adds the status of href, e.g. 404 for a card deleted since the sync token.
+func (ms *multistatus) status(href string, code int) {
+	ms.buf.WriteString("<d:response><d:href>")
+	xml.EscapeText(&ms.buf, []byte(href))
+	fmt.Fprintf(&ms.buf, "</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>", code, http.StatusText(code))
+}
+
+// syncToken adds the new sync token of a sync-collection.
+func (ms *multistatus) syncToken(token string) {
+	ms.buf.WriteString(element(xml.Name{Space: nsDAV, Local: "sync-token"}, escape(token)))
+}
+
+func (ms *multistatus) write(w http.ResponseWriter) {
+	ms.buf.WriteString("</d:multistatus>")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(ms.buf.Bytes())
+}
+
+// element returns the element name with value, XML already escaped.
+func element(name xml.Name, value string) string {
+	tag, ns := name.Local, ""
+	if p, ok := prefixes[name.Space]; ok {
+		tag = p + ":" + name.Local
+	} else if name.Space != "" {
+		ns = ` xmlns="` + escape(name.Space) + `"`
+	}
+	if value == "" {
+		return "<" + tag + ns + "/>"
+	}
+	return "<" + tag + ns + ">" + value + "</" + tag + ">"
+}
+
+// href returns the XML of a DAV:href.
+func href(path string) string {
+	return "<d:href>" + escape(path) + "</d:href>"
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeError writes a precondition that failed, e.g. DAV:valid-sync-token.
+func writeError(w http.ResponseWriter, code int, precondition xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `%s<d:error xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">%s</d:error>`,
+		xml.Header, element(precondition, ""))
+}
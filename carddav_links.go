@@ -0,0 +1,85 @@
+// 2017.09.19 rjj: What the CardDAV sync knows of the cards of the remote address book
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"sort"
+	"sync"
+)
+
+// CardDAVLink ties a contact to its card in the remote address book of the
+// CardDAV sync (package carddavsync), as they were at the last sync.
+type CardDAVLink struct {
+	// Href is the path of the card, as the remote server names it.
+	Href      string
+	ContactID int64
+	// UID is the UID of the card, which the card keeps when the contact is pushed.
+	UID string
+	// ETag is that of the card at the last sync, it changes with the card.
+	ETag string
+	// Hash is that of the contact at the last sync, see carddavsync.
+	Hash string
+}
+
+// CardDAVLinkStore persists the links of the CardDAV sync. Implementations
+// must be safe for concurrent use.
+type CardDAVLinkStore interface {
+	// Links returns all the links, by Href.
+	Links() ([]*CardDAVLink, error)
+	// PutLink saves l, replacing the link of its Href.
+	PutLink(l *CardDAVLink) error
+	// DeleteLink removes the link of href. Deleting an unknown link is not an error.
+	DeleteLink(href string) error
+}
+
+// MemoryCardDAVLinkStore is a CardDAVLinkStore kept in memory, for tests
+// and CONTACTS_DB=memory, where the contacts don't last either.
+type MemoryCardDAVLinkStore struct {
+	mu    sync.Mutex
+	links map[string]CardDAVLink
+}
+
+// Ensure MemoryCardDAVLinkStore conforms to the CardDAVLinkStore interface.
+var _ CardDAVLinkStore = &MemoryCardDAVLinkStore{}
+
+// NewMemoryCardDAVLinkStore returns an empty MemoryCardDAVLinkStore.
+func NewMemoryCardDAVLinkStore() *MemoryCardDAVLinkStore {
+	return &MemoryCardDAVLinkStore{links: map[string]CardDAVLink{}}
+}
+
+// Links returns all the links, by Href.
+func (s *MemoryCardDAVLinkStore) Links() ([]*CardDAVLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	links := make([]*CardDAVLink, 0, len(s.links))
+	for _, l := range s.links {
+		l := l
+		links = append(links, &l)
+	}
+	sort.Sort(linksByHref(links))
+	return links, nil
+}
+
+// PutLink saves l.
+func (s *MemoryCardDAVLinkStore) PutLink(l *CardDAVLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[l.Href] = *l
+	return nil
+}
+
+// DeleteLink removes the link of href.
+func (s *MemoryCardDAVLinkStore) DeleteLink(href string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.links, href)
+	return nil
+}
+
+type linksByHref []*CardDAVLink
+
+func (l linksByHref) Len() int           { return len(l) }
+func (l linksByHref) Less(i, j int) bool { return l[i].Href < l[j].Href }
+func (l linksByHref) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
@@ -0,0 +1,68 @@
+// 2017.09.18 rjj: The names CardDAV clients gave the cards they created
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import "sync"
+
+// CardDAVNameStore keeps the names of the cards CardDAV clients created in
+// the address book of a user (package carddav), e.g. "8D2F….vcf": a card
+// stays where the client PUT it, the contact of a card it didn't name is
+// at "<id>.vcf". The names outlive their contacts, so the address books
+// can still tell the clients which cards were deleted. Implementations
+// must be safe for concurrent use.
+type CardDAVNameStore interface {
+	// CardNames returns the names of the cards of userID, by contact ID.
+	CardNames(userID string) (map[int64]string, error)
+	// CardContact returns the contact ID of the card name of userID, 0 for none.
+	CardContact(userID, name string) (int64, error)
+	// PutCardName names the card of the contact id of userID, replacing
+	// what the name was of.
+	PutCardName(userID, name string, id int64) error
+}
+
+// MemoryCardDAVNameStore is a CardDAVNameStore kept in memory, for tests
+// and CONTACTS_DB=memory.
+type MemoryCardDAVNameStore struct {
+	mu sync.Mutex
+	// names are the contact IDs by user and name.
+	names map[string]map[string]int64
+}
+
+// Ensure MemoryCardDAVNameStore conforms to the CardDAVNameStore interface.
+var _ CardDAVNameStore = &MemoryCardDAVNameStore{}
+
+// NewMemoryCardDAVNameStore returns an empty MemoryCardDAVNameStore.
+func NewMemoryCardDAVNameStore() *MemoryCardDAVNameStore {
+	return &MemoryCardDAVNameStore{names: map[string]map[string]int64{}}
+}
+
+// CardNames returns the names of the cards of userID, by contact ID.
+func (s *MemoryCardDAVNameStore) CardNames(userID string) (map[int64]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := map[int64]string{}
+	for name, id := range s.names[userID] {
+		names[id] = name
+	}
+	return names, nil
+}
+
+// CardContact returns the contact ID of the card name of userID, 0 for none.
+func (s *MemoryCardDAVNameStore) CardContact(userID, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.names[userID][name], nil
+}
+
+// PutCardName names the card of the contact id of userID.
+func (s *MemoryCardDAVNameStore) PutCardName(userID, name string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names[userID] == nil {
+		s.names[userID] = map[string]int64{}
+	}
+	s.names[userID][name] = id
+	return nil
+}
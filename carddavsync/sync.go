@@ -0,0 +1,390 @@
+// 2017.09.19 rjj: Two-way sync of the contacts of a user with a remote CardDAV address book.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package carddavsync keeps the contacts of a user and the cards of a remote
+// CardDAV address book the same, for teams whose master list is there.
+//
+// A contact and its card are linked (contacts.CardDAVLink) by the href of
+// the card, with its UID, the ETag of the card and a hash of the contact as
+// they were at the last sync. A sync compares both sides with the links:
+//
+//	changed on one side	the change is pulled, or pushed with If-Match
+//	deleted on one side	the other side is deleted, unless it changed too
+//	changed on both sides	a conflict, see Policy
+//	new on one side		added to the other
+//
+// Only the fields of a contact are synced: the remote cards lose what else
+// they had, e.g. a photo, when a contact is pushed. Contacts and cards on
+// both sides before the first sync aren't matched, they end up twice.
+package carddavsync
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/carddav"
+	"github.com/rjj-work/yum-contacts/vcard"
+)
+
+// Policy is what is kept of a contact changed on both sides since the last sync.
+type Policy string
+
+const (
+	// RemoteWins keeps the remote card, the master list.
+	RemoteWins Policy = "remote"
+	// LocalWins keeps the contact.
+	LocalWins Policy = "local"
+	// KeepBoth keeps both, as two contacts and two cards.
+	KeepBoth Policy = "both"
+)
+
+// Syncer syncs the contacts UserID created with the cards of Remote.
+type Syncer struct {
+	DB     contacts.ContactDatabase
+	Remote *carddav.Client
+	Links  contacts.CardDAVLinkStore
+	// UserID and UserName are the creator of the contacts of new cards.
+	UserID, UserName string
+	Conflict         Policy
+
+	mu sync.Mutex
+}
+
+// New returns the Syncer of cfg on db.
+func New(db contacts.ContactDatabase, cfg *contacts.CardDAVSyncConfig) *Syncer {
+	return &Syncer{
+		DB:       db,
+		Remote:   &carddav.Client{URL: cfg.URL, Username: cfg.Username, Password: cfg.Password},
+		Links:    cfg.Links,
+		UserID:   cfg.UserID,
+		UserName: cfg.UserName,
+		Conflict: Policy(cfg.Conflict),
+	}
+}
+
+// Result counts what a sync did.
+type Result struct {
+	Pulled, Pushed, DeletedLocal, DeletedRemote, Conflicts int
+	// Errors are of the contacts and cards that couldn't be synced, they are
+	// tried again at the next sync.
+	Errors []error
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%d pulled, %d pushed, %d deleted here, %d deleted remotely, %d conflicts, %d errors",
+		r.Pulled, r.Pushed, r.DeletedLocal, r.DeletedRemote, r.Conflicts, len(r.Errors))
+}
+
+// Changed reports whether the sync changed anything.
+func (r *Result) Changed() bool {
+	return r.Pulled+r.Pushed+r.DeletedLocal+r.DeletedRemote > 0
+}
+
+func (r *Result) errorf(format string, a ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Errorf(format, a...))
+}
+
+// hash returns the hash of the fields of c that are synced.
+func hash(c *contacts.Contact) string {
+	sum := sha1.Sum([]byte(strings.Join([]string{c.FirstName, c.LastName, c.Address, c.Email, c.Phone, c.Tags}, "\x00")))
+	return fmt.Sprintf("%x", sum)
+}
+
+// uid returns the UID of a card, "" for none.
+func uid(card *vcard.Card) string {
+	if p := card.Preferred("UID"); p != nil {
+		return p.Text()
+	}
+	return ""
+}
+
+// item is a contact and its card, to pull or to push.
+type item struct {
+	// href is that of the card, "" for a new card.
+	href string
+	// link is that of the card, nil for a new card. A push gives the UID of
+	// the card, and its ETag for If-Match.
+	link *contacts.CardDAVLink
+	// local is the contact of the link, nil for a new contact.
+	local *contacts.Contact
+}
+
+// newUID returns the UID of a card kept besides another, see KeepBoth.
+func newUID() string {
+	return "urn:uuid:" + uuid.NewV4().String()
+}
+
+// contactOf returns the contact of a card, checked.
+func contactOf(card *vcard.Card) (*contacts.Contact, error) {
+	c := card.Contact()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Sync syncs the contacts and the cards once. The error is of the lists of
+// either side, those of single contacts and cards are in the Result.
+func (s *Syncer) Sync() (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, err := s.Remote.List()
+	if err != nil {
+		return nil, err
+	}
+	remote := map[string]string{}
+	for _, o := range objects {
+		remote[o.Href] = o.ETag
+	}
+	links, err := s.Links.Links()
+	if err != nil {
+		return nil, err
+	}
+	local := map[int64]*contacts.Contact{}
+	err = s.DB.ForEachContact(contacts.ContactCriteria{CreatedByID: s.UserID}, func(c *contacts.Contact) error {
+		local[c.ID] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Result{}
+	var pulls, pushes []item
+	for _, l := range links {
+		c := local[l.ContactID]
+		etag, onRemote := remote[l.Href]
+		delete(local, l.ContactID)
+		delete(remote, l.Href)
+		localChanged := c != nil && hash(c) != l.Hash
+		remoteChanged := onRemote && etag != l.ETag
+
+		switch {
+		case c == nil && !onRemote:
+			s.deleteLink(r, l.Href)
+		case c == nil:
+			if remoteChanged && s.Conflict != LocalWins {
+				// Deleted here but changed there: added again
+				r.Conflicts++
+				pulls = append(pulls, item{href: l.Href, link: l})
+				continue
+			}
+			if err := s.Remote.Delete(l.Href, etag); err != nil {
+				r.errorf("could not delete card %s: %v", l.Href, err)
+				continue
+			}
+			r.DeletedRemote++
+			s.deleteLink(r, l.Href)
+		case !onRemote:
+			if localChanged && s.Conflict != RemoteWins {
+				// Deleted there but changed here: added again
+				r.Conflicts++
+				s.deleteLink(r, l.Href)
+				pushes = append(pushes, item{link: &contacts.CardDAVLink{UID: l.UID}, local: c})
+				continue
+			}
+			if err := s.DB.DeleteContact(c.ID); err != nil {
+				r.errorf("could not delete contact %d: %v", c.ID, err)
+				continue
+			}
+			r.DeletedLocal++
+			s.deleteLink(r, l.Href)
+		case localChanged && remoteChanged:
+			r.Conflicts++
+			switch s.Conflict {
+			case LocalWins:
+				l.ETag = etag
+				pushes = append(pushes, item{href: l.Href, link: l, local: c})
+			case KeepBoth:
+				// The card becomes a new contact, the contact a new card
+				s.deleteLink(r, l.Href)
+				pulls = append(pulls, item{href: l.Href})
+				pushes = append(pushes, item{link: &contacts.CardDAVLink{UID: newUID()}, local: c})
+			default:
+				pulls = append(pulls, item{href: l.Href, link: l, local: c})
+			}
+		case localChanged:
+			pushes = append(pushes, item{href: l.Href, link: l, local: c})
+		case remoteChanged:
+			pulls = append(pulls, item{href: l.Href, link: l, local: c})
+		}
+	}
+	for href := range remote {
+		pulls = append(pulls, item{href: href})
+	}
+
+	if err := s.pullAll(r, pulls); err != nil {
+		return nil, err
+	}
+	// The contacts added here, in the order they were
+	var added []*contacts.Contact
+	for _, c := range local {
+		added = append(added, c)
+	}
+	sort.Sort(byID(added))
+	for _, c := range added {
+		pushes = append(pushes, item{link: &contacts.CardDAVLink{}, local: c})
+	}
+	for _, p := range pushes {
+		s.push(r, p)
+	}
+	return r, nil
+}
+
+// pullAll gets the cards of pulls and saves them. Cards deleted since the
+// list are left to the next sync, like those that can't be read.
+func (s *Syncer) pullAll(r *Result, pulls []item) error {
+	if len(pulls) == 0 {
+		return nil
+	}
+	hrefs := make([]string, len(pulls))
+	byHref := map[string]item{}
+	for i, p := range pulls {
+		hrefs[i] = p.href
+		byHref[p.href] = p
+	}
+	objects, err := s.Remote.Multiget(hrefs)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		p, ok := byHref[o.Href]
+		switch {
+		case !ok:
+		case o.Err != nil:
+			r.errorf("could not pull card %s: %v", o.Href, o.Err)
+		default:
+			s.save(r, o, p)
+		}
+	}
+	return nil
+}
+
+// save saves the card o as the contact of p, and links them.
+func (s *Syncer) save(r *Result, o carddav.Object, p item) {
+	c, err := contactOf(o.Card)
+	if err != nil {
+		r.errorf("could not pull card %s: %v", o.Href, err)
+		return
+	}
+	if p.local != nil {
+		c.ID, c.CreatedBy, c.CreatedByID, c.CreatedDate = p.local.ID, p.local.CreatedBy, p.local.CreatedByID, p.local.CreatedDate
+		if err := s.DB.UpdateContact(c); err != nil {
+			r.errorf("could not update contact %d: %v", c.ID, err)
+			return
+		}
+	} else {
+		c.CreatedBy, c.CreatedByID = s.UserName, s.UserID
+		id, err := s.DB.AddContact(c)
+		if err != nil {
+			r.errorf("could not add contact of card %s: %v", o.Href, err)
+			return
+		}
+		c.ID = id
+	}
+	r.Pulled++
+	s.putLink(r, &contacts.CardDAVLink{Href: o.Href, ContactID: c.ID, UID: uid(o.Card), ETag: o.ETag, Hash: hash(c)})
+}
+
+// push writes the contact of p to the card of its link, with the ETag of
+// the link, or to a new card if p has no href.
+func (s *Syncer) push(r *Result, p item) {
+	c := p.local
+	card := vcard.FromContact(c, vcard.Version3)
+	if p.link.UID != "" {
+		// The UID of a card doesn't change
+		card.Preferred("UID").Value = vcard.TextValue(p.link.UID)
+	}
+	href, etag := p.href, ""
+	if href == "" {
+		href = s.newHref(c, p.link.UID)
+	} else {
+		etag = p.link.ETag
+	}
+	href, etag, err := s.Remote.Put(href, card, etag)
+	if err == carddav.ErrPreconditionFailed {
+		// Changed in the meantime, a conflict at the next sync
+		r.errorf("card of contact %d changed during the sync", c.ID)
+		return
+	}
+	if err == nil && etag == "" {
+		etag, err = s.Remote.ETag(href)
+	}
+	if err != nil {
+		r.errorf("could not push contact %d: %v", c.ID, err)
+		return
+	}
+	r.Pushed++
+	if p.href != "" && href != p.href {
+		s.deleteLink(r, p.href)
+	}
+	s.putLink(r, &contacts.CardDAVLink{Href: href, ContactID: c.ID, UID: uid(card), ETag: etag, Hash: hash(c)})
+}
+
+// newHref returns the href of a new card of c, named after its UID when it
+// has one already: the card of c may still be at the name of its ID, e.g.
+// when the contact is kept besides it as a new card (KeepBoth).
+func (s *Syncer) newHref(c *contacts.Contact, uid string) string {
+	path := "/"
+	if u, err := url.Parse(s.Remote.URL); err == nil {
+		path = u.EscapedPath()
+	}
+	name := strconv.FormatInt(c.ID, 10)
+	if uid != "" {
+		name = url.PathEscape(strings.TrimPrefix(uid, "urn:uuid:"))
+	}
+	return strings.TrimSuffix(path, "/") + "/yum-contacts-" + name + ".vcf"
+}
+
+func (s *Syncer) putLink(r *Result, l *contacts.CardDAVLink) {
+	if err := s.Links.PutLink(l); err != nil {
+		r.errorf("could not save the link of %s: %v", l.Href, err)
+	}
+}
+
+func (s *Syncer) deleteLink(r *Result, href string) {
+	if err := s.Links.DeleteLink(href); err != nil {
+		r.errorf("could not delete the link of %s: %v", href, err)
+	}
+}
+
+type byID []*contacts.Contact
+
+func (c byID) Len() int           { return len(c) }
+func (c byID) Less(i, j int) bool { return c[i].ID < c[j].ID }
+func (c byID) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// Run syncs now and then every interval, until stop is closed.
+func (s *Syncer) Run(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		r, err := s.Sync()
+		switch {
+		case err != nil:
+			log.Printf("CardDAV sync: %v", err)
+		case r.Changed() || len(r.Errors) > 0:
+			log.Printf("CardDAV sync: %v", r)
+			for _, err := range r.Errors {
+				log.Printf("CardDAV sync: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
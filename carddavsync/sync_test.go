@@ -0,0 +1,415 @@
+// 2017.09.19 rjj: Tests of the CardDAV sync, with the CardDAV server of package carddav as the remote.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package carddavsync
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+	"github.com/rjj-work/yum-contacts/carddav"
+)
+
+// remoteUser is the account of the remote address book.
+var remoteUser = &contacts.User{ID: "team", Name: "The Team"}
+
+// newTestSyncer returns a Syncer of the contacts of user 1234 in local with
+// an address book of the contacts of remoteUser in remote, served over HTTP.
+// Close the server when done.
+func newTestSyncer(t *testing.T, local, remote contacts.ContactDatabase, policy Policy) (*Syncer, *httptest.Server) {
+	h := carddav.NewHandler(remote, contacts.NewMemoryCardDAVNameStore(), "/dav")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "team" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(contacts.NewContext(r.Context(), remoteUser)))
+	}))
+	return newSyncer(local, srv.URL+"/dav/contacts/", policy), srv
+}
+
+// newSyncer returns a Syncer of the contacts of user 1234 in local with the
+// address book of url.
+func newSyncer(local contacts.ContactDatabase, url string, policy Policy) *Syncer {
+	return New(local, &contacts.CardDAVSyncConfig{
+		URL:      url,
+		Username: "team",
+		Password: "secret",
+		UserID:   "1234",
+		UserName: "Jane Doe",
+		Conflict: string(policy),
+		Links:    contacts.NewMemoryCardDAVLinkStore(),
+	})
+}
+
+// fakeRemote is an address book at /ab/ that keeps the cards as they are
+// sent, at the hrefs the client PUT them, like most CardDAV servers do.
+// Package carddav names the cards after their contacts instead.
+type fakeRemote struct {
+	mu sync.Mutex
+	// cards are the vCards by href.
+	cards map[string]string
+}
+
+func newFakeRemote() *fakeRemote {
+	return &fakeRemote{cards: map[string]string{}}
+}
+
+func etagOf(card string) string {
+	return fmt.Sprintf("\"%x\"", sha1.Sum([]byte(card)))
+}
+
+// edit replaces old with new in the card of href.
+func (f *fakeRemote) edit(href, old, new string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cards[href] = strings.Replace(f.cards[href], old, new, -1)
+}
+
+func (f *fakeRemote) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.cards)
+}
+
+func (f *fakeRemote) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	href := r.URL.EscapedPath()
+	card, exists := f.cards[href]
+	switch r.Method {
+	case "PROPFIND":
+		var b bytes.Buffer
+		b.WriteString(`<d:multistatus xmlns:d="DAV:"><d:response><d:href>/ab/</d:href><d:propstat>`)
+		b.WriteString(`<d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		for h, c := range f.cards {
+			fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getetag>%s</d:getetag></d:prop>`, h, etagOf(c))
+			b.WriteString(`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		}
+		b.WriteString(`</d:multistatus>`)
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(b.String()))
+	case "REPORT":
+		var req struct {
+			Hrefs []string `xml:"DAV: href"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var b bytes.Buffer
+		b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">`)
+		for _, h := range req.Hrefs {
+			c, ok := f.cards[h]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&b, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getetag>%s</d:getetag><card:address-data>`, h, etagOf(c))
+			xml.EscapeText(&b, []byte(c))
+			b.WriteString(`</card:address-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+		}
+		b.WriteString(`</d:multistatus>`)
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(b.String()))
+	case "HEAD", "GET":
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", etagOf(card))
+	case "PUT":
+		if r.Header.Get("If-None-Match") == "*" && exists ||
+			r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != etagOf(card)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		f.cards[href] = string(body)
+		w.Header().Set("ETag", etagOf(string(body)))
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case "DELETE":
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != etagOf(card) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		delete(f.cards, href)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// emails returns the names and emails of the contacts of userID, sorted.
+func emails(t *testing.T, db contacts.ContactDatabase, userID string) []string {
+	var got []string
+	err := db.ForEachContact(contacts.ContactCriteria{CreatedByID: userID}, func(c *contacts.Contact) error {
+		got = append(got, c.FirstName+" "+c.LastName+" "+c.Email)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	return got
+}
+
+func syncOnce(t *testing.T, s *Syncer) *Result {
+	r, err := s.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range r.Errors {
+		t.Error(err)
+	}
+	return r
+}
+
+func add(t *testing.T, db contacts.ContactDatabase, c *contacts.Contact) *contacts.Contact {
+	id, err := db.AddContact(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ID = id
+	return c
+}
+
+func update(t *testing.T, db contacts.ContactDatabase, id int64, email string) {
+	c, err := db.GetContact(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Email = email
+	if err := db.UpdateContact(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// findByName returns the contact of userID with the first name.
+func findByName(t *testing.T, db contacts.ContactDatabase, userID, firstName string) *contacts.Contact {
+	cs, err := db.FindContacts(contacts.ContactCriteria{CreatedByID: userID, Name: firstName})
+	if err != nil || len(cs) != 1 {
+		t.Fatalf("%s of %s: got %d contacts, %v", firstName, userID, len(cs), err)
+	}
+	return cs[0]
+}
+
+func TestSync(t *testing.T) {
+	local, remote := contacts.NewMemoryDB(), contacts.NewMemoryDB()
+	add(t, local, &contacts.Contact{FirstName: "Cali", LastName: "Jackson", Email: "cali@example.com", CreatedByID: "1234"})
+	add(t, local, &contacts.Contact{FirstName: "Homer", LastName: "Simpson", Email: "homer@example.com", CreatedByID: "5678"})
+	add(t, remote, &contacts.Contact{FirstName: "Ned", LastName: "Flanders", Email: "ned@example.com", CreatedByID: "team"})
+	s, srv := newTestSyncer(t, local, remote, RemoteWins)
+	defer srv.Close()
+
+	r := syncOnce(t, s)
+	if r.Pulled != 1 || r.Pushed != 1 {
+		t.Errorf("first sync: got %v, want 1 pulled, 1 pushed", r)
+	}
+	want := []string{"Cali Jackson cali@example.com", "Ned Flanders ned@example.com"}
+	if got := emails(t, local, "1234"); !reflect.DeepEqual(got, want) {
+		t.Errorf("local: got %q, want %q", got, want)
+	}
+	if got := emails(t, remote, "team"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote: got %q, want %q", got, want)
+	}
+	if got := emails(t, local, "5678"); len(got) != 1 {
+		t.Errorf("the contacts of other users: got %q", got)
+	}
+	if r := syncOnce(t, s); r.Changed() {
+		t.Errorf("sync without changes: got %v", r)
+	}
+
+	// A change on each side
+	update(t, local, findByName(t, local, "1234", "Cali").ID, "cali@example.org")
+	update(t, remote, findByName(t, remote, "team", "Ned").ID, "ned@example.org")
+	r = syncOnce(t, s)
+	if r.Pulled != 1 || r.Pushed != 1 || r.Conflicts != 0 {
+		t.Errorf("changes: got %v, want 1 pulled, 1 pushed", r)
+	}
+	want = []string{"Cali Jackson cali@example.org", "Ned Flanders ned@example.org"}
+	if got := emails(t, local, "1234"); !reflect.DeepEqual(got, want) {
+		t.Errorf("local: got %q, want %q", got, want)
+	}
+	if got := emails(t, remote, "team"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote: got %q, want %q", got, want)
+	}
+	if r := syncOnce(t, s); r.Changed() {
+		t.Errorf("sync after the changes: got %v", r)
+	}
+
+	// A delete on each side
+	local.DeleteContact(findByName(t, local, "1234", "Ned").ID)
+	remote.DeleteContact(findByName(t, remote, "team", "Cali").ID)
+	r = syncOnce(t, s)
+	if r.DeletedLocal != 1 || r.DeletedRemote != 1 {
+		t.Errorf("deletes: got %v, want 1 deleted on each side", r)
+	}
+	if got := emails(t, local, "1234"); len(got) != 0 {
+		t.Errorf("local: got %q, want none", got)
+	}
+	if got := emails(t, remote, "team"); len(got) != 0 {
+		t.Errorf("remote: got %q, want none", got)
+	}
+	if links, _ := s.Links.Links(); len(links) != 0 {
+		t.Errorf("links: got %d, want none", len(links))
+	}
+}
+
+func TestSyncConflicts(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		want   []string
+	}{
+		{RemoteWins, []string{"Ned Flanders remote@example.com"}},
+		{LocalWins, []string{"Ned Flanders local@example.com"}},
+		{KeepBoth, []string{"Ned Flanders local@example.com", "Ned Flanders remote@example.com"}},
+	}
+	for _, tt := range tests {
+		local, remote := contacts.NewMemoryDB(), contacts.NewMemoryDB()
+		add(t, remote, &contacts.Contact{FirstName: "Ned", LastName: "Flanders", Email: "ned@example.com", CreatedByID: "team"})
+		s, srv := newTestSyncer(t, local, remote, tt.policy)
+		syncOnce(t, s)
+
+		update(t, local, findByName(t, local, "1234", "Ned").ID, "local@example.com")
+		update(t, remote, findByName(t, remote, "team", "Ned").ID, "remote@example.com")
+		if r := syncOnce(t, s); r.Conflicts != 1 {
+			t.Errorf("%s: got %v, want 1 conflict", tt.policy, r)
+		}
+		if got := emails(t, local, "1234"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: local: got %q, want %q", tt.policy, got, tt.want)
+		}
+		if got := emails(t, remote, "team"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: remote: got %q, want %q", tt.policy, got, tt.want)
+		}
+		if r := syncOnce(t, s); r.Changed() || r.Conflicts != 0 {
+			t.Errorf("%s: sync after the conflict: got %v", tt.policy, r)
+		}
+		srv.Close()
+	}
+}
+
+func TestSyncDeleteConflicts(t *testing.T) {
+	// Deleted on one side, changed on the other: the change is kept, unless
+	// the policy is that the side of the delete wins
+	tests := []struct {
+		policy                Policy
+		deleteLocal           bool
+		wantLocal, wantRemote int
+	}{
+		{RemoteWins, true, 1, 1},
+		{LocalWins, true, 0, 0},
+		{RemoteWins, false, 0, 0},
+		{LocalWins, false, 1, 1},
+		{KeepBoth, false, 1, 1},
+	}
+	for _, tt := range tests {
+		local, remote := contacts.NewMemoryDB(), contacts.NewMemoryDB()
+		add(t, remote, &contacts.Contact{FirstName: "Ned", LastName: "Flanders", Email: "ned@example.com", CreatedByID: "team"})
+		s, srv := newTestSyncer(t, local, remote, tt.policy)
+		syncOnce(t, s)
+
+		localNed, remoteNed := findByName(t, local, "1234", "Ned"), findByName(t, remote, "team", "Ned")
+		if tt.deleteLocal {
+			local.DeleteContact(localNed.ID)
+			update(t, remote, remoteNed.ID, "remote@example.com")
+		} else {
+			remote.DeleteContact(remoteNed.ID)
+			update(t, local, localNed.ID, "local@example.com")
+		}
+		syncOnce(t, s)
+		if got := len(emails(t, local, "1234")); got != tt.wantLocal {
+			t.Errorf("%s, deleted locally %v: got %d local contacts, want %d", tt.policy, tt.deleteLocal, got, tt.wantLocal)
+		}
+		if got := len(emails(t, remote, "team")); got != tt.wantRemote {
+			t.Errorf("%s, deleted locally %v: got %d remote cards, want %d", tt.policy, tt.deleteLocal, got, tt.wantRemote)
+		}
+		if r := syncOnce(t, s); r.Changed() {
+			t.Errorf("%s, deleted locally %v: sync after: got %v", tt.policy, tt.deleteLocal, r)
+		}
+		srv.Close()
+	}
+}
+
+func TestSyncBadCard(t *testing.T) {
+	// A card that can't be read is an error of the sync, the other cards
+	// and the contacts are still synced
+	local, remote := contacts.NewMemoryDB(), newFakeRemote()
+	add(t, local, &contacts.Contact{FirstName: "Cali", LastName: "Jackson", Email: "cali@example.com", CreatedByID: "1234"})
+	remote.cards["/ab/bad.vcf"] = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Bad\r\n"
+	remote.cards["/ab/ned.vcf"] = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Ned Flanders\r\nN:Flanders;Ned;;;\r\nEMAIL:ned@example.com\r\nEND:VCARD\r\n"
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+	s := newSyncer(local, srv.URL+"/ab/", RemoteWins)
+
+	for i := 0; i < 2; i++ {
+		r, err := s.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Errors) != 1 || !strings.Contains(r.Errors[0].Error(), "/ab/bad.vcf") {
+			t.Errorf("sync %d: got errors %v, want the bad card", i, r.Errors)
+		}
+		if i == 0 && (r.Pulled != 1 || r.Pushed != 1) {
+			t.Errorf("sync %d: got %v, want 1 pulled, 1 pushed", i, r)
+		}
+	}
+	want := []string{"Cali Jackson cali@example.com", "Ned Flanders ned@example.com"}
+	if got := emails(t, local, "1234"); !reflect.DeepEqual(got, want) {
+		t.Errorf("local: got %q, want %q", got, want)
+	}
+	if got := remote.len(); got != 3 {
+		t.Errorf("remote: got %d cards, want 3", got)
+	}
+}
+
+func TestSyncKeepBothOwnCard(t *testing.T) {
+	// The card of a contact pushed from here keeps the name the sync gave it,
+	// on a remote that doesn't rename the cards: the contact kept besides it
+	// in a conflict needs another one
+	local, remote := contacts.NewMemoryDB(), newFakeRemote()
+	cali := add(t, local, &contacts.Contact{FirstName: "Cali", LastName: "Jackson", Email: "cali@example.com", CreatedByID: "1234"})
+	srv := httptest.NewServer(remote)
+	defer srv.Close()
+	s := newSyncer(local, srv.URL+"/ab/", KeepBoth)
+	syncOnce(t, s)
+	href := fmt.Sprintf("/ab/yum-contacts-%d.vcf", cali.ID)
+	if _, ok := remote.cards[href]; !ok {
+		t.Fatalf("got cards %v, want %s", remote.cards, href)
+	}
+
+	update(t, local, cali.ID, "local@example.com")
+	remote.edit(href, "cali@example.com", "remote@example.com")
+	if r := syncOnce(t, s); r.Conflicts != 1 || r.Pulled != 1 || r.Pushed != 1 {
+		t.Errorf("conflict: got %v, want 1 conflict, 1 pulled, 1 pushed", r)
+	}
+	want := []string{"Cali Jackson local@example.com", "Cali Jackson remote@example.com"}
+	if got := emails(t, local, "1234"); !reflect.DeepEqual(got, want) {
+		t.Errorf("local: got %q, want %q", got, want)
+	}
+	if got := remote.len(); got != 2 {
+		t.Errorf("remote: got %d cards, want 2", got)
+	}
+	if r := syncOnce(t, s); r.Changed() || r.Conflicts != 0 {
+		t.Errorf("sync after the conflict: got %v", r)
+	}
+}
@@ -0,0 +1,204 @@
+// 2017.08.29 rjj: Message catalog for localized responses.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package catalog holds translated messages keyed by name, e.g.
+// "find_contact.not_found", with plural forms chosen per language.
+//
+// Messages are fmt format strings. Since word order differs between
+// languages, translations can use explicit argument indexes like %[2]s.
+//
+// Lookup falls back from a regional tag to its base language ("fr-CA" to
+// "fr") and from there to the default language, and a message missing from a
+// translation falls back to the default language too, so a partial
+// translation never produces an empty response.
+package catalog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Default is the language used when nothing better matches.
+const Default = "en"
+
+// Form is a plural category, see http://cldr.unicode.org/index/cldr-spec/plural-rules
+type Form int
+
+const (
+	Other Form = iota
+	Zero
+	One
+	Two
+	Few
+	Many
+)
+
+// PluralRule picks the plural Form to use for a count of n.
+type PluralRule func(n int) Form
+
+// Plural rules for the languages we know about.
+var (
+	// OneOther is used by English, Spanish, German, ... (1 is singular).
+	OneOther PluralRule = func(n int) Form {
+		if n == 1 {
+			return One
+		}
+		return Other
+	}
+	// ZeroOneOther is used by French and Portuguese (0 and 1 are singular).
+	ZeroOneOther PluralRule = func(n int) Form {
+		if n == 0 || n == 1 {
+			return One
+		}
+		return Other
+	}
+)
+
+// Message is a translated message. Only Other is required, the other forms
+// are used by Plural when the language's rule picks them.
+type Message struct {
+	Other string
+	Zero  string
+	One   string
+	Two   string
+	Few   string
+	Many  string
+}
+
+// form returns the format string for f, falling back to Other.
+func (m Message) form(f Form) string {
+	var s string
+	switch f {
+	case Zero:
+		s = m.Zero
+	case One:
+		s = m.One
+	case Two:
+		s = m.Two
+	case Few:
+		s = m.Few
+	case Many:
+		s = m.Many
+	}
+	if s == "" {
+		return m.Other
+	}
+	return s
+}
+
+// Catalog is the set of messages for one language.
+type Catalog struct {
+	lang     string
+	plural   PluralRule
+	messages map[string]Message
+	fallback *Catalog
+}
+
+var (
+	mu       sync.RWMutex
+	catalogs = map[string]*Catalog{}
+)
+
+// Register adds messages for lang (e.g. "en" or "fr"), using rule to choose
+// plural forms. It can be called more than once for the same language, later
+// messages replace earlier ones with the same key.
+func Register(lang string, rule PluralRule, messages map[string]Message) {
+	lang = normalize(lang)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c, ok := catalogs[lang]
+	if !ok {
+		c = &Catalog{lang: lang, messages: map[string]Message{}}
+		catalogs[lang] = c
+	}
+	if rule != nil {
+		c.plural = rule
+	}
+	for k, m := range messages {
+		c.messages[k] = m
+	}
+}
+
+// Lookup returns the catalog best matching the language tag, which may be
+// empty, a base language ("fr") or regional ("fr-CA", "fr_CA").
+func Lookup(tag string) *Catalog {
+	tag = normalize(tag)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if c, ok := catalogs[tag]; ok {
+		return c.withFallback()
+	}
+	if i := strings.Index(tag, "-"); i > 0 {
+		if c, ok := catalogs[tag[:i]]; ok {
+			return c.withFallback()
+		}
+	}
+	if c, ok := catalogs[Default]; ok {
+		return c
+	}
+	return &Catalog{lang: Default, messages: map[string]Message{}}
+}
+
+// withFallback returns a copy of c that falls back to the default language.
+// Called with mu held.
+func (c *Catalog) withFallback() *Catalog {
+	if c.lang == Default {
+		return c
+	}
+	cc := *c
+	cc.fallback = catalogs[Default]
+	return &cc
+}
+
+// normalize lower-cases tag and uses "-" as separator.
+func normalize(tag string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(tag), "_", "-", -1))
+}
+
+// Lang returns the language of the catalog.
+func (c *Catalog) Lang() string {
+	return c.lang
+}
+
+// message returns the message for key, from the fallback if need be.
+func (c *Catalog) message(key string) (Message, PluralRule, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if m, ok := c.messages[key]; ok {
+		return m, c.plural, true
+	}
+	if c.fallback != nil {
+		if m, ok := c.fallback.messages[key]; ok {
+			return m, c.fallback.plural, true
+		}
+	}
+	return Message{}, nil, false
+}
+
+// Sprintf formats the message for key. A missing key formats as the key
+// itself, so it is easy to spot.
+func (c *Catalog) Sprintf(key string, v ...interface{}) string {
+	m, _, ok := c.message(key)
+	if !ok {
+		return key
+	}
+	return fmt.Sprintf(m.Other, v...)
+}
+
+// Plural formats the message for key, in the plural form for n.
+// n is not passed to the format, include it in v if it should be shown.
+func (c *Catalog) Plural(key string, n int, v ...interface{}) string {
+	m, rule, ok := c.message(key)
+	if !ok {
+		return key
+	}
+	f := Other
+	if rule != nil {
+		f = rule(n)
+	}
+	return fmt.Sprintf(m.form(f), v...)
+}
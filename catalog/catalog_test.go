@@ -0,0 +1,71 @@
+// 2017.08.29 rjj: Tests for the message catalog.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package catalog
+
+import "testing"
+
+func init() {
+	Register("en", OneOther, map[string]Message{
+		"tally":     {One: "You have %d contact", Other: "You have %d contacts"},
+		"not_found": {Other: "No contact found for %s %s"},
+		"only_en":   {Other: "English only"},
+	})
+	Register("fr", ZeroOneOther, map[string]Message{
+		"tally":     {One: "Vous avez %d contact", Other: "Vous avez %d contacts"},
+		"not_found": {Other: "Aucun contact trouvé pour %[2]s, %[1]s"},
+	})
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		tag, want string
+	}{
+		{"en", "en"},
+		{"fr", "fr"},
+		{"fr-CA", "fr"},
+		{"FR_ca", "fr"},
+		{"de-DE", "en"},
+		{"", "en"},
+	}
+	for _, tt := range tests {
+		if got := Lookup(tt.tag).Lang(); got != tt.want {
+			t.Errorf("Lookup(%q): got %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestPlural(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"en", 0, "You have 0 contacts"},
+		{"en", 1, "You have 1 contact"},
+		{"en", 2, "You have 2 contacts"},
+		{"fr", 0, "Vous avez 0 contact"},
+		{"fr", 1, "Vous avez 1 contact"},
+		{"fr", 2, "Vous avez 2 contacts"},
+	}
+	for _, tt := range tests {
+		if got := Lookup(tt.lang).Plural("tally", tt.n, tt.n); got != tt.want {
+			t.Errorf("%s Plural(%d): got %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	fr := Lookup("fr-CA")
+	if got, want := fr.Sprintf("not_found", "Homer", "Simpson"), "Aucun contact trouvé pour Simpson, Homer"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// Missing translations fall back to English, missing keys show the key.
+	if got, want := fr.Sprintf("only_en"), "English only"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := fr.Sprintf("missing.key"), "missing.key"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
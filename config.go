@@ -6,8 +6,11 @@ package contacts
 
 import (
 	_ "errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/mgo.v2"
 
@@ -16,6 +19,12 @@ import (
 	_ "golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+
+	"github.com/rjj-work/yum-contacts/alexa"
+	"github.com/rjj-work/yum-contacts/oauthserver"
+	"github.com/rjj-work/yum-contacts/slack"
+	"github.com/rjj-work/yum-contacts/twilio"
+	"github.com/rjj-work/yum-contacts/webhookauth"
 )
 
 var (
@@ -27,6 +36,36 @@ var (
 
 	SessionStore sessions.Store
 
+	// WebhookAuth is checked for every call to /contactsWebhook, nil means no checks.
+	WebhookAuth *webhookauth.Config
+
+	// OAuthServer issues the access tokens Actions on Google sends with
+	// webhook calls once a user linked their account, nil when disabled.
+	OAuthServer *oauthserver.Server
+
+	// Alexa verifies the /alexa calls of the Alexa skills, nil when disabled.
+	Alexa *alexa.Config
+
+	// Slack verifies the /slack/* calls of the Slack app, nil when disabled.
+	Slack *slack.Config
+
+	// Twilio verifies the /twilio/* SMS and voice webhook calls, nil when disabled.
+	Twilio *twilio.Config
+
+	// GRPCAddr is where the gRPC ContactService listens, e.g. ":8081", off when "".
+	GRPCAddr string
+
+	// CardDAVNames are the names of the cards CardDAV clients added.
+	CardDAVNames CardDAVNameStore
+
+	// CardDAVSync is the remote address book the contacts of a user are
+	// synced with, nil when disabled.
+	CardDAVSync *CardDAVSyncConfig
+
+	// LDAP is the directory of the contacts for LDAP clients and the LDIF
+	// export, never nil.
+	LDAP *LDAPConfig
+
 	//PubsubClient *pubsub.Client
 
 	// Force import of mgo yum_contacts.
@@ -38,11 +77,15 @@ const PubsubTopicID = "fill-contact-details"
 func init() {
 	var err error
 
+	// CONTACTS_DB=memory keeps the contacts in memory instead, for the webhook
+	// simulator (see app/simulate.go) and trying the app without Cloud SQL.
+	inMemory := os.Getenv("CONTACTS_DB") == "memory"
+
 	// [START cloudsql]
 	// To use Cloud SQL, uncomment the following lines, and update the username,
 	// password and instance connection string. When running locally,
 	// localhost:3306 is used, and the instance name is ignored.
-	DB, err = configureCloudSQL(cloudSQLConfig{
+	sqlConfig := cloudSQLConfig{
 		Username: "root",
 		Password: "<YOUR-Cloud-SQL-root-password>",
 		// The connection name of the Cloud SQL v2 instance, i.e.,
@@ -50,7 +93,12 @@ func init() {
 		// Cloud SQL v1 instances are not supported.
 		Instance: "rjj-work-testing:us-east1:rjj-work-mysql-01",
 		Port: 13306,
-	})
+	}
+	if inMemory {
+		DB = NewMemoryDB()
+	} else {
+		DB, err = configureCloudSQL(sqlConfig)
+	}
 	// [END cloudsql]
 
 
@@ -77,6 +125,86 @@ func init() {
 	SessionStore = cookieStore
 	// [END sessions]
 
+	// [START webhook_auth]
+	// Authentication of API.AI webhook calls, configured from the environment
+	// (see app.yaml) so the secrets are not in the source.
+	WebhookAuth, err = configureWebhookAuth()
+	// [END webhook_auth]
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// [START alexa]
+	// The Alexa skill ID(s), comma separated, from the Alexa developer console.
+	Alexa = configureAlexa()
+	// [END alexa]
+
+	// [START slack]
+	// The /contact slash command, configured from the environment (see app.yaml).
+	Slack = configureSlack()
+	// [END slack]
+
+	// [START twilio]
+	// Contact lookups by SMS or phone call, configured from the environment (see app.yaml).
+	Twilio = configureTwilio()
+	// [END twilio]
+
+	// [START grpc]
+	// The gRPC ContactService for the backend services, configured from the
+	// environment (see app.yaml).
+	GRPCAddr = configureGRPC()
+	// [END grpc]
+
+	// [START ldap]
+	// The read-only LDAP directory for printers and phones, configured from
+	// the environment (see app.yaml).
+	LDAP, err = configureLDAP()
+	// [END ldap]
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// [START watch]
+	// The WatchContacts stream of the gRPC ContactService and the sync tokens of
+	// the CardDAV address book need the changes of every contact, whichever way
+	// in they were made.
+	DB = NewWatchedDatabase(DB)
+	// [END watch]
+
+	// [START oauth_server]
+	// Account linking for Actions on Google, configured from the environment
+	// (see app.yaml). Users consent while logged in, so it needs user sign-in
+	// (OAuthConfig) above.
+	if !inMemory {
+		OAuthServer, err = configureOAuthServer(sqlConfig)
+	}
+	// [END oauth_server]
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// [START carddav_names]
+	// The names phones and desktops gave the cards they added to the CardDAV
+	// address books, see package carddav.
+	CardDAVNames = NewMemoryCardDAVNameStore()
+	if !inMemory {
+		CardDAVNames, err = newMySQLCardDAVNameStore(sqlConfig.mySQLConfig())
+	}
+	// [END carddav_names]
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// [START carddav_sync]
+	// Two-way sync of the contacts of a user with a remote CardDAV address
+	// book, configured from the environment (see app.yaml).
+	CardDAVSync, err = configureCardDAVSync(sqlConfig, inMemory)
+	// [END carddav_sync]
+
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -97,28 +225,267 @@ func configureOAuthClient(clientID, clientSecret string) *oauth2.Config {
 	}
 }
 
+// configureWebhookAuth reads the webhook credentials from the environment:
+//	WEBHOOK_USERNAME, WEBHOOK_PASSWORD: basic auth, as set in the API.AI fulfillment settings
+//	WEBHOOK_HEADER: a custom header and its secret value, e.g. "X-Webhook-Secret: abc123"
+//	WEBHOOK_HMAC_SECRET: key for an HMAC-SHA256 signature of the body (X-Hub-Signature header)
+// Returns nil, i.e. no authentication, when none of them are set, and an
+// error when one is only half set, e.g. WEBHOOK_PASSWORD without WEBHOOK_USERNAME.
+func configureWebhookAuth() (*webhookauth.Config, error) {
+	cfg := &webhookauth.Config{
+		Username:   os.Getenv("WEBHOOK_USERNAME"),
+		Password:   os.Getenv("WEBHOOK_PASSWORD"),
+		HMACSecret: []byte(os.Getenv("WEBHOOK_HMAC_SECRET")),
+	}
+	if h := os.Getenv("WEBHOOK_HEADER"); h != "" {
+		if i := strings.Index(h, ":"); i > 0 {
+			cfg.HeaderName = strings.TrimSpace(h[:i])
+			cfg.HeaderValue = strings.TrimSpace(h[i+1:])
+		} else {
+			return nil, fmt.Errorf("WEBHOOK_HEADER should be \"Name: value\", not %q", h)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("WEBHOOK_USERNAME, WEBHOOK_PASSWORD, WEBHOOK_HEADER: %v", err)
+	}
+
+	if !cfg.Enabled() {
+		if os.Getenv("GAE_INSTANCE") != "" {
+			log.Printf("Warning: /contactsWebhook accepts unauthenticated calls, set WEBHOOK_USERNAME or WEBHOOK_HEADER")
+		}
+		return nil, nil
+	}
+	return cfg, nil
+}
+
+// configureOAuthServer reads the account linking client from the environment:
+//	OAUTH_SERVER_CLIENT_ID, OAUTH_SERVER_CLIENT_SECRET: as entered in the Actions on Google account linking settings
+//	OAUTH_SERVER_REDIRECT_URIS: comma separated, e.g. https://oauth-redirect.googleusercontent.com/r/<project-id>
+// Returns nil, i.e. no authorization server, when the client ID is not set.
+func configureOAuthServer(config cloudSQLConfig) (*oauthserver.Server, error) {
+	clientID := os.Getenv("OAUTH_SERVER_CLIENT_ID")
+	if clientID == "" {
+		return nil, nil
+	}
+	if OAuthConfig == nil {
+		log.Printf("OAUTH_SERVER_CLIENT_ID is set but user sign-in is not configured, account linking disabled")
+		return nil, nil
+	}
+
+	client := &oauthserver.Client{
+		ID:     clientID,
+		Secret: os.Getenv("OAUTH_SERVER_CLIENT_SECRET"),
+		Name:   "Google Assistant",
+	}
+	for _, u := range strings.Split(os.Getenv("OAUTH_SERVER_REDIRECT_URIS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			client.RedirectURIs = append(client.RedirectURIs, u)
+		}
+	}
+
+	store, err := newMySQLTokenStore(config.mySQLConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &oauthserver.Server{
+		Clients: []*oauthserver.Client{client},
+		Store:   store,
+	}, nil
+}
+
 type cloudSQLConfig struct {
 	Username, Password, Instance string
 	Port int
 }
 
 func configureCloudSQL(config cloudSQLConfig) (ContactDatabase, error) {
+	return newMySQLDB(config.mySQLConfig())
+}
+
+// mySQLConfig returns the connection settings for production or local use.
+func (config cloudSQLConfig) mySQLConfig() MySQLConfig {
 	if os.Getenv("GAE_INSTANCE") != "" {
 		// Running in production.
-		return newMySQLDB(MySQLConfig{
+		return MySQLConfig{
 			Username:   config.Username,
 			Password:   config.Password,
 			UnixSocket: "/cloudsql/" + config.Instance,
-		})
+		}
 	}
 
 	// Running locally.
-	return newMySQLDB(MySQLConfig{
+	return MySQLConfig{
 		Username: config.Username,
 		Password: config.Password,
 		Host:     "localhost",
 		// 3306 conflicts with local MySQL instance, so use different port for proxy
 		// Port:     3306,
 		Port:     config.Port,
-	})
+	}
+}
+
+// configureAlexa returns the Alexa skill settings, nil without skill IDs:
+//	ALEXA_SKILL_IDS: the IDs of the skills /alexa answers, comma separated
+func configureAlexa() *alexa.Config {
+	var ids []string
+	for _, id := range strings.Split(os.Getenv("ALEXA_SKILL_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return &alexa.Config{SkillIDs: ids}
+}
+
+// configureSlack returns the Slack app settings, nil without a signing secret:
+//	SLACK_SIGNING_SECRET: from the Basic Information page of the Slack app
+//	SLACK_USERS: Slack user IDs and the IDs of their accounts here, e.g. "U024BE7LH=1234,U0G9QF9C6=5678",
+//		other Slack users are anonymous
+func configureSlack() *slack.Config {
+	secret := os.Getenv("SLACK_SIGNING_SECRET")
+	if secret == "" {
+		return nil
+	}
+	return &slack.Config{SigningSecret: []byte(secret), Users: userMap("SLACK_USERS")}
+}
+
+// configureTwilio returns the Twilio account settings, nil without an auth token:
+//	TWILIO_AUTH_TOKEN: from the Twilio console, it signs the webhook calls
+//	TWILIO_USERS: the phone numbers (E.164) allowed to look up contacts, and the IDs of their
+//		users here, e.g. "+15556367890=1234,+14075550100=5678"
+func configureTwilio() *twilio.Config {
+	token := os.Getenv("TWILIO_AUTH_TOKEN")
+	if token == "" {
+		return nil
+	}
+	return &twilio.Config{AuthToken: []byte(token), Users: userMap("TWILIO_USERS")}
+}
+
+// configureGRPC returns the address of the gRPC server:
+//	GRPC_ADDR: e.g. ":8081", a port other than the HTTP one, no gRPC server when unset
+func configureGRPC() string {
+	return strings.TrimSpace(os.Getenv("GRPC_ADDR"))
+}
+
+// LDAPConfig is the LDAP directory of the contacts, see package contactsldap.
+type LDAPConfig struct {
+	// Addr is where the LDAP server listens, e.g. ":3890", off when "".
+	Addr string
+	// BaseDN is the DN the entries of the contacts are under, for the LDAP
+	// server and the LDIF export.
+	BaseDN string
+	// BindDN and BindPassword are the credentials of the LDAP clients.
+	BindDN, BindPassword string
+	// UserID is the user whose contacts the LDAP clients see.
+	UserID string
+}
+
+// configureLDAP returns the LDAP directory settings:
+//	LDAP_ADDR: e.g. ":3890", a port other than the HTTP one, no LDAP server when unset
+//	LDAP_BASE_DN: ou=contacts,dc=example,dc=com by default, the DefaultBaseDN of package contactsldap
+//	LDAP_BIND_DN, LDAP_BIND_PASSWORD: the credentials clients bind with, required with LDAP_ADDR
+//	LDAP_USER: the ID of the user whose contacts the clients see, required with LDAP_ADDR
+func configureLDAP() (*LDAPConfig, error) {
+	cfg := &LDAPConfig{
+		Addr:         strings.TrimSpace(os.Getenv("LDAP_ADDR")),
+		BaseDN:       strings.TrimSpace(os.Getenv("LDAP_BASE_DN")),
+		BindDN:       strings.TrimSpace(os.Getenv("LDAP_BIND_DN")),
+		BindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		UserID:       strings.TrimSpace(os.Getenv("LDAP_USER")),
+	}
+	if cfg.BaseDN == "" {
+		cfg.BaseDN = "ou=contacts,dc=example,dc=com"
+	}
+	// Not a directory of every user's contacts anyone on the network can read
+	if cfg.Addr != "" && (cfg.BindDN == "" || cfg.BindPassword == "" || cfg.UserID == "") {
+		return nil, fmt.Errorf("LDAP_ADDR is set but LDAP_BIND_DN, LDAP_BIND_PASSWORD or LDAP_USER is not")
+	}
+	return cfg, nil
+}
+
+// CardDAVSyncConfig is the remote address book of the CardDAV sync, see
+// package carddavsync.
+type CardDAVSyncConfig struct {
+	// URL is that of the address book, Username and Password log in to it.
+	URL, Username, Password string
+	// UserID is the user whose contacts are synced, the contacts of new
+	// remote cards are created by them.
+	UserID, UserName string
+	// Conflict is what is kept of a contact changed on both sides: "remote",
+	// "local" or "both".
+	Conflict string
+	// Interval is the time between syncs.
+	Interval time.Duration
+	// Links are what the sync knows of the remote cards.
+	Links CardDAVLinkStore
+}
+
+// configureCardDAVSync returns the CardDAV sync settings, nil without a URL:
+//	CARDDAV_SYNC_URL: the address book, e.g. https://dav.example.com/addressbooks/team/contacts/
+//	CARDDAV_SYNC_USERNAME, CARDDAV_SYNC_PASSWORD: its account
+//	CARDDAV_SYNC_USER: the ID of the user whose contacts are synced, CARDDAV_SYNC_USER_NAME their name
+//	CARDDAV_SYNC_CONFLICT: remote (the default, the remote address book is the master list), local,
+//		or both, as two contacts
+//	CARDDAV_SYNC_INTERVAL: e.g. 5m, 15m by default
+func configureCardDAVSync(config cloudSQLConfig, inMemory bool) (*CardDAVSyncConfig, error) {
+	u := strings.TrimSpace(os.Getenv("CARDDAV_SYNC_URL"))
+	if u == "" {
+		return nil, nil
+	}
+	cfg := &CardDAVSyncConfig{
+		URL:      u,
+		Username: os.Getenv("CARDDAV_SYNC_USERNAME"),
+		Password: os.Getenv("CARDDAV_SYNC_PASSWORD"),
+		UserID:   strings.TrimSpace(os.Getenv("CARDDAV_SYNC_USER")),
+		UserName: strings.TrimSpace(os.Getenv("CARDDAV_SYNC_USER_NAME")),
+		Conflict: strings.TrimSpace(os.Getenv("CARDDAV_SYNC_CONFLICT")),
+		Interval: 15 * time.Minute,
+	}
+	if cfg.UserID == "" {
+		return nil, fmt.Errorf("CARDDAV_SYNC_URL is set but CARDDAV_SYNC_USER is not")
+	}
+	switch cfg.Conflict {
+	case "":
+		cfg.Conflict = "remote"
+	case "remote", "local", "both":
+	default:
+		return nil, fmt.Errorf("CARDDAV_SYNC_CONFLICT should be remote, local or both, not %q", cfg.Conflict)
+	}
+	if s := os.Getenv("CARDDAV_SYNC_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("CARDDAV_SYNC_INTERVAL should be a duration, e.g. 15m, not %q", s)
+		}
+		cfg.Interval = d
+	}
+
+	if inMemory {
+		cfg.Links = NewMemoryCardDAVLinkStore()
+		return cfg, nil
+	}
+	links, err := newMySQLCardDAVLinkStore(config.mySQLConfig())
+	if err != nil {
+		return nil, err
+	}
+	cfg.Links = links
+	return cfg, nil
+}
+
+// userMap parses the environment variable name, "key=userID,...", into a map.
+func userMap(name string) map[string]string {
+	users := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			log.Printf("%s should be \"key=userID,...\", ignoring %q", name, pair)
+			continue
+		}
+		users[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return users
 }
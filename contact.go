@@ -2,6 +2,8 @@
 
 package contacts
 
+import "fmt"
+
 // Contact definds the basic data collected for each entry.
 type Contact struct {
 	ID           int64
@@ -10,6 +12,8 @@ type Contact struct {
 	Address      string
 	Email        string
 	Phone        string
+	// Tags are comma separated labels, e.g. "family,work", see NormalizeTags
+	Tags         string
 	CreatedBy    string
 	CreatedByID  string
 	CreatedDate  string
@@ -31,6 +35,15 @@ func (b *Contact) SetCreatorAnonymous() {
 	b.CreatedByID = "anonymous"
 }
 
+// NotFoundError is returned by GetContact for an ID no contact has.
+type NotFoundError struct {
+	ID int64
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("contact not found with ID %d", e.ID)
+}
+
 // ContactDatabase provides thread-safe access to a database of contacts.
 type ContactDatabase interface {
 	// ListContacts returns a list of contacts, ordered by title.
@@ -40,12 +53,16 @@ type ContactDatabase interface {
 	// the user who created the contact entry.
 	ListContactsCreatedBy(userID string) ([]*Contact, error)
 
-	// GetContact retrieves a contact by its ID.
+	// GetContact retrieves a contact by its ID, a *NotFoundError if there is none.
 	GetContact(id int64) (*Contact, error)
 
 	// AddContact saves a given contact, assigning it a new ID.
 	AddContact(b *Contact) (id int64, err error)
 
+	// AddContacts saves the given contacts, all of them or none, assigning them
+	// new IDs in order. For bulk imports, a batch at a time.
+	AddContacts(cs []*Contact) (ids []int64, err error)
+
 	// DeleteContact removes a given contact by its ID.
 	DeleteContact(id int64) error
 
@@ -55,10 +72,31 @@ type ContactDatabase interface {
 	// TallyContacts provides a count of contacts
 	TallyContacts() (int64, error)
 
-	// FindByNameContacts looks up contact by first and last name
-	//	Note the name of function indicates we expect to only find 1 (or zero)
+	// TallyContactsCreatedBy provides a count of contacts created by the given user,
+	// ErrNoUser for "", see TallyContacts.
+	TallyContactsCreatedBy(userID string) (int64, error)
+
+	// TallyContactsMatching provides a count of the contacts matching all the
+	// criteria, e.g. created by a user since a date, with a tag or without an email.
+	TallyContactsMatching(criteria ContactCriteria) (int64, error)
+
+	// FindContactByName looks up contacts by first and last name
+	//	Usually finds 1 (or zero), but several contacts can share a name
 	FindContactByName(string, string) ([]*Contact, error)
 
+	// FindContactByNameCreatedBy looks up contacts by first and last name,
+	// filtered by the user who created the contact entry, ErrNoUser for "".
+	FindContactByNameCreatedBy(userID, firstName, lastName string) ([]*Contact, error)
+
+	// FindContacts returns the contacts matching all the criteria, ordered by name.
+	FindContacts(criteria ContactCriteria) ([]*Contact, error)
+
+	// ForEachContact calls fn with the contacts matching all the criteria,
+	// ordered by name, one at a time as they are read, so a caller streaming
+	// them out doesn't hold the list. It stops at the first error of fn, and
+	// returns it.
+	ForEachContact(criteria ContactCriteria, fn func(*Contact) error) error
+
 	// Close closes the database, freeing up any available resources.
 	// TODO(cbro): Close() should return an error.
 	Close()
@@ -0,0 +1,37 @@
+// 2017.09.17 rjj: Keeping spreadsheets from running the cells of CSV files as formulas
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactscsv
+
+import (
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// formulaStart are the characters a spreadsheet takes a cell starting with
+// for a formula, tab and carriage return included, which some strip first.
+const formulaStart = "=+-@\t\r"
+
+// EscapeFormula returns s for a cell of a CSV file spreadsheets open:
+// anyone can name a contact =HYPERLINK(...), so text starting like a
+// formula gets a ' in front, which spreadsheets show as text. Phone numbers,
+// e.g. +33123456789, are left alone, Read gets them back as they are.
+func EscapeFormula(s string) string {
+	if s == "" || strings.IndexByte(formulaStart, s[0]) < 0 {
+		return s
+	}
+	if _, ok := contacts.NormalizePhone(s); ok {
+		return s
+	}
+	return "'" + s
+}
+
+// unescapeFormula returns the text of a cell EscapeFormula escaped.
+func unescapeFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.IndexByte(formulaStart, s[1]) >= 0 {
+		return s[1:]
+	}
+	return s
+}
@@ -0,0 +1,280 @@
+// 2017.09.16 rjj: Reading contacts from the CSV files of spreadsheets and other address books
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package contactscsv reads contacts from CSV files, with their columns
+// mapped onto the Contact fields: by the header of a known export, Google
+// Contacts or Outlook, by the column names, or as the user chose. The cells
+// of the files it writes are escaped with EscapeFormula.
+package contactscsv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// Fields are the Contact fields a column can be mapped onto.
+var Fields = []string{"FirstName", "LastName", "Address", "Email", "Phone", "Tags"}
+
+// Mapping is the Contact field of each column of a file, one of Fields or
+// "" for the columns left out. Several columns may map onto a field: their
+// addresses are joined, their tags are all kept, the first email or phone
+// number set is kept.
+type Mapping []string
+
+// Check returns an error when m doesn't fit a header of n columns, maps
+// onto a field that isn't one of Fields, or maps onto no name.
+func (m Mapping) Check(n int) error {
+	if len(m) != n {
+		return fmt.Errorf("contactscsv: %d columns mapped, the file has %d", len(m), n)
+	}
+	named := false
+	for i, f := range m {
+		switch f {
+		case "":
+		case "FirstName", "LastName":
+			named = true
+		default:
+			if !isField(f) {
+				return fmt.Errorf("contactscsv: column %d mapped onto unknown field %q", i+1, f)
+			}
+		}
+	}
+	if !named {
+		return errors.New("contactscsv: map a column onto FirstName or LastName")
+	}
+	return nil
+}
+
+func isField(f string) bool {
+	for _, field := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Layout is the header of the CSV export of an address book.
+type Layout struct {
+	Name string
+	// Columns maps the header names onto Fields, the others are left out.
+	Columns map[string]string
+	// Required are the header names that tell the layout apart.
+	Required []string
+}
+
+// GoogleContacts is the "Google CSV" export of Google Contacts.
+var GoogleContacts = &Layout{
+	Name: "Google Contacts",
+	Columns: map[string]string{
+		"Given Name":              "FirstName",
+		"Family Name":             "LastName",
+		"Address 1 - Street":      "Address",
+		"Address 1 - City":        "Address",
+		"Address 1 - Region":      "Address",
+		"Address 1 - Postal Code": "Address",
+		"Address 1 - Country":     "Address",
+		"E-mail 1 - Value":        "Email",
+		"E-mail 2 - Value":        "Email",
+		"Phone 1 - Value":         "Phone",
+		"Phone 2 - Value":         "Phone",
+		"Group Membership":        "Tags",
+	},
+	Required: []string{"Given Name", "Family Name", "Group Membership"},
+}
+
+// Outlook is the "Comma Separated Values" export of Outlook.
+var Outlook = &Layout{
+	Name: "Outlook",
+	Columns: map[string]string{
+		"First Name":          "FirstName",
+		"Last Name":           "LastName",
+		"Home Street":         "Address",
+		"Home City":           "Address",
+		"Home State":          "Address",
+		"Home Postal Code":    "Address",
+		"Home Country/Region": "Address",
+		"E-mail Address":      "Email",
+		"E-mail 2 Address":    "Email",
+		"Mobile Phone":        "Phone",
+		"Home Phone":          "Phone",
+		"Business Phone":      "Phone",
+		"Categories":          "Tags",
+	},
+	Required: []string{"First Name", "Last Name", "E-mail Address"},
+}
+
+// Layouts are the layouts Detect knows.
+var Layouts = []*Layout{GoogleContacts, Outlook}
+
+// aliases are the column names mapped onto Fields without a known layout,
+// lower case without spaces or punctuation.
+var aliases = map[string]string{
+	"firstname": "FirstName", "givenname": "FirstName", "first": "FirstName",
+	"lastname": "LastName", "familyname": "LastName", "surname": "LastName", "last": "LastName",
+	"address": "Address", "streetaddress": "Address", "street": "Address", "city": "Address",
+	"email": "Email", "emailaddress": "Email", "mail": "Email",
+	"phone": "Phone", "phonenumber": "Phone", "telephone": "Phone", "mobile": "Phone", "tel": "Phone",
+	"tags": "Tags", "labels": "Tags", "categories": "Tags", "groups": "Tags",
+}
+
+// Detect returns the layout of header, nil if it isn't a known export, and
+// the mapping of its columns: by the layout, else by the column names.
+func Detect(header []string) (*Layout, Mapping) {
+	has := map[string]bool{}
+	for _, h := range header {
+		has[strings.TrimSpace(h)] = true
+	}
+	m := make(Mapping, len(header))
+next:
+	for _, l := range Layouts {
+		for _, r := range l.Required {
+			if !has[r] {
+				continue next
+			}
+		}
+		for i, h := range header {
+			m[i] = l.Columns[strings.TrimSpace(h)]
+		}
+		return l, m
+	}
+	for i, h := range header {
+		m[i] = aliases[strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(h))]
+	}
+	return nil, m
+}
+
+// File is a CSV file with a header.
+type File struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Read reads a CSV file, its first record being the header. Rows may have
+// fewer or more fields than the header, the missing ones are empty.
+func Read(r io.Reader) (*File, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("contactscsv: empty file, expected a header")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("contactscsv: %v", err)
+	}
+	// Excel writes a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	f := &File{Header: header}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("contactscsv: %v", err)
+		}
+		f.Rows = append(f.Rows, row)
+	}
+}
+
+// Row is a row of a file as a contact.
+type Row struct {
+	// Row is the number of the row in the file, the header being 1, like
+	// spreadsheets number them.
+	Row     int
+	Contact *contacts.Contact
+	// Err is why the contact can't be added, see Contact.Validate.
+	Err error
+}
+
+// Contacts returns the rows of f as contacts, checked and normalized by
+// Contact.Validate. m must fit f, see Mapping.Check.
+func (f *File) Contacts(m Mapping) []Row {
+	rows := make([]Row, len(f.Rows))
+	for i, fields := range f.Rows {
+		c := m.Contact(fields)
+		rows[i] = Row{Row: i + 2, Contact: c, Err: c.Validate()}
+	}
+	return rows
+}
+
+// Contact returns the fields of a row as a contact, as they are.
+func (m Mapping) Contact(fields []string) *contacts.Contact {
+	var address, tags []string
+	c := &contacts.Contact{}
+	for i, field := range m {
+		if i >= len(fields) || field == "" {
+			continue
+		}
+		v := unescapeFormula(strings.TrimSpace(fields[i]))
+		if v == "" {
+			continue
+		}
+		switch field {
+		case "Address":
+			for _, line := range strings.Split(v, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					address = append(address, line)
+				}
+			}
+		case "Tags":
+			tags = append(tags, splitTags(v)...)
+		default:
+			// Google puts several values in a field with " ::: "
+			if j := strings.Index(v, ":::"); j >= 0 {
+				v = strings.TrimSpace(v[:j])
+			}
+			setFirst(c, field, v)
+		}
+	}
+	c.Address = strings.Join(address, ", ")
+	c.Tags = strings.Join(tags, ",")
+	return c
+}
+
+// setFirst sets the field of c to v, unless an earlier column did.
+func setFirst(c *contacts.Contact, field, v string) {
+	var p *string
+	switch field {
+	case "FirstName":
+		p = &c.FirstName
+	case "LastName":
+		p = &c.LastName
+	case "Email":
+		p = &c.Email
+	case "Phone":
+		p = &c.Phone
+	default:
+		return
+	}
+	if *p == "" {
+		*p = v
+	}
+}
+
+// splitTags returns the tags of a field separated by commas, by semicolons
+// like Outlook does, or by " ::: " like Google Contacts does. The groups of
+// Google Contacts itself, "* myContacts" and "* starred", are left out.
+func splitTags(v string) []string {
+	var tags []string
+	for _, t := range strings.FieldsFunc(strings.Replace(v, ":::", ",", -1), func(r rune) bool {
+		return r == ',' || r == ';'
+	}) {
+		if t = strings.TrimSpace(t); t != "" && !strings.HasPrefix(t, "*") {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,147 @@
+// 2017.09.16 rjj: Tests for reading contacts from CSV files.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactscsv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// The first columns of the exports, and some of the others
+const (
+	googleCSV = "Name,Given Name,Additional Name,Family Name,Group Membership,E-mail 1 - Type,E-mail 1 - Value,Phone 1 - Type,Phone 1 - Value,Address 1 - Type,Address 1 - Formatted,Address 1 - Street,Address 1 - City,Address 1 - Region,Address 1 - Postal Code,Address 1 - Country\r\n" +
+		`Homer Simpson,Homer,Jay,Simpson,* myContacts ::: Family ::: Work,* Home,homer@example.com ::: chunkylover53@example.com,Mobile,407-555-0100,Home,"742 Evergreen Terrace` + "\n" + `Springfield",742 Evergreen Terrace,Springfield,OR,97475,` + "\r\n"
+	outlookCSV = "\ufeffFirst Name,Middle Name,Last Name,Business Phone,Home Phone,Mobile Phone,Home Street,Home City,Home State,Home Postal Code,Home Country/Region,E-mail Address,E-mail 2 Address,Categories\r\n" +
+		",,Flanders,,555-0199,555-0100,744 Evergreen Terrace,Springfield,,,,ned@example.com,,Church;Neighbors\r\n"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		csv    string
+		layout *Layout
+		want   *contacts.Contact
+	}{
+		{googleCSV, GoogleContacts, &contacts.Contact{
+			FirstName: "Homer", LastName: "Simpson", Address: "742 Evergreen Terrace, Springfield, OR, 97475",
+			Email: "homer@example.com", Phone: "407-555-0100", Tags: "Family,Work"}},
+		{outlookCSV, Outlook, &contacts.Contact{
+			LastName: "Flanders", Address: "744 Evergreen Terrace, Springfield",
+			Email: "ned@example.com", Phone: "555-0199", Tags: "Church,Neighbors"}},
+		{"E-Mail,Last name,First name,Notes,Phone number,Labels\nlisa@example.com,Simpson,Lisa,saxophone,,kids\n", nil, &contacts.Contact{
+			FirstName: "Lisa", LastName: "Simpson", Email: "lisa@example.com", Tags: "kids"}},
+	}
+	for _, tt := range tests {
+		f, err := Read(strings.NewReader(tt.csv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layout, m := Detect(f.Header)
+		if layout != tt.layout {
+			t.Errorf("got layout %v, want %v", layout, tt.layout)
+		}
+		if err := m.Check(len(f.Header)); err != nil {
+			t.Error(err)
+		}
+		if got := m.Contact(f.Rows[0]); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got  %+v\nwant %+v", got, tt.want)
+		}
+	}
+}
+
+func TestContacts(t *testing.T) {
+	f, err := Read(strings.NewReader("name,surname,phone\n" +
+		"Bart,Simpson,(407) 555 0100\n" +
+		"Maggie\n" +
+		",,555-0100\n" +
+		"Lisa,Simpson,call me\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "name" is no known column, the user maps it
+	m := Mapping{"FirstName", "LastName", "Phone"}
+	var got []string
+	for _, r := range f.Contacts(m) {
+		s := r.Contact.FirstName + " " + r.Contact.LastName + " " + r.Contact.Phone
+		if r.Err != nil {
+			s = r.Err.Error()
+		}
+		got = append(got, s)
+		if r.Row != len(got)+1 {
+			t.Errorf("got row %d, want %d", r.Row, len(got)+1)
+		}
+	}
+	want := []string{
+		"Bart Simpson 407-555-0100",
+		"Maggie  ",
+		"invalid contact: firstName: a first or last name is required",
+		"invalid contact: phone: not a phone number",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestEscapeFormula(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"Homer", "Homer"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"-2+3", "'-2+3"},
+		{"+33123456789", "+33123456789"},
+		{"'Ohana", "'Ohana"},
+		{"", ""},
+	} {
+		if got := EscapeFormula(tt.in); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// An exported file reads back as it was
+	f, err := Read(strings.NewReader("firstName,lastName,phone\n" +
+		EscapeFormula("=1+1") + "," + EscapeFormula("'Ohana") + "," + EscapeFormula("+33123456789") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m := Detect(f.Header)
+	rows := f.Contacts(m)
+	if len(rows) != 1 || rows[0].Err != nil {
+		t.Fatalf("got %+v", rows)
+	}
+	if c := rows[0].Contact; c.FirstName != "=1+1" || c.LastName != "'Ohana" || c.Phone != "+33123456789" {
+		t.Errorf("got %q %q %q", c.FirstName, c.LastName, c.Phone)
+	}
+}
+
+func TestMappingCheck(t *testing.T) {
+	for _, tt := range []struct {
+		m    Mapping
+		want string
+	}{
+		{Mapping{"LastName", ""}, ""},
+		{Mapping{"LastName"}, "contactscsv: 1 columns mapped, the file has 2"},
+		{Mapping{"Email", ""}, "contactscsv: map a column onto FirstName or LastName"},
+		{Mapping{"FirstName", "CreatedByID"}, `contactscsv: column 2 mapped onto unknown field "CreatedByID"`},
+	} {
+		got := ""
+		if err := tt.m.Check(2); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := Read(strings.NewReader("")); err == nil {
+		t.Error("empty file: got no error")
+	}
+	if _, err := Read(strings.NewReader("First Name\n\"Homer\"x\"\n")); err != nil {
+		t.Errorf("stray quote: got %v, want it read lazily", err)
+	}
+}
@@ -0,0 +1,239 @@
+// 2017.09.14 rjj: Tests for the GraphQL API.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsgraphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// countingDB counts the calls to the database.
+type countingDB struct {
+	contacts.ContactDatabase
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (db *countingDB) count(method string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.calls[method]++
+}
+
+func (db *countingDB) reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.calls = map[string]int{}
+}
+
+func (db *countingDB) GetContact(id int64) (*contacts.Contact, error) {
+	db.count("GetContact")
+	return db.ContactDatabase.GetContact(id)
+}
+
+func (db *countingDB) ListContactsCreatedBy(userID string) ([]*contacts.Contact, error) {
+	db.count("ListContactsCreatedBy")
+	return db.ContactDatabase.ListContactsCreatedBy(userID)
+}
+
+func (db *countingDB) TallyContactsCreatedBy(userID string) (int64, error) {
+	db.count("TallyContactsCreatedBy")
+	return db.ContactDatabase.TallyContactsCreatedBy(userID)
+}
+
+var homer = &contacts.User{ID: "homer", Name: "Homer Simpson", ImageURL: "https://example.com/homer.png"}
+
+// newTestDB returns a database with 3 contacts of Homer's, IDs 1 to 3, and
+// one of Ned's, ID 4.
+func newTestDB(t *testing.T) *countingDB {
+	db := &countingDB{ContactDatabase: contacts.NewMemoryDB()}
+	for _, c := range []*contacts.Contact{
+		{FirstName: "Bart", LastName: "Simpson", Tags: "family", CreatedBy: homer.Name, CreatedByID: homer.ID},
+		{FirstName: "Lisa", LastName: "Simpson", Tags: "family", CreatedBy: homer.Name, CreatedByID: homer.ID},
+		{FirstName: "Lenny", LastName: "Leonard", Email: "lenny@example.com", CreatedBy: homer.Name, CreatedByID: homer.ID},
+		{FirstName: "Rod", LastName: "Flanders", CreatedBy: "Ned Flanders", CreatedByID: "ned"},
+	} {
+		if _, err := db.AddContact(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.reset()
+	return db
+}
+
+// response is a GraphQL response, with the data kept as JSON.
+type response struct {
+	Data   json.RawMessage
+	Errors []struct {
+		Message    string
+		Extensions map[string]interface{}
+	}
+}
+
+// query returns the response of h to the query, as u.
+func query(t *testing.T, h http.Handler, u *contacts.User, q string, vars map[string]interface{}) *response {
+	body, _ := json.Marshal(request{Query: q, Variables: vars})
+	r := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if u != nil {
+		r = r.WithContext(contacts.NewContext(r.Context(), u))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	resp := &response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+
+	tests := []struct {
+		query, want string
+	}{
+		{`{ me { id displayName imageURL } }`,
+			`{"me":{"id":"homer","displayName":"Homer Simpson","imageURL":"https://example.com/homer.png"}}`},
+		{`{ contact(id: 1) { firstName tags createdBy { displayName } } }`,
+			`{"contact":{"firstName":"Bart","tags":["family"],"createdBy":{"displayName":"Homer Simpson"}}}`},
+		// Ned's
+		{`{ contact(id: 4) { firstName } }`, `{"contact":null}`},
+		{`{ contact(id: 99) { firstName } }`, `{"contact":null}`},
+		// Ordered by last name
+		{`{ contacts(first: 2) { totalCount nodes { firstName } pageInfo { endCursor hasNextPage } } }`,
+			`{"contacts":{"totalCount":3,"nodes":[{"firstName":"Lenny"},{"firstName":"Bart"}],"pageInfo":{"endCursor":"Mg","hasNextPage":true}}}`},
+		{`{ contacts(first: 2, after: "Mg") { nodes { firstName } pageInfo { endCursor hasNextPage } } }`,
+			`{"contacts":{"nodes":[{"firstName":"Lisa"}],"pageInfo":{"endCursor":null,"hasNextPage":false}}}`},
+		{`{ searchContacts(filter: {tag: "family", name: "lis"}) { nodes { firstName } } }`,
+			`{"searchContacts":{"nodes":[{"firstName":"Lisa"}]}}`},
+		{`{ searchContacts(filter: {missingEmail: true}) { totalCount } }`,
+			`{"searchContacts":{"totalCount":2}}`},
+	}
+	for _, tt := range tests {
+		resp := query(t, h, homer, tt.query, nil)
+		if len(resp.Errors) != 0 {
+			t.Errorf("%s: got errors %v", tt.query, resp.Errors)
+			continue
+		}
+		if got := string(resp.Data); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.query, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{
+		`{ contacts(after: "bad") { totalCount } }`,
+		`{ searchContacts(filter: {createdAfter: "yesterday"}) { totalCount } }`,
+		`{ contact(id: 1) { unknownField } }`,
+	} {
+		if resp := query(t, h, homer, q, nil); len(resp.Errors) == 0 {
+			t.Errorf("%s: got %s, want an error", q, resp.Data)
+		}
+	}
+}
+
+func TestGraphQLBatching(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db)
+
+	resp := query(t, h, homer, `{ contacts { nodes { firstName createdBy { contactCount } } } }`, nil)
+	if len(resp.Errors) != 0 {
+		t.Fatal(resp.Errors)
+	}
+	if !strings.Contains(string(resp.Data), `"createdBy":{"contactCount":3}`) {
+		t.Errorf("got %s, want contactCount 3", resp.Data)
+	}
+	if got := db.calls["TallyContactsCreatedBy"]; got != 1 {
+		t.Errorf("3 contacts by the same user: got %d tallies, want 1", got)
+	}
+
+	db.reset()
+	resp = query(t, h, homer, `{ a: contact(id: 1) { firstName } b: contact(id: 2) { firstName } c: contact(id: 3) { firstName } d: contact(id: 1) { lastName } }`, nil)
+	if got, want := string(resp.Data), `{"a":{"firstName":"Bart"},"b":{"firstName":"Lisa"},"c":{"firstName":"Lenny"},"d":{"lastName":"Simpson"}}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := db.calls["ListContactsCreatedBy"] + db.calls["GetContact"]; got != 1 {
+		t.Errorf("3 contacts by ID: got %v, want 1 call", db.calls)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	h := NewHandler(newTestDB(t))
+
+	resp := query(t, h, homer, `mutation($in: ContactInput!) { createContact(input: $in) { id phone createdDate createdBy { id } } }`,
+		map[string]interface{}{"in": map[string]interface{}{"firstName": "Maggie", "lastName": "Simpson", "phone": "(407) 555 0100"}})
+	if len(resp.Errors) != 0 {
+		t.Fatal(resp.Errors)
+	}
+	var created struct {
+		CreateContact struct {
+			ID, Phone, CreatedDate string
+			CreatedBy              struct{ ID string }
+		}
+	}
+	json.Unmarshal(resp.Data, &created)
+	if c := created.CreateContact; c.ID != "5" || c.Phone != "407-555-0100" || c.CreatedDate == "" || c.CreatedBy.ID != "homer" {
+		t.Errorf("create: got %s", resp.Data)
+	}
+
+	resp = query(t, h, homer, `mutation { createContact(input: {email: "maggie"}) { id } }`, nil)
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != "INVALID_INPUT" || resp.Errors[0].Extensions["fields"] == nil {
+		t.Errorf("create invalid: got %+v, want an INVALID_INPUT error with the fields", resp.Errors)
+	}
+
+	resp = query(t, h, homer, `mutation { updateContact(id: 5, input: {email: "maggie@example.com"}) { firstName email } }`, nil)
+	if got, want := string(resp.Data), `{"updateContact":{"firstName":"Maggie","email":"maggie@example.com"}}`; got != want {
+		t.Errorf("update: got %s %v, want %s", got, resp.Errors, want)
+	}
+
+	ned := &contacts.User{ID: "ned", Name: "Ned Flanders"}
+	if resp = query(t, h, ned, `mutation { deleteContact(id: 5) }`, nil); len(resp.Errors) == 0 {
+		t.Errorf("delete other user's: got %s, want an error", resp.Data)
+	}
+	resp = query(t, h, homer, `mutation { deleteContact(id: 5) }`, nil)
+	if got, want := string(resp.Data), `{"deleteContact":"5"}`; got != want {
+		t.Errorf("delete: got %s %v, want %s", got, resp.Errors, want)
+	}
+	resp = query(t, h, homer, `{ contact(id: 5) { id } }`, nil)
+	if got, want := string(resp.Data), `{"contact":null}`; got != want {
+		t.Errorf("get deleted: got %s, want %s", got, want)
+	}
+}
+
+func TestGraphQLHandler(t *testing.T) {
+	h := NewHandler(newTestDB(t))
+	tests := []struct {
+		method, contentType string
+		user                *contacts.User
+		want                int
+	}{
+		{"POST", "application/json", nil, http.StatusUnauthorized},
+		{"GET", "", homer, http.StatusMethodNotAllowed},
+		{"POST", "text/plain", homer, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/graphql", strings.NewReader(`{"query": "{ me { id } }"}`))
+		r.Header.Set("Content-Type", tt.contentType)
+		if tt.user != nil {
+			r = r.WithContext(contacts.NewContext(r.Context(), tt.user))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.contentType, w.Code, tt.want)
+		}
+	}
+}
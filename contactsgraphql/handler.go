@@ -0,0 +1,64 @@
+// 2017.09.14 rjj: HTTP handler of the GraphQL API.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsgraphql
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// maxBody bounds the size of the requests.
+const maxBody = 64 << 10
+
+// request is the body of a POST, as GraphQL clients send it.
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type handler struct {
+	db     contacts.ContactDatabase
+	schema *graphql.Schema
+}
+
+// NewHandler returns the GraphQL API of db. It answers POSTs of
+// application/json bodies of {"query", "operationName", "variables"} with
+// the {"data", "errors"} of the GraphQL spec, for the User of their context.
+func NewHandler(db contacts.ContactDatabase) http.Handler {
+	return &handler{db: db, schema: parseSchema(&resolver{db: db})}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := contacts.UserFromContext(r.Context())
+	if u == nil || u.ID == "" {
+		http.Error(w, "no user", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "queries must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "the body must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&req); err != nil {
+		http.Error(w, "bad JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := withLoaders(r.Context(), h.db, u)
+	resp := h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,123 @@
+// 2017.09.14 rjj: Batched loads of the GraphQL resolvers.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsgraphql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/graph-gophers/dataloader"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// loaderWait is how long a loader collects keys before loading them, the
+// resolvers of the items of a list all start within it.
+const loaderWait = 2 * time.Millisecond
+
+// loaders batch and cache the loads of the resolvers of a request.
+type loaders struct {
+	// contacts loads contacts by idKey, nil for none of the caller's.
+	contacts *dataloader.Loader
+	// counts loads the number of contacts of users by ID.
+	counts *dataloader.Loader
+}
+
+type loadersKey struct{}
+
+// withLoaders returns ctx with new loaders from db, for the caller u.
+func withLoaders(ctx context.Context, db contacts.ContactDatabase, u *contacts.User) context.Context {
+	l := &loaders{
+		contacts: dataloader.NewBatchedLoader(contactsBatch(db, u), dataloader.WithWait(loaderWait)),
+		counts:   dataloader.NewBatchedLoader(countsBatch(db), dataloader.WithWait(loaderWait)),
+	}
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// contact returns the contact id of the caller, nil for none.
+func (l *loaders) contact(ctx context.Context, id int64) (*contacts.Contact, error) {
+	v, err := l.contacts.Load(ctx, idKey(id))()
+	if err != nil {
+		return nil, err
+	}
+	return v.(*contacts.Contact), nil
+}
+
+// count returns how many contacts the user userID created.
+func (l *loaders) count(ctx context.Context, userID string) (int64, error) {
+	v, err := l.counts.Load(ctx, dataloader.StringKey(userID))()
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+// changed forgets what the loaders know of c, after a mutation.
+func (l *loaders) changed(ctx context.Context, c *contacts.Contact) {
+	l.contacts.Clear(ctx, idKey(c.ID))
+	l.counts.Clear(ctx, dataloader.StringKey(c.CreatedByID))
+}
+
+// idKey is a contact ID as a dataloader.Key.
+type idKey int64
+
+func (k idKey) String() string   { return strconv.FormatInt(int64(k), 10) }
+func (k idKey) Raw() interface{} { return int64(k) }
+
+// contactsBatch loads one contact with GetContact, and more with one
+// ListContactsCreatedBy: the caller can only see their own contacts anyway.
+func contactsBatch(db contacts.ContactDatabase, u *contacts.User) dataloader.BatchFunc {
+	return func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		results := make([]*dataloader.Result, len(keys))
+		if len(keys) == 1 {
+			c, err := getContact(db, u, keys[0].Raw().(int64))
+			results[0] = &dataloader.Result{Data: c, Error: err}
+			return results
+		}
+
+		cts, err := db.ListContactsCreatedBy(u.ID)
+		if err != nil {
+			err = fmt.Errorf("could not list contacts: %v", err)
+		}
+		byID := make(map[int64]*contacts.Contact, len(cts))
+		for _, c := range cts {
+			byID[c.ID] = c
+		}
+		for i, k := range keys {
+			results[i] = &dataloader.Result{Data: byID[k.Raw().(int64)], Error: err}
+		}
+		return results
+	}
+}
+
+// countsBatch tallies the contacts of each user once.
+func countsBatch(db contacts.ContactDatabase) dataloader.BatchFunc {
+	return func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		results := make([]*dataloader.Result, len(keys))
+		for i, k := range keys {
+			n, err := db.TallyContactsCreatedBy(k.String())
+			if err != nil {
+				err = fmt.Errorf("could not count contacts: %v", err)
+			}
+			results[i] = &dataloader.Result{Data: n, Error: err}
+		}
+		return results
+	}
+}
+
+// getContact returns the contact id of u, nil for none.
+func getContact(db contacts.ContactDatabase, u *contacts.User, id int64) (*contacts.Contact, error) {
+	c, err := contacts.GetContactOf(db, u.ID, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get contact: %v", err)
+	}
+	return c, nil
+}
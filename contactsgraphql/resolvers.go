@@ -0,0 +1,358 @@
+// 2017.09.14 rjj: Resolvers of the GraphQL schema.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsgraphql
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+const (
+	maxPageSize = 500
+	// maxDepth bounds the nesting of queries.
+	maxDepth = 10
+)
+
+// resolver resolves the Query and Mutation types.
+type resolver struct {
+	db contacts.ContactDatabase
+}
+
+func (r *resolver) Me(ctx context.Context) *userResolver {
+	u := contacts.UserFromContext(ctx)
+	return &userResolver{id: u.ID, name: u.Name, imageURL: u.ImageURL}
+}
+
+func (r *resolver) Contact(ctx context.Context, args struct{ ID graphql.ID }) (*contactResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, nil
+	}
+	c, err := loadersFromContext(ctx).contact(ctx, id)
+	if c == nil {
+		return nil, err
+	}
+	return &contactResolver{c}, nil
+}
+
+func (r *resolver) Contacts(ctx context.Context, args struct {
+	First int32
+	After *string
+}) (*connectionResolver, error) {
+	cts, err := r.db.ListContactsCreatedBy(contacts.UserFromContext(ctx).ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list contacts: %v", err)
+	}
+	return newConnection(cts, args.First, args.After)
+}
+
+func (r *resolver) SearchContacts(ctx context.Context, args struct {
+	Filter contactFilter
+	First  int32
+	After  *string
+}) (*connectionResolver, error) {
+	criteria, err := args.Filter.criteria(contacts.UserFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	cts, err := r.db.FindContacts(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("could not find contacts: %v", err)
+	}
+	return newConnection(cts, args.First, args.After)
+}
+
+func (r *resolver) CreateContact(ctx context.Context, args struct{ Input contactInput }) (*contactResolver, error) {
+	u := contacts.UserFromContext(ctx)
+	c := &contacts.Contact{CreatedBy: u.Name, CreatedByID: u.ID}
+	args.Input.apply(c)
+	if err := c.Validate(); err != nil {
+		return nil, inputError(err)
+	}
+
+	id, err := r.db.AddContact(c)
+	if err != nil {
+		return nil, fmt.Errorf("could not save contact: %v", err)
+	}
+	c.ID = id
+	loadersFromContext(ctx).changed(ctx, c)
+	// Read back for the dates the database sets
+	if saved, err := r.db.GetContact(id); err == nil {
+		c = saved
+	}
+	return &contactResolver{c}, nil
+}
+
+func (r *resolver) UpdateContact(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input contactInput
+}) (*contactResolver, error) {
+	c, err := r.contact(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+	args.Input.apply(c)
+	if err := c.Validate(); err != nil {
+		return nil, inputError(err)
+	}
+
+	if err := r.db.UpdateContact(c); err != nil {
+		return nil, fmt.Errorf("could not update contact: %v", err)
+	}
+	loadersFromContext(ctx).changed(ctx, c)
+	if saved, err := r.db.GetContact(c.ID); err == nil {
+		c = saved
+	}
+	return &contactResolver{c}, nil
+}
+
+func (r *resolver) DeleteContact(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	c, err := r.contact(ctx, args.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := r.db.DeleteContact(c.ID); err != nil {
+		return "", fmt.Errorf("could not delete contact: %v", err)
+	}
+	loadersFromContext(ctx).changed(ctx, c)
+	return args.ID, nil
+}
+
+// contact returns the contact id of the caller, for a mutation.
+func (r *resolver) contact(ctx context.Context, id graphql.ID) (*contacts.Contact, error) {
+	n, err := parseID(id)
+	if err != nil {
+		return nil, fmt.Errorf("no contact %s", id)
+	}
+	c, err := getContact(r.db, contacts.UserFromContext(ctx), n)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, fmt.Errorf("no contact %s", id)
+	}
+	return c, nil
+}
+
+func parseID(id graphql.ID) (int64, error) {
+	return strconv.ParseInt(string(id), 10, 64)
+}
+
+// contactResolver resolves the Contact type.
+type contactResolver struct {
+	c *contacts.Contact
+}
+
+func (r *contactResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(r.c.ID, 10))
+}
+
+func (r *contactResolver) FirstName() string { return r.c.FirstName }
+func (r *contactResolver) LastName() string  { return r.c.LastName }
+func (r *contactResolver) Address() string   { return r.c.Address }
+func (r *contactResolver) Email() string     { return r.c.Email }
+func (r *contactResolver) Phone() string     { return r.c.Phone }
+
+func (r *contactResolver) Tags() []string {
+	if r.c.Tags == "" {
+		return []string{}
+	}
+	return strings.Split(r.c.Tags, ",")
+}
+
+func (r *contactResolver) CreatedBy(ctx context.Context) *userResolver {
+	if u := contacts.UserFromContext(ctx); u.ID == r.c.CreatedByID {
+		return &userResolver{id: u.ID, name: u.Name, imageURL: u.ImageURL}
+	}
+	return &userResolver{id: r.c.CreatedByID, name: r.c.CreatedByDisplayName()}
+}
+
+func (r *contactResolver) CreatedDate() *string { return rfc3339(r.c.CreatedDate) }
+func (r *contactResolver) LastEdited() *string  { return rfc3339(r.c.LastEdited) }
+
+// rfc3339 returns a date of the database in RFC 3339, nil for none.
+func rfc3339(s string) *string {
+	t, err := time.Parse(contacts.CreatedDateLayout, s)
+	if err != nil {
+		return nil
+	}
+	s = t.Format(time.RFC3339)
+	return &s
+}
+
+// userResolver resolves the User type.
+type userResolver struct {
+	id, name, imageURL string
+}
+
+func (r *userResolver) ID() graphql.ID      { return graphql.ID(r.id) }
+func (r *userResolver) DisplayName() string { return r.name }
+
+func (r *userResolver) ImageURL() *string {
+	if r.imageURL == "" {
+		return nil
+	}
+	return &r.imageURL
+}
+
+func (r *userResolver) ContactCount(ctx context.Context) (int32, error) {
+	n, err := loadersFromContext(ctx).count(ctx, r.id)
+	return int32(n), err
+}
+
+// connectionResolver resolves the ContactConnection and PageInfo types.
+type connectionResolver struct {
+	nodes     []*contactResolver
+	total     int32
+	endCursor *string
+}
+
+// newConnection returns the page of cts of first contacts after the cursor.
+// Cursors are the offset of the next page.
+func newConnection(cts []*contacts.Contact, first int32, after *string) (*connectionResolver, error) {
+	if first < 0 {
+		return nil, fmt.Errorf("negative first")
+	}
+	if first > maxPageSize {
+		first = maxPageSize
+	}
+	start := 0
+	if after != nil {
+		b, err := base64.RawURLEncoding.DecodeString(*after)
+		if err == nil {
+			start, err = strconv.Atoi(string(b))
+		}
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("bad cursor %q", *after)
+		}
+	}
+	if start > len(cts) {
+		start = len(cts)
+	}
+	end := start + int(first)
+	if end > len(cts) {
+		end = len(cts)
+	}
+
+	conn := &connectionResolver{total: int32(len(cts))}
+	for _, c := range cts[start:end] {
+		conn.nodes = append(conn.nodes, &contactResolver{c})
+	}
+	if end < len(cts) {
+		cursor := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+		conn.endCursor = &cursor
+	}
+	return conn, nil
+}
+
+func (r *connectionResolver) Nodes() []*contactResolver {
+	if r.nodes == nil {
+		return []*contactResolver{}
+	}
+	return r.nodes
+}
+
+func (r *connectionResolver) TotalCount() int32             { return r.total }
+func (r *connectionResolver) PageInfo() *connectionResolver { return r }
+func (r *connectionResolver) EndCursor() *string            { return r.endCursor }
+func (r *connectionResolver) HasNextPage() bool             { return r.endCursor != nil }
+
+// contactFilter is the ContactFilter input.
+type contactFilter struct {
+	Name, Tag, City             *string
+	EmailDomain                 *string
+	PhonePrefix, PhoneSuffix    *string
+	MissingEmail, MissingPhone  *bool
+	CreatedAfter, CreatedBefore *string
+}
+
+// criteria returns the criteria of f, for the contacts of u.
+func (f *contactFilter) criteria(u *contacts.User) (contacts.ContactCriteria, error) {
+	criteria := contacts.ContactCriteria{CreatedByID: u.ID}
+	for _, s := range []struct {
+		dst *string
+		src *string
+	}{
+		{&criteria.Name, f.Name},
+		{&criteria.Tag, f.Tag},
+		{&criteria.City, f.City},
+		{&criteria.EmailDomain, f.EmailDomain},
+		{&criteria.PhonePrefix, f.PhonePrefix},
+		{&criteria.PhoneSuffix, f.PhoneSuffix},
+	} {
+		if s.src != nil {
+			*s.dst = *s.src
+		}
+	}
+	criteria.MissingEmail = f.MissingEmail != nil && *f.MissingEmail
+	criteria.MissingPhone = f.MissingPhone != nil && *f.MissingPhone
+	for _, b := range []struct {
+		dst *time.Time
+		src *string
+	}{{&criteria.CreatedSince, f.CreatedAfter}, {&criteria.CreatedBefore, f.CreatedBefore}} {
+		if b.src == nil {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, *b.src)
+		if err != nil {
+			return criteria, fmt.Errorf("bad date %q, want RFC 3339, e.g. 2017-09-01T00:00:00Z", *b.src)
+		}
+		*b.dst = t
+	}
+	return criteria, nil
+}
+
+// contactInput is the ContactInput input.
+type contactInput struct {
+	FirstName, LastName, Address, Email, Phone *string
+	Tags                                       *[]string
+}
+
+// apply copies the fields set in in to c.
+func (in *contactInput) apply(c *contacts.Contact) {
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&c.FirstName, in.FirstName},
+		{&c.LastName, in.LastName},
+		{&c.Address, in.Address},
+		{&c.Email, in.Email},
+		{&c.Phone, in.Phone},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if in.Tags != nil {
+		c.Tags = strings.Join(*in.Tags, ",")
+	}
+}
+
+// validationError is a contacts.ValidationError, with the fields in the
+// extensions of the GraphQL error.
+type validationError struct {
+	contacts.ValidationError
+}
+
+func (e validationError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "INVALID_INPUT", "fields": e.ValidationError}
+}
+
+// inputError returns the error of Contact.Validate for the response.
+func inputError(err error) error {
+	if errs, ok := err.(contacts.ValidationError); ok {
+		return validationError{errs}
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+// 2017.09.14 rjj: GraphQL schema of the contacts.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package contactsgraphql serves the contacts of the caller as a GraphQL API
+// over a contacts.ContactDatabase, for clients that fetch exactly the fields
+// they need. NewHandler returns it as an http.Handler, the caller must be in
+// the request's context, see contacts.NewContext.
+//
+// The loaders of loaders.go batch the loads of the resolvers of a request,
+// so a page of contacts with their createdBy { contactCount } costs a
+// couple of database calls, not one per contact.
+package contactsgraphql
+
+import graphql "github.com/graph-gophers/graphql-go"
+
+// Schema is the GraphQL schema of the API, in the schema language.
+const Schema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+# Query reads the contacts of the caller, the user of the access token or the
+# login session. Contacts of other users are not found.
+type Query {
+	# The caller.
+	me: User!
+	# A contact by its ID, null when there is none.
+	contact(id: ID!): Contact
+	# The contacts, ordered by name, first at a time after the cursor.
+	contacts(first: Int = 50, after: String): ContactConnection!
+	# The contacts matching all the criteria of the filter, ordered by name,
+	# first at a time after the cursor.
+	searchContacts(filter: ContactFilter!, first: Int = 50, after: String): ContactConnection!
+}
+
+type Mutation {
+	# Adds a contact, checked like the web forms: it needs a first or last
+	# name, and a valid email and phone number when they are set.
+	createContact(input: ContactInput!): Contact!
+	# Changes the fields set in the input, and keeps the others.
+	updateContact(id: ID!, input: ContactInput!): Contact!
+	# Removes a contact, and returns its ID.
+	deleteContact(id: ID!): ID!
+}
+
+type Contact {
+	id: ID!
+	firstName: String!
+	lastName: String!
+	address: String!
+	email: String!
+	# Normalized, e.g. 407-555-0100 or +33123456789.
+	phone: String!
+	tags: [String!]!
+	createdBy: User!
+	# RFC 3339, null when unknown.
+	createdDate: String
+	lastEdited: String
+}
+
+# User is the profile of a user who creates contacts.
+type User {
+	id: ID!
+	displayName: String!
+	# The picture of the profile, null when unknown.
+	imageURL: String
+	# How many contacts the user created.
+	contactCount: Int!
+}
+
+# ContactConnection is a page of contacts.
+type ContactConnection {
+	nodes: [Contact!]!
+	# The number of contacts on all the pages.
+	totalCount: Int!
+	pageInfo: PageInfo!
+}
+
+type PageInfo {
+	# The after argument of the next page, null when there are none.
+	endCursor: String
+	hasNextPage: Boolean!
+}
+
+# ContactFilter selects contacts, unset criteria match any contact.
+input ContactFilter {
+	# Part of the first or last name.
+	name: String
+	# One of the contact's tags.
+	tag: String
+	# Part of the address.
+	city: String
+	# The part of the email after the @.
+	emailDomain: String
+	# First or last digits of the phone number.
+	phonePrefix: String
+	phoneSuffix: String
+	# Contacts without an email, or phone number.
+	missingEmail: Boolean
+	missingPhone: Boolean
+	# RFC 3339 bounds of createdDate, after is included, before is not.
+	createdAfter: String
+	createdBefore: String
+}
+
+# ContactInput is the fields of a contact, unset ones are empty for
+# createContact and kept by updateContact.
+input ContactInput {
+	firstName: String
+	lastName: String
+	address: String
+	email: String
+	phone: String
+	tags: [String!]
+}
+`
+
+// parseSchema returns Schema resolved by r.
+func parseSchema(r *resolver) *graphql.Schema {
+	return graphql.MustParseSchema(Schema, r, graphql.MaxDepth(maxDepth))
+}
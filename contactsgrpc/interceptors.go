@@ -0,0 +1,120 @@
+// 2017.09.13 rjj: Authentication and logging of the gRPC calls.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsgrpc
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/rjj-work/yum-contacts"
+	pb "github.com/rjj-work/yum-contacts/contactspb"
+)
+
+// Authenticator returns the user of a bearer token, or an error for tokens
+// that are unknown or expired.
+type Authenticator func(token string) (*contacts.User, error)
+
+// caller returns the user set by the authentication interceptors, see
+// contacts.UserFromContext, or a user without an ID, who has no contacts.
+func caller(ctx context.Context) *contacts.User {
+	if u := contacts.UserFromContext(ctx); u != nil {
+		return u
+	}
+	return &contacts.User{}
+}
+
+// NewServer returns a gRPC server of svc, for the users of the bearer tokens
+// auth knows, logging every call.
+func NewServer(svc *Service, auth Authenticator, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(UnaryLogging(), UnaryAuth(auth)),
+		grpc.ChainStreamInterceptor(StreamLogging(), StreamAuth(auth)))
+	s := grpc.NewServer(opts...)
+	pb.RegisterContactServiceServer(s, svc)
+	return s
+}
+
+// authenticate returns ctx with the user of the "authorization: Bearer
+// <token>" metadata.
+func authenticate(ctx context.Context, auth Authenticator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "an \"authorization: Bearer <token>\" is required")
+	}
+	u, err := auth(strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer ")))
+	if err != nil || u == nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+	return contacts.NewContext(ctx, u), nil
+}
+
+// UnaryAuth refuses calls without a valid bearer token, and passes the
+// others on with their user, see caller.
+func UnaryAuth(auth Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, auth)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth is UnaryAuth for streams.
+func StreamAuth(auth Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), auth)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ss, ctx})
+	}
+}
+
+// contextStream is a stream with another context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryLogging logs every call with its status code and duration.
+func UnaryLogging() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamLogging logs every stream when it ends.
+func StreamLogging() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logCall(method string, start time.Time, err error) {
+	if err != nil {
+		log.Printf("gRPC %s: %s in %v: %v", method, status.Code(err), time.Since(start), status.Convert(err).Message())
+		return
+	}
+	log.Printf("gRPC %s: OK in %v", method, time.Since(start))
+}
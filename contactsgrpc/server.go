@@ -0,0 +1,326 @@
+// 2017.09.13 rjj: gRPC ContactService over a ContactDatabase.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package contactsgrpc implements the ContactService of package contactspb
+// over a contacts.ContactDatabase, for the contacts of the caller. NewServer
+// returns it as a grpc.Server with the authentication and logging
+// interceptors of interceptors.go.
+package contactsgrpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/rjj-work/yum-contacts"
+	pb "github.com/rjj-work/yum-contacts/contactspb"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Service is the ContactService of DB. WatchContacts needs a
+// *contacts.WatchedDatabase, it is UNIMPLEMENTED on other databases.
+type Service struct {
+	pb.UnimplementedContactServiceServer
+	DB contacts.ContactDatabase
+}
+
+// Ensure Service conforms to the ContactServiceServer interface.
+var _ pb.ContactServiceServer = &Service{}
+
+// GetContact returns a contact by its ID.
+func (s *Service) GetContact(ctx context.Context, req *pb.GetContactRequest) (*pb.Contact, error) {
+	c, err := s.contact(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(c), nil
+}
+
+// ListContacts returns the contacts, ordered by name, a page at a time.
+func (s *Service) ListContacts(ctx context.Context, req *pb.ListContactsRequest) (*pb.ListContactsResponse, error) {
+	user := caller(ctx)
+	cts, err := s.DB.ListContactsCreatedBy(user.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not list contacts: %v", err)
+	}
+	page, next, err := paginate(cts, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListContactsResponse{Contacts: page, NextPageToken: next}, nil
+}
+
+// CreateContact adds a contact.
+func (s *Service) CreateContact(ctx context.Context, req *pb.CreateContactRequest) (*pb.Contact, error) {
+	user := caller(ctx)
+	if req.Contact == nil {
+		return nil, status.Error(codes.InvalidArgument, "no contact")
+	}
+	c := &contacts.Contact{CreatedBy: user.Name, CreatedByID: user.ID}
+	setFields(c, req.Contact, nil)
+	if err := c.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	id, err := s.DB.AddContact(c)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not save contact: %v", err)
+	}
+	// Read back for the dates the database sets
+	if saved, err := s.DB.GetContact(id); err == nil {
+		c = saved
+	} else {
+		c.ID = id
+	}
+	return toProto(c), nil
+}
+
+// UpdateContact changes the fields of the update mask, or all of them.
+func (s *Service) UpdateContact(ctx context.Context, req *pb.UpdateContactRequest) (*pb.Contact, error) {
+	if req.Contact == nil {
+		return nil, status.Error(codes.InvalidArgument, "no contact")
+	}
+	c, err := s.contact(ctx, req.Contact.Id)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	if req.UpdateMask != nil {
+		paths = req.UpdateMask.Paths
+	}
+	for _, p := range paths {
+		if !updatable[p] {
+			return nil, status.Errorf(codes.InvalidArgument, "%q can't be updated", p)
+		}
+	}
+	setFields(c, req.Contact, paths)
+	if err := c.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.DB.UpdateContact(c); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not update contact: %v", err)
+	}
+	if saved, err := s.DB.GetContact(c.ID); err == nil {
+		c = saved
+	}
+	return toProto(c), nil
+}
+
+// DeleteContact removes a contact.
+func (s *Service) DeleteContact(ctx context.Context, req *pb.DeleteContactRequest) (*emptypb.Empty, error) {
+	c, err := s.contact(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.DeleteContact(c.ID); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not delete contact: %v", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// SearchContacts returns the contacts matching the filter, a page at a time.
+func (s *Service) SearchContacts(ctx context.Context, req *pb.SearchContactsRequest) (*pb.SearchContactsResponse, error) {
+	criteria, err := toCriteria(caller(ctx), req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	cts, err := s.DB.FindContacts(criteria)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not find contacts: %v", err)
+	}
+	page, next, err := paginate(cts, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SearchContactsResponse{Contacts: page, NextPageToken: next}, nil
+}
+
+// CountContacts returns how many contacts match the filter.
+func (s *Service) CountContacts(ctx context.Context, req *pb.CountContactsRequest) (*pb.CountContactsResponse, error) {
+	criteria, err := toCriteria(caller(ctx), req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.DB.TallyContactsMatching(criteria)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not count contacts: %v", err)
+	}
+	return &pb.CountContactsResponse{Count: n}, nil
+}
+
+// WatchContacts streams the changes to the contacts until the client goes
+// away, or falls behind.
+func (s *Service) WatchContacts(req *pb.WatchContactsRequest, stream pb.ContactService_WatchContactsServer) error {
+	db, ok := s.DB.(*contacts.WatchedDatabase)
+	if !ok {
+		return status.Error(codes.Unimplemented, "the database is not watched")
+	}
+	changes, stop := db.Watch(caller(stream.Context()).ID)
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case change, ok := <-changes:
+			if !ok {
+				return status.Error(codes.Aborted, "fell behind on the changes, list the contacts again")
+			}
+			if err := stream.Send(&pb.ContactEvent{Type: eventTypes[change.Type], Contact: toProto(change.Contact)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var eventTypes = map[contacts.ChangeType]pb.ContactEvent_Type{
+	contacts.ContactAdded:   pb.ContactEvent_CREATED,
+	contacts.ContactUpdated: pb.ContactEvent_UPDATED,
+	contacts.ContactDeleted: pb.ContactEvent_DELETED,
+}
+
+// contact returns the contact id of the caller, other users' are NOT_FOUND.
+func (s *Service) contact(ctx context.Context, id int64) (*contacts.Contact, error) {
+	c, err := contacts.GetContactOf(s.DB, caller(ctx).ID, id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get contact: %v", err)
+	}
+	if c == nil {
+		return nil, status.Errorf(codes.NotFound, "no contact %d", id)
+	}
+	return c, nil
+}
+
+// paginate returns the page of cts after the token, and the token of the
+// next one. Tokens are the offset of the page.
+func paginate(cts []*contacts.Contact, size int32, token string) ([]*pb.Contact, string, error) {
+	if size < 0 {
+		return nil, "", status.Error(codes.InvalidArgument, "negative page_size")
+	}
+	if size == 0 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	start := 0
+	if token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start < 0 {
+			return nil, "", status.Errorf(codes.InvalidArgument, "bad page_token %q", token)
+		}
+	}
+	if start > len(cts) {
+		start = len(cts)
+	}
+	end := start + int(size)
+	next := strconv.Itoa(end)
+	if end >= len(cts) {
+		end, next = len(cts), ""
+	}
+
+	page := make([]*pb.Contact, 0, end-start)
+	for _, c := range cts[start:end] {
+		page = append(page, toProto(c))
+	}
+	return page, next, nil
+}
+
+// updatable are the field mask paths UpdateContact accepts.
+var updatable = map[string]bool{
+	"first_name": true, "last_name": true, "address": true, "email": true, "phone": true, "tags": true,
+}
+
+// setFields copies the fields of p named by paths to c, or all of them for none.
+func setFields(c *contacts.Contact, p *pb.Contact, paths []string) {
+	set := func(path string) bool {
+		if len(paths) == 0 {
+			return true
+		}
+		for _, s := range paths {
+			if s == path {
+				return true
+			}
+		}
+		return false
+	}
+	if set("first_name") {
+		c.FirstName = p.FirstName
+	}
+	if set("last_name") {
+		c.LastName = p.LastName
+	}
+	if set("address") {
+		c.Address = p.Address
+	}
+	if set("email") {
+		c.Email = p.Email
+	}
+	if set("phone") {
+		c.Phone = p.Phone
+	}
+	if set("tags") {
+		c.Tags = strings.Join(p.Tags, ",")
+	}
+}
+
+// toProto returns c as a message.
+func toProto(c *contacts.Contact) *pb.Contact {
+	p := &pb.Contact{
+		Id:        c.ID,
+		FirstName: c.FirstName,
+		LastName:  c.LastName,
+		Address:   c.Address,
+		Email:     c.Email,
+		Phone:     c.Phone,
+		CreatedBy: c.CreatedByDisplayName(),
+	}
+	if c.Tags != "" {
+		p.Tags = strings.Split(c.Tags, ",")
+	}
+	if t, err := time.Parse(contacts.CreatedDateLayout, c.CreatedDate); err == nil {
+		p.CreateTime = timestamppb.New(t)
+	}
+	return p
+}
+
+// toCriteria returns the criteria of f, for the contacts of user.
+func toCriteria(user *contacts.User, f *pb.ContactFilter) (contacts.ContactCriteria, error) {
+	criteria := contacts.ContactCriteria{CreatedByID: user.ID}
+	if f == nil {
+		return criteria, nil
+	}
+	criteria.Name = f.Name
+	criteria.Tag = f.Tag
+	criteria.City = f.City
+	criteria.EmailDomain = f.EmailDomain
+	criteria.PhonePrefix = f.PhonePrefix
+	criteria.PhoneSuffix = f.PhoneSuffix
+	criteria.MissingEmail = f.MissingEmail
+	criteria.MissingPhone = f.MissingPhone
+	for _, b := range []struct {
+		ts *timestamppb.Timestamp
+		t  *time.Time
+	}{{f.CreatedAfter, &criteria.CreatedSince}, {f.CreatedBefore, &criteria.CreatedBefore}} {
+		if b.ts == nil {
+			continue
+		}
+		if err := b.ts.CheckValid(); err != nil {
+			return criteria, status.Errorf(codes.InvalidArgument, "bad timestamp: %v", err)
+		}
+		*b.t = b.ts.AsTime()
+	}
+	return criteria, nil
+}
@@ -0,0 +1,205 @@
+// 2017.09.13 rjj: Tests for the gRPC ContactService, over an in-memory connection.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsgrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/rjj-work/yum-contacts"
+	pb "github.com/rjj-work/yum-contacts/contactspb"
+)
+
+var testUsers = map[string]*contacts.User{
+	"homer-token": {ID: "homer", Name: "Homer Simpson"},
+	"ned-token":   {ID: "ned", Name: "Ned Flanders"},
+}
+
+func testAuth(token string) (*contacts.User, error) {
+	if u, ok := testUsers[token]; ok {
+		return u, nil
+	}
+	return nil, errors.New("unknown token")
+}
+
+// newTestClient returns a client of a server on db, and a function to stop both.
+func newTestClient(t *testing.T, db contacts.ContactDatabase) (pb.ContactServiceClient, func()) {
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(&Service{DB: db}, testAuth)
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewContactServiceClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+// as returns a context with the bearer token.
+func as(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestAuthentication(t *testing.T) {
+	client, stop := newTestClient(t, contacts.NewMemoryDB())
+	defer stop()
+
+	for _, ctx := range []context.Context{context.Background(), as("wrong-token")} {
+		_, err := client.CountContacts(ctx, &pb.CountContactsRequest{})
+		if got, want := status.Code(err), codes.Unauthenticated; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := client.CountContacts(as("homer-token"), &pb.CountContactsRequest{}); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
+
+func TestContactService(t *testing.T) {
+	client, stop := newTestClient(t, contacts.NewMemoryDB())
+	defer stop()
+	homer := as("homer-token")
+
+	for _, name := range []string{"Bart", "Lisa", "Maggie"} {
+		if _, err := client.CreateContact(homer, &pb.CreateContactRequest{Contact: &pb.Contact{FirstName: name, LastName: "Simpson", Tags: []string{"family"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := client.CreateContact(homer, &pb.CreateContactRequest{Contact: &pb.Contact{FirstName: "Lenny", LastName: "Leonard", Phone: "(407) 555 0100"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Id != 4 || c.Phone != "407-555-0100" || c.CreatedBy != "Homer Simpson" || c.CreateTime == nil {
+		t.Errorf("create: got %v", c)
+	}
+	_, err = client.CreateContact(homer, &pb.CreateContactRequest{Contact: &pb.Contact{Email: "lenny"}})
+	if got, want := status.Code(err), codes.InvalidArgument; got != want {
+		t.Errorf("create invalid: got %v, want %v", got, want)
+	}
+
+	// Pages of 3, ordered by name
+	list, err := client.ListContacts(homer, &pb.ListContactsRequest{PageSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contacts) != 3 || list.NextPageToken == "" {
+		t.Fatalf("first page: got %v", list)
+	}
+	list, err = client.ListContacts(homer, &pb.ListContactsRequest{PageSize: 3, PageToken: list.NextPageToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contacts) != 1 || list.NextPageToken != "" {
+		t.Errorf("last page: got %v", list)
+	}
+
+	c, err = client.UpdateContact(homer, &pb.UpdateContactRequest{
+		Contact:    &pb.Contact{Id: 4, Email: "lenny@example.com"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Email != "lenny@example.com" || c.FirstName != "Lenny" {
+		t.Errorf("update email: got %v, want the other fields kept", c)
+	}
+
+	search, err := client.SearchContacts(homer, &pb.SearchContactsRequest{Filter: &pb.ContactFilter{Tag: "family", Name: "lis"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(search.Contacts) != 1 || search.Contacts[0].FirstName != "Lisa" {
+		t.Errorf("search: got %v, want Lisa", search.Contacts)
+	}
+	count, err := client.CountContacts(homer, &pb.CountContactsRequest{Filter: &pb.ContactFilter{MissingEmail: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Count != 3 {
+		t.Errorf("count without email: got %d, want 3", count.Count)
+	}
+
+	// Homer's contacts are not Ned's
+	ned := as("ned-token")
+	if _, err := client.GetContact(ned, &pb.GetContactRequest{Id: 4}); status.Code(err) != codes.NotFound {
+		t.Errorf("get other user's: got %v, want NotFound", err)
+	}
+	if _, err := client.DeleteContact(ned, &pb.DeleteContactRequest{Id: 4}); status.Code(err) != codes.NotFound {
+		t.Errorf("delete other user's: got %v, want NotFound", err)
+	}
+
+	if _, err := client.DeleteContact(homer, &pb.DeleteContactRequest{Id: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetContact(homer, &pb.GetContactRequest{Id: 4}); status.Code(err) != codes.NotFound {
+		t.Errorf("get deleted: got %v, want NotFound", err)
+	}
+}
+
+func TestWatchContacts(t *testing.T) {
+	db := contacts.NewWatchedDatabase(contacts.NewMemoryDB())
+	client, stop := newTestClient(t, db)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(as("homer-token"), 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchContacts(ctx, &pb.WatchContactsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changes made through another way in, e.g. the web app, are seen too.
+	// The stream is set up once the server has it, add until the first one comes.
+	events := make(chan *pb.ContactEvent)
+	go func() {
+		for {
+			e, err := stream.Recv()
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- e
+		}
+	}()
+	db.AddContact(&contacts.Contact{FirstName: "Ned", CreatedByID: "ned"})
+	var e *pb.ContactEvent
+	for e == nil {
+		db.AddContact(&contacts.Contact{FirstName: "Bart", CreatedByID: "homer"})
+		select {
+		case e = <-events:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if e.Type != pb.ContactEvent_CREATED || e.Contact.FirstName != "Bart" {
+		t.Errorf("got %v, want Bart created", e)
+	}
+}
+
+func TestWatchUnwatchedDatabase(t *testing.T) {
+	client, stop := newTestClient(t, contacts.NewMemoryDB())
+	defer stop()
+	stream, err := client.WatchContacts(as("homer-token"), &pb.WatchContactsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Errorf("got %v, want Unimplemented", err)
+	}
+}
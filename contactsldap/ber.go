@@ -0,0 +1,240 @@
+// 2017.09.20 rjj: The subset of BER (X.690) that LDAP messages are made of.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The classes of the tags.
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+)
+
+// The universal tags of LDAP.
+const (
+	tagBoolean     = 1
+	tagInteger     = 2
+	tagOctetString = 4
+	tagEnumerated  = 10
+	tagSequence    = 16
+	tagSet         = 17
+)
+
+// maxPacket bounds the size of the messages a client may send, and
+// maxDepth how deep their elements nest.
+const (
+	maxPacket = 1 << 20
+	maxDepth  = 64
+)
+
+var errTooLarge = errors.New("ber: message too large")
+
+// packet is a BER element: a primitive value, or constructed of children.
+type packet struct {
+	class       byte
+	constructed bool
+	tag         int
+	// value is the content of a primitive
+	value    []byte
+	children []*packet
+}
+
+// readPacket reads a packet from r. LDAP messages only use the definite
+// length forms, and tags up to 30.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	length := int(n)
+	if n&0x80 != 0 {
+		size := int(n & 0x7f)
+		if size == 0 || size > 4 {
+			return nil, fmt.Errorf("ber: unsupported length of %d bytes", size)
+		}
+		length = 0
+		for i := 0; i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length < 0 || length > maxPacket {
+		return nil, errTooLarge
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return newPacket(id, content, 0)
+}
+
+// parsePacket parses the packet at the start of b, at depth in the
+// packet being read, and returns the rest.
+func parsePacket(b []byte, depth int) (*packet, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	id, length, b := b[0], int(b[1]), b[2:]
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 4 || size > len(b) {
+			return nil, nil, fmt.Errorf("ber: bad length")
+		}
+		length = 0
+		for _, c := range b[:size] {
+			length = length<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if length < 0 || length > len(b) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	p, err := newPacket(id, b[:length], depth)
+	return p, b[length:], err
+}
+
+// newPacket returns the packet of the identifier octet id and the content.
+func newPacket(id byte, content []byte, depth int) (*packet, error) {
+	p := &packet{class: id & 0xc0, constructed: id&0x20 != 0, tag: int(id & 0x1f)}
+	if p.tag == 0x1f {
+		return nil, fmt.Errorf("ber: unsupported high tag number")
+	}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+	if depth >= maxDepth {
+		return nil, fmt.Errorf("ber: nested too deep")
+	}
+	for len(content) > 0 {
+		child, rest, err := parsePacket(content, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		content = rest
+	}
+	return p, nil
+}
+
+// bytes returns the encoding of p.
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.bytes()...)
+		}
+	}
+	id := p.class | byte(p.tag)
+	if p.constructed {
+		id |= 0x20
+	}
+	b := []byte{id}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	default:
+		var size []byte
+		for ; n > 0; n >>= 8 {
+			size = append([]byte{byte(n)}, size...)
+		}
+		b = append(b, 0x80|byte(len(size)))
+		b = append(b, size...)
+	}
+	return append(b, content...)
+}
+
+// is tells whether p has the class and the tag.
+func (p *packet) is(class byte, tag int) bool {
+	return p != nil && p.class == class && p.tag == tag
+}
+
+// child returns the i-th child of p, nil if it has none.
+func (p *packet) child(i int) *packet {
+	if p == nil || i >= len(p.children) {
+		return nil
+	}
+	return p.children[i]
+}
+
+// str returns the value of p as a string, "" for nil.
+func (p *packet) str() string {
+	if p == nil {
+		return ""
+	}
+	return string(p.value)
+}
+
+// int returns the value of an INTEGER or ENUMERATED p.
+func (p *packet) int() (int64, error) {
+	if p == nil || p.constructed || len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errors.New("ber: bad integer")
+	}
+	n := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+// bool returns the value of a BOOLEAN p.
+func (p *packet) bool() (bool, error) {
+	if p == nil || p.constructed || len(p.value) != 1 {
+		return false, errors.New("ber: bad boolean")
+	}
+	return p.value[0] != 0, nil
+}
+
+func newConstructed(class byte, tag int, children ...*packet) *packet {
+	return &packet{class: class, constructed: true, tag: tag, children: children}
+}
+
+func newSequence(children ...*packet) *packet {
+	return newConstructed(classUniversal, tagSequence, children...)
+}
+
+func newString(class byte, tag int, s string) *packet {
+	return &packet{class: class, tag: tag, value: []byte(s)}
+}
+
+func newOctetString(s string) *packet {
+	return newString(classUniversal, tagOctetString, s)
+}
+
+// newInteger returns an INTEGER, or another tag of the same encoding.
+func newInteger(class byte, tag int, n int64) *packet {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		// Minimal two's complement: stop once the sign bit is right
+		if n >= -0x80 && n < 0x80 {
+			break
+		}
+		n >>= 8
+	}
+	return &packet{class: class, tag: tag, value: b}
+}
+
+func newBoolean(v bool) *packet {
+	p := &packet{class: classUniversal, tag: tagBoolean, value: []byte{0}}
+	if v {
+		p.value[0] = 0xff
+	}
+	return p
+}
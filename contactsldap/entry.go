@@ -0,0 +1,123 @@
+// 2017.09.20 rjj: Contacts as LDAP directory entries, for LDIF and the LDAP server.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package contactsldap publishes the contacts as an LDAP directory of
+// inetOrgPerson entries (RFC 2798), for the devices that read an address
+// book over LDAP, like office printers and VoIP phones: as LDIF (RFC 2849)
+// with an Encoder, or live with a read-only Server of LDAPv3 searches.
+package contactsldap
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// DefaultBaseDN is the DN the entries are under when none is configured.
+const DefaultBaseDN = "ou=contacts,dc=example,dc=com"
+
+// Entry is a directory entry.
+type Entry struct {
+	DN         string
+	Attributes []Attribute
+}
+
+// Attribute is an attribute of an entry and its values.
+type Attribute struct {
+	Type   string
+	Values []string
+}
+
+// Add appends the attribute typ with values, unless there are none.
+func (e *Entry) Add(typ string, values ...string) {
+	if len(values) > 0 {
+		e.Attributes = append(e.Attributes, Attribute{typ, values})
+	}
+}
+
+// Get returns the values of the attribute typ, whose case doesn't matter.
+func (e *Entry) Get(typ string) []string {
+	for _, a := range e.Attributes {
+		if strings.EqualFold(a.Type, typ) {
+			return a.Values
+		}
+	}
+	return nil
+}
+
+// DN returns the DN of the entry of the contact id under baseDN.
+func DN(id int64, baseDN string) string {
+	return "uid=" + strconv.FormatInt(id, 10) + "," + baseDN
+}
+
+// FromContact returns c as an inetOrgPerson entry under baseDN:
+//
+//	uid		the ID, the RDN of the entry
+//	cn		the first and last name, or else the email or phone number
+//	sn, givenName	the last name, or cn as sn is required, and the first name
+//	mail		the email
+//	telephoneNumber	the phone number
+//	postalAddress	the address, a line for each part between commas
+func FromContact(c *contacts.Contact, baseDN string) *Entry {
+	e := &Entry{DN: DN(c.ID, baseDN)}
+	e.Add("objectClass", "top", "person", "organizationalPerson", "inetOrgPerson")
+	e.Add("uid", strconv.FormatInt(c.ID, 10))
+
+	cn := strings.TrimSpace(c.FirstName + " " + c.LastName)
+	if cn == "" {
+		cn = firstOf(c.Email, c.Phone, "Contact "+strconv.FormatInt(c.ID, 10))
+	}
+	e.Add("cn", cn)
+	e.Add("sn", firstOf(strings.TrimSpace(c.LastName), cn))
+	if s := strings.TrimSpace(c.FirstName); s != "" {
+		e.Add("givenName", s)
+	}
+	if s := strings.TrimSpace(c.Email); s != "" {
+		e.Add("mail", s)
+	}
+	if s := strings.TrimSpace(c.Phone); s != "" {
+		e.Add("telephoneNumber", s)
+	}
+	if a := postalAddress(c.Address); a != "" {
+		e.Add("postalAddress", a)
+	}
+	return e
+}
+
+// postalAddress returns the address as a PostalAddress (RFC 4517), its
+// lines separated by "$", in which "$" and "\" are escaped.
+func postalAddress(address string) string {
+	var lines []string
+	for _, s := range strings.Split(address, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			s = strings.Replace(s, `\`, `\5C`, -1)
+			lines = append(lines, strings.Replace(s, "$", `\24`, -1))
+		}
+	}
+	return strings.Join(lines, "$")
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// normalizeDN returns dn to compare DNs with: lower case, without the
+// spaces around the separators. It is good enough for the DNs of this
+// directory, which have no escaped characters.
+func normalizeDN(dn string) string {
+	rdns := strings.Split(dn, ",")
+	for i, rdn := range rdns {
+		if j := strings.Index(rdn, "="); j >= 0 {
+			rdn = strings.TrimSpace(rdn[:j]) + "=" + strings.TrimSpace(rdn[j+1:])
+		}
+		rdns[i] = strings.ToLower(strings.TrimSpace(rdn))
+	}
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,176 @@
+// 2017.09.20 rjj: The filters of LDAP searches, matched against the entries.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsldap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The choices of Filter (RFC 4511, 4.5.1), as context tags.
+const (
+	filterAnd         = 0
+	filterOr          = 1
+	filterNot         = 2
+	filterEquality    = 3
+	filterSubstrings  = 4
+	filterGreater     = 5
+	filterLess        = 6
+	filterPresent     = 7
+	filterApprox      = 8
+	filterExtensible  = 9
+	substringsInitial = 0
+	substringsAny     = 1
+	substringsFinal   = 2
+)
+
+// filter is a parsed search filter.
+type filter struct {
+	op int
+	// children of and, or and not
+	children []*filter
+	attr     string
+	// value of the comparisons
+	value string
+	// initial, any and final of substrings, normalized
+	initial, final string
+	any            []string
+}
+
+// parseFilter parses the filter of a search request.
+func parseFilter(p *packet) (*filter, error) {
+	if p == nil || p.class != classContext {
+		return nil, fmt.Errorf("bad filter")
+	}
+	f := &filter{op: p.tag}
+	switch p.tag {
+	case filterAnd, filterOr, filterNot:
+		if !p.constructed || p.tag == filterNot && len(p.children) != 1 {
+			return nil, fmt.Errorf("bad filter")
+		}
+		for _, c := range p.children {
+			child, err := parseFilter(c)
+			if err != nil {
+				return nil, err
+			}
+			f.children = append(f.children, child)
+		}
+	case filterEquality, filterGreater, filterLess, filterApprox:
+		if len(p.children) != 2 {
+			return nil, fmt.Errorf("bad filter")
+		}
+		f.attr, f.value = p.child(0).str(), p.child(1).str()
+	case filterSubstrings:
+		if len(p.children) != 2 || len(p.child(1).children) == 0 {
+			return nil, fmt.Errorf("bad substrings filter")
+		}
+		f.attr = p.child(0).str()
+		for _, s := range p.child(1).children {
+			v := normalize(f.attr, s.str())
+			switch s.tag {
+			case substringsInitial:
+				f.initial = v
+			case substringsAny:
+				f.any = append(f.any, v)
+			case substringsFinal:
+				f.final = v
+			}
+		}
+	case filterPresent:
+		if p.constructed {
+			return nil, fmt.Errorf("bad filter")
+		}
+		f.attr = p.str()
+	case filterExtensible:
+		// Not supported, it matches nothing
+	default:
+		return nil, fmt.Errorf("bad filter")
+	}
+	return f, nil
+}
+
+// match tells whether e matches f. Undefined filters, like those of
+// attributes e doesn't have, don't match, even under a not.
+func (f *filter) match(e *Entry) bool {
+	switch f.op {
+	case filterAnd:
+		for _, c := range f.children {
+			if !c.match(e) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if c.match(e) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !f.children[0].match(e)
+	case filterPresent:
+		return strings.EqualFold(f.attr, "objectClass") || len(e.Get(f.attr)) > 0
+	case filterExtensible:
+		return false
+	}
+
+	want := normalize(f.attr, f.value)
+	for _, v := range e.Get(f.attr) {
+		v = normalize(f.attr, v)
+		switch f.op {
+		case filterEquality, filterApprox:
+			if v == want {
+				return true
+			}
+		case filterGreater:
+			if v >= want {
+				return true
+			}
+		case filterLess:
+			if v <= want {
+				return true
+			}
+		case filterSubstrings:
+			if f.matchSubstrings(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSubstrings tells whether the normalized value v matches the
+// substrings of f, in order.
+func (f *filter) matchSubstrings(v string) bool {
+	if !strings.HasPrefix(v, f.initial) {
+		return false
+	}
+	v = v[len(f.initial):]
+	for _, s := range f.any {
+		i := strings.Index(v, s)
+		if i < 0 {
+			return false
+		}
+		v = v[i+len(s):]
+	}
+	return strings.HasSuffix(v, f.final)
+}
+
+// normalize returns the value of the attribute attr as its matching rule
+// compares them: without case, the spaces collapsed, and without spaces or
+// hyphens for telephoneNumber (RFC 4517, caseIgnoreMatch and
+// telephoneNumberMatch).
+func normalize(attr, value string) string {
+	if strings.EqualFold(attr, "telephoneNumber") {
+		return strings.Map(func(r rune) rune {
+			if r == ' ' || r == '-' {
+				return -1
+			}
+			return r
+		}, value)
+	}
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
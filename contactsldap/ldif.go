@@ -0,0 +1,92 @@
+// 2017.09.20 rjj: Writing directory entries as LDIF.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsldap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"unicode/utf8"
+)
+
+// lineLength is where the lines of LDIF are folded.
+const lineLength = 76
+
+// Encoder writes entries as the content records of an LDIF file.
+type Encoder struct {
+	w           *bufio.Writer
+	wroteHeader bool
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes e, after the version line for the first entry.
+func (enc *Encoder) Encode(e *Entry) error {
+	if !enc.wroteHeader {
+		enc.w.WriteString("version: 1\n")
+		enc.wroteHeader = true
+	}
+	enc.w.WriteString("\n")
+	enc.line("dn", e.DN)
+	for _, a := range e.Attributes {
+		for _, v := range a.Values {
+			enc.line(a.Type, v)
+		}
+	}
+	return enc.w.Flush()
+}
+
+// Close writes the version line if no entry was encoded, for an empty
+// but valid file.
+func (enc *Encoder) Close() error {
+	if !enc.wroteHeader {
+		enc.w.WriteString("version: 1\n")
+		enc.wroteHeader = true
+	}
+	return enc.w.Flush()
+}
+
+// line writes the attribute typ and its value, base64 encoded unless it is
+// a SAFE-STRING, folded.
+func (enc *Encoder) line(typ, value string) {
+	l := typ + ": " + value
+	if !safeString(value) {
+		l = typ + ":: " + base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	// Fold between characters, each continuation line starts with a space
+	limit := lineLength
+	for len(l) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(l[i]) {
+			i--
+		}
+		enc.w.WriteString(l[:i])
+		enc.w.WriteString("\n ")
+		l = l[i:]
+		limit = lineLength - 1
+	}
+	enc.w.WriteString(l)
+	enc.w.WriteString("\n")
+}
+
+// safeString tells whether s can be written as is: ASCII without NUL, CR
+// or LF, not starting with a space, a colon or "<", not ending with a space.
+func safeString(s string) bool {
+	if s == "" {
+		return true
+	}
+	if s[0] == ' ' || s[0] == ':' || s[0] == '<' || s[len(s)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == 0 || c == '\n' || c == '\r' || c > 0x7f {
+			return false
+		}
+	}
+	return true
+}
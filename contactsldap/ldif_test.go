@@ -0,0 +1,113 @@
+// 2017.09.20 rjj: Tests of the LDIF of the contacts.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsldap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+func TestLDIF(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	cts := []*contacts.Contact{
+		{ID: 1, FirstName: "Homer", LastName: "Simpson", Email: "homer@example.com", Phone: "555-1234",
+			Address: "742 Evergreen Terrace, Springfield, $5 off with C:\\coupon"},
+		{ID: 2, FirstName: "Zoë", LastName: "Ångström"},
+		{ID: 3, Email: "anon@example.com"},
+		{ID: 4, FirstName: "Alexander", LastName: "Name-That-Goes-On-And-On-Well-Past-The-Seventy-Six-Columns-Of-A-Line"},
+	}
+	for _, c := range cts {
+		if err := enc.Encode(FromContact(c, "ou=contacts,dc=example,dc=com")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `version: 1
+
+dn: uid=1,ou=contacts,dc=example,dc=com
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+uid: 1
+cn: Homer Simpson
+sn: Simpson
+givenName: Homer
+mail: homer@example.com
+telephoneNumber: 555-1234
+postalAddress: 742 Evergreen Terrace$Springfield$\245 off with C:\5Ccoupon
+
+dn: uid=2,ou=contacts,dc=example,dc=com
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+uid: 2
+cn:: Wm/DqyDDhW5nc3Ryw7Zt
+sn:: w4VuZ3N0csO2bQ==
+givenName:: Wm/Dqw==
+
+dn: uid=3,ou=contacts,dc=example,dc=com
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+uid: 3
+cn: anon@example.com
+sn: anon@example.com
+mail: anon@example.com
+
+dn: uid=4,ou=contacts,dc=example,dc=com
+objectClass: top
+objectClass: person
+objectClass: organizationalPerson
+objectClass: inetOrgPerson
+uid: 4
+cn: Alexander Name-That-Goes-On-And-On-Well-Past-The-Seventy-Six-Columns-Of-
+ A-Line
+sn: Name-That-Goes-On-And-On-Well-Past-The-Seventy-Six-Columns-Of-A-Line
+givenName: Alexander
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLDIFEmpty(t *testing.T) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "version: 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSafeString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"", true},
+		{"Homer Simpson", true},
+		{"a: b", true},
+		{" leading space", false},
+		{"trailing space ", false},
+		{":colon", false},
+		{"<url", false},
+		{"two\nlines", false},
+		{"Zoë", false},
+	}
+	for _, tt := range tests {
+		if got := safeString(tt.s); got != tt.want {
+			t.Errorf("safeString(%q): got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+// 2017.09.20 rjj: A read-only LDAPv3 server of the contacts.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsldap
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// The protocol operations (RFC 4511, 4.2 to 4.14), as application tags.
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchEntry      = 4
+	opSearchDone       = 5
+	opModifyRequest    = 6
+	opAddRequest       = 8
+	opDelRequest       = 10
+	opModifyDNRequest  = 12
+	opCompareRequest   = 14
+	opAbandonRequest   = 16
+	opExtendedRequest  = 23
+	opExtendedResponse = 24
+)
+
+// The result codes the server answers with.
+const (
+	resultSuccess                  = 0
+	resultProtocolError            = 2
+	resultSizeLimitExceeded        = 4
+	resultAuthMethodNotSupported   = 7
+	resultNoSuchObject             = 32
+	resultInvalidCredentials       = 49
+	resultInsufficientAccessRights = 50
+	resultUnwillingToPerform       = 53
+)
+
+// The scopes of a search.
+const (
+	scopeBase = 0
+	scopeOne  = 1
+	scopeSub  = 2
+)
+
+const (
+	// DefaultMaxResults is the number of entries a search returns at most,
+	// when the Server doesn't say.
+	DefaultMaxResults = 500
+	// idleTimeout is how long a connection stays open between requests.
+	idleTimeout = 2 * time.Minute
+)
+
+// ErrNoCredentials is returned by Serve for a Server without a BindDN,
+// BindPassword or UserID: a directory anyone can search is not served.
+var ErrNoCredentials = errors.New("contactsldap: BindDN, BindPassword and UserID are required")
+
+// Server answers the LDAP searches of the contacts of DB, as the entries of
+// FromContact under BaseDN. Binds and searches are all it does: changes
+// are refused with unwillingToPerform.
+type Server struct {
+	DB contacts.ContactDatabase
+	// BaseDN is DefaultBaseDN when "".
+	BaseDN string
+	// BindDN and BindPassword are the credentials searches need.
+	BindDN, BindPassword string
+	// UserID is the user whose contacts the clients that bind see, the
+	// contacts of other users aren't in the directory.
+	UserID string
+	// MaxResults is DefaultMaxResults when 0.
+	MaxResults int
+}
+
+// ListenAndServe serves the directory on the TCP address addr.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the directory on the connections of l, until it fails.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	if s.BindDN == "" || s.BindPassword == "" || s.UserID == "" {
+		return ErrNoCredentials
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) baseDN() string {
+	if s.BaseDN == "" {
+		return DefaultBaseDN
+	}
+	return s.BaseDN
+}
+
+// session is a connection of a client.
+type session struct {
+	s *Server
+	w *bufio.Writer
+	// bound tells whether the client may search.
+	bound bool
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	ss := &session{s: s, w: bufio.NewWriter(conn)}
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		p, err := readPacket(r)
+		if err != nil {
+			if err != io.EOF && !isTimeout(err) {
+				log.Printf("ldap: %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		id, op, err := parseMessage(p)
+		if err != nil {
+			log.Printf("ldap: %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if op.is(classApplication, opUnbindRequest) {
+			return
+		}
+		if err := ss.handle(id, op); err != nil {
+			log.Printf("ldap: %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := ss.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// parseMessage returns the message ID and the protocol operation of an
+// LDAPMessage, whose controls are ignored.
+func parseMessage(p *packet) (int64, *packet, error) {
+	if !p.is(classUniversal, tagSequence) || len(p.children) < 2 {
+		return 0, nil, errors.New("bad LDAPMessage")
+	}
+	id, err := p.child(0).int()
+	if err != nil {
+		return 0, nil, errors.New("bad message ID")
+	}
+	op := p.child(1)
+	if op.class != classApplication {
+		return 0, nil, errors.New("bad protocol operation")
+	}
+	return id, op, nil
+}
+
+// handle answers the request op of the message id.
+func (ss *session) handle(id int64, op *packet) error {
+	switch op.tag {
+	case opBindRequest:
+		ss.bind(id, op)
+	case opSearchRequest:
+		return ss.search(id, op)
+	case opAbandonRequest:
+		// Searches are done before the next request is read
+	case opModifyRequest, opAddRequest, opDelRequest, opModifyDNRequest, opCompareRequest:
+		ss.result(id, op.tag+1, resultUnwillingToPerform, "", "the directory is read-only")
+	case opExtendedRequest:
+		// StartTLS among others
+		ss.result(id, opExtendedResponse, resultProtocolError, "", "unsupported extended operation")
+	default:
+		return errors.New("unknown protocol operation " + strconv.Itoa(op.tag))
+	}
+	return nil
+}
+
+// write writes the message id of the protocol operation op.
+func (ss *session) write(id int64, op *packet) {
+	ss.w.Write(newSequence(newInteger(classUniversal, tagInteger, id), op).bytes())
+}
+
+// result writes the LDAPResult of the protocol operation tag.
+func (ss *session) result(id int64, tag, code int, matchedDN, message string) {
+	ss.write(id, newConstructed(classApplication, tag,
+		newInteger(classUniversal, tagEnumerated, int64(code)),
+		newOctetString(matchedDN),
+		newOctetString(message)))
+}
+
+// bind answers a BindRequest. Anonymous binds succeed, but don't let the
+// client search.
+func (ss *session) bind(id int64, op *packet) {
+	version, err := op.child(0).int()
+	if err != nil || version != 2 && version != 3 {
+		ss.result(id, opBindResponse, resultProtocolError, "", "LDAP version 3 or 2 only")
+		return
+	}
+	name, auth := op.child(1).str(), op.child(2)
+	if !auth.is(classContext, 0) || auth.constructed {
+		ss.result(id, opBindResponse, resultAuthMethodNotSupported, "", "simple binds only")
+		return
+	}
+	password := auth.str()
+	s := ss.s
+	switch {
+	case name == "" && password == "":
+		ss.bound = false
+	case password == "":
+		// An unauthenticated bind, RFC 4513 5.1.2
+		ss.bound = false
+		ss.result(id, opBindResponse, resultUnwillingToPerform, "", "unauthenticated binds are not allowed")
+		return
+	case s.BindPassword != "" && normalizeDN(name) == normalizeDN(s.BindDN) &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.BindPassword)) == 1:
+		ss.bound = true
+	default:
+		ss.bound = false
+		ss.result(id, opBindResponse, resultInvalidCredentials, "", "")
+		return
+	}
+	ss.result(id, opBindResponse, resultSuccess, "", "")
+}
+
+// searchRequest is a parsed SearchRequest.
+type searchRequest struct {
+	baseObject string
+	scope      int
+	sizeLimit  int
+	typesOnly  bool
+	filter     *filter
+	// attributes are the types of the attributes to return, all for nil
+	attributes map[string]bool
+}
+
+func parseSearchRequest(op *packet) (*searchRequest, error) {
+	if len(op.children) < 8 {
+		return nil, errors.New("bad search request")
+	}
+	req := &searchRequest{baseObject: op.child(0).str()}
+	scope, err := op.child(1).int()
+	if err != nil || scope < scopeBase || scope > scopeSub {
+		return nil, errors.New("bad search scope")
+	}
+	req.scope = int(scope)
+	sizeLimit, err := op.child(3).int()
+	if err != nil || sizeLimit < 0 {
+		return nil, errors.New("bad size limit")
+	}
+	req.sizeLimit = int(sizeLimit)
+	if req.typesOnly, err = op.child(5).bool(); err != nil {
+		return nil, errors.New("bad typesOnly")
+	}
+	if req.filter, err = parseFilter(op.child(6)); err != nil {
+		return nil, err
+	}
+	for _, a := range op.child(7).children {
+		switch t := strings.ToLower(a.str()); t {
+		case "*":
+			// All of them, even with others
+			req.attributes = nil
+			return req, nil
+		case "":
+		default:
+			// "1.1" for none is never a type
+			if req.attributes == nil {
+				req.attributes = map[string]bool{}
+			}
+			req.attributes[t] = true
+		}
+	}
+	return req, nil
+}
+
+// search answers a SearchRequest with the entries that match, then the
+// SearchResultDone.
+func (ss *session) search(id int64, op *packet) error {
+	req, err := parseSearchRequest(op)
+	if err != nil {
+		ss.result(id, opSearchDone, resultProtocolError, "", err.Error())
+		return nil
+	}
+	if !ss.bound {
+		ss.result(id, opSearchDone, resultInsufficientAccessRights, "", "bind first")
+		return nil
+	}
+
+	s := ss.s
+	limit := s.MaxResults
+	if limit <= 0 {
+		limit = DefaultMaxResults
+	}
+	if req.sizeLimit > 0 && req.sizeLimit < limit {
+		limit = req.sizeLimit
+	}
+	sent := 0
+	send := func(e *Entry) bool {
+		if !req.filter.match(e) {
+			return true
+		}
+		if sent == limit {
+			return false
+		}
+		ss.write(id, req.entry(e))
+		sent++
+		return true
+	}
+
+	baseDN := s.baseDN()
+	base, target := normalizeDN(baseDN), normalizeDN(req.baseObject)
+	switch {
+	case target == "" && req.scope == scopeBase:
+		send(rootDSE(baseDN))
+
+	case target == base:
+		if req.scope != scopeOne && !send(baseEntry(baseDN)) {
+			err = errSizeLimit
+			break
+		}
+		if req.scope == scopeBase {
+			break
+		}
+		err = s.DB.ForEachContact(contacts.ContactCriteria{CreatedByID: s.UserID}, func(c *contacts.Contact) error {
+			if !send(FromContact(c, baseDN)) {
+				return errSizeLimit
+			}
+			return nil
+		})
+
+	case strings.HasSuffix(target, ","+base) && strings.HasPrefix(target, "uid="):
+		cid, perr := strconv.ParseInt(target[len("uid="):len(target)-len(base)-1], 10, 64)
+		var c *contacts.Contact
+		if perr == nil {
+			// Other users' contacts aren't in the directory
+			c, perr = contacts.GetContactOf(s.DB, s.UserID, cid)
+		}
+		if perr != nil || c == nil {
+			ss.result(id, opSearchDone, resultNoSuchObject, baseDN, "")
+			return nil
+		}
+		if req.scope != scopeOne {
+			send(FromContact(c, baseDN))
+		}
+
+	case strings.HasSuffix(target, ","+base):
+		ss.result(id, opSearchDone, resultNoSuchObject, baseDN, "")
+		return nil
+
+	default:
+		ss.result(id, opSearchDone, resultNoSuchObject, "", "")
+		return nil
+	}
+
+	switch {
+	case err == errSizeLimit:
+		ss.result(id, opSearchDone, resultSizeLimitExceeded, "", "")
+	case err != nil:
+		return err
+	default:
+		ss.result(id, opSearchDone, resultSuccess, "", "")
+	}
+	return nil
+}
+
+var errSizeLimit = errors.New("size limit exceeded")
+
+// entry returns e as a SearchResultEntry, with the attributes asked for.
+func (req *searchRequest) entry(e *Entry) *packet {
+	attrs := newSequence()
+	for _, a := range e.Attributes {
+		if req.attributes != nil && !req.attributes[strings.ToLower(a.Type)] {
+			continue
+		}
+		values := newConstructed(classUniversal, tagSet)
+		if !req.typesOnly {
+			for _, v := range a.Values {
+				values.children = append(values.children, newOctetString(v))
+			}
+		}
+		attrs.children = append(attrs.children, newSequence(newOctetString(a.Type), values))
+	}
+	return newConstructed(classApplication, opSearchEntry, newOctetString(e.DN), attrs)
+}
+
+// rootDSE returns the entry of the server, which clients read to find the
+// base DN.
+func rootDSE(baseDN string) *Entry {
+	e := &Entry{}
+	e.Add("objectClass", "top")
+	e.Add("namingContexts", baseDN)
+	e.Add("supportedLDAPVersion", "3", "2")
+	e.Add("vendorName", "yum-contacts")
+	return e
+}
+
+// baseEntry returns the entry of baseDN, which the contacts are under.
+func baseEntry(baseDN string) *Entry {
+	e := &Entry{DN: baseDN}
+	rdn := strings.SplitN(baseDN, ",", 2)[0]
+	if i := strings.Index(rdn, "="); i > 0 && strings.EqualFold(strings.TrimSpace(rdn[:i]), "ou") {
+		e.Add("objectClass", "top", "organizationalUnit")
+		e.Add("ou", strings.TrimSpace(rdn[i+1:]))
+	} else {
+		e.Add("objectClass", "top")
+	}
+	return e
+}
@@ -0,0 +1,319 @@
+// 2017.09.20 rjj: Tests of the LDAP server, with a client of the BER of ber.go over a pipe.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contactsldap
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+const (
+	testBaseDN = "ou=contacts,dc=example,dc=com"
+	testBindDN = "cn=printer,dc=example,dc=com"
+)
+
+// testClient sends LDAP requests to a server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	id   int64
+}
+
+// newTestClient returns a client of s, on a database of the Simpsons of
+// user "office", and a contact of another user, and the credentials of
+// testBindDN.
+func newTestClient(t *testing.T, s *Server) *testClient {
+	if s.DB == nil {
+		s.DB = contacts.NewMemoryDB()
+		for _, c := range []*contacts.Contact{
+			{FirstName: "Homer", LastName: "Simpson", Email: "homer@example.com", Phone: "555-1234", Address: "742 Evergreen Terrace, Springfield", CreatedByID: "office"},
+			{FirstName: "Marge", LastName: "Simpson", Email: "marge@example.com", Phone: "555 1235", CreatedByID: "office"},
+			{FirstName: "John", LastName: "Smith", Email: "john@example.org", CreatedByID: "office"},
+			{FirstName: "Ned", LastName: "Flanders", Phone: "555-9999", CreatedByID: "office"},
+			{FirstName: "Montgomery", LastName: "Burns", Phone: "555-0001", CreatedByID: "burns"},
+		} {
+			if _, err := s.DB.AddContact(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if s.BaseDN == "" {
+		s.BaseDN = testBaseDN
+	}
+	if s.BindDN == "" {
+		s.BindDN, s.BindPassword, s.UserID = testBindDN, "secret", "office"
+	}
+	client, server := net.Pipe()
+	go s.serveConn(server)
+	return &testClient{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+// send sends the protocol operation op, and returns its message ID.
+func (c *testClient) send(op *packet) int64 {
+	c.id++
+	msg := newSequence(newInteger(classUniversal, tagInteger, c.id), op)
+	if _, err := c.conn.Write(msg.bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.id
+}
+
+// read returns the protocol operation of the next response.
+func (c *testClient) read() *packet {
+	p, err := readPacket(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	id, op, err := parseMessage(p)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if id != c.id {
+		c.t.Fatalf("got message ID %d, want %d", id, c.id)
+	}
+	return op
+}
+
+// resultCode returns the result code of the LDAPResult op, checking its tag.
+func (c *testClient) resultCode(op *packet, tag int) int {
+	if !op.is(classApplication, tag) {
+		c.t.Fatalf("got operation %d, want %d", op.tag, tag)
+	}
+	code, err := op.child(0).int()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return int(code)
+}
+
+func (c *testClient) bind(dn, password string) int {
+	c.send(newConstructed(classApplication, opBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newOctetString(dn),
+		newString(classContext, 0, password)))
+	return c.resultCode(c.read(), opBindResponse)
+}
+
+// search returns the entries a search finds, and its result code.
+func (c *testClient) search(base string, scope, sizeLimit int, typesOnly bool, f *packet, attrs ...string) ([]*Entry, int) {
+	attributes := newSequence()
+	for _, a := range attrs {
+		attributes.children = append(attributes.children, newOctetString(a))
+	}
+	c.send(newConstructed(classApplication, opSearchRequest,
+		newOctetString(base),
+		newInteger(classUniversal, tagEnumerated, int64(scope)),
+		newInteger(classUniversal, tagEnumerated, 0),
+		newInteger(classUniversal, tagInteger, int64(sizeLimit)),
+		newInteger(classUniversal, tagInteger, 0),
+		newBoolean(typesOnly),
+		f,
+		attributes))
+	var entries []*Entry
+	for {
+		op := c.read()
+		if !op.is(classApplication, opSearchEntry) {
+			return entries, c.resultCode(op, opSearchDone)
+		}
+		e := &Entry{DN: op.child(0).str()}
+		for _, a := range op.child(1).children {
+			var values []string
+			for _, v := range a.child(1).children {
+				values = append(values, v.str())
+			}
+			e.Attributes = append(e.Attributes, Attribute{a.child(0).str(), values})
+		}
+		entries = append(entries, e)
+	}
+}
+
+// dns returns the DNs of entries.
+func dns(entries []*Entry) []string {
+	var dns []string
+	for _, e := range entries {
+		dns = append(dns, e.DN)
+	}
+	return dns
+}
+
+func eq(attr, value string) *packet {
+	return newConstructed(classContext, filterEquality, newOctetString(attr), newOctetString(value))
+}
+
+func approx(attr, value string) *packet {
+	return newConstructed(classContext, filterApprox, newOctetString(attr), newOctetString(value))
+}
+
+func present(attr string) *packet {
+	return newString(classContext, filterPresent, attr)
+}
+
+// substrings returns the filter of attr=initial*any*...*final.
+func substrings(attr, initial string, final string, any ...string) *packet {
+	subs := newSequence()
+	if initial != "" {
+		subs.children = append(subs.children, newString(classContext, substringsInitial, initial))
+	}
+	for _, s := range any {
+		subs.children = append(subs.children, newString(classContext, substringsAny, s))
+	}
+	if final != "" {
+		subs.children = append(subs.children, newString(classContext, substringsFinal, final))
+	}
+	return newConstructed(classContext, filterSubstrings, newOctetString(attr), subs)
+}
+
+func and(fs ...*packet) *packet { return newConstructed(classContext, filterAnd, fs...) }
+func or(fs ...*packet) *packet  { return newConstructed(classContext, filterOr, fs...) }
+func not(f *packet) *packet     { return newConstructed(classContext, filterNot, f) }
+
+func TestLDAPSearch(t *testing.T) {
+	c := newTestClient(t, &Server{})
+	defer c.conn.Close()
+	if code := c.bind(testBindDN, "secret"); code != resultSuccess {
+		t.Fatalf("bind: got result code %d", code)
+	}
+
+	dn := func(id int64) string { return DN(id, testBaseDN) }
+	tests := []struct {
+		name   string
+		base   string
+		scope  int
+		filter *packet
+		want   []string
+		code   int
+	}{
+		// By name, as ForEachContact reads them
+		{"everyone", testBaseDN, scopeOne, eq("objectClass", "inetOrgPerson"), []string{dn(4), dn(1), dn(2), dn(3)}, resultSuccess},
+		{"the base too", testBaseDN, scopeSub, present("objectClass"), []string{testBaseDN, dn(4), dn(1), dn(2), dn(3)}, resultSuccess},
+		{"the base only", testBaseDN, scopeBase, present("objectClass"), []string{testBaseDN}, resultSuccess},
+		{"equality, without case", testBaseDN, scopeSub, eq("SN", "simpson"), []string{dn(1), dn(2)}, resultSuccess},
+		{"approx", testBaseDN, scopeSub, approx("cn", "john  smith"), []string{dn(3)}, resultSuccess},
+		{"substrings", testBaseDN, scopeSub, substrings("cn", "", "", "SIM"), []string{dn(1), dn(2)}, resultSuccess},
+		{"initial and final", testBaseDN, scopeSub, substrings("mail", "j", ".org"), []string{dn(3)}, resultSuccess},
+		{"telephone number", testBaseDN, scopeSub, eq("telephoneNumber", "555 12-35"), []string{dn(2)}, resultSuccess},
+		{"telephone number substrings", testBaseDN, scopeSub, substrings("telephoneNumber", "", "", "5512"), []string{dn(1), dn(2)}, resultSuccess},
+		{"or", testBaseDN, scopeSub, or(eq("givenName", "ned"), eq("mail", "homer@example.com")), []string{dn(4), dn(1)}, resultSuccess},
+		{"and not", testBaseDN, scopeSub, and(present("mail"), not(eq("sn", "Simpson"))), []string{dn(3)}, resultSuccess},
+		{"a contact", dn(2), scopeBase, present("objectClass"), []string{dn(2)}, resultSuccess},
+		{"under a contact", dn(2), scopeOne, present("objectClass"), nil, resultSuccess},
+		{"no such contact", dn(42), scopeBase, present("objectClass"), nil, resultNoSuchObject},
+		{"another user's contact", dn(5), scopeBase, present("objectClass"), nil, resultNoSuchObject},
+		{"not under the base", "ou=people,dc=example,dc=com", scopeSub, present("objectClass"), nil, resultNoSuchObject},
+		{"root DSE", "", scopeBase, present("objectClass"), []string{""}, resultSuccess},
+	}
+	for _, tt := range tests {
+		entries, code := c.search(tt.base, tt.scope, 0, false, tt.filter)
+		if code != tt.code {
+			t.Errorf("%s: got result code %d, want %d", tt.name, code, tt.code)
+		}
+		if got := dns(entries); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// The attributes asked for
+	entries, _ := c.search(dn(1), scopeBase, 0, false, present("objectClass"), "cn", "TelephoneNumber", "postalAddress")
+	want := []Attribute{
+		{"cn", []string{"Homer Simpson"}},
+		{"telephoneNumber", []string{"555-1234"}},
+		{"postalAddress", []string{"742 Evergreen Terrace$Springfield"}},
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Attributes, want) {
+		t.Errorf("attributes: got %v, want %v", entries, want)
+	}
+	entries, _ = c.search(dn(1), scopeBase, 0, true, present("objectClass"), "mail")
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Attributes, []Attribute{{"mail", nil}}) {
+		t.Errorf("types only: got %v", entries)
+	}
+	entries, _ = c.search(dn(1), scopeBase, 0, false, present("objectClass"), "1.1")
+	if len(entries) != 1 || len(entries[0].Attributes) != 0 {
+		t.Errorf("no attributes: got %v", entries)
+	}
+	entries, _ = c.search("", scopeBase, 0, false, present("objectClass"), "namingContexts")
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Get("namingContexts"), []string{testBaseDN}) {
+		t.Errorf("root DSE: got %v", entries)
+	}
+
+	// The size limit of the request, then of the server
+	entries, code := c.search(testBaseDN, scopeOne, 2, false, present("objectClass"))
+	if code != resultSizeLimitExceeded || len(entries) != 2 {
+		t.Errorf("size limit: got %d entries, result code %d", len(entries), code)
+	}
+	c.conn.Close()
+	c = newTestClient(t, &Server{MaxResults: 3})
+	defer c.conn.Close()
+	c.bind(testBindDN, "secret")
+	if entries, code := c.search(testBaseDN, scopeOne, 0, false, present("objectClass")); code != resultSizeLimitExceeded || len(entries) != 3 {
+		t.Errorf("max results: got %d entries, result code %d", len(entries), code)
+	}
+}
+
+func TestLDAPBind(t *testing.T) {
+	s := &Server{}
+	c := newTestClient(t, s)
+	defer c.conn.Close()
+
+	if _, code := c.search(testBaseDN, scopeSub, 0, false, present("objectClass")); code != resultInsufficientAccessRights {
+		t.Errorf("search before bind: got result code %d", code)
+	}
+	if code := c.bind("", ""); code != resultSuccess {
+		t.Errorf("anonymous bind: got result code %d", code)
+	}
+	if _, code := c.search(testBaseDN, scopeSub, 0, false, present("objectClass")); code != resultInsufficientAccessRights {
+		t.Errorf("anonymous search: got result code %d", code)
+	}
+	if code := c.bind("cn=printer,dc=example,dc=com", "wrong"); code != resultInvalidCredentials {
+		t.Errorf("wrong password: got result code %d", code)
+	}
+	if code := c.bind("cn=printer,dc=example,dc=com", ""); code != resultUnwillingToPerform {
+		t.Errorf("unauthenticated bind: got result code %d", code)
+	}
+	if code := c.bind("CN=Printer, DC=example, DC=com", "secret"); code != resultSuccess {
+		t.Errorf("bind: got result code %d", code)
+	}
+	if entries, code := c.search(testBaseDN, scopeOne, 0, false, present("objectClass")); code != resultSuccess || len(entries) != 4 {
+		t.Errorf("search: got %d entries, result code %d", len(entries), code)
+	}
+
+	// SASL
+	c.send(newConstructed(classApplication, opBindRequest,
+		newInteger(classUniversal, tagInteger, 3),
+		newOctetString(""),
+		newConstructed(classContext, 3, newOctetString("EXTERNAL"))))
+	if code := c.resultCode(c.read(), opBindResponse); code != resultAuthMethodNotSupported {
+		t.Errorf("SASL bind: got result code %d", code)
+	}
+
+	// The directory is read-only
+	c.send(newString(classApplication, opDelRequest, DN(1, testBaseDN)))
+	if code := c.resultCode(c.read(), opDelRequest+1); code != resultUnwillingToPerform {
+		t.Errorf("delete: got result code %d", code)
+	}
+	if _, err := s.DB.GetContact(1); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+}
+
+func TestLDAPServeNoCredentials(t *testing.T) {
+	for _, s := range []*Server{
+		{},
+		{BindDN: testBindDN, UserID: "office"},
+		{BindDN: testBindDN, BindPassword: "secret"},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Serve(l); err != ErrNoCredentials {
+			t.Errorf("%+v: got %v, want %v", s, err, ErrNoCredentials)
+		}
+	}
+}
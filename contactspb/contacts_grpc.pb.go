@@ -0,0 +1,423 @@
+// 2017.09.13 rjj: gRPC interface to the contacts, served by package contactsgrpc.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: contactspb/contacts.proto
+
+package contactspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ContactService_GetContact_FullMethodName     = "/yumcontacts.v1.ContactService/GetContact"
+	ContactService_ListContacts_FullMethodName   = "/yumcontacts.v1.ContactService/ListContacts"
+	ContactService_CreateContact_FullMethodName  = "/yumcontacts.v1.ContactService/CreateContact"
+	ContactService_UpdateContact_FullMethodName  = "/yumcontacts.v1.ContactService/UpdateContact"
+	ContactService_DeleteContact_FullMethodName  = "/yumcontacts.v1.ContactService/DeleteContact"
+	ContactService_SearchContacts_FullMethodName = "/yumcontacts.v1.ContactService/SearchContacts"
+	ContactService_CountContacts_FullMethodName  = "/yumcontacts.v1.ContactService/CountContacts"
+	ContactService_WatchContacts_FullMethodName  = "/yumcontacts.v1.ContactService/WatchContacts"
+)
+
+// ContactServiceClient is the client API for ContactService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ContactServiceClient interface {
+	// GetContact returns a contact by its ID.
+	GetContact(ctx context.Context, in *GetContactRequest, opts ...grpc.CallOption) (*Contact, error)
+	// ListContacts returns the contacts, ordered by name, a page at a time.
+	ListContacts(ctx context.Context, in *ListContactsRequest, opts ...grpc.CallOption) (*ListContactsResponse, error)
+	// CreateContact adds a contact, checked like the web forms: it needs a
+	// first or last name, and a valid email and phone number when they are set.
+	CreateContact(ctx context.Context, in *CreateContactRequest, opts ...grpc.CallOption) (*Contact, error)
+	// UpdateContact changes the fields of update_mask, or all of them.
+	UpdateContact(ctx context.Context, in *UpdateContactRequest, opts ...grpc.CallOption) (*Contact, error)
+	// DeleteContact removes a contact.
+	DeleteContact(ctx context.Context, in *DeleteContactRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SearchContacts returns the contacts matching all the criteria of the
+	// filter, ordered by name, a page at a time.
+	SearchContacts(ctx context.Context, in *SearchContactsRequest, opts ...grpc.CallOption) (*SearchContactsResponse, error)
+	// CountContacts returns how many contacts match all the criteria of the filter.
+	CountContacts(ctx context.Context, in *CountContactsRequest, opts ...grpc.CallOption) (*CountContactsResponse, error)
+	// WatchContacts streams the changes to the contacts from now on. The
+	// stream ends with ABORTED when the client falls behind, list again then.
+	WatchContacts(ctx context.Context, in *WatchContactsRequest, opts ...grpc.CallOption) (ContactService_WatchContactsClient, error)
+}
+
+type contactServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewContactServiceClient(cc grpc.ClientConnInterface) ContactServiceClient {
+	return &contactServiceClient{cc}
+}
+
+func (c *contactServiceClient) GetContact(ctx context.Context, in *GetContactRequest, opts ...grpc.CallOption) (*Contact, error) {
+	out := new(Contact)
+	err := c.cc.Invoke(ctx, ContactService_GetContact_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) ListContacts(ctx context.Context, in *ListContactsRequest, opts ...grpc.CallOption) (*ListContactsResponse, error) {
+	out := new(ListContactsResponse)
+	err := c.cc.Invoke(ctx, ContactService_ListContacts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) CreateContact(ctx context.Context, in *CreateContactRequest, opts ...grpc.CallOption) (*Contact, error) {
+	out := new(Contact)
+	err := c.cc.Invoke(ctx, ContactService_CreateContact_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) UpdateContact(ctx context.Context, in *UpdateContactRequest, opts ...grpc.CallOption) (*Contact, error) {
+	out := new(Contact)
+	err := c.cc.Invoke(ctx, ContactService_UpdateContact_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) DeleteContact(ctx context.Context, in *DeleteContactRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, ContactService_DeleteContact_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) SearchContacts(ctx context.Context, in *SearchContactsRequest, opts ...grpc.CallOption) (*SearchContactsResponse, error) {
+	out := new(SearchContactsResponse)
+	err := c.cc.Invoke(ctx, ContactService_SearchContacts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) CountContacts(ctx context.Context, in *CountContactsRequest, opts ...grpc.CallOption) (*CountContactsResponse, error) {
+	out := new(CountContactsResponse)
+	err := c.cc.Invoke(ctx, ContactService_CountContacts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *contactServiceClient) WatchContacts(ctx context.Context, in *WatchContactsRequest, opts ...grpc.CallOption) (ContactService_WatchContactsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ContactService_ServiceDesc.Streams[0], ContactService_WatchContacts_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &contactServiceWatchContactsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ContactService_WatchContactsClient interface {
+	Recv() (*ContactEvent, error)
+	grpc.ClientStream
+}
+
+type contactServiceWatchContactsClient struct {
+	grpc.ClientStream
+}
+
+func (x *contactServiceWatchContactsClient) Recv() (*ContactEvent, error) {
+	m := new(ContactEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ContactServiceServer is the server API for ContactService service.
+// All implementations must embed UnimplementedContactServiceServer
+// for forward compatibility
+type ContactServiceServer interface {
+	// GetContact returns a contact by its ID.
+	GetContact(context.Context, *GetContactRequest) (*Contact, error)
+	// ListContacts returns the contacts, ordered by name, a page at a time.
+	ListContacts(context.Context, *ListContactsRequest) (*ListContactsResponse, error)
+	// CreateContact adds a contact, checked like the web forms: it needs a
+	// first or last name, and a valid email and phone number when they are set.
+	CreateContact(context.Context, *CreateContactRequest) (*Contact, error)
+	// UpdateContact changes the fields of update_mask, or all of them.
+	UpdateContact(context.Context, *UpdateContactRequest) (*Contact, error)
+	// DeleteContact removes a contact.
+	DeleteContact(context.Context, *DeleteContactRequest) (*emptypb.Empty, error)
+	// SearchContacts returns the contacts matching all the criteria of the
+	// filter, ordered by name, a page at a time.
+	SearchContacts(context.Context, *SearchContactsRequest) (*SearchContactsResponse, error)
+	// CountContacts returns how many contacts match all the criteria of the filter.
+	CountContacts(context.Context, *CountContactsRequest) (*CountContactsResponse, error)
+	// WatchContacts streams the changes to the contacts from now on. The
+	// stream ends with ABORTED when the client falls behind, list again then.
+	WatchContacts(*WatchContactsRequest, ContactService_WatchContactsServer) error
+	mustEmbedUnimplementedContactServiceServer()
+}
+
+// UnimplementedContactServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedContactServiceServer struct {
+}
+
+func (UnimplementedContactServiceServer) GetContact(context.Context, *GetContactRequest) (*Contact, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetContact not implemented")
+}
+func (UnimplementedContactServiceServer) ListContacts(context.Context, *ListContactsRequest) (*ListContactsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListContacts not implemented")
+}
+func (UnimplementedContactServiceServer) CreateContact(context.Context, *CreateContactRequest) (*Contact, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateContact not implemented")
+}
+func (UnimplementedContactServiceServer) UpdateContact(context.Context, *UpdateContactRequest) (*Contact, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateContact not implemented")
+}
+func (UnimplementedContactServiceServer) DeleteContact(context.Context, *DeleteContactRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteContact not implemented")
+}
+func (UnimplementedContactServiceServer) SearchContacts(context.Context, *SearchContactsRequest) (*SearchContactsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchContacts not implemented")
+}
+func (UnimplementedContactServiceServer) CountContacts(context.Context, *CountContactsRequest) (*CountContactsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountContacts not implemented")
+}
+func (UnimplementedContactServiceServer) WatchContacts(*WatchContactsRequest, ContactService_WatchContactsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchContacts not implemented")
+}
+func (UnimplementedContactServiceServer) mustEmbedUnimplementedContactServiceServer() {}
+
+// UnsafeContactServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ContactServiceServer will
+// result in compilation errors.
+type UnsafeContactServiceServer interface {
+	mustEmbedUnimplementedContactServiceServer()
+}
+
+func RegisterContactServiceServer(s grpc.ServiceRegistrar, srv ContactServiceServer) {
+	s.RegisterService(&ContactService_ServiceDesc, srv)
+}
+
+func _ContactService_GetContact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetContactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).GetContact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_GetContact_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).GetContact(ctx, req.(*GetContactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_ListContacts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListContactsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).ListContacts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_ListContacts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).ListContacts(ctx, req.(*ListContactsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_CreateContact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateContactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).CreateContact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_CreateContact_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).CreateContact(ctx, req.(*CreateContactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_UpdateContact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateContactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).UpdateContact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_UpdateContact_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).UpdateContact(ctx, req.(*UpdateContactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_DeleteContact_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteContactRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).DeleteContact(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_DeleteContact_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).DeleteContact(ctx, req.(*DeleteContactRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_SearchContacts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchContactsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).SearchContacts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_SearchContacts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).SearchContacts(ctx, req.(*SearchContactsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_CountContacts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountContactsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ContactServiceServer).CountContacts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ContactService_CountContacts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ContactServiceServer).CountContacts(ctx, req.(*CountContactsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ContactService_WatchContacts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchContactsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ContactServiceServer).WatchContacts(m, &contactServiceWatchContactsServer{stream})
+}
+
+type ContactService_WatchContactsServer interface {
+	Send(*ContactEvent) error
+	grpc.ServerStream
+}
+
+type contactServiceWatchContactsServer struct {
+	grpc.ServerStream
+}
+
+func (x *contactServiceWatchContactsServer) Send(m *ContactEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ContactService_ServiceDesc is the grpc.ServiceDesc for ContactService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ContactService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "yumcontacts.v1.ContactService",
+	HandlerType: (*ContactServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetContact",
+			Handler:    _ContactService_GetContact_Handler,
+		},
+		{
+			MethodName: "ListContacts",
+			Handler:    _ContactService_ListContacts_Handler,
+		},
+		{
+			MethodName: "CreateContact",
+			Handler:    _ContactService_CreateContact_Handler,
+		},
+		{
+			MethodName: "UpdateContact",
+			Handler:    _ContactService_UpdateContact_Handler,
+		},
+		{
+			MethodName: "DeleteContact",
+			Handler:    _ContactService_DeleteContact_Handler,
+		},
+		{
+			MethodName: "SearchContacts",
+			Handler:    _ContactService_SearchContacts_Handler,
+		},
+		{
+			MethodName: "CountContacts",
+			Handler:    _ContactService_CountContacts_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchContacts",
+			Handler:       _ContactService_WatchContacts_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "contactspb/contacts.proto",
+}
@@ -0,0 +1,12 @@
+// 2017.09.13 rjj: Generated protocol buffer and gRPC code for the contacts.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package contactspb holds the messages and the client and server stubs of
+// ContactService, generated from contacts.proto. After changing it, with
+// protoc, protoc-gen-go and protoc-gen-go-grpc on the PATH:
+//
+//	go generate github.com/rjj-work/yum-contacts/contactspb
+package contactspb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative contactspb/contacts.proto
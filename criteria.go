@@ -0,0 +1,154 @@
+// 2017.09.03 rjj: Finding contacts by their attributes
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CreatedDateLayout is the format of Contact.CreatedDate, as MySQL returns a datetime.
+const CreatedDateLayout = "2006-01-02 15:04:05"
+
+// ErrNoUser is returned by the lookups of the contacts of a user, e.g.
+// TallyContactsCreatedBy, for an empty user ID: a caller that lost track of
+// its user doesn't get every user's contacts.
+var ErrNoUser = errors.New("contacts: no user ID")
+
+// ContactCriteria selects contacts by their attributes, used by FindContacts.
+// A contact must match every criterion that is set, empty ones match any contact,
+// except for the owner: CreatedByID or AllUsers must be set.
+// Text compares case insensitively, like the MySQL utf8_general_ci collation.
+type ContactCriteria struct {
+	// CreatedByID limits the search to the contacts of one user.
+	CreatedByID string
+	// AllUsers searches the contacts of every user, when CreatedByID is "".
+	AllUsers bool
+
+	// City, or any other part of the address.
+	City string
+	// EmailDomain is the part after the @, e.g. "example.com".
+	EmailDomain string
+	// PhonePrefix and PhoneSuffix are the first or last digits of the phone
+	// number, punctuation and spaces are ignored on both sides.
+	PhonePrefix string
+	PhoneSuffix string
+	// Name is part of the first or last name.
+	Name string
+	// Tag is one of the contact's tags.
+	Tag string
+
+	// CreatedSince and CreatedBefore bound the CreatedDate (in UTC, like the
+	// database), zero times don't.
+	CreatedSince  time.Time
+	CreatedBefore time.Time
+
+	// MissingEmail and MissingPhone select contacts without one.
+	MissingEmail bool
+	MissingPhone bool
+}
+
+// Empty reports whether c selects nothing but the owner, i.e. every contact.
+func (c ContactCriteria) Empty() bool {
+	return c.City == "" && c.EmailDomain == "" && c.PhonePrefix == "" &&
+		c.PhoneSuffix == "" && c.Name == "" && c.Tag == "" &&
+		c.CreatedSince.IsZero() && c.CreatedBefore.IsZero() && !c.MissingEmail && !c.MissingPhone
+}
+
+// checkUser returns ErrNoUser when c selects neither a user nor all of them.
+func (c ContactCriteria) checkUser() error {
+	if c.CreatedByID == "" && !c.AllUsers {
+		return ErrNoUser
+	}
+	return nil
+}
+
+// Match reports whether ct meets all the criteria.
+func (c ContactCriteria) Match(ct *Contact) bool {
+	if c.CreatedByID != "" && ct.CreatedByID != c.CreatedByID {
+		return false
+	}
+	if c.City != "" && !containsFold(ct.Address, c.City) {
+		return false
+	}
+	if c.EmailDomain != "" {
+		at := strings.LastIndex(ct.Email, "@")
+		if at < 0 || !strings.EqualFold(ct.Email[at+1:], strings.TrimPrefix(c.EmailDomain, "@")) {
+			return false
+		}
+	}
+	phone := Digits(ct.Phone)
+	if c.PhonePrefix != "" && (Digits(c.PhonePrefix) == "" || !strings.HasPrefix(phone, Digits(c.PhonePrefix))) {
+		return false
+	}
+	if c.PhoneSuffix != "" && (Digits(c.PhoneSuffix) == "" || !strings.HasSuffix(phone, Digits(c.PhoneSuffix))) {
+		return false
+	}
+	if c.Name != "" && !containsFold(ct.FirstName, c.Name) && !containsFold(ct.LastName, c.Name) &&
+		!containsFold(ct.FirstName+" "+ct.LastName, c.Name) {
+		return false
+	}
+	if c.Tag != "" && !hasTag(ct.Tags, c.Tag) {
+		return false
+	}
+	if c.MissingEmail && strings.TrimSpace(ct.Email) != "" {
+		return false
+	}
+	if c.MissingPhone && strings.TrimSpace(ct.Phone) != "" {
+		return false
+	}
+	if !c.CreatedSince.IsZero() || !c.CreatedBefore.IsZero() {
+		created, err := time.ParseInLocation(CreatedDateLayout, ct.CreatedDate, time.UTC)
+		if err != nil {
+			return false
+		}
+		if !c.CreatedSince.IsZero() && created.Before(c.CreatedSince) {
+			return false
+		}
+		if !c.CreatedBefore.IsZero() && !created.Before(c.CreatedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeTags returns tags as stored: comma separated, without surrounding
+// spaces, empty tags or duplicates, e.g. " family, Work,,family" gives "family,Work".
+func NormalizeTags(tags string) string {
+	var out []string
+	for _, t := range strings.Split(tags, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" && !hasTag(strings.Join(out, ","), t) {
+			out = append(out, t)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// Digits returns the digits of a phone number, e.g. "555-636-7890" gives "5556367890".
+func Digits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// hasTag reports whether the normalized tags contain tag.
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.Split(tags, ",") {
+		if t != "" && strings.EqualFold(t, strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
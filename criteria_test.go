@@ -0,0 +1,49 @@
+// 2017.09.21 rjj: Tests of the lookups of the contacts of a user.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import "testing"
+
+func TestNoUser(t *testing.T) {
+	db := NewMemoryDB()
+	for _, c := range []*Contact{
+		{FirstName: "Bart", LastName: "Simpson", CreatedByID: "homer"},
+		{FirstName: "Bart", LastName: "Simpson", CreatedByID: "ned"},
+	} {
+		if _, err := db.AddContact(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := db.TallyContactsCreatedBy(""); err != ErrNoUser {
+		t.Errorf("TallyContactsCreatedBy: got %d, %v, want %v", n, err, ErrNoUser)
+	}
+	if cs, err := db.FindContactByNameCreatedBy("", "Bart", "Simpson"); err != ErrNoUser {
+		t.Errorf("FindContactByNameCreatedBy: got %d contacts, %v, want %v", len(cs), err, ErrNoUser)
+	}
+	if cs, err := db.FindContacts(ContactCriteria{Name: "Bart"}); err != ErrNoUser {
+		t.Errorf("FindContacts: got %d contacts, %v, want %v", len(cs), err, ErrNoUser)
+	}
+	if n, err := db.TallyContactsMatching(ContactCriteria{Name: "Bart"}); err != ErrNoUser {
+		t.Errorf("TallyContactsMatching: got %d, %v, want %v", n, err, ErrNoUser)
+	}
+	if err := db.ForEachContact(ContactCriteria{}, func(*Contact) error { return nil }); err != ErrNoUser {
+		t.Errorf("ForEachContact: got %v, want %v", err, ErrNoUser)
+	}
+
+	// Every user's contacts have to be asked for
+	if n, err := db.TallyContacts(); err != nil || n != 2 {
+		t.Errorf("TallyContacts: got %d, %v, want 2", n, err)
+	}
+	if cs, err := db.FindContactByName("Bart", "Simpson"); err != nil || len(cs) != 2 {
+		t.Errorf("FindContactByName: got %d contacts, %v, want 2", len(cs), err)
+	}
+	if cs, err := db.FindContacts(ContactCriteria{Name: "Bart", AllUsers: true}); err != nil || len(cs) != 2 {
+		t.Errorf("FindContacts for all users: got %d contacts, %v, want 2", len(cs), err)
+	}
+	if n, err := db.TallyContactsMatching(ContactCriteria{Name: "Bart", CreatedByID: "homer"}); err != nil || n != 1 {
+		t.Errorf("TallyContactsMatching for homer: got %d, %v, want 1", n, err)
+	}
+}
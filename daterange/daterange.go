@@ -0,0 +1,143 @@
+// 2017.09.05 rjj: Date ranges from API.AI date parameters.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package daterange parses the values of the API.AI sys.date and
+// sys.date-period entities ("2017-08-21", "2017-08-01/2017-08-31") into time
+// ranges, and tells how a range relates to today, so a reply can say "last
+// month" rather than repeat the dates the user never said.
+package daterange
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateLayout is the API.AI date format.
+const DateLayout = "2006-01-02"
+
+// Range is the half open interval [Start, End). A zero End means open ended.
+type Range struct {
+	Start, End time.Time
+}
+
+// Contains reports whether t is in r.
+func (r Range) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && (r.End.IsZero() || t.Before(r.End))
+}
+
+// ParseDate returns the day s (sys.date) in loc.
+func ParseDate(s string, loc *time.Location) (Range, error) {
+	d, err := time.ParseInLocation(DateLayout, strings.TrimSpace(s), loc)
+	if err != nil {
+		return Range{}, fmt.Errorf("daterange: bad date %q", s)
+	}
+	return Range{Start: d, End: d.AddDate(0, 0, 1)}, nil
+}
+
+// ParsePeriod returns the days of s (sys.date-period, both ends included) in
+// loc. A single date is accepted too.
+func ParsePeriod(s string, loc *time.Location) (Range, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) == 1 {
+		return ParseDate(s, loc)
+	}
+	if len(parts) != 2 {
+		return Range{}, fmt.Errorf("daterange: bad date period %q", s)
+	}
+	from, err := ParseDate(parts[0], loc)
+	if err != nil {
+		return Range{}, err
+	}
+	to, err := ParseDate(parts[1], loc)
+	if err != nil {
+		return Range{}, err
+	}
+	if to.End.Before(from.End) {
+		return Range{}, fmt.Errorf("daterange: period %q ends before it starts", s)
+	}
+	return Range{Start: from.Start, End: to.End}, nil
+}
+
+// Since returns the open ended range from the start of the day of t.
+func Since(t time.Time) Range {
+	return Range{Start: day(t)}
+}
+
+// Kind is how a Range relates to the current date.
+type Kind int
+
+const (
+	// Days is any other range, e.g. "between August 3 and August 9".
+	Days Kind = iota
+	Day       // a single day, e.g. "on August 21"
+	Open      // open ended, e.g. "since August 21"
+	Today
+	Yesterday
+	ThisWeek // Monday to Sunday
+	LastWeek
+	ThisMonth
+	LastMonth
+	Month // a whole calendar month, e.g. "in July 2017"
+	ThisYear
+	LastYear
+	Year // a whole calendar year, e.g. "in 2015"
+)
+
+// Kind tells how r relates to now. Ranges running up to the end of today
+// count as the whole current week, month or year, since that's how a user
+// asking for "this month" on the 5th thinks of it.
+func (r Range) Kind(now time.Time) Kind {
+	if r.End.IsZero() {
+		return Open
+	}
+	today := day(now.In(r.Start.Location()))
+
+	switch {
+	case r.Start.Equal(today) && r.End.Equal(today.AddDate(0, 0, 1)):
+		return Today
+	case r.Start.Equal(today.AddDate(0, 0, -1)) && r.End.Equal(today):
+		return Yesterday
+	case r.End.Equal(r.Start.AddDate(0, 0, 1)):
+		return Day
+	}
+
+	week := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	year := time.Date(today.Year(), 1, 1, 0, 0, 0, 0, today.Location())
+	switch {
+	case r.Start.Equal(week) && (r.End.Equal(week.AddDate(0, 0, 7)) || r.End.Equal(today.AddDate(0, 0, 1))):
+		return ThisWeek
+	case r.Start.Equal(week.AddDate(0, 0, -7)) && r.End.Equal(week):
+		return LastWeek
+	case r.Start.Equal(month) && (r.End.Equal(month.AddDate(0, 1, 0)) || r.End.Equal(today.AddDate(0, 0, 1))):
+		return ThisMonth
+	case r.Start.Equal(month.AddDate(0, -1, 0)) && r.End.Equal(month):
+		return LastMonth
+	case r.Start.Equal(year) && (r.End.Equal(year.AddDate(1, 0, 0)) || r.End.Equal(today.AddDate(0, 0, 1))):
+		return ThisYear
+	case r.Start.Equal(year.AddDate(-1, 0, 0)) && r.End.Equal(year):
+		return LastYear
+	case r.Start.Day() == 1 && r.End.Equal(r.Start.AddDate(0, 1, 0)):
+		return Month
+	case r.Start.YearDay() == 1 && r.End.Equal(r.Start.AddDate(1, 0, 0)):
+		return Year
+	}
+	return Days
+}
+
+// Last returns the last day of r, e.g. August 31 for August, since End is
+// the day after. It is the zero time for open ended ranges.
+func (r Range) Last() time.Time {
+	if r.End.IsZero() {
+		return time.Time{}
+	}
+	return r.End.AddDate(0, 0, -1)
+}
+
+// day returns the start of the day of t.
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
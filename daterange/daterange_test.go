@@ -0,0 +1,76 @@
+// 2017.09.05 rjj: Tests for date ranges.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package daterange
+
+import (
+	"testing"
+	"time"
+)
+
+// now is a Friday.
+var now = time.Date(2017, time.September, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParsePeriod(t *testing.T) {
+	r, err := ParsePeriod("2017-08-01/2017-08-31", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Start, time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got start %v, want %v", got, want)
+	}
+	if got, want := r.End, time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got end %v, want %v", got, want)
+	}
+	if got, want := r.Last(), time.Date(2017, 8, 31, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got last %v, want %v", got, want)
+	}
+	if !r.Contains(time.Date(2017, 8, 31, 23, 59, 0, 0, time.UTC)) {
+		t.Error("August does not contain August 31")
+	}
+	if r.Contains(now) {
+		t.Error("August contains September 1")
+	}
+
+	for _, bad := range []string{"", "yesterday", "2017-08-31/2017-08-01", "2017-08-01/2017-08-02/2017-08-03"} {
+		if _, err := ParsePeriod(bad, time.UTC); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		period string
+		want   Kind
+	}{
+		{"2017-09-01", Today},
+		{"2017-08-31", Yesterday},
+		{"2017-08-21", Day},
+		{"2017-08-28/2017-09-03", ThisWeek},
+		{"2017-08-28/2017-09-01", ThisWeek},
+		{"2017-08-21/2017-08-27", LastWeek},
+		{"2017-09-01/2017-09-30", ThisMonth},
+		{"2017-08-01/2017-08-31", LastMonth},
+		{"2017-07-01/2017-07-31", Month},
+		{"2017-01-01/2017-12-31", ThisYear},
+		{"2017-01-01/2017-09-01", ThisYear},
+		{"2016-01-01/2016-12-31", LastYear},
+		{"2015-01-01/2015-12-31", Year},
+		{"2017-08-03/2017-08-09", Days},
+	}
+	for _, tt := range tests {
+		r, err := ParsePeriod(tt.period, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Kind(now); got != tt.want {
+			t.Errorf("%s: got kind %d, want %d", tt.period, got, tt.want)
+		}
+	}
+
+	if got := Since(now).Kind(now); got != Open {
+		t.Errorf("since: got kind %d, want %d", got, Open)
+	}
+}
@@ -0,0 +1,262 @@
+// Adapted from Bookshelf
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+// 2017.09.02 rjj: Brought back for the webhook simulator (app/simulate.go), and
+//	CONTACTS_DB=memory to run the app without Cloud SQL.
+
+package contacts
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ensure memoryDB conforms to the ContactDatabase interface.
+var _ ContactDatabase = &memoryDB{}
+
+// memoryDB is a simple in-memory persistence layer for contacts.
+type memoryDB struct {
+	mu       sync.Mutex
+	nextID   int64              // next ID to assign to a contact.
+	contacts map[int64]*Contact // maps from Contact ID to Contact.
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{
+		contacts: make(map[int64]*Contact),
+		nextID:   1,
+	}
+}
+
+// NewMemoryDB returns an empty ContactDatabase kept in memory, IDs start at 1.
+func NewMemoryDB() ContactDatabase {
+	return newMemoryDB()
+}
+
+// Close closes the database.
+func (db *memoryDB) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.contacts = nil
+}
+
+// GetContact retrieves a contact by its ID.
+func (db *memoryDB) GetContact(id int64) (*Contact, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	contact, ok := db.contacts[id]
+	if !ok {
+		return nil, &NotFoundError{id}
+	}
+	// A copy, like the other databases return, so callers can't change the
+	// stored contact without UpdateContact.
+	c := *contact
+	return &c, nil
+}
+
+// AddContact saves a given contact, assigning it a new ID.
+func (db *memoryDB) AddContact(b *Contact) (id int64, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c := *b
+	c.ID = db.nextID
+	if c.CreatedDate == "" {
+		// Like the MySQL datetime column, UTC on Cloud SQL
+		c.CreatedDate = time.Now().UTC().Format(CreatedDateLayout)
+	}
+	db.contacts[c.ID] = &c
+
+	db.nextID++
+
+	return c.ID, nil
+}
+
+// AddContacts saves the given contacts, assigning them new IDs in order.
+func (db *memoryDB) AddContacts(cs []*Contact) ([]int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// The same date for the batch, like one MySQL transaction
+	now := time.Now().UTC().Format(CreatedDateLayout)
+	ids := make([]int64, len(cs))
+	for i, b := range cs {
+		c := *b
+		c.ID = db.nextID
+		if c.CreatedDate == "" {
+			c.CreatedDate = now
+		}
+		db.contacts[c.ID] = &c
+		db.nextID++
+		ids[i] = c.ID
+	}
+	return ids, nil
+}
+
+// DeleteContact removes a given contact by its ID.
+func (db *memoryDB) DeleteContact(id int64) error {
+	if id == 0 {
+		return errors.New("memorydb: contact with unassigned ID passed into deleteContact")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.contacts[id]; !ok {
+		return fmt.Errorf("memorydb: could not delete contact with ID %d, does not exist", id)
+	}
+	delete(db.contacts, id)
+	return nil
+}
+
+// UpdateContact updates the entry for a given contact.
+func (db *memoryDB) UpdateContact(b *Contact) error {
+	if b.ID == 0 {
+		return errors.New("memorydb: contact with unassigned ID passed into updateContact")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, ok := db.contacts[b.ID]
+	if !ok {
+		return fmt.Errorf("memorydb: could not update contact with ID %d, does not exist", b.ID)
+	}
+	c := *b
+	c.CreatedDate = old.CreatedDate
+	db.contacts[b.ID] = &c
+	return nil
+}
+
+// contactsByName sorts contacts by last name, then first name.
+type contactsByName []*Contact
+
+func (s contactsByName) Less(i, j int) bool {
+	if s[i].LastName != s[j].LastName {
+		return s[i].LastName < s[j].LastName
+	}
+	if s[i].FirstName != s[j].FirstName {
+		return s[i].FirstName < s[j].FirstName
+	}
+	return s[i].ID < s[j].ID
+}
+func (s contactsByName) Len() int      { return len(s) }
+func (s contactsByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// contactsByID sorts contacts oldest first.
+type contactsByID []*Contact
+
+func (s contactsByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s contactsByID) Len() int           { return len(s) }
+func (s contactsByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// filter returns copies of the contacts for which keep returns true.
+func (db *memoryDB) filter(keep func(*Contact) bool) []*Contact {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var contacts []*Contact
+	for _, c := range db.contacts {
+		if keep(c) {
+			c := *c
+			contacts = append(contacts, &c)
+		}
+	}
+	return contacts
+}
+
+// createdBy keeps the contacts of userID, or all of them for an empty userID.
+func createdBy(userID string) func(*Contact) bool {
+	return func(c *Contact) bool {
+		return userID == "" || c.CreatedByID == userID
+	}
+}
+
+// ListContacts returns a list of contacts, ordered by name.
+func (db *memoryDB) ListContacts() ([]*Contact, error) {
+	return db.ListContactsCreatedBy("")
+}
+
+// ListContactsCreatedBy returns a list of contacts, ordered by name, filtered by
+// the user who created the contact entry.
+func (db *memoryDB) ListContactsCreatedBy(userID string) ([]*Contact, error) {
+	contacts := db.filter(createdBy(userID))
+	sort.Sort(contactsByName(contacts))
+	return contacts, nil
+}
+
+// TallyContacts returns the number of contacts, of all users.
+func (db *memoryDB) TallyContacts() (int64, error) {
+	return int64(len(db.filter(createdBy("")))), nil
+}
+
+// TallyContactsCreatedBy returns the number of contacts created by the given user.
+func (db *memoryDB) TallyContactsCreatedBy(userID string) (int64, error) {
+	if userID == "" {
+		return -1, ErrNoUser
+	}
+	return int64(len(db.filter(createdBy(userID)))), nil
+}
+
+// TallyContactsMatching returns the number of contacts matching all the criteria.
+func (db *memoryDB) TallyContactsMatching(criteria ContactCriteria) (int64, error) {
+	if err := criteria.checkUser(); err != nil {
+		return -1, err
+	}
+	return int64(len(db.filter(criteria.Match))), nil
+}
+
+// FindContactByName returns the contacts with the given first and last name, oldest first.
+func (db *memoryDB) FindContactByName(fn, ln string) ([]*Contact, error) {
+	return db.findByName(createdBy(""), fn, ln), nil
+}
+
+// FindContactByNameCreatedBy returns the contacts with the given first and last name, oldest first,
+// filtered by the user who created the contact entry.
+func (db *memoryDB) FindContactByNameCreatedBy(userID, fn, ln string) ([]*Contact, error) {
+	if userID == "" {
+		return nil, ErrNoUser
+	}
+	return db.findByName(createdBy(userID), fn, ln), nil
+}
+
+// findByName returns the contacts mine keeps with the given first and last name, oldest first.
+// Names compare case insensitively, like the MySQL utf8_general_ci collation.
+func (db *memoryDB) findByName(mine func(*Contact) bool, fn, ln string) []*Contact {
+	contacts := db.filter(func(c *Contact) bool {
+		return mine(c) && strings.EqualFold(c.FirstName, fn) && strings.EqualFold(c.LastName, ln)
+	})
+	sort.Sort(contactsByID(contacts))
+	return contacts
+}
+
+// FindContacts returns the contacts matching all the criteria, ordered by name.
+func (db *memoryDB) FindContacts(criteria ContactCriteria) ([]*Contact, error) {
+	if err := criteria.checkUser(); err != nil {
+		return nil, err
+	}
+	contacts := db.filter(criteria.Match)
+	sort.Sort(contactsByName(contacts))
+	return contacts, nil
+}
+
+// ForEachContact calls fn with the contacts matching all the criteria,
+// ordered by name. They are in memory anyway, fn gets copies.
+func (db *memoryDB) ForEachContact(criteria ContactCriteria, fn func(*Contact) error) error {
+	contacts, err := db.FindContacts(criteria)
+	if err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
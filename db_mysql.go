@@ -9,6 +9,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/go-sql-driver/mysql"
 )
@@ -26,10 +27,18 @@ var createTableStatements = []string{
 		createdBy VARCHAR(255) NULL,
 		createdById VARCHAR(255) NULL,
 		createdDate datetime DEFAULT CURRENT_TIMESTAMP,
+		tags VARCHAR(255) NULL,
 		PRIMARY KEY (id)
 	)`,
 }
 
+// 2017.09.03 rjj: Columns added after the table was first created, added to existing
+// tables by ensureTableExists. ADD COLUMN appends, so SELECT * keeps the order of
+// createTableStatements, which scanContact relies on.
+var addedColumns = []struct{ name, definition string }{
+	{"tags", "VARCHAR(255) NULL"},
+}
+
 // mysqlDB persists contacts to a MySQL instance.
 type mysqlDB struct {
 	conn *sql.DB
@@ -43,6 +52,9 @@ type mysqlDB struct {
 	// Added as part of the API.AI Fulfillement
 	tally       *sql.Stmt
 	findByName  *sql.Stmt
+	// Per user versions, for webhook account linking
+	tallyBy      *sql.Stmt
+	findByNameBy *sql.Stmt
 }
 
 // Ensure mysqlDB conforms to the ContactDatabase interface.
@@ -133,6 +145,12 @@ func newMySQLDB(config MySQLConfig) (ContactDatabase, error) {
 	if db.findByName, err = conn.Prepare(findByNameStatement); err != nil {
 	return nil, fmt.Errorf("mysql: prepare findByName: %v", err)
 	}
+	if db.tallyBy, err = conn.Prepare(tallyByStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare tallyBy: %v", err)
+	}
+	if db.findByNameBy, err = conn.Prepare(findByNameByStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare findByNameBy: %v", err)
+	}
 
 	return db, nil
 }
@@ -159,10 +177,11 @@ func scanContact(s rowScanner) (*Contact, error) {
 		createdBy   sql.NullString
 		createdByID sql.NullString
 		createdDate sql.NullString
+		tags        sql.NullString
 	)
 	if err := s.Scan(&id, &firstName, &lastName, &address, &email, &phone,
 		// &imageURL,
-		&createdBy, &createdByID, &createdDate); err != nil {
+		&createdBy, &createdByID, &createdDate, &tags); err != nil {
 		return nil, err
 	}
 
@@ -173,6 +192,7 @@ func scanContact(s rowScanner) (*Contact, error) {
 		Address:     address.String,
 		Email:       email.String,
 		Phone:       phone.String,
+		Tags:        tags.String,
 		CreatedBy:   createdBy.String,
 		CreatedByID: createdByID.String,
 		CreatedDate: createdDate.String,
@@ -239,7 +259,7 @@ const getStatement = "SELECT * FROM contacts WHERE id = ?"
 func (db *mysqlDB) GetContact(id int64) (*Contact, error) {
 	contact, err := scanContact(db.get.QueryRow(id))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("mysql: could not find contact with id %d", id)
+		return nil, &NotFoundError{id}
 	}
 	if err != nil {
 		return nil, fmt.Errorf("mysql: could not get contact: %v", err)
@@ -249,13 +269,13 @@ func (db *mysqlDB) GetContact(id int64) (*Contact, error) {
 
 const insertStatement = `
   INSERT INTO contacts (
-    firstName, lastName, address, email, phone, createdBy, createdById
-  ) VALUES (?, ?, ?, ?, ?, ?, ?)`
+    firstName, lastName, address, email, phone, createdBy, createdById, tags
+  ) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
 // AddContact saves a given contact, assigning it a new ID.
 func (db *mysqlDB) AddContact(b *Contact) (id int64, err error) {
 	r, err := execAffectingOneRow(db.insert, b.FirstName, b.LastName, b.Address, b.Email, b.Phone,
-		b.CreatedBy, b.CreatedByID)
+		b.CreatedBy, b.CreatedByID, b.Tags)
 	if err != nil {
 		return 0, err
 	}
@@ -267,6 +287,32 @@ func (db *mysqlDB) AddContact(b *Contact) (id int64, err error) {
 	return lastInsertID, nil
 }
 
+// AddContacts saves the given contacts in one transaction, all of them or
+// none, assigning them new IDs in order.
+func (db *mysqlDB) AddContacts(cs []*Contact) ([]int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not begin transaction: %v", err)
+	}
+	insert := tx.Stmt(db.insert)
+	ids := make([]int64, len(cs))
+	for i, b := range cs {
+		r, err := execAffectingOneRow(insert, b.FirstName, b.LastName, b.Address, b.Email, b.Phone,
+			b.CreatedBy, b.CreatedByID, b.Tags)
+		if err == nil {
+			ids[i], err = r.LastInsertId()
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("mysql: could not add contact %d of %d: %v", i+1, len(cs), err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("mysql: could not commit contacts: %v", err)
+	}
+	return ids, nil
+}
+
 const deleteStatement = `DELETE FROM contacts WHERE id = ?`
 
 // DeleteContact removes a given contact by its ID.
@@ -281,7 +327,7 @@ func (db *mysqlDB) DeleteContact(id int64) error {
 const updateStatement = `
   UPDATE contacts
   SET firstName=?, lastName=?, address=?, email=?, phone=?,
-      createdBy=?, createdById=?
+      createdBy=?, createdById=?, tags=?
   WHERE id = ?`
 
 // UpdateContact updates the entry for a given contact.
@@ -291,7 +337,7 @@ func (db *mysqlDB) UpdateContact(b *Contact) error {
 	}
 
 	_, err := execAffectingOneRow(db.update, b.FirstName, b.LastName, b.Address, b.Email, b.Phone,
-		b.CreatedBy, b.CreatedByID, b.ID)
+		b.CreatedBy, b.CreatedByID, b.Tags, b.ID)
 	return err
 }
 
@@ -324,6 +370,25 @@ func (config MySQLConfig) ensureTableExists() error {
 		// Unknown error.
 		return fmt.Errorf("mysql: could not connect to the database: %v", err)
 	}
+	return addColumns(conn, "contacts", addedColumns)
+}
+
+// addColumns adds the columns missing from an existing table.
+func addColumns(conn *sql.DB, table string, columns []struct{ name, definition string }) error {
+	for _, c := range columns {
+		var n int
+		err := conn.QueryRow(`SELECT count(1) FROM information_schema.columns
+			WHERE table_schema = 'yum_contacts' AND table_name = ? AND column_name = ?`, table, c.name).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("mysql: could not check column %s: %v", c.name, err)
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := conn.Exec("ALTER TABLE yum_contacts." + table + " ADD COLUMN " + c.name + " " + c.definition); err != nil {
+			return fmt.Errorf("mysql: could not add column %s: %v", c.name, err)
+		}
+	}
 	return nil
 }
 
@@ -357,14 +422,30 @@ func execAffectingOneRow(stmt *sql.Stmt, args ...interface{}) (sql.Result, error
 // 2017.08.21 rjj: Counting capability
 const tallyStatement = `SELECT count(1) FROM contacts`
 
-// TallyContacts returns the number of contacts, if we had owners of contacts, it should be in that context.
+// TallyContacts returns the number of contacts, of all users, see TallyContactsCreatedBy.
 // Note if tally can not be determined, -1 is returned as tally value.
 func (db *mysqlDB) TallyContacts() (int64, error) {
+	return tallyRows(db.tally)
+}
+
+const tallyByStatement = `SELECT count(1) FROM contacts WHERE createdById = ?`
+
+// TallyContactsCreatedBy returns the number of contacts created by the given user.
+// Note if tally can not be determined, -1 is returned as tally value.
+func (db *mysqlDB) TallyContactsCreatedBy(userID string) (int64, error) {
+	if userID == "" {
+		return -1, ErrNoUser
+	}
+	return tallyRows(db.tallyBy, userID)
+}
+
+// tallyRows runs a count(1) query
+func tallyRows(stmt *sql.Stmt, args ...interface{}) (int64, error) {
 
 	tallyError := int64( -1 )	// Default value
 	tally := tallyError
 
-	rows, err := db.tally.Query()
+	rows, err := stmt.Query(args...)
 	if err != nil {
 		return tallyError, err
 	}
@@ -385,21 +466,36 @@ func (db *mysqlDB) TallyContacts() (int64, error) {
 }
 
 
-// HACK: Forcing at most 1 contact to be found with this name.
 const findByNameStatement = `
   SELECT * FROM contacts
-  WHERE firstname = ? and lastname = ? LIMIT 1`
+  WHERE firstname = ? and lastname = ? ORDER BY id`
 
-// ListContacts returns a list of contacts, ordered by name.
+// FindContactByName returns the contacts with the given first and last name, oldest first.
 // There are several design choices to be made here:
 //	- What if there is more than 1 contact with this name ?
-//	- Should this procedure only return a single Contact, in which ase we do not need to return a slice
-//	- Should we allow returning multiple contacts, but only take the first one, i.e. really return at most 1 ?
-//		This is enforces a design decision at a very low level.
-//		I don't think this is a good long term solution, but for this first cut I'm going to use it.
-//	- At some point in the evolution of this it will be necessary to face this issue directly.
+//		All of them are returned, it is up to the caller to pick one or let the user choose,
+//		e.g. the webhook shows a list on devices with a screen.
+//	- At some point in the evolution of this it may be necessary to find by other attributes.
 func (db *mysqlDB) FindContactByName( fn, ln string ) ([]*Contact, error) {
-	rows, err := db.findByName.Query(fn, ln)
+	return findRows(db.findByName, fn, ln)
+}
+
+const findByNameByStatement = `
+  SELECT * FROM contacts
+  WHERE createdById = ? and firstname = ? and lastname = ? ORDER BY id`
+
+// FindContactByNameCreatedBy returns the contacts with the given first and last name, oldest first,
+// filtered by the user who created the contact entry.
+func (db *mysqlDB) FindContactByNameCreatedBy(userID, fn, ln string) ([]*Contact, error) {
+	if userID == "" {
+		return nil, ErrNoUser
+	}
+	return findRows(db.findByNameBy, userID, fn, ln)
+}
+
+// findRows runs a query returning contacts
+func findRows(stmt *sql.Stmt, args ...interface{}) ([]*Contact, error) {
+	rows, err := stmt.Query(args...)
 	if err != nil {
 		return nil, err
 	}
@@ -409,12 +505,129 @@ func (db *mysqlDB) FindContactByName( fn, ln string ) ([]*Contact, error) {
 	for rows.Next() {
 		contact, err := scanContact(rows)
 		if err != nil {
-			return nil, fmt.Errorf("mysql::FindContactByName could not read row: %v", err)
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
 		}
 
-		// With the LIMIT 1, we should only have 1 row
 		contacts = append(contacts, contact)
 	}
 
 	return contacts, nil
 }
+
+// FindContacts returns the contacts matching all the criteria, ordered by name.
+func (db *mysqlDB) FindContacts(criteria ContactCriteria) ([]*Contact, error) {
+	var contacts []*Contact
+	err := db.ForEachContact(criteria, func(c *Contact) error {
+		contacts = append(contacts, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// ForEachContact calls fn with the contacts matching all the criteria,
+// ordered by name, as the rows are read. The connection is held until fn
+// has seen the last one.
+// The query depends on which criteria are set, so it is built here rather than prepared.
+// Phone numbers are stored as entered, so the phone criteria are checked on the rows.
+func (db *mysqlDB) ForEachContact(criteria ContactCriteria, fn func(*Contact) error) error {
+	if err := criteria.checkUser(); err != nil {
+		return err
+	}
+	where, args := criteriaWhere(criteria)
+	rows, err := db.conn.Query("SELECT * FROM contacts"+where+" ORDER BY lastName, firstName, id", args...)
+	if err != nil {
+		return fmt.Errorf("mysql: could not find contacts: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		if !criteria.Match(contact) {
+			continue
+		}
+		if err := fn(contact); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// TallyContactsMatching returns the number of contacts matching all the criteria.
+// The phone criteria can't be counted in SQL, see FindContacts, so those are counted on the rows.
+// Note if tally can not be determined, -1 is returned as tally value.
+func (db *mysqlDB) TallyContactsMatching(criteria ContactCriteria) (int64, error) {
+	if err := criteria.checkUser(); err != nil {
+		return -1, err
+	}
+	if criteria.PhonePrefix != "" || criteria.PhoneSuffix != "" {
+		contacts, err := db.FindContacts(criteria)
+		if err != nil {
+			return -1, err
+		}
+		return int64(len(contacts)), nil
+	}
+
+	where, args := criteriaWhere(criteria)
+	tally := int64(-1)
+	if err := db.conn.QueryRow("SELECT count(1) FROM contacts"+where, args...).Scan(&tally); err != nil {
+		return -1, fmt.Errorf("mysql: could not tally contacts: %v", err)
+	}
+	return tally, nil
+}
+
+// criteriaWhere returns the WHERE clause, empty without criteria, and its
+// arguments for all the criteria but the phone ones. The callers check the
+// owner first, see ContactCriteria.checkUser: no createdById is all users.
+func criteriaWhere(criteria ContactCriteria) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, values ...interface{}) {
+		where = append(where, cond)
+		args = append(args, values...)
+	}
+	if criteria.CreatedByID != "" {
+		add("createdById = ?", criteria.CreatedByID)
+	}
+	if criteria.City != "" {
+		add("address LIKE ?", "%"+likeEscape(criteria.City)+"%")
+	}
+	if criteria.EmailDomain != "" {
+		add("email LIKE ?", "%@"+likeEscape(strings.TrimPrefix(criteria.EmailDomain, "@")))
+	}
+	if criteria.Name != "" {
+		name := "%" + likeEscape(criteria.Name) + "%"
+		add("(firstName LIKE ? OR lastName LIKE ? OR CONCAT(firstName, ' ', lastName) LIKE ?)", name, name, name)
+	}
+	if criteria.Tag != "" {
+		add("CONCAT(',', tags, ',') LIKE ?", "%,"+likeEscape(strings.TrimSpace(criteria.Tag))+",%")
+	}
+	if criteria.MissingEmail {
+		add("(email IS NULL OR TRIM(email) = '')")
+	}
+	if criteria.MissingPhone {
+		add("(phone IS NULL OR TRIM(phone) = '')")
+	}
+	// createdDate is a UTC datetime, compared as such
+	if !criteria.CreatedSince.IsZero() {
+		add("createdDate >= ?", criteria.CreatedSince.UTC().Format(CreatedDateLayout))
+	}
+	if !criteria.CreatedBefore.IsZero() {
+		add("createdDate < ?", criteria.CreatedBefore.UTC().Format(CreatedDateLayout))
+	}
+
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// likeEscape escapes the LIKE wildcards in s, so user input matches literally.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
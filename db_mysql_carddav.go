@@ -0,0 +1,220 @@
+// 2017.09.19 rjj: MySQL storage for the names of the CardDAV cards and the links of the CardDAV sync
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Kept next to the contacts table, created on first use like oauth_tokens.
+// The hrefs are the key, MySQL indexes at most 767 bytes.
+const createCardDAVLinksStatement = `CREATE TABLE IF NOT EXISTS carddav_links (
+		href VARCHAR(255) NOT NULL,
+		contactId BIGINT NOT NULL,
+		uid VARCHAR(255) NOT NULL,
+		etag VARCHAR(255) NOT NULL,
+		hash CHAR(40) NOT NULL,
+		PRIMARY KEY (href)
+	)`
+
+// mysqlCardDAVLinkStore persists the links of the CardDAV sync to a MySQL instance.
+type mysqlCardDAVLinkStore struct {
+	conn *sql.DB
+
+	list   *sql.Stmt
+	put    *sql.Stmt
+	delete *sql.Stmt
+}
+
+// Ensure mysqlCardDAVLinkStore conforms to the CardDAVLinkStore interface.
+var _ CardDAVLinkStore = &mysqlCardDAVLinkStore{}
+
+// newMySQLCardDAVLinkStore creates a new CardDAVLinkStore backed by a given MySQL server.
+func newMySQLCardDAVLinkStore(config MySQLConfig) (CardDAVLinkStore, error) {
+	if err := config.ensureTableExists(); err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("mysql", config.dataStoreName("yum_contacts"))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql: could not establish a good connection: %v", err)
+	}
+	if _, err := conn.Exec(createCardDAVLinksStatement); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql: could not create carddav_links: %v", err)
+	}
+
+	s := &mysqlCardDAVLinkStore{
+		conn: conn,
+	}
+	if s.list, err = conn.Prepare(listCardDAVLinksStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare listCardDAVLinks: %v", err)
+	}
+	if s.put, err = conn.Prepare(putCardDAVLinkStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare putCardDAVLink: %v", err)
+	}
+	if s.delete, err = conn.Prepare(deleteCardDAVLinkStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare deleteCardDAVLink: %v", err)
+	}
+	return s, nil
+}
+
+const listCardDAVLinksStatement = `
+  SELECT href, contactId, uid, etag, hash FROM carddav_links ORDER BY href`
+
+// Links returns all the links, by Href.
+func (s *mysqlCardDAVLinkStore) Links() ([]*CardDAVLink, error) {
+	rows, err := s.list.Query()
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not list CardDAV links: %v", err)
+	}
+	defer rows.Close()
+
+	var links []*CardDAVLink
+	for rows.Next() {
+		l := &CardDAVLink{}
+		if err := rows.Scan(&l.Href, &l.ContactID, &l.UID, &l.ETag, &l.Hash); err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("mysql: could not list CardDAV links: %v", err)
+	}
+	return links, nil
+}
+
+const putCardDAVLinkStatement = `
+  REPLACE INTO carddav_links (href, contactId, uid, etag, hash) VALUES (?, ?, ?, ?, ?)`
+
+// PutLink saves l, replacing the link of its Href.
+func (s *mysqlCardDAVLinkStore) PutLink(l *CardDAVLink) error {
+	if _, err := s.put.Exec(l.Href, l.ContactID, l.UID, l.ETag, l.Hash); err != nil {
+		return fmt.Errorf("mysql: could not save CardDAV link: %v", err)
+	}
+	return nil
+}
+
+const deleteCardDAVLinkStatement = `DELETE FROM carddav_links WHERE href = ?`
+
+// DeleteLink removes the link of href.
+func (s *mysqlCardDAVLinkStore) DeleteLink(href string) error {
+	if _, err := s.delete.Exec(href); err != nil {
+		return fmt.Errorf("mysql: could not delete CardDAV link: %v", err)
+	}
+	return nil
+}
+
+// The names are those of a user, the pair is the key.
+const createCardDAVNamesStatement = `CREATE TABLE IF NOT EXISTS carddav_names (
+		userId VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		contactId BIGINT NOT NULL,
+		PRIMARY KEY (userId, name)
+	)`
+
+// mysqlCardDAVNameStore persists the names of the CardDAV cards to a MySQL instance.
+type mysqlCardDAVNameStore struct {
+	conn *sql.DB
+
+	list    *sql.Stmt
+	contact *sql.Stmt
+	put     *sql.Stmt
+}
+
+// Ensure mysqlCardDAVNameStore conforms to the CardDAVNameStore interface.
+var _ CardDAVNameStore = &mysqlCardDAVNameStore{}
+
+// newMySQLCardDAVNameStore creates a new CardDAVNameStore backed by a given MySQL server.
+func newMySQLCardDAVNameStore(config MySQLConfig) (CardDAVNameStore, error) {
+	if err := config.ensureTableExists(); err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("mysql", config.dataStoreName("yum_contacts"))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql: could not establish a good connection: %v", err)
+	}
+	if _, err := conn.Exec(createCardDAVNamesStatement); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql: could not create carddav_names: %v", err)
+	}
+
+	s := &mysqlCardDAVNameStore{
+		conn: conn,
+	}
+	if s.list, err = conn.Prepare(listCardDAVNamesStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare listCardDAVNames: %v", err)
+	}
+	if s.contact, err = conn.Prepare(getCardDAVNameStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare getCardDAVName: %v", err)
+	}
+	if s.put, err = conn.Prepare(putCardDAVNameStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare putCardDAVName: %v", err)
+	}
+	return s, nil
+}
+
+const listCardDAVNamesStatement = `
+  SELECT name, contactId FROM carddav_names WHERE userId = ?`
+
+// CardNames returns the names of the cards of userID, by contact ID.
+func (s *mysqlCardDAVNameStore) CardNames(userID string) (map[int64]string, error) {
+	rows, err := s.list.Query(userID)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not list CardDAV names: %v", err)
+	}
+	defer rows.Close()
+
+	names := map[int64]string{}
+	for rows.Next() {
+		var name string
+		var id int64
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		names[id] = name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("mysql: could not list CardDAV names: %v", err)
+	}
+	return names, nil
+}
+
+const getCardDAVNameStatement = `
+  SELECT contactId FROM carddav_names WHERE userId = ? AND name = ?`
+
+// CardContact returns the contact ID of the card name of userID, 0 for none.
+func (s *mysqlCardDAVNameStore) CardContact(userID, name string) (int64, error) {
+	var id int64
+	err := s.contact.QueryRow(userID, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not get CardDAV name: %v", err)
+	}
+	return id, nil
+}
+
+const putCardDAVNameStatement = `
+  REPLACE INTO carddav_names (userId, name, contactId) VALUES (?, ?, ?)`
+
+// PutCardName names the card of the contact id of userID.
+func (s *mysqlCardDAVNameStore) PutCardName(userID, name string, id int64) error {
+	if _, err := s.put.Exec(userID, name, id); err != nil {
+		return fmt.Errorf("mysql: could not save CardDAV name: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// 2017.09.01 rjj: MySQL storage for the OAuth2 authorization server tokens
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/rjj-work/yum-contacts/oauthserver"
+)
+
+// Kept next to the contacts table, created on first use since databases
+// created before account linking don't have it.
+const createOAuthTokensStatement = `CREATE TABLE IF NOT EXISTS oauth_tokens (
+		hash CHAR(64) NOT NULL,
+		kind VARCHAR(16) NOT NULL,
+		clientId VARCHAR(255) NOT NULL,
+		userId VARCHAR(255) NOT NULL,
+		userName VARCHAR(255) NULL,
+		scope VARCHAR(255) NULL,
+		redirectUri TEXT NULL,
+		parent CHAR(64) NULL,
+		expires datetime NULL,
+		tokenId VARCHAR(32) NULL,
+		label VARCHAR(255) NULL,
+		PRIMARY KEY (hash),
+		KEY (parent),
+		KEY (userId, kind)
+	)`
+
+// 2017.09.18 rjj: Columns added with the app passwords, added to existing
+// tables by newMySQLTokenStore.
+var addedTokenColumns = []struct{ name, definition string }{
+	{"tokenId", "VARCHAR(32) NULL"},
+	{"label", "VARCHAR(255) NULL"},
+}
+
+// mysqlTokenStore persists OAuth2 tokens to a MySQL instance.
+type mysqlTokenStore struct {
+	conn *sql.DB
+
+	put    *sql.Stmt
+	get    *sql.Stmt
+	delete *sql.Stmt
+	list   *sql.Stmt
+	purge  *sql.Stmt
+}
+
+// Ensure mysqlTokenStore conforms to the oauthserver.Store interface.
+var _ oauthserver.Store = &mysqlTokenStore{}
+
+// newMySQLTokenStore creates a new oauthserver.Store backed by a given MySQL server.
+func newMySQLTokenStore(config MySQLConfig) (oauthserver.Store, error) {
+	if err := config.ensureTableExists(); err != nil {
+		return nil, err
+	}
+
+	// parseTime so expires scans into a time.Time
+	conn, err := sql.Open("mysql", config.dataStoreName("yum_contacts")+"?parseTime=true")
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql: could not establish a good connection: %v", err)
+	}
+	if _, err := conn.Exec(createOAuthTokensStatement); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("mysql: could not create oauth_tokens: %v", err)
+	}
+	if err := addColumns(conn, "oauth_tokens", addedTokenColumns); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &mysqlTokenStore{
+		conn: conn,
+	}
+	if s.put, err = conn.Prepare(putTokenStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare putToken: %v", err)
+	}
+	if s.get, err = conn.Prepare(getTokenStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare getToken: %v", err)
+	}
+	if s.delete, err = conn.Prepare(deleteTokenStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare deleteToken: %v", err)
+	}
+	if s.list, err = conn.Prepare(listTokensStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare listTokens: %v", err)
+	}
+	if s.purge, err = conn.Prepare(deleteExpiredTokensStatement); err != nil {
+		return nil, fmt.Errorf("mysql: prepare deleteExpiredTokens: %v", err)
+	}
+	return s, nil
+}
+
+const putTokenStatement = `
+  INSERT INTO oauth_tokens (
+    hash, kind, clientId, userId, userName, scope, redirectUri, parent, expires, tokenId, label
+  ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// Put saves t.
+func (s *mysqlTokenStore) Put(t *oauthserver.Token) error {
+	var expires interface{}
+	if !t.Expires.IsZero() {
+		expires = t.Expires.UTC()
+	}
+	_, err := execAffectingOneRow(s.put, t.Hash, t.Kind, t.ClientID, t.UserID, t.UserName,
+		t.Scope, t.RedirectURI, t.Parent, expires, t.ID, t.Label)
+	return err
+}
+
+const getTokenStatement = `
+  SELECT hash, kind, clientId, userId, userName, scope, redirectUri, parent, expires, tokenId, label
+  FROM oauth_tokens WHERE hash = ?`
+
+// Get returns the token with the given hash, or oauthserver.ErrNotFound.
+func (s *mysqlTokenStore) Get(hash string) (*oauthserver.Token, error) {
+	t, err := scanToken(s.get.QueryRow(hash))
+	if err == sql.ErrNoRows {
+		return nil, oauthserver.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get token: %v", err)
+	}
+	return t, nil
+}
+
+// scanToken reads a token from a row of getTokenStatement or
+// listTokensStatement.
+func scanToken(s rowScanner) (*oauthserver.Token, error) {
+	var (
+		t                                    oauthserver.Token
+		userName, scope, redirectURI, parent sql.NullString
+		id, label                            sql.NullString
+		expires                              mysql.NullTime
+	)
+	err := s.Scan(&t.Hash, &t.Kind, &t.ClientID, &t.UserID,
+		&userName, &scope, &redirectURI, &parent, &expires, &id, &label)
+	if err != nil {
+		return nil, err
+	}
+	t.UserName = userName.String
+	t.Scope = scope.String
+	t.RedirectURI = redirectURI.String
+	t.Parent = parent.String
+	if expires.Valid {
+		t.Expires = expires.Time
+	}
+	t.ID = id.String
+	t.Label = label.String
+	return &t, nil
+}
+
+const listTokensStatement = `
+  SELECT hash, kind, clientId, userId, userName, scope, redirectUri, parent, expires, tokenId, label
+  FROM oauth_tokens WHERE userId = ? AND kind = ?`
+
+// List returns the tokens of kind of userID.
+func (s *mysqlTokenStore) List(userID, kind string) ([]*oauthserver.Token, error) {
+	rows, err := s.list.Query(userID, kind)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not list tokens: %v", err)
+	}
+	defer rows.Close()
+	var tokens []*oauthserver.Token
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("mysql: could not list tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+const deleteTokenStatement = `DELETE FROM oauth_tokens WHERE hash = ? OR parent = ?`
+
+// Delete removes the token with the given hash and the tokens issued with it.
+func (s *mysqlTokenStore) Delete(hash string) error {
+	if _, err := s.delete.Exec(hash, hash); err != nil {
+		return fmt.Errorf("mysql: could not delete token: %v", err)
+	}
+	return nil
+}
+
+const deleteExpiredTokensStatement = `DELETE FROM oauth_tokens WHERE expires IS NOT NULL AND expires < ?`
+
+// DeleteExpired removes the tokens that have expired at now.
+func (s *mysqlTokenStore) DeleteExpired(now time.Time) error {
+	if _, err := s.purge.Exec(now.UTC()); err != nil {
+		return fmt.Errorf("mysql: could not delete expired tokens: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// 2017.08.26 rjj: Intent dispatch shared by the webhook front ends.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package intent provides a registry of conversational intent handlers.
+//
+// Handlers work on a Request/Response pair that is independent of the wire
+// format used by API.AI (or any other assistant platform), so the same
+// handler can be reached from several endpoints. Handlers are registered by
+// intent name, usually from an init function:
+//
+//	func init() {
+//		intent.HandleFunc("number_of_contacts", tallyContacts)
+//	}
+//
+// Middleware registered with Use wraps every handler, which is where logging,
+// authorization and metrics hooks live.
+package intent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rjj-work/yum-contacts/ssml"
+)
+
+// ErrUnhandled is returned by Dispatch when no handler is registered for an
+// intent and the registry has no fallback.
+var ErrUnhandled = errors.New("intent: no handler registered")
+
+// Context is a named conversational context carried between turns, e.g.
+// "current_contact" holding the name of the contact being discussed.
+type Context struct {
+	Name       string
+	Parameters map[string]string
+	Lifespan   int
+}
+
+// Request is a platform-neutral view of a single intent invocation.
+type Request struct {
+	// Intent is the name the handler was registered under.
+	Intent string
+	// Action is the optional action name configured for the intent.
+	Action string
+	// Query is the user's utterance, as resolved by the platform.
+	Query string
+	// Params holds the intent's parameters, keyed by parameter name.
+	Params map[string]string
+	// Contexts are the input contexts active for this turn.
+	Contexts []Context
+	// Lang is the language tag of the conversation, e.g. "en" or "fr-CA".
+	Lang string
+	// SessionID identifies the conversation.
+	SessionID string
+	// UserID identifies the user to the application (the createdById of
+	// their contacts), "" if the user could not be identified.
+	UserID string
+	// UserName is the display name of the user, if known.
+	UserName string
+	// Capabilities lists what the user's device can do, e.g. show a screen.
+	Capabilities []string
+	// Arguments holds platform supplied values that are not intent
+	// parameters, e.g. the key of an option picked from a list.
+	Arguments map[string]string
+	// SlotFilling is set while the platform is still collecting the
+	// intent's required parameters, the response is the prompt for the next
+	// one. Query then holds the answer to the last prompt, whether or not it
+	// could be recognized as a parameter value.
+	SlotFilling bool
+	// Unhandled is set by Dispatch when no handler is registered for the
+	// intent and it goes to the fallback.
+	Unhandled bool
+	// Raw is the decoded wire request, for handlers that need platform
+	// specific data.
+	Raw interface{}
+}
+
+// Param returns the named parameter, or "" if it is not present.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// HasCapability reports whether the user's device has the named capability.
+func (r *Request) HasCapability(name string) bool {
+	for _, c := range r.Capabilities {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Context returns the named input context, or nil if it is not active.
+func (r *Request) Context(name string) *Context {
+	for i := range r.Contexts {
+		if r.Contexts[i].Name == name {
+			return &r.Contexts[i]
+		}
+	}
+	return nil
+}
+
+// Response is filled in by a handler and translated back to the wire format
+// by the caller.
+type Response struct {
+	// Speech is what the assistant says, plain text unless SSML is set.
+	Speech string
+	// SSML is set when Speech is an SSML <speak> document, see package
+	// ssml, rather than plain text.
+	SSML bool
+	// DisplayText is what the assistant shows on screen.
+	DisplayText string
+	// Contexts are the output contexts to set for the next turn.
+	Contexts []Context
+	// Data holds platform specific payloads, keyed by platform name.
+	Data map[string]interface{}
+}
+
+// Say sets both Speech and DisplayText to the formatted message, plain text.
+func (r *Response) Say(format string, v ...interface{}) {
+	r.Speech, r.SSML = fmt.Sprintf(format, v...), false
+	r.DisplayText = r.Speech
+}
+
+// SSMLSpeech returns Speech as an SSML document, plain text escaped.
+func (r *Response) SSMLSpeech() string {
+	if r.SSML {
+		return r.Speech
+	}
+	return ssml.Wrap(r.Speech)
+}
+
+// SetContext adds an output context, replacing any with the same name.
+func (r *Response) SetContext(c Context) {
+	for i := range r.Contexts {
+		if r.Contexts[i].Name == c.Name {
+			r.Contexts[i] = c
+			return
+		}
+	}
+	r.Contexts = append(r.Contexts, c)
+}
+
+// Handler responds to an intent.
+type Handler interface {
+	ServeIntent(req *Request, resp *Response) error
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(req *Request, resp *Response) error
+
+// ServeIntent calls f(req, resp).
+func (f HandlerFunc) ServeIntent(req *Request, resp *Response) error {
+	return f(req, resp)
+}
+
+// Middleware wraps a Handler with additional behaviour.
+type Middleware func(Handler) Handler
+
+// Registry maps intent names to handlers. It is safe for concurrent use.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	fallback   Handler
+	middleware []Middleware
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for the named intent.
+// Like http.ServeMux, it panics if the name is empty or already registered.
+func (reg *Registry) Handle(name string, h Handler) {
+	if name == "" {
+		panic("intent: empty intent name")
+	}
+	if h == nil {
+		panic("intent: nil handler for " + name)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.handlers[name]; ok {
+		panic("intent: multiple registrations for " + name)
+	}
+	reg.handlers[name] = h
+}
+
+// HandleFunc registers the handler function for the named intent.
+func (reg *Registry) HandleFunc(name string, f func(*Request, *Response) error) {
+	reg.Handle(name, HandlerFunc(f))
+}
+
+// HandleFallback registers the handler used for intents with no handler of
+// their own.
+func (reg *Registry) HandleFallback(h Handler) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.fallback = h
+}
+
+// Use appends middleware to the chain applied to every handler. The first
+// middleware registered is the outermost.
+func (reg *Registry) Use(mw ...Middleware) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.middleware = append(reg.middleware, mw...)
+}
+
+// Intents returns the registered intent names in sorted order.
+func (reg *Registry) Intents() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	names := make([]string, 0, len(reg.handlers))
+	for name := range reg.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dispatch runs the handler registered for req.Intent, wrapped in the
+// registry's middleware.
+func (reg *Registry) Dispatch(req *Request, resp *Response) error {
+	reg.mu.RLock()
+	h, ok := reg.handlers[req.Intent]
+	if !ok {
+		h = reg.fallback
+		req.Unhandled = true
+	}
+	mw := reg.middleware
+	reg.mu.RUnlock()
+
+	if h == nil {
+		return ErrUnhandled
+	}
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h.ServeIntent(req, resp)
+}
+
+// DefaultRegistry is the Registry used by the package level functions.
+var DefaultRegistry = NewRegistry()
+
+// Handle registers the handler for the named intent in DefaultRegistry.
+func Handle(name string, h Handler) { DefaultRegistry.Handle(name, h) }
+
+// HandleFunc registers the handler function for the named intent in
+// DefaultRegistry.
+func HandleFunc(name string, f func(*Request, *Response) error) {
+	DefaultRegistry.HandleFunc(name, f)
+}
+
+// HandleFallback registers the fallback handler in DefaultRegistry.
+func HandleFallback(h Handler) { DefaultRegistry.HandleFallback(h) }
+
+// Use appends middleware to DefaultRegistry.
+func Use(mw ...Middleware) { DefaultRegistry.Use(mw...) }
+
+// Dispatch runs the DefaultRegistry handler for req.Intent.
+func Dispatch(req *Request, resp *Response) error { return DefaultRegistry.Dispatch(req, resp) }
@@ -0,0 +1,198 @@
+// 2017.08.26 rjj: Tests for the intent registry.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package intent
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDispatch(t *testing.T) {
+	reg := NewRegistry()
+	reg.HandleFunc("number_of_contacts", func(req *Request, resp *Response) error {
+		resp.Say("Tally %d", 42)
+		return nil
+	})
+
+	var resp Response
+	if err := reg.Dispatch(&Request{Intent: "number_of_contacts"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Speech, "Tally 42"; got != want {
+		t.Errorf("Speech: got %q, want %q", got, want)
+	}
+	if got, want := resp.DisplayText, resp.Speech; got != want {
+		t.Errorf("DisplayText: got %q, want %q", got, want)
+	}
+}
+
+func TestDispatchUnhandled(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Dispatch(&Request{Intent: "nope"}, &Response{}); err != ErrUnhandled {
+		t.Errorf("got err %v, want %v", err, ErrUnhandled)
+	}
+
+	reg.HandleFallback(HandlerFunc(func(req *Request, resp *Response) error {
+		resp.Say("fallback for %s", req.Intent)
+		return nil
+	}))
+
+	var resp Response
+	if err := reg.Dispatch(&Request{Intent: "nope"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Speech, "fallback for nope"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	reg := NewRegistry()
+	noop := func(*Request, *Response) error { return nil }
+	reg.HandleFunc("find_contact", noop)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic on duplicate registration")
+		}
+	}()
+	reg.HandleFunc("find_contact", noop)
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	reg := NewRegistry()
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(req *Request, resp *Response) error {
+				calls = append(calls, name)
+				return next.ServeIntent(req, resp)
+			})
+		}
+	}
+	reg.Use(trace("outer"), trace("inner"))
+	reg.HandleFunc("find_contact", func(*Request, *Response) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	if err := reg.Dispatch(&Request{Intent: "find_contact"}, &Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "inner", "handler"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %v, want %v", calls, want)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	reg := NewRegistry()
+	errDenied := errors.New("denied")
+	reg.Use(Authorize(func(req *Request) error {
+		if req.SessionID == "" {
+			return errDenied
+		}
+		return nil
+	}))
+	ran := false
+	reg.HandleFunc("delete_contact", func(*Request, *Response) error {
+		ran = true
+		return nil
+	})
+
+	if err := reg.Dispatch(&Request{Intent: "delete_contact"}, &Response{}); err != errDenied {
+		t.Errorf("got err %v, want %v", err, errDenied)
+	}
+	if ran {
+		t.Error("handler ran despite failed authorization")
+	}
+}
+
+func TestLoggingAndMetrics(t *testing.T) {
+	reg := NewRegistry()
+	var logged []string
+	m := new(expvar.Map).Init()
+	reg.Use(Logging(func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}), Metrics(m))
+	reg.HandleFunc("add_contact", func(*Request, *Response) error {
+		return errors.New("boom")
+	})
+
+	params := map[string]string{"given-name": "Homer", "email": "homer@example.com"}
+	reg.Dispatch(&Request{Intent: "add_contact", Params: params}, &Response{})
+	reg.Dispatch(&Request{Intent: "add_contact"}, &Response{})
+
+	if got, want := len(logged), 2; got != want {
+		t.Errorf("log lines: got %d, want %d", got, want)
+	}
+	// The parameter names, not what the user said
+	if got := logged[0]; !strings.Contains(got, "[email given-name]") || strings.Contains(got, "Homer") || !strings.Contains(got, "boom") {
+		t.Errorf("got %q, want the parameter names and the error only", got)
+	}
+	if got, want := m.Get("add_contact.calls").String(), "2"; got != want {
+		t.Errorf("calls: got %s, want %s", got, want)
+	}
+	if got, want := m.Get("add_contact.errors").String(), "2"; got != want {
+		t.Errorf("errors: got %s, want %s", got, want)
+	}
+
+	// Whatever users say, one key for the fallback
+	reg.HandleFallback(HandlerFunc(func(*Request, *Response) error { return nil }))
+	reg.Dispatch(&Request{Intent: "sing_a_song"}, &Response{})
+	reg.Dispatch(&Request{Intent: "AMAZON.FallbackIntent"}, &Response{})
+	if got, want := m.Get("unhandled.calls").String(), "2"; got != want {
+		t.Errorf("unhandled calls: got %s, want %s", got, want)
+	}
+	if m.Get("sing_a_song.calls") != nil {
+		t.Errorf("unhandled intent counted under its name")
+	}
+}
+
+func TestIntents(t *testing.T) {
+	reg := NewRegistry()
+	noop := func(*Request, *Response) error { return nil }
+	reg.HandleFunc("number_of_contacts", noop)
+	reg.HandleFunc("find_contact", noop)
+
+	if got, want := strings.Join(reg.Intents(), ","), "find_contact,number_of_contacts"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestContexts(t *testing.T) {
+	req := &Request{Contexts: []Context{
+		{Name: "current_contact", Parameters: map[string]string{"first_name": "Cali"}},
+	}}
+	if c := req.Context("current_contact"); c == nil || c.Parameters["first_name"] != "Cali" {
+		t.Errorf("got %+v, want current_contact context", c)
+	}
+	if c := req.Context("missing"); c != nil {
+		t.Errorf("got %+v, want nil", c)
+	}
+
+	var resp Response
+	resp.SetContext(Context{Name: "current_contact", Lifespan: 5})
+	resp.SetContext(Context{Name: "current_contact", Lifespan: 2})
+	if got, want := len(resp.Contexts), 1; got != want {
+		t.Fatalf("got %d contexts, want %d", got, want)
+	}
+	if got, want := resp.Contexts[0].Lifespan, 2; got != want {
+		t.Errorf("Lifespan: got %d, want %d", got, want)
+	}
+}
+
+func TestHasCapability(t *testing.T) {
+	req := &Request{Capabilities: []string{"actions.capability.AUDIO_OUTPUT", "actions.capability.SCREEN_OUTPUT"}}
+	if !req.HasCapability("actions.capability.SCREEN_OUTPUT") {
+		t.Error("want screen output capability")
+	}
+	if req.HasCapability("actions.capability.WEB_BROWSER") {
+		t.Error("want no web browser capability")
+	}
+}
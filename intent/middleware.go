@@ -0,0 +1,80 @@
+// 2017.08.26 rjj: Standard middleware for intent handlers.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package intent
+
+import (
+	"expvar"
+	"sort"
+	"time"
+)
+
+// Logging returns middleware that reports each intent, the names of its
+// parameters, how long it took and any error through logf (typically
+// log.Printf). The values of the parameters are left out, they are the
+// names, emails and phone numbers of contacts.
+func Logging(logf func(format string, v ...interface{})) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request, resp *Response) error {
+			start := time.Now()
+			err := next.ServeIntent(req, resp)
+			outcome := "ok"
+			if err != nil {
+				outcome = err.Error()
+			}
+			logf("intent %q session %q params %v: %v, %s",
+				req.Intent, req.SessionID, paramNames(req.Params), time.Since(start), outcome)
+			return err
+		})
+	}
+}
+
+// paramNames returns the names of params, sorted.
+func paramNames(params map[string]string) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Authorize returns middleware that calls check before the handler and
+// returns its error, without running the handler, if it is non-nil.
+func Authorize(check func(req *Request) error) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request, resp *Response) error {
+			if err := check(req); err != nil {
+				return err
+			}
+			return next.ServeIntent(req, resp)
+		})
+	}
+}
+
+// unhandledKey is the key Metrics counts the intents with no handler of
+// their own under, whatever their names: they come from the users, the
+// keys would be unbounded.
+const unhandledKey = "unhandled"
+
+// Metrics returns middleware that counts calls and errors per intent in m,
+// under the keys "<intent>.calls" and "<intent>.errors", or
+// "unhandled.calls" and "unhandled.errors" for the fallback.
+// Pass a map created with expvar.NewMap to publish the counts on /debug/vars.
+func Metrics(m *expvar.Map) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request, resp *Response) error {
+			key := req.Intent
+			if req.Unhandled {
+				key = unhandledKey
+			}
+			m.Add(key+".calls", 1)
+			err := next.ServeIntent(req, resp)
+			if err != nil {
+				m.Add(key+".errors", 1)
+			}
+			return err
+		})
+	}
+}
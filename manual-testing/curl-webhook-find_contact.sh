@@ -1,6 +1,8 @@
 #!/bin/bash
 
+# If the webhook requires basic auth, set WEBHOOK_USERNAME and WEBHOOK_PASSWORD
 curl -v \
+  ${WEBHOOK_USERNAME:+-u "$WEBHOOK_USERNAME:$WEBHOOK_PASSWORD"} \
   -H 'Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8' \
   -H 'Content-Type: application/json' \
   -d@curl-webhook-find_contact.json \
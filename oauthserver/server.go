@@ -0,0 +1,546 @@
+// 2017.09.01 rjj: Minimal OAuth2 authorization server.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package oauthserver is a minimal OAuth2 (RFC 6749) authorization server
+// for the authorization code grant, enough for Actions on Google account
+// linking: an authorize step the app puts behind its own login and consent
+// page, a token endpoint issuing access and refresh tokens, token revocation
+// (RFC 7009) and validation of bearer tokens.
+//
+// Tokens are opaque random strings; a Store keeps only their SHA-256 hash.
+package oauthserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default lifetimes, used when the Server fields are zero.
+const (
+	DefaultCodeTTL        = 10 * time.Minute
+	DefaultAccessTokenTTL = time.Hour
+	DefaultAppPasswordTTL = 365 * 24 * time.Hour
+	DefaultPurgeInterval  = time.Hour
+)
+
+// Error is an OAuth2 error response, see RFC 6749 section 5.2.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oauthserver: " + e.Code
+	}
+	return "oauthserver: " + e.Code + ": " + e.Description
+}
+
+// Errors returned by the Server methods.
+var (
+	ErrInvalidRequest       = &Error{Code: "invalid_request"}
+	ErrInvalidClient        = &Error{Code: "invalid_client"}
+	ErrInvalidGrant         = &Error{Code: "invalid_grant"}
+	ErrUnsupportedGrantType = &Error{Code: "unsupported_grant_type"}
+	ErrUnsupportedResponse  = &Error{Code: "unsupported_response_type"}
+	ErrAccessDenied         = &Error{Code: "access_denied"}
+
+	// ErrInvalidToken is returned by Validate for unknown, expired or
+	// revoked bearer tokens.
+	ErrInvalidToken = errors.New("oauthserver: invalid bearer token")
+)
+
+// Client is an application allowed to ask users for access, e.g. Actions on
+// Google for one project.
+type Client struct {
+	ID     string
+	Secret string
+	// Name is shown on the consent page.
+	Name string
+	// RedirectURIs lists the exact redirect_uri values the client may use.
+	RedirectURIs []string
+}
+
+func (c *Client) allowsRedirect(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// Server issues and checks tokens for a fixed set of clients.
+type Server struct {
+	Clients []*Client
+	Store   Store
+
+	CodeTTL        time.Duration
+	AccessTokenTTL time.Duration
+	AppPasswordTTL time.Duration
+	// PurgeInterval is how often the expired tokens are deleted from the
+	// Store, when tokens are issued.
+	PurgeInterval time.Duration
+
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// purged is when the expired tokens were last deleted.
+	purged time.Time
+}
+
+// AuthorizeRequest is a validated request to the authorize endpoint, waiting
+// for the user's consent.
+type AuthorizeRequest struct {
+	Client      *Client
+	RedirectURI string
+	Scope       string
+	State       string
+
+	// redirectGiven is set when the request had a redirect_uri, rather than
+	// the only one of the client, the token request must then repeat it.
+	redirectGiven bool
+}
+
+// Values returns the query parameters that recreate ar, for the hidden
+// fields of a consent form.
+func (ar *AuthorizeRequest) Values() url.Values {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", ar.Client.ID)
+	if ar.redirectGiven {
+		v.Set("redirect_uri", ar.RedirectURI)
+	}
+	if ar.Scope != "" {
+		v.Set("scope", ar.Scope)
+	}
+	if ar.State != "" {
+		v.Set("state", ar.State)
+	}
+	return v
+}
+
+// ParseAuthorize validates the authorize request parameters in v. Errors
+// must be shown to the user rather than redirected, since the redirect_uri
+// may not be trustworthy.
+func (s *Server) ParseAuthorize(v url.Values) (*AuthorizeRequest, error) {
+	c := s.client(v.Get("client_id"))
+	if c == nil {
+		return nil, &Error{Code: ErrInvalidClient.Code, Description: "unknown client_id"}
+	}
+	redirect := v.Get("redirect_uri")
+	given := redirect != ""
+	if !given && len(c.RedirectURIs) == 1 {
+		redirect = c.RedirectURIs[0]
+	}
+	if !c.allowsRedirect(redirect) {
+		return nil, &Error{Code: ErrInvalidRequest.Code, Description: "redirect_uri not registered for client"}
+	}
+	if v.Get("response_type") != "code" {
+		return nil, ErrUnsupportedResponse
+	}
+	return &AuthorizeRequest{
+		Client:        c,
+		RedirectURI:   redirect,
+		Scope:         v.Get("scope"),
+		State:         v.Get("state"),
+		redirectGiven: given,
+	}, nil
+}
+
+// Approve issues an authorization code for the user and returns the URL to
+// redirect the user's browser to.
+func (s *Server) Approve(ar *AuthorizeRequest, userID, userName string) (string, error) {
+	code, t, err := newToken(KindCode)
+	if err != nil {
+		return "", err
+	}
+	t.Grant = Grant{ClientID: ar.Client.ID, UserID: userID, UserName: userName, Scope: ar.Scope}
+	if ar.redirectGiven {
+		t.RedirectURI = ar.RedirectURI
+	}
+	t.Expires = s.timeNow().Add(durationOr(s.CodeTTL, DefaultCodeTTL))
+	if err := s.put(t); err != nil {
+		return "", err
+	}
+	return redirectURL(ar, url.Values{"code": {code}}), nil
+}
+
+// Deny returns the URL that tells the client the user refused access.
+func (s *Server) Deny(ar *AuthorizeRequest) string {
+	return redirectURL(ar, url.Values{"error": {ErrAccessDenied.Code}})
+}
+
+func redirectURL(ar *AuthorizeRequest, v url.Values) string {
+	if ar.State != "" {
+		v.Set("state", ar.State)
+	}
+	u, err := url.Parse(ar.RedirectURI)
+	if err != nil {
+		// Registered redirect URIs are trusted to parse.
+		return ar.RedirectURI + "?" + v.Encode()
+	}
+	q := u.Query()
+	for k, vs := range v {
+		q[k] = vs
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// TokenResponse is the token endpoint's successful response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Exchange runs the token endpoint logic for the form values of r, after
+// authenticating the client with basic auth or client_id/client_secret.
+func (s *Server) Exchange(r *http.Request) (*TokenResponse, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, ErrInvalidRequest
+	}
+	c, err := s.authenticateClient(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		t, err := s.take(r.PostForm.Get("code"), KindCode, c)
+		if err != nil {
+			return nil, err
+		}
+		// The same redirect_uri as the authorize request, if it had one
+		// (RFC 6749 section 4.1.3)
+		if t.RedirectURI != "" && t.RedirectURI != r.PostForm.Get("redirect_uri") {
+			return nil, ErrInvalidGrant
+		}
+		return s.issue(t.Grant, true, "")
+	case "refresh_token":
+		hash := Hash(r.PostForm.Get("refresh_token"))
+		t, err := s.Store.Get(hash)
+		if err == ErrNotFound {
+			return nil, ErrInvalidGrant
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind != KindRefresh || t.ClientID != c.ID {
+			return nil, ErrInvalidGrant
+		}
+		return s.issue(t.Grant, false, hash)
+	case "":
+		return nil, ErrInvalidRequest
+	default:
+		return nil, ErrUnsupportedGrantType
+	}
+}
+
+// take returns the token for value and deletes it, since authorization codes
+// are single use.
+func (s *Server) take(value, kind string, c *Client) (*Token, error) {
+	hash := Hash(value)
+	t, err := s.Store.Get(hash)
+	if err == ErrNotFound {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Store.Delete(hash); err != nil {
+		return nil, err
+	}
+	if t.Kind != kind || t.ClientID != c.ID || t.Expired(s.timeNow()) {
+		return nil, ErrInvalidGrant
+	}
+	return t, nil
+}
+
+// issue creates an access token for g, and a refresh token if withRefresh.
+// Access tokens issued from a refresh token record it as their parent.
+func (s *Server) issue(g Grant, withRefresh bool, parent string) (*TokenResponse, error) {
+	resp := &TokenResponse{TokenType: "bearer"}
+
+	if withRefresh {
+		refresh, rt, err := newToken(KindRefresh)
+		if err != nil {
+			return nil, err
+		}
+		rt.Grant = g
+		if err := s.put(rt); err != nil {
+			return nil, err
+		}
+		resp.RefreshToken = refresh
+		parent = rt.Hash
+	}
+
+	access, at, err := newToken(KindAccess)
+	if err != nil {
+		return nil, err
+	}
+	ttl := durationOr(s.AccessTokenTTL, DefaultAccessTokenTTL)
+	at.Grant = g
+	at.Parent = parent
+	at.Expires = s.timeNow().Add(ttl)
+	if err := s.put(at); err != nil {
+		return nil, err
+	}
+	resp.AccessToken = access
+	resp.ExpiresIn = int64(ttl / time.Second)
+	return resp, nil
+}
+
+// Revoke runs the revocation endpoint logic (RFC 7009) for the form values
+// of r. Revoking a refresh token also revokes the access tokens issued with
+// it. Unknown tokens are not an error.
+func (s *Server) Revoke(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return ErrInvalidRequest
+	}
+	c, err := s.authenticateClient(r)
+	if err != nil {
+		return err
+	}
+	value := r.PostForm.Get("token")
+	if value == "" {
+		return ErrInvalidRequest
+	}
+	hash := Hash(value)
+	t, err := s.Store.Get(hash)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t.ClientID != c.ID {
+		// Not this client's token; say nothing about it.
+		return nil
+	}
+	return s.Store.Delete(hash)
+}
+
+// Validate returns the grant of a bearer access token.
+func (s *Server) Validate(value string) (*Grant, error) {
+	return s.validate(value, KindAccess)
+}
+
+// 2017.09.18 rjj: App passwords, for the CardDAV accounts of phones and
+// desktop address books, which only do HTTP Basic authentication.
+
+// IssueAppPassword returns a new app password for the user of g, the
+// ClientID saying what for, e.g. "carddav", with the label the user gave it.
+// It lasts AppPasswordTTL, a year by default, unless revoked with
+// RevokeAppPassword.
+func (s *Server) IssueAppPassword(g Grant, label string) (string, error) {
+	password, t, err := newToken(KindAppPassword)
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	t.Grant = g
+	t.ID, t.Label = hex.EncodeToString(id), label
+	t.Expires = s.timeNow().Add(durationOr(s.AppPasswordTTL, DefaultAppPasswordTTL))
+	if err := s.put(t); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// ValidateAppPassword returns the grant of an app password, or ErrInvalidToken.
+func (s *Server) ValidateAppPassword(password string) (*Grant, error) {
+	return s.validate(password, KindAppPassword)
+}
+
+// AppPasswords returns the app passwords of userID that haven't expired,
+// the one expiring first first. Only their ID, Label and Expires say
+// anything about them.
+func (s *Server) AppPasswords(userID string) ([]*Token, error) {
+	tokens, err := s.Store.List(userID, KindAppPassword)
+	if err != nil {
+		return nil, err
+	}
+	var valid []*Token
+	for _, t := range tokens {
+		if !t.Expired(s.timeNow()) {
+			valid = append(valid, t)
+		}
+	}
+	sort.Sort(byExpires(valid))
+	return valid, nil
+}
+
+// byExpires sorts tokens by when they expire, then by ID.
+type byExpires []*Token
+
+func (a byExpires) Len() int      { return len(a) }
+func (a byExpires) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byExpires) Less(i, j int) bool {
+	if !a[i].Expires.Equal(a[j].Expires) {
+		return a[i].Expires.Before(a[j].Expires)
+	}
+	return a[i].ID < a[j].ID
+}
+
+// RevokeAppPassword revokes the app password of userID with the ID id.
+// Unknown IDs, and those of the passwords of other users, are not an error.
+func (s *Server) RevokeAppPassword(userID, id string) error {
+	tokens, err := s.Store.List(userID, KindAppPassword)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.ID == id && id != "" {
+			return s.Store.Delete(t.Hash)
+		}
+	}
+	return nil
+}
+
+// validate returns the grant of a token of kind.
+func (s *Server) validate(value, kind string) (*Grant, error) {
+	if value == "" {
+		return nil, ErrInvalidToken
+	}
+	t, err := s.Store.Get(Hash(value))
+	if err == ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind != kind || t.Expired(s.timeNow()) {
+		return nil, ErrInvalidToken
+	}
+	return &t.Grant, nil
+}
+
+// ServeToken is an http.Handler for the token endpoint.
+func (s *Server) ServeToken(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Exchange(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ServeRevoke is an http.Handler for the revocation endpoint.
+func (s *Server) ServeRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := s.Revoke(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		log.Printf("oauthserver: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusBadRequest
+	if e.Code == ErrInvalidClient.Code {
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeJSON(w, status, e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) client(id string) *Client {
+	for _, c := range s.Clients {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) authenticateClient(r *http.Request) (*Client, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	c := s.client(id)
+	if c == nil || subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return c, nil
+}
+
+// put saves t, after deleting the expired tokens if it's been PurgeInterval
+// since the last time, so they don't pile up in the Store.
+func (s *Server) put(t *Token) error {
+	now := s.timeNow()
+	s.mu.Lock()
+	purge := now.Sub(s.purged) >= durationOr(s.PurgeInterval, DefaultPurgeInterval)
+	if purge {
+		s.purged = now
+	}
+	s.mu.Unlock()
+	if purge {
+		if err := s.Store.DeleteExpired(now); err != nil {
+			log.Printf("oauthserver: could not delete expired tokens: %v", err)
+		}
+	}
+	return s.Store.Put(t)
+}
+
+func (s *Server) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// Hash returns the Store key for a token value.
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random token value and its Token, without Grant.
+func newToken(kind string) (string, *Token, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+	return value, &Token{Hash: Hash(value), Kind: kind}, nil
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
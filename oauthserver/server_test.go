@@ -0,0 +1,351 @@
+// 2017.09.01 rjj: Tests for the OAuth2 authorization server.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oauthserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redirect = "https://oauth-redirect.googleusercontent.com/r/yum-contacts"
+
+func newServer() *Server {
+	return &Server{
+		Clients: []*Client{{ID: "aog", Secret: "s3cret", Name: "Google Assistant", RedirectURIs: []string{redirect}}},
+		Store:   NewMemoryStore(),
+	}
+}
+
+func authorizeValues() url.Values {
+	return url.Values{
+		"response_type": {"code"},
+		"client_id":     {"aog"},
+		"redirect_uri":  {redirect},
+		"state":         {"xyz"},
+	}
+}
+
+// approve runs the authorize step and returns the authorization code.
+func approve(t *testing.T, s *Server) string {
+	ar, err := s.ParseAuthorize(authorizeValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.Approve(ar, "1234", "Jane Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.Query().Get("state"), "xyz"; got != want {
+		t.Errorf("got state %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(to, redirect+"?") {
+		t.Errorf("got redirect %q, want prefix %q", to, redirect)
+	}
+	return u.Query().Get("code")
+}
+
+func tokenRequest(v url.Values) *http.Request {
+	r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(v.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func exchange(t *testing.T, s *Server, v url.Values) (*TokenResponse, *Error) {
+	r := tokenRequest(v)
+	r.SetBasicAuth("aog", "s3cret")
+	w := httptest.NewRecorder()
+	s.ServeToken(w, r)
+	if w.Code != http.StatusOK {
+		var e Error
+		if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		return nil, &e
+	}
+	var resp TokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp, nil
+}
+
+func TestParseAuthorize(t *testing.T) {
+	s := newServer()
+	tests := []struct {
+		name string
+		set  func(v url.Values)
+		want string
+	}{
+		{"ok", func(v url.Values) {}, ""},
+		{"unknown client", func(v url.Values) { v.Set("client_id", "evil") }, "invalid_client"},
+		{"other redirect", func(v url.Values) { v.Set("redirect_uri", "https://evil.example.com/") }, "invalid_request"},
+		{"default redirect", func(v url.Values) { v.Del("redirect_uri") }, ""},
+		{"implicit grant", func(v url.Values) { v.Set("response_type", "token") }, "unsupported_response_type"},
+	}
+	for _, tt := range tests {
+		v := authorizeValues()
+		tt.set(v)
+		_, err := s.ParseAuthorize(v)
+		got := ""
+		if err != nil {
+			got = err.(*Error).Code
+		}
+		if got != tt.want {
+			t.Errorf("%s: got error %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeny(t *testing.T) {
+	s := newServer()
+	ar, err := s.ParseAuthorize(authorizeValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Deny(ar)
+	want := redirect + "?error=access_denied&state=xyz"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCodeFlow(t *testing.T) {
+	s := newServer()
+	code := approve(t, s)
+
+	resp, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect}})
+	if e != nil {
+		t.Fatalf("exchange: %v", e)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.TokenType != "bearer" {
+		t.Fatalf("got %+v, want access and refresh tokens", resp)
+	}
+	if got, want := resp.ExpiresIn, int64(DefaultAccessTokenTTL/time.Second); got != want {
+		t.Errorf("got expires_in %d, want %d", got, want)
+	}
+
+	g, err := s.Validate(resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.UserID != "1234" || g.UserName != "Jane Doe" || g.ClientID != "aog" {
+		t.Errorf("got grant %+v", g)
+	}
+
+	// Codes are single use.
+	if _, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect}}); e == nil || e.Code != "invalid_grant" {
+		t.Errorf("reused code: got %v, want invalid_grant", e)
+	}
+	// Refresh tokens are not bearer tokens.
+	if _, err := s.Validate(resp.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("refresh token as bearer: got %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestRedirectURI(t *testing.T) {
+	s := newServer()
+	for _, uri := range []string{"", "https://evil.example.com/"} {
+		v := url.Values{"grant_type": {"authorization_code"}, "code": {approve(t, s)}}
+		if uri != "" {
+			v.Set("redirect_uri", uri)
+		}
+		if _, e := exchange(t, s, v); e == nil || e.Code != "invalid_grant" {
+			t.Errorf("redirect_uri %q: got %v, want invalid_grant", uri, e)
+		}
+	}
+
+	// Not needed when the authorize request had none
+	v := authorizeValues()
+	v.Del("redirect_uri")
+	ar, err := s.ParseAuthorize(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Values().Get("redirect_uri") != "" {
+		t.Errorf("consent form: got %v, want no redirect_uri", ar.Values())
+	}
+	to, err := s.Approve(ar, "1234", "Jane Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(to)
+	if _, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {u.Query().Get("code")}}); e != nil {
+		t.Errorf("no redirect_uri: got %v, want tokens", e)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	s := newServer()
+	store := s.Store.(*MemoryStore)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	approve(t, s)
+	resp, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {approve(t, s)}, "redirect_uri": {redirect}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got := len(store.tokens); got != 3 {
+		t.Fatalf("got %d tokens, want an unused code, a refresh and an access token", got)
+	}
+
+	// The next token issued after the interval deletes the expired ones
+	now = now.Add(DefaultPurgeInterval + time.Second)
+	approve(t, s)
+	if got := len(store.tokens); got != 2 {
+		t.Errorf("got %d tokens, want the refresh token and the new code", got)
+	}
+	if _, e := exchange(t, s, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}}); e != nil {
+		t.Errorf("refresh after the purge: %v", e)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := newServer()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	code := approve(t, s)
+
+	now = now.Add(DefaultCodeTTL + time.Second)
+	if _, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect}}); e == nil || e.Code != "invalid_grant" {
+		t.Errorf("expired code: got %v, want invalid_grant", e)
+	}
+
+	code = approve(t, s)
+	resp, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	now = now.Add(DefaultAccessTokenTTL + time.Second)
+	if _, err := s.Validate(resp.AccessToken); err != ErrInvalidToken {
+		t.Errorf("expired access token: got %v, want %v", err, ErrInvalidToken)
+	}
+
+	// The refresh token still works.
+	refreshed, e := exchange(t, s, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, err := s.Validate(refreshed.AccessToken); err != nil {
+		t.Errorf("refreshed access token: %v", err)
+	}
+}
+
+func TestClientAuthentication(t *testing.T) {
+	s := newServer()
+	code := approve(t, s)
+
+	r := tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirect}})
+	r.SetBasicAuth("aog", "wrong")
+	w := httptest.NewRecorder()
+	s.ServeToken(w, r)
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("got status %d, want %d", got, want)
+	}
+
+	// Credentials in the form body are accepted too.
+	r = tokenRequest(url.Values{"grant_type": {"authorization_code"}, "code": {approve(t, s)}, "redirect_uri": {redirect},
+		"client_id": {"aog"}, "client_secret": {"s3cret"}})
+	w = httptest.NewRecorder()
+	s.ServeToken(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got status %d, want %d: %s", got, want, w.Body)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := newServer()
+	resp, e := exchange(t, s, url.Values{"grant_type": {"authorization_code"}, "code": {approve(t, s)}, "redirect_uri": {redirect}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	refreshed, e := exchange(t, s, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	r := tokenRequest(url.Values{"token": {resp.RefreshToken}})
+	r.SetBasicAuth("aog", "s3cret")
+	w := httptest.NewRecorder()
+	s.ServeRevoke(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d, want %d", got, want)
+	}
+
+	for _, tok := range []string{resp.AccessToken, refreshed.AccessToken} {
+		if _, err := s.Validate(tok); err != ErrInvalidToken {
+			t.Errorf("access token after revoking refresh token: got %v, want %v", err, ErrInvalidToken)
+		}
+	}
+	if _, e := exchange(t, s, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {resp.RefreshToken}}); e == nil || e.Code != "invalid_grant" {
+		t.Errorf("revoked refresh token: got %v, want invalid_grant", e)
+	}
+}
+
+func TestAppPassword(t *testing.T) {
+	s := newServer()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	password, err := s.IssueAppPassword(Grant{ClientID: "carddav", UserID: "1234", UserName: "Jane Doe"}, "Phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := s.IssueAppPassword(Grant{ClientID: "carddav", UserID: "1234"}, "Laptop"); err != nil {
+		t.Fatal(err)
+	}
+	s.IssueAppPassword(Grant{ClientID: "carddav", UserID: "5678"}, "Tablet")
+	g, err := s.ValidateAppPassword(password)
+	if err != nil || g.UserID != "1234" || g.ClientID != "carddav" {
+		t.Errorf("got %+v %v, want the grant of Jane Doe", g, err)
+	}
+	// Not a bearer token
+	if _, err := s.Validate(password); err != ErrInvalidToken {
+		t.Errorf("as an access token: got %v, want %v", err, ErrInvalidToken)
+	}
+
+	// Listed by label, the passwords themselves are gone
+	list, err := s.AppPasswords("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Label != "Phone" || list[1].Label != "Laptop" || list[0].ID == "" || list[0].ID == list[1].ID {
+		t.Fatalf("got %+v, want Phone and Laptop", list)
+	}
+	phone := list[0].ID
+
+	if err := s.RevokeAppPassword("5678", phone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAppPassword(password); err != nil {
+		t.Errorf("revoked by another user: got %v, want it still valid", err)
+	}
+	if err := s.RevokeAppPassword("1234", phone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ValidateAppPassword(password); err != ErrInvalidToken {
+		t.Errorf("revoked: got %v, want %v", err, ErrInvalidToken)
+	}
+	if list, _ := s.AppPasswords("1234"); len(list) != 1 || list[0].Label != "Laptop" {
+		t.Errorf("after revoking Phone: got %+v, want Laptop", list)
+	}
+
+	password, _ = s.IssueAppPassword(Grant{ClientID: "carddav", UserID: "1234"}, "")
+	now = now.Add(DefaultAppPasswordTTL + time.Second)
+	if _, err := s.ValidateAppPassword(password); err != ErrInvalidToken {
+		t.Errorf("expired: got %v, want %v", err, ErrInvalidToken)
+	}
+	if list, _ := s.AppPasswords("1234"); len(list) != 0 {
+		t.Errorf("expired: got %+v listed", list)
+	}
+}
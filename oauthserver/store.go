@@ -0,0 +1,146 @@
+// 2017.09.01 rjj: Storage for the OAuth2 authorization server.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package oauthserver
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Get for unknown tokens.
+var ErrNotFound = errors.New("oauthserver: token not found")
+
+// Kinds of Token.
+const (
+	KindCode    = "code"
+	KindAccess  = "access"
+	KindRefresh = "refresh"
+	// KindAppPassword is a password for apps that can't do OAuth2, see
+	// Server.IssueAppPassword.
+	KindAppPassword = "app_password"
+)
+
+// Grant is what the user agreed to: Client may act for User within Scope.
+type Grant struct {
+	ClientID string
+	UserID   string
+	UserName string
+	Scope    string
+}
+
+// Token is an issued authorization code, access token or refresh token.
+// Only a hash of the token value is kept, so a leaked store can't be used
+// to call the API.
+type Token struct {
+	Hash string
+	Kind string
+	Grant
+	// RedirectURI is the redirect_uri an authorization code was issued for.
+	RedirectURI string
+	// Parent is the hash of the refresh token an access token was issued
+	// with, so revoking the refresh token revokes its access tokens too.
+	Parent string
+	// Expires is the zero time for tokens that don't expire.
+	Expires time.Time
+	// ID and Label tell the app passwords of a user apart, since the
+	// passwords themselves aren't kept: ID is random, Label is what the
+	// user calls it, e.g. "Phone".
+	ID    string
+	Label string
+}
+
+// Expired reports whether t has expired at now.
+func (t *Token) Expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+// Store persists tokens. Implementations must be safe for concurrent use.
+type Store interface {
+	// Put saves t.
+	Put(t *Token) error
+	// Get returns the token with the given hash, or ErrNotFound.
+	Get(hash string) (*Token, error)
+	// Delete removes the token with the given hash, and any tokens whose
+	// Parent it is. Deleting an unknown token is not an error.
+	Delete(hash string) error
+	// List returns the tokens of kind of userID, in no particular order.
+	List(userID, kind string) ([]*Token, error)
+	// DeleteExpired removes the tokens that have expired at now.
+	DeleteExpired(now time.Time) error
+}
+
+// MemoryStore is a Store kept in memory, for tests and single instance
+// deployments that can live with users re-linking after a restart.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+// Ensure MemoryStore conforms to the Store interface.
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: map[string]Token{}}
+}
+
+// Put saves t.
+func (s *MemoryStore) Put(t *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.Hash] = *t
+	return nil
+}
+
+// Get returns the token with the given hash, or ErrNotFound.
+func (s *MemoryStore) Get(hash string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+// List returns the tokens of kind of userID.
+func (s *MemoryStore) List(userID, kind string) ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []*Token
+	for _, t := range s.tokens {
+		if t.UserID == userID && t.Kind == kind {
+			t := t
+			tokens = append(tokens, &t)
+		}
+	}
+	return tokens, nil
+}
+
+// DeleteExpired removes the tokens that have expired at now.
+func (s *MemoryStore) DeleteExpired(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for h, t := range s.tokens {
+		if t.Expired(now) {
+			delete(s.tokens, h)
+		}
+	}
+	return nil
+}
+
+// Delete removes the token with the given hash and its children.
+func (s *MemoryStore) Delete(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, hash)
+	for h, t := range s.tokens {
+		if t.Parent == hash {
+			delete(s.tokens, h)
+		}
+	}
+	return nil
+}
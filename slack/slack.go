@@ -0,0 +1,238 @@
+// 2017.09.09 rjj: Slack slash commands and interactive messages.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package slack verifies the requests Slack sends for slash commands and
+// interactive messages, parses their form payloads and builds Block Kit
+// messages to answer them.
+//
+// See https://api.slack.com/authentication/verifying-requests-from-slack
+// and https://api.slack.com/block-kit
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers of the signature.
+const (
+	SignatureHeader = "X-Slack-Signature"
+	TimestampHeader = "X-Slack-Request-Timestamp"
+)
+
+// MaxTimestampSkew is how far the timestamp of a request may be from now,
+// older requests could be replayed.
+const MaxTimestampSkew = 5 * time.Minute
+
+// Errors returned by Verify.
+var (
+	ErrTimestamp = errors.New("slack: request timestamp missing or too far from now")
+	ErrSignature = errors.New("slack: missing or wrong request signature")
+)
+
+// Config is how the app talks to one Slack workspace.
+type Config struct {
+	// SigningSecret is from the app's Basic Information page.
+	SigningSecret []byte
+
+	// Users maps Slack user IDs to the application's, the others are
+	// anonymous.
+	Users map[string]string
+
+	// now is the clock, time.Now when nil.
+	now func() time.Time
+}
+
+// Verify checks the signature of r. The body is read and replaced, so
+// handlers can still read it.
+func (c *Config) Verify(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	ts := r.Header.Get(TimestampHeader)
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrTimestamp
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	if d := now().Sub(time.Unix(secs, 0)); d > MaxTimestampSkew || d < -MaxTimestampSkew {
+		return ErrTimestamp
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(SignatureHeader), "v0="))
+	if err != nil || !hmac.Equal(got, Sign(c.SigningSecret, ts, body)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Wrap returns a handler that responds 401 Unauthorized to requests that
+// fail Verify, and passes the others on to h.
+func (c *Config) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Verify(r); err != nil {
+			log.Printf("Slack call from %s rejected: %v", r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Sign returns the version 0 signature of a request body sent at timestamp.
+func Sign(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Command is a slash command, e.g. "/contact homer simpson".
+type Command struct {
+	Command     string
+	Text        string
+	TeamID      string
+	ChannelID   string
+	UserID      string
+	UserName    string
+	ResponseURL string
+	TriggerID   string
+}
+
+// ParseCommand returns the slash command of a request's form.
+func ParseCommand(form url.Values) *Command {
+	return &Command{
+		Command:     form.Get("command"),
+		Text:        strings.TrimSpace(form.Get("text")),
+		TeamID:      form.Get("team_id"),
+		ChannelID:   form.Get("channel_id"),
+		UserID:      form.Get("user_id"),
+		UserName:    form.Get("user_name"),
+		ResponseURL: form.Get("response_url"),
+		TriggerID:   form.Get("trigger_id"),
+	}
+}
+
+// Interaction is what a user did with an interactive message, e.g. click a
+// button.
+type Interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions     []Action `json:"actions"`
+	ResponseURL string   `json:"response_url"`
+}
+
+// Action is one clicked element.
+type Action struct {
+	ActionID string `json:"action_id"`
+	BlockID  string `json:"block_id"`
+	Value    string `json:"value,omitempty"`
+}
+
+// ParseInteraction returns the interaction in the payload field of a
+// request's form.
+func ParseInteraction(form url.Values) (*Interaction, error) {
+	var in Interaction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &in); err != nil {
+		return nil, errors.New("slack: bad interaction payload: " + err.Error())
+	}
+	return &in, nil
+}
+
+// Response types of a Message: only to the user, or to the whole channel.
+const (
+	Ephemeral = "ephemeral"
+	InChannel = "in_channel"
+)
+
+// Message is the answer to a command. Text is the notification, and the
+// message when there are no Blocks.
+type Message struct {
+	ResponseType string  `json:"response_type,omitempty"`
+	Text         string  `json:"text"`
+	Blocks       []Block `json:"blocks,omitempty"`
+}
+
+// Block is a section, actions, context or divider block.
+type Block struct {
+	Type      string    `json:"type"`
+	BlockID   string    `json:"block_id,omitempty"`
+	Text      *Text     `json:"text,omitempty"`
+	Fields    []Text    `json:"fields,omitempty"`
+	Accessory *Element  `json:"accessory,omitempty"`
+	Elements  []Element `json:"elements,omitempty"`
+}
+
+// Text is plain_text or mrkdwn.
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Element is a button, or text in a context block.
+type Element struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	ActionID string `json:"action_id,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Style    string `json:"style,omitempty"`
+}
+
+// Markdown returns mrkdwn text, s must be escaped already, see Escape.
+func Markdown(s string) *Text {
+	return &Text{Type: "mrkdwn", Text: s}
+}
+
+// PlainText returns plain_text.
+func PlainText(s string) *Text {
+	return &Text{Type: "plain_text", Text: s}
+}
+
+// Section returns a section block with markdown text.
+func Section(markdown string) Block {
+	return Block{Type: "section", Text: Markdown(markdown)}
+}
+
+// Actions returns an actions block.
+func Actions(elements ...Element) Block {
+	return Block{Type: "actions", Elements: elements}
+}
+
+// Divider returns a divider block.
+func Divider() Block {
+	return Block{Type: "divider"}
+}
+
+// LinkButton returns a button opening url. Slack still sends the click as
+// an interaction, which must be acknowledged.
+func LinkButton(text, actionID, url string) Element {
+	return Element{Type: "button", Text: PlainText(text), ActionID: actionID, URL: url}
+}
+
+// Escape escapes the characters mrkdwn gives a meaning to: &, < and >.
+func Escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
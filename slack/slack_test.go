@@ -0,0 +1,117 @@
+// 2017.09.09 rjj: Tests for Slack request verification and payloads.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package slack
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2017, time.September, 1, 12, 0, 0, 0, time.UTC)
+
+func signedRequest(secret, body string, at time.Time) *http.Request {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	r := httptest.NewRequest("POST", "/slack/command", strings.NewReader(body))
+	r.Header.Set(TimestampHeader, ts)
+	r.Header.Set(SignatureHeader, "v0="+hex.EncodeToString(Sign([]byte(secret), ts, []byte(body))))
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	c := &Config{SigningSecret: []byte("secret"), now: func() time.Time { return now }}
+	body := "command=%2Fcontact&text=homer+simpson"
+
+	r := signedRequest("secret", body, now.Add(-time.Minute))
+	if err := c.Verify(r); err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	// The body is still there for the handler
+	if b, _ := ioutil.ReadAll(r.Body); string(b) != body {
+		t.Errorf("got body %q, want %q", b, body)
+	}
+
+	if err := c.Verify(signedRequest("other", body, now)); err != ErrSignature {
+		t.Errorf("wrong secret: got %v, want %v", err, ErrSignature)
+	}
+	if err := c.Verify(signedRequest("secret", body, now.Add(-10*time.Minute))); err != ErrTimestamp {
+		t.Errorf("old request: got %v, want %v", err, ErrTimestamp)
+	}
+	r = signedRequest("secret", body, now)
+	r.Header.Del(TimestampHeader)
+	if err := c.Verify(r); err != ErrTimestamp {
+		t.Errorf("no timestamp: got %v, want %v", err, ErrTimestamp)
+	}
+	r = signedRequest("secret", body, now)
+	r.Body = ioutil.NopCloser(strings.NewReader(body + "&text=marge"))
+	if err := c.Verify(r); err != ErrSignature {
+		t.Errorf("changed body: got %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	c := &Config{SigningSecret: []byte("secret"), now: func() time.Time { return now }}
+	h := c.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("other", "text=x", now))
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("got status %d, want %d", got, want)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("secret", "text=x", now))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got status %d, want %d", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cmd := ParseCommand(url.Values{"command": {"/contact"}, "text": {" homer simpson "}, "user_id": {"U1"}})
+	if got, want := cmd.Text, "homer simpson"; got != want {
+		t.Errorf("got text %q, want %q", got, want)
+	}
+	if got, want := cmd.UserID, "U1"; got != want {
+		t.Errorf("got user %q, want %q", got, want)
+	}
+
+	in, err := ParseInteraction(url.Values{"payload": {`{"type":"block_actions","user":{"id":"U1"},"actions":[{"action_id":"open_contact","value":"1"}]}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Actions) != 1 || in.Actions[0].ActionID != "open_contact" || in.User.ID != "U1" {
+		t.Errorf("got %+v, want one open_contact action by U1", in)
+	}
+	if _, err := ParseInteraction(url.Values{}); err == nil {
+		t.Error("no payload: want error")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	m := Message{
+		ResponseType: Ephemeral,
+		Text:         "Found Homer",
+		Blocks: []Block{
+			Section("*" + Escape("Homer & Marge <Simpson>") + "*"),
+			Actions(LinkButton("Open", "open_contact", "https://example.com/contacts/1")),
+		},
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"response_type":"ephemeral","text":"Found Homer","blocks":[` +
+		`{"type":"section","text":{"type":"mrkdwn","text":"*Homer \u0026amp; Marge \u0026lt;Simpson\u0026gt;*"}},` +
+		`{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"Open"},"action_id":"open_contact","url":"https://example.com/contacts/1"}]}]}`
+	if got := string(b); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
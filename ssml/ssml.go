@@ -0,0 +1,198 @@
+// 2017.08.28 rjj: SSML for reading contact details aloud.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package ssml builds Speech Synthesis Markup Language strings, with helpers
+// that make phone numbers and email addresses sound right when spoken.
+//
+// Without markup a phone number like 555-123-4567 is read as a sum, and an
+// email address as a run of letters and punctuation.
+//
+// See https://developers.google.com/actions/reference/ssml
+package ssml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Short and Long are the pauses used between fields of a contact.
+const (
+	Short = 200 * time.Millisecond
+	Long  = 400 * time.Millisecond
+)
+
+// Words are the words used to read out the punctuation in email addresses
+// and phone numbers.
+type Words struct {
+	At, Dot, Dash, Underscore, Plus string
+}
+
+// English is the default Words.
+var English = Words{At: "at", Dot: "dot", Dash: "dash", Underscore: "underscore", Plus: "plus"}
+
+// Builder accumulates SSML. The zero value is ready to use, with English
+// Words.
+type Builder struct {
+	buf   bytes.Buffer
+	words *Words
+}
+
+// New returns an empty Builder.
+func New() *Builder {
+	return &Builder{}
+}
+
+// SetWords sets the words used by Phone and Email, e.g. for another language.
+func (b *Builder) SetWords(w Words) *Builder {
+	b.words = &w
+	return b
+}
+
+// w returns the Words in use.
+func (b *Builder) w() *Words {
+	if b.words == nil {
+		return &English
+	}
+	return b.words
+}
+
+// Wrap returns plain text as an SSML document, escaping it, markup
+// included: text that looks like SSML is still text.
+func Wrap(s string) string {
+	return New().Text(s).String()
+}
+
+// Text appends plain text, escaping XML special characters.
+func (b *Builder) Text(s string) *Builder {
+	b.space()
+	xml.EscapeText(&b.buf, []byte(s))
+	return b
+}
+
+// Textf appends formatted plain text.
+func (b *Builder) Textf(format string, v ...interface{}) *Builder {
+	return b.Text(fmt.Sprintf(format, v...))
+}
+
+// Pause appends a break of duration d.
+func (b *Builder) Pause(d time.Duration) *Builder {
+	fmt.Fprintf(&b.buf, `<break time="%dms"/>`, d/time.Millisecond)
+	return b
+}
+
+// Characters appends s to be spelled out one character at a time.
+func (b *Builder) Characters(s string) *Builder {
+	b.space()
+	b.buf.WriteString(`<say-as interpret-as="characters">`)
+	xml.EscapeText(&b.buf, []byte(s))
+	b.buf.WriteString(`</say-as>`)
+	return b
+}
+
+// Phone appends a phone number read digit by digit, with a short pause
+// between the groups written in the number (e.g. 555-123-4567).
+func (b *Builder) Phone(phone string) *Builder {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, "+") {
+		b.Text(b.w().Plus)
+	}
+
+	groups := strings.FieldsFunc(phone, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+	for i, g := range groups {
+		if i > 0 {
+			b.Pause(Short)
+		}
+		b.Characters(g)
+	}
+	return b
+}
+
+// Email appends an email address the way people say one: "at" and "dot"
+// for the punctuation, words spoken as words, and anything that doesn't look
+// like a word (initials, numbers) spelled out.
+func (b *Builder) Email(email string) *Builder {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return b.Characters(email)
+	}
+
+	b.emailPart(email[:at])
+	b.Text(b.w().At)
+	b.emailPart(email[at+1:])
+	return b
+}
+
+// emailPart appends one side of an email address, splitting it into words
+// at the usual separators.
+func (b *Builder) emailPart(s string) {
+	var word bytes.Buffer
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		if speakable(word.String()) {
+			b.Text(word.String())
+		} else {
+			b.Characters(word.String())
+		}
+		word.Reset()
+	}
+
+	for _, r := range s {
+		switch r {
+		case '.':
+			flush()
+			b.Text(b.w().Dot)
+		case '-':
+			flush()
+			b.Text(b.w().Dash)
+		case '_':
+			flush()
+			b.Text(b.w().Underscore)
+		case '+':
+			flush()
+			b.Text(b.w().Plus)
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+}
+
+// speakable reports whether w can be read as a word rather than spelled:
+// letters only, at least three of them, and at least one vowel.
+func speakable(w string) bool {
+	if len(w) < 3 {
+		return false
+	}
+	vowel := false
+	for _, r := range w {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+		if strings.ContainsRune("aeiouyAEIOUY", r) {
+			vowel = true
+		}
+	}
+	return vowel
+}
+
+// space separates consecutive text so words don't run together.
+func (b *Builder) space() {
+	if n := b.buf.Len(); n > 0 && b.buf.Bytes()[n-1] != ' ' {
+		b.buf.WriteByte(' ')
+	}
+}
+
+// String returns the accumulated SSML as a <speak> document.
+func (b *Builder) String() string {
+	return "<speak>" + b.buf.String() + "</speak>"
+}
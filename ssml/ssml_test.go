@@ -0,0 +1,69 @@
+// 2017.08.28 rjj: Tests for the SSML builder.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package ssml
+
+import "testing"
+
+func TestPhone(t *testing.T) {
+	got := New().Phone("555-123-4567").String()
+	want := `<speak><say-as interpret-as="characters">555</say-as><break time="200ms"/> ` +
+		`<say-as interpret-as="characters">123</say-as><break time="200ms"/> ` +
+		`<say-as interpret-as="characters">4567</say-as></speak>`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	got = New().Phone("+44 20").String()
+	want = `<speak>plus <say-as interpret-as="characters">44</say-as><break time="200ms"/> ` +
+		`<say-as interpret-as="characters">20</say-as></speak>`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		email, want string
+	}{
+		{"homer.simpson@simpsons.guru",
+			`<speak>homer dot simpson at simpsons dot guru</speak>`},
+		{"hj_42@mail.example.com",
+			`<speak><say-as interpret-as="characters">hj</say-as> underscore <say-as interpret-as="characters">42</say-as> ` +
+				`at mail dot example dot com</speak>`},
+		{"no-at-sign",
+			`<speak><say-as interpret-as="characters">no-at-sign</say-as></speak>`},
+	}
+	for _, tt := range tests {
+		if got := New().Email(tt.email).String(); got != tt.want {
+			t.Errorf("Email(%q): got %s, want %s", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestSetWords(t *testing.T) {
+	fr := Words{At: "arobase", Dot: "point", Dash: "tiret", Underscore: "tiret bas", Plus: "plus"}
+	got := New().SetWords(fr).Email("homer.simpson@simpsons.guru").String()
+	want := `<speak>homer point simpson arobase simpsons point guru</speak>`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTextEscaping(t *testing.T) {
+	got := New().Text("Found:").Text("Tom & Jerry <cartoons>").Pause(Long).String()
+	want := `<speak>Found: Tom &amp; Jerry &lt;cartoons&gt;<break time="400ms"/></speak>`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if got, want := Wrap("1 < 2"), "<speak>1 &lt; 2</speak>"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := Wrap("<speak>hi</speak>"), "<speak>&lt;speak&gt;hi&lt;/speak&gt;</speak>"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
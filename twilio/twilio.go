@@ -0,0 +1,140 @@
+// 2017.09.10 rjj: Twilio SMS and voice webhooks.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package twilio verifies the form posts Twilio sends to SMS and voice
+// webhooks, and writes the TwiML that answers them.
+//
+// See https://www.twilio.com/docs/usage/security#validating-requests
+// and https://www.twilio.com/docs/voice/twiml
+package twilio
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// SignatureHeader carries the request signature.
+const SignatureHeader = "X-Twilio-Signature"
+
+// ErrSignature is returned by Verify.
+var ErrSignature = errors.New("twilio: missing or wrong request signature")
+
+// Config is the Twilio account the webhooks answer.
+type Config struct {
+	// AuthToken is the account's auth token, the key of the signatures.
+	AuthToken []byte
+
+	// Users maps the phone numbers allowed to use the webhooks, in E.164
+	// format (+15556367890), to the application's user IDs.
+	Users map[string]string
+}
+
+// Verify checks the signature of r, a form post. Twilio signs the URL it
+// was configured with, which behind a proxy (App Engine) is rebuilt from the
+// X-Forwarded-Proto header.
+func (c *Config) Verify(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	got, err := base64.StdEncoding.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || !hmac.Equal(got, Sign(c.AuthToken, RequestURL(r), r.PostForm)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Wrap returns a handler that responds 403 Forbidden to requests that fail
+// Verify, and passes the others on to h.
+func (c *Config) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Verify(r); err != nil {
+			log.Printf("Twilio call from %s rejected: %v", r.RemoteAddr, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Sign returns the signature of a post of params to url: the HMAC-SHA1 of
+// the URL followed by each parameter name and value, sorted by name.
+func Sign(authToken []byte, url string, params url.Values) []byte {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mac := hmac.New(sha1.New, authToken)
+	io.WriteString(mac, url)
+	for _, name := range names {
+		for _, v := range params[name] {
+			io.WriteString(mac, name+v)
+		}
+	}
+	return mac.Sum(nil)
+}
+
+// RequestURL returns the URL r was sent to, as the client saw it.
+func RequestURL(r *http.Request) string {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// Response is a TwiML document. Only the verbs used here are supported.
+type Response struct {
+	XMLName xml.Name `xml:"Response"`
+	Verbs   []interface{}
+}
+
+// Message replies to an SMS.
+type Message struct {
+	XMLName xml.Name `xml:"Message"`
+	Body    string   `xml:",chardata"`
+}
+
+// Say speaks Text on a call.
+type Say struct {
+	XMLName  xml.Name `xml:"Say"`
+	Language string   `xml:"language,attr,omitempty"`
+	Text     string   `xml:",chardata"`
+}
+
+// Gather listens to the caller, then posts what they said (SpeechResult)
+// to Action. The verbs in it are said while listening.
+type Gather struct {
+	XMLName xml.Name `xml:"Gather"`
+	Input   string   `xml:"input,attr"`
+	Action  string   `xml:"action,attr,omitempty"`
+	Timeout int      `xml:"timeout,attr,omitempty"`
+	Verbs   []interface{}
+}
+
+// Hangup ends the call.
+type Hangup struct {
+	XMLName xml.Name `xml:"Hangup"`
+}
+
+// Write writes the TwiML document to w.
+func (resp *Response) Write(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,86 @@
+// 2017.09.10 rjj: Tests for Twilio request verification and TwiML.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package twilio
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func signedRequest(token, target string, form url.Values) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	// Twilio called the https URL, the proxy forwarded it as http
+	signed := "https://" + strings.TrimPrefix(target, "http://")
+	r.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(Sign([]byte(token), signed, form)))
+	return r
+}
+
+func TestSign(t *testing.T) {
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+14158675309"},
+		"To":      {"+18005551212"},
+	}
+	got := base64.StdEncoding.EncodeToString(Sign([]byte("12345"), "https://mycompany.com/myapp.php?foo=1&bar=2", params))
+	if want := "hQB5VTHIpMUO6TFoLtwSh6arFMk="; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	c := &Config{AuthToken: []byte("token")}
+	form := url.Values{"From": {"+15556367890"}, "Body": {"find homer simpson"}}
+
+	r := signedRequest("token", "http://example.com/twilio/sms?x=1", form)
+	if err := c.Verify(r); err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	if got, want := r.PostForm.Get("Body"), "find homer simpson"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+
+	if err := c.Verify(signedRequest("other", "http://example.com/twilio/sms", form)); err != ErrSignature {
+		t.Errorf("wrong token: got %v, want %v", err, ErrSignature)
+	}
+	r = signedRequest("token", "http://example.com/twilio/sms", form)
+	r.Header.Del("X-Forwarded-Proto")
+	if err := c.Verify(r); err != ErrSignature {
+		t.Errorf("other URL: got %v, want %v", err, ErrSignature)
+	}
+	r = signedRequest("token", "http://example.com/twilio/sms", form)
+	r.Header.Del(SignatureHeader)
+	if err := c.Verify(r); err != ErrSignature {
+		t.Errorf("no signature: got %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	resp := &Response{Verbs: []interface{}{
+		&Gather{Input: "speech", Action: "/twilio/voice", Verbs: []interface{}{&Say{Text: "Say find & a name"}}},
+		&Message{Body: "Homer <Simpson>"},
+		&Hangup{},
+	}}
+	if err := resp.Write(w); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<Response><Gather input="speech" action="/twilio/voice"><Say>Say find &amp; a name</Say></Gather>` +
+		`<Message>Homer &lt;Simpson&gt;</Message><Hangup></Hangup></Response>`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+	if got, want := w.Header().Get("Content-Type"), "text/xml; charset=utf-8"; got != want {
+		t.Errorf("got content type %q, want %q", got, want)
+	}
+}
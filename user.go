@@ -0,0 +1,43 @@
+// 2017.09.19 rjj: The caller of the APIs, whose contacts are the ones they created
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import "context"
+
+// User is the caller of an API, e.g. package contactsgrpc, who works on the
+// contacts they created.
+type User struct {
+	ID, Name, ImageURL string
+}
+
+type userKey struct{}
+
+// NewContext returns ctx with the caller u.
+func NewContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFromContext returns the caller, or nil.
+func UserFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
+
+// GetContactOf returns the contact id of userID, nil when there is none or
+// another user created it: the APIs don't tell whether other users have
+// the ID.
+func GetContactOf(db ContactDatabase, userID string, id int64) (*Contact, error) {
+	c, err := db.GetContact(id)
+	if _, ok := err.(*NotFoundError); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.CreatedByID != userID {
+		return nil, nil
+	}
+	return c, nil
+}
@@ -0,0 +1,39 @@
+// 2017.09.19 rjj: Tests of the caller of the APIs.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUserContext(t *testing.T) {
+	if u := UserFromContext(context.Background()); u != nil {
+		t.Errorf("got %+v, want nil", u)
+	}
+	homer := &User{ID: "homer", Name: "Homer Simpson"}
+	if u := UserFromContext(NewContext(context.Background(), homer)); u != homer {
+		t.Errorf("got %+v, want %+v", u, homer)
+	}
+}
+
+func TestGetContactOf(t *testing.T) {
+	db := NewMemoryDB()
+	id, err := db.AddContact(&Contact{FirstName: "Bart", CreatedByID: "homer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := GetContactOf(db, "homer", id); err != nil || c == nil || c.FirstName != "Bart" {
+		t.Errorf("own contact: got %+v, %v", c, err)
+	}
+	// Other users' contacts and missing ones look the same
+	if c, err := GetContactOf(db, "ned", id); err != nil || c != nil {
+		t.Errorf("other user's contact: got %+v, %v, want nil, nil", c, err)
+	}
+	if c, err := GetContactOf(db, "homer", id+1); err != nil || c != nil {
+		t.Errorf("missing contact: got %+v, %v, want nil, nil", c, err)
+	}
+}
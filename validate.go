@@ -0,0 +1,99 @@
+// 2017.09.07 rjj: Checking the emails and phone numbers users give
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// ValidEmail reports whether email is a bare address with a dotted domain,
+// e.g. "homer@example.com" but not "Homer <homer@example.com>" or "homer@localhost".
+func ValidEmail(email string) bool {
+	a, err := mail.ParseAddress(email)
+	if err != nil || a.Address != email || a.Name != "" {
+		return false
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// NormalizePhone returns phone in the format of the stored numbers, and
+// whether it looks like a phone number at all: 7 to 15 digits, ignoring
+// punctuation and spaces. North American numbers are written 555-0100,
+// 407-555-0100 or 1-407-555-0100, international ones +33123456789.
+func NormalizePhone(phone string) (string, bool) {
+	phone = strings.TrimSpace(phone)
+	for _, r := range phone {
+		if !strings.ContainsRune("0123456789 +-.()/", r) {
+			return "", false
+		}
+	}
+	d := Digits(phone)
+	if len(d) < 7 || len(d) > 15 {
+		return "", false
+	}
+	if strings.HasPrefix(phone, "+") {
+		return "+" + d, true
+	}
+	switch {
+	case len(d) == 7:
+		return d[:3] + "-" + d[3:], true
+	case len(d) == 10:
+		return d[:3] + "-" + d[3:6] + "-" + d[6:], true
+	case len(d) == 11 && d[0] == '1':
+		return "1-" + d[1:4] + "-" + d[4:7] + "-" + d[7:], true
+	}
+	return d, true
+}
+
+// FieldError is what is wrong with one field of a contact, see Contact.Validate.
+// Field is named like the JSON API names it, e.g. "firstName".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the fields Contact.Validate refused.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid contact: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the fields people fill in, the same way for the web forms
+// and the JSON API, and puts the phone number and tags in the format they
+// are stored in. A contact needs a first or a last name, the other fields
+// may be empty. The error is a ValidationError.
+func (c *Contact) Validate() error {
+	c.FirstName = strings.TrimSpace(c.FirstName)
+	c.LastName = strings.TrimSpace(c.LastName)
+	c.Address = strings.TrimSpace(c.Address)
+	c.Email = strings.TrimSpace(c.Email)
+	c.Tags = NormalizeTags(c.Tags)
+
+	var errs ValidationError
+	if c.FirstName == "" && c.LastName == "" {
+		errs = append(errs, FieldError{"firstName", "a first or last name is required"})
+	}
+	if c.Email != "" && !ValidEmail(c.Email) {
+		errs = append(errs, FieldError{"email", "not an email address"})
+	}
+	if c.Phone != "" {
+		if phone, ok := NormalizePhone(c.Phone); ok {
+			c.Phone = phone
+		} else {
+			errs = append(errs, FieldError{"phone", "not a phone number"})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// 2017.09.07 rjj: Tests for checking emails and phone numbers
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidEmail(t *testing.T) {
+	for _, ok := range []string{"homer@example.com", "homer.simpson+work@mail.example.co.uk"} {
+		if !ValidEmail(ok) {
+			t.Errorf("%q: got invalid, want valid", ok)
+		}
+	}
+	for _, bad := range []string{"", "homer", "homer@", "homer@localhost", "homer@example.", "homer at example dot com",
+		"Homer <homer@example.com>", " homer@example.com"} {
+		if ValidEmail(bad) {
+			t.Errorf("%q: got valid, want invalid", bad)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"5550100", "555-0100"},
+		{"(407) 555 0100", "407-555-0100"},
+		{"407.555.0100", "407-555-0100"},
+		{"1 407 555 0100", "1-407-555-0100"},
+		{"+33 1 23 45 67 89", "+33123456789"},
+		{"44 20 7946 0958", "442079460958"},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizePhone(tt.in)
+		if !ok || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q", tt.in, got, ok, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "555", "call me maybe", "1234567890123456", "555-0100 ext 2"} {
+		if got, ok := NormalizePhone(bad); ok {
+			t.Errorf("%q: got %q, want invalid", bad, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &Contact{FirstName: " Homer ", LastName: "Simpson", Email: "homer@example.com", Phone: "(407) 555 0100", Tags: "Work, family"}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	if got, want := c.FirstName, "Homer"; got != want {
+		t.Errorf("got first name %q, want %q", got, want)
+	}
+	if got, want := c.Phone, "407-555-0100"; got != want {
+		t.Errorf("got phone %q, want %q", got, want)
+	}
+	if got, want := c.Tags, NormalizeTags("Work, family"); got != want {
+		t.Errorf("got tags %q, want %q", got, want)
+	}
+
+	err := (&Contact{FirstName: " ", Email: "homer", Phone: "call me"}).Validate()
+	errs, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("got %#v, want a ValidationError", err)
+	}
+	var fields []string
+	for _, f := range errs {
+		fields = append(fields, f.Field)
+	}
+	if got, want := strings.Join(fields, ","), "firstName,email,phone"; got != want {
+		t.Errorf("got fields %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+// 2017.09.15 rjj: Contacts as vCards, and back.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package vcard
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+// ProdID is the PRODID of the cards of FromContact.
+const ProdID = "-//rjj-work//yum-contacts//EN"
+
+// uidSpace is the namespace of the UIDs of contacts.
+var uidSpace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/rjj-work/yum-contacts")
+
+// UID returns the UID of the card of the contact id, a URN that stays the
+// same across exports so address books can tell the cards apart.
+func UID(id int64) string {
+	return "urn:uuid:" + uuid.NewV5(uidSpace, strconv.FormatInt(id, 10)).String()
+}
+
+// FromContact returns c as a card of version, Version3 or Version4:
+//
+//	FN and N	the first and last name
+//	ADR		the address, as the street, it is not split in parts
+//	EMAIL, TEL	the email and the phone number, a tel: URI in 4.0 when it can be
+//	CATEGORIES	the tags
+//	REV		when it was last edited, or added
+//	UID		see UID
+func FromContact(c *contacts.Contact, version string) *Card {
+	card := &Card{Version: version}
+	card.Add("PRODID", TextValue(ProdID))
+	card.Add("UID", UID(c.ID))
+
+	fn := strings.TrimSpace(c.FirstName + " " + c.LastName)
+	if fn == "" {
+		fn = firstOf(c.Email, c.Phone, "Contact "+strconv.FormatInt(c.ID, 10))
+	}
+	card.Add("FN", TextValue(fn))
+	card.Add("N", StructuredValue(c.LastName, c.FirstName, "", "", ""))
+
+	if c.Address != "" {
+		card.Add("ADR", StructuredValue("", "", c.Address, "", "", "", ""))
+	}
+	if c.Email != "" {
+		if version == Version3 {
+			card.Add("EMAIL", TextValue(c.Email), "TYPE", "INTERNET")
+		} else {
+			card.Add("EMAIL", TextValue(c.Email))
+		}
+	}
+	if c.Phone != "" {
+		switch uri := telURI(c.Phone); {
+		case version == Version3:
+			card.Add("TEL", TextValue(c.Phone), "TYPE", "VOICE")
+		case uri != "":
+			card.Add("TEL", uri, "VALUE", "uri")
+		default:
+			card.Add("TEL", TextValue(c.Phone), "VALUE", "text")
+		}
+	}
+	if c.Tags != "" {
+		card.Add("CATEGORIES", ListValue(strings.Split(c.Tags, ",")...))
+	}
+
+	if t, err := time.Parse(contacts.CreatedDateLayout, firstOf(c.LastEdited, c.CreatedDate)); err == nil {
+		if version == Version3 {
+			card.Add("REV", t.Format("2006-01-02T15:04:05Z"))
+		} else {
+			card.Add("REV", t.Format("20060102T150405Z"))
+		}
+	}
+	return card
+}
+
+// Contact returns the contact of the card, to be checked with
+// Contact.Validate. The ID and dates are left to the database, the address
+// is the parts of the preferred ADR, comma separated.
+func (card *Card) Contact() *contacts.Contact {
+	c := &contacts.Contact{}
+	if p := card.Preferred("N"); p != nil {
+		n := p.Components()
+		c.LastName = strings.TrimSpace(n[0])
+		if len(n) > 1 {
+			c.FirstName = strings.TrimSpace(n[1])
+		}
+	}
+	if c.FirstName == "" && c.LastName == "" {
+		// Split the FN on its last space, "Homer Jay Simpson" is Homer Jay
+		if p := card.Preferred("FN"); p != nil {
+			fn := strings.TrimSpace(p.Text())
+			if i := strings.LastIndex(fn, " "); i > 0 {
+				c.FirstName, c.LastName = strings.TrimSpace(fn[:i]), fn[i+1:]
+			} else {
+				c.FirstName = fn
+			}
+		}
+	}
+
+	if p := card.Preferred("ADR"); p != nil {
+		// PO box, extended, street, locality, region, postal code, country
+		adr := append(p.Components(), make([]string, 7)...)[:7]
+		region := strings.TrimSpace(strings.TrimSpace(adr[4]) + " " + strings.TrimSpace(adr[5]))
+		var parts []string
+		for _, part := range []string{adr[0], adr[1], adr[2], adr[3], region, adr[6]} {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, strings.Replace(part, "\n", ", ", -1))
+			}
+		}
+		c.Address = strings.Join(parts, ", ")
+	}
+	if p := card.Preferred("EMAIL"); p != nil {
+		c.Email = strings.TrimSpace(strings.TrimPrefix(p.Text(), "mailto:"))
+	}
+	if p := card.Preferred("TEL"); p != nil {
+		c.Phone = phoneOf(p.Text())
+	}
+
+	var tags []string
+	for _, p := range card.All("CATEGORIES") {
+		tags = append(tags, p.List()...)
+	}
+	c.Tags = strings.Join(tags, ",")
+	return c
+}
+
+// telURI returns phone, as stored, as a global tel: URI, "" for a local number.
+func telURI(phone string) string {
+	d := contacts.Digits(phone)
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		return "tel:+" + d
+	case len(d) == 10:
+		return "tel:+1-" + d[:3] + "-" + d[3:6] + "-" + d[6:]
+	case len(d) == 11 && d[0] == '1':
+		return "tel:+1-" + d[1:4] + "-" + d[4:7] + "-" + d[7:]
+	}
+	return ""
+}
+
+// phoneOf returns the phone number of a TEL value, a tel: URI or text.
+// North American numbers lose their +1, like the numbers of the web forms.
+func phoneOf(value string) string {
+	phone := strings.TrimSpace(strings.TrimPrefix(value, "tel:"))
+	// Parameters of the URI, e.g. ;ext=102
+	if i := strings.IndexByte(phone, ';'); i >= 0 {
+		phone = phone[:i]
+	}
+	if d := contacts.Digits(phone); strings.HasPrefix(phone, "+1") && len(d) == 11 {
+		return d[1:4] + "-" + d[4:7] + "-" + d[7:]
+	}
+	return phone
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+// 2017.09.15 rjj: Tests for contacts as vCards.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package vcard
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rjj-work/yum-contacts"
+)
+
+var homer = &contacts.Contact{
+	ID:          7,
+	FirstName:   "Homer",
+	LastName:    "Simpson",
+	Address:     "742 Evergreen Terrace, Springfield",
+	Email:       "homer@example.com",
+	Phone:       "407-555-0100",
+	Tags:        "family,work",
+	CreatedDate: "2017-08-24 12:00:00",
+}
+
+func encode(t *testing.T, c *contacts.Contact, version string) string {
+	var b bytes.Buffer
+	if err := NewEncoder(&b).Encode(FromContact(c, version)); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestFromContact(t *testing.T) {
+	uid := UID(7)
+	if !strings.HasPrefix(uid, "urn:uuid:") || uid != UID(7) || uid == UID(8) {
+		t.Errorf("UID: got %q, want a stable urn:uuid per ID", uid)
+	}
+
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{Version3, []string{
+			"BEGIN:VCARD",
+			"VERSION:3.0",
+			"PRODID:-//rjj-work//yum-contacts//EN",
+			"UID:" + uid,
+			"FN:Homer Simpson",
+			"N:Simpson;Homer;;;",
+			`ADR:;;742 Evergreen Terrace\, Springfield;;;;`,
+			"EMAIL;TYPE=INTERNET:homer@example.com",
+			"TEL;TYPE=VOICE:407-555-0100",
+			"CATEGORIES:family,work",
+			"REV:2017-08-24T12:00:00Z",
+			"END:VCARD",
+			"",
+		}},
+		{Version4, []string{
+			"BEGIN:VCARD",
+			"VERSION:4.0",
+			"PRODID:-//rjj-work//yum-contacts//EN",
+			"UID:" + uid,
+			"FN:Homer Simpson",
+			"N:Simpson;Homer;;;",
+			`ADR:;;742 Evergreen Terrace\, Springfield;;;;`,
+			"EMAIL:homer@example.com",
+			"TEL;VALUE=uri:tel:+1-407-555-0100",
+			"CATEGORIES:family,work",
+			"REV:20170824T120000Z",
+			"END:VCARD",
+			"",
+		}},
+	}
+	for _, tt := range tests {
+		if got, want := encode(t, homer, tt.version), strings.Join(tt.want, "\r\n"); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.version, got, want)
+		}
+	}
+}
+
+func TestContactRoundTrip(t *testing.T) {
+	for _, c := range []*contacts.Contact{
+		homer,
+		{FirstName: "Marge", Phone: "+33123456789"},
+		{LastName: "Flanders", Phone: "555-0100", Address: "744 Evergreen Terrace; Springfield"},
+	} {
+		for _, version := range []string{Version3, Version4} {
+			card, err := NewDecoder(strings.NewReader(encode(t, c, version))).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := card.Contact()
+			want := *c
+			want.ID, want.CreatedDate = 0, ""
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("%s: got %+v, want %+v", version, got, &want)
+			}
+		}
+	}
+}
+
+func TestContact(t *testing.T) {
+	// As other address books write them
+	const vcf = "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Ned Flanders\r\n" +
+		"ADR;TYPE=HOME:;;744 Evergreen Terrace;Springfield;OR;97475;USA\r\n" +
+		"ADR;TYPE=WORK,pref:;Suite 1;The Leftorium;Springfield;;;\r\n" +
+		"EMAIL:ned@example.com\r\n" +
+		"EMAIL;TYPE=INTERNET,pref:ned@leftorium.example.com\r\n" +
+		"TEL;TYPE=CELL:+1 (407) 555-0100\r\n" +
+		"CATEGORIES:church\r\n" +
+		"CATEGORIES:neighbors\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"FN:Cher\r\n" +
+		"TEL;VALUE=uri;PREF=1:tel:+33-1-23-45-67-89;ext=2\r\n" +
+		"ADR:;;1 Rue de Rivoli;Paris;;75001;France\r\n" +
+		"END:VCARD\r\n"
+	d := NewDecoder(strings.NewReader(vcf))
+	want := []*contacts.Contact{
+		{FirstName: "Ned", LastName: "Flanders", Address: "Suite 1, The Leftorium, Springfield",
+			Email: "ned@leftorium.example.com", Phone: "407-555-0100", Tags: "church,neighbors"},
+		{FirstName: "Cher", Address: "1 Rue de Rivoli, Paris, 75001, France", Phone: "+33-1-23-45-67-89"},
+	}
+	for _, w := range want {
+		card, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := card.Contact(); !reflect.DeepEqual(got, w) {
+			t.Errorf("got  %+v\nwant %+v", got, w)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+// 2017.09.15 rjj: vCard 3.0 and 4.0 encoding and decoding.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package vcard reads and writes vCards, the .vcf files of address books,
+// and maps them to and from contacts (see contact.go).
+//
+// It handles the syntax shared by vCard 3.0 (RFC 2426) and 4.0 (RFC 6350):
+// folded lines, groups, parameters, and the escaping of text, structured and
+// list values. Cards of version 2.1 are read as far as they share it.
+package vcard
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Versions of the cards.
+const (
+	Version3 = "3.0"
+	Version4 = "4.0"
+)
+
+// maxLine bounds the unfolded lines, e.g. of an inline PHOTO.
+const maxLine = 1 << 20
+
+// Card is a vCard.
+type Card struct {
+	// Version is the VERSION of the card, e.g. "3.0".
+	Version string
+	// Props are the properties, in order, except BEGIN, END and VERSION.
+	Props []*Property
+	// Line is the line number of BEGIN:VCARD in a decoded file.
+	Line int
+}
+
+// Property is a content line of a card, e.g. TEL;TYPE=work:407-555-0100.
+type Property struct {
+	Group string
+	// Name is in upper case, e.g. "TEL".
+	Name string
+	// Params are the parameters by their upper case name, e.g. "TYPE".
+	Params map[string][]string
+	// Value is as written in the card, escaped, see Text, Components and List.
+	Value string
+}
+
+// Add adds a property to the card, params are name and value pairs.
+func (c *Card) Add(name, value string, params ...string) *Property {
+	p := &Property{Name: strings.ToUpper(name), Value: value}
+	for i := 0; i+1 < len(params); i += 2 {
+		p.AddParam(params[i], params[i+1])
+	}
+	c.Props = append(c.Props, p)
+	return p
+}
+
+// All returns the properties named name.
+func (c *Card) All(name string) []*Property {
+	var props []*Property
+	for _, p := range c.Props {
+		if p.Name == strings.ToUpper(name) {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Preferred returns the preferred property named name: the one with the
+// lowest PREF (4.0), else the first with TYPE=pref (3.0), else the first one.
+// It is nil when there are none.
+func (c *Card) Preferred(name string) *Property {
+	var best *Property
+	bestPref := 101
+	for _, p := range c.All(name) {
+		pref := 100
+		if v := p.Param("PREF"); v != "" {
+			fmt.Sscan(v, &pref)
+		} else if p.HasType("pref") {
+			pref = 1
+		}
+		if pref < bestPref {
+			best, bestPref = p, pref
+		}
+	}
+	return best
+}
+
+// AddParam adds a parameter value.
+func (p *Property) AddParam(name, value string) {
+	if p.Params == nil {
+		p.Params = map[string][]string{}
+	}
+	name = strings.ToUpper(name)
+	p.Params[name] = append(p.Params[name], value)
+}
+
+// Param returns the first value of the parameter name, "" for none.
+func (p *Property) Param(name string) string {
+	if v := p.Params[strings.ToUpper(name)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// HasType returns whether typ is one of the TYPE parameters, e.g. "work".
+func (p *Property) HasType(typ string) bool {
+	for _, v := range p.Params["TYPE"] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(t, typ) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Text returns the value as text, unescaped.
+func (p *Property) Text() string {
+	return unescape(p.Value)
+}
+
+// Components returns the components of a structured value, e.g. of N or
+// ADR, unescaped.
+func (p *Property) Components() []string {
+	return splitUnescaped(p.Value, ';')
+}
+
+// List returns the items of a list value, e.g. of CATEGORIES, unescaped.
+func (p *Property) List() []string {
+	return splitUnescaped(p.Value, ',')
+}
+
+// TextValue returns s escaped as a text value.
+func TextValue(s string) string {
+	return escaper.Replace(s)
+}
+
+// StructuredValue returns the components escaped as a structured value.
+func StructuredValue(components ...string) string {
+	for i, c := range components {
+		components[i] = TextValue(c)
+	}
+	return strings.Join(components, ";")
+}
+
+// ListValue returns the items escaped as a list value.
+func ListValue(items ...string) string {
+	for i, item := range items {
+		items[i] = TextValue(item)
+	}
+	return strings.Join(items, ",")
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\r\n", `\n`, "\n", `\n`, ",", `\,`, ";", `\;`)
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// splitUnescaped splits s on the sep that are not escaped, and unescapes
+// the parts.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, unescape(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescape(s[start:]))
+}
+
+// Encoder writes cards to a stream.
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+// NewEncoder returns an encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes c, with CRLF line endings and lines folded at 75 octets.
+func (e *Encoder) Encode(c *Card) error {
+	e.line("BEGIN:VCARD")
+	e.line("VERSION:" + c.Version)
+	for _, p := range c.Props {
+		e.line(p.String())
+	}
+	e.line("END:VCARD")
+	return e.err
+}
+
+// line writes a content line, folded.
+func (e *Encoder) line(s string) {
+	if e.err != nil {
+		return
+	}
+	var b bytes.Buffer
+	for limit := 75; len(s) > limit; limit = 74 {
+		// Don't cut a UTF-8 sequence
+		i := limit
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		b.WriteString(s[:i])
+		b.WriteString("\r\n ")
+		s = s[i:]
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, e.err = io.WriteString(e.w, b.String())
+}
+
+// String returns the content line of p, unfolded.
+func (p *Property) String() string {
+	var b bytes.Buffer
+	if p.Group != "" {
+		b.WriteString(p.Group)
+		b.WriteByte('.')
+	}
+	b.WriteString(p.Name)
+	for _, name := range sortedKeys(p.Params) {
+		b.WriteByte(';')
+		b.WriteString(name)
+		b.WriteByte('=')
+		for i, v := range p.Params[name] {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			v = strings.Replace(v, `"`, "", -1)
+			if strings.ContainsAny(v, ":;,") {
+				v = `"` + v + `"`
+			}
+			b.WriteString(v)
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(p.Value)
+	return b.String()
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SyntaxError is a card, or text between cards, the Decoder could not read.
+// The Decoder skipped it, the next Decode reads the next card.
+type SyntaxError struct {
+	// Line is the line number of the error.
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("vcard: line %d: %s", e.Line, e.Msg)
+}
+
+// Decoder reads cards from a stream.
+type Decoder struct {
+	s *bufio.Scanner
+	// line is the number of the last physical line read.
+	line int
+	// next is the physical line read ahead, to unfold, ok when there is one.
+	next   string
+	nextOK bool
+}
+
+// NewDecoder returns a decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), maxLine)
+	d := &Decoder{s: s}
+	d.advance()
+	return d
+}
+
+func (d *Decoder) advance() {
+	d.nextOK = d.s.Scan()
+	if d.nextOK {
+		d.line++
+		d.next = strings.TrimSuffix(d.s.Text(), "\r")
+		if d.line == 1 {
+			d.next = strings.TrimPrefix(d.next, "\ufeff")
+		}
+	}
+}
+
+// readLine returns the next content line, unfolded, and its line number.
+func (d *Decoder) readLine() (string, int, error) {
+	if !d.nextOK {
+		if err := d.s.Err(); err != nil {
+			return "", d.line, err
+		}
+		return "", d.line, io.EOF
+	}
+	n := d.line
+	line := d.next
+	d.advance()
+	for d.nextOK && (strings.HasPrefix(d.next, " ") || strings.HasPrefix(d.next, "\t")) {
+		line += d.next[1:]
+		if len(line) > maxLine {
+			return "", n, bufio.ErrTooLong
+		}
+		d.advance()
+	}
+	return line, n, nil
+}
+
+// Decode returns the next card, io.EOF after the last one. It returns a
+// *SyntaxError for a card it could not read, and goes on with the next one;
+// other errors are those of the stream.
+func (d *Decoder) Decode() (*Card, error) {
+	// Skip to BEGIN:VCARD
+	var junk *SyntaxError
+	for {
+		if d.nextOK && isBegin(d.next) {
+			if junk != nil {
+				return nil, junk
+			}
+			break
+		}
+		line, n, err := d.readLine()
+		if err == io.EOF && junk != nil {
+			return nil, junk
+		}
+		if err != nil {
+			return nil, err
+		}
+		if junk == nil && strings.TrimSpace(line) != "" {
+			junk = &SyntaxError{n, "expected BEGIN:VCARD"}
+		}
+	}
+
+	_, begin, _ := d.readLine()
+	card := &Card{Line: begin}
+	var bad *SyntaxError
+	for {
+		// Nested cards of 2.1, e.g. AGENT, are not supported: take it as the
+		// next card, so only this one is lost.
+		if d.nextOK && isBegin(d.next) {
+			return nil, &SyntaxError{begin, "no END:VCARD before the next BEGIN:VCARD"}
+		}
+		line, n, err := d.readLine()
+		if err == io.EOF {
+			return nil, &SyntaxError{begin, "no END:VCARD"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseProperty(line)
+		if err != nil {
+			if bad == nil {
+				bad = &SyntaxError{n, err.Error()}
+			}
+			continue
+		}
+		switch p.Name {
+		case "END":
+			if bad != nil {
+				return nil, bad
+			}
+			switch card.Version {
+			case "2.1", Version3, Version4:
+			case "":
+				return nil, &SyntaxError{begin, "no VERSION"}
+			default:
+				return nil, &SyntaxError{begin, fmt.Sprintf("unsupported VERSION %q", card.Version)}
+			}
+			return card, nil
+		case "VERSION":
+			card.Version = strings.TrimSpace(p.Value)
+		default:
+			card.Props = append(card.Props, p)
+		}
+	}
+}
+
+func isBegin(line string) bool {
+	return strings.EqualFold(strings.TrimSpace(line), "BEGIN:VCARD")
+}
+
+// parseProperty parses a content line, [group.]name(;param)*:value.
+func parseProperty(line string) (*Property, error) {
+	p := &Property{}
+	i := strings.IndexAny(line, ";:")
+	if i < 0 {
+		return nil, fmt.Errorf("no ':' in %q", line)
+	}
+	name := line[:i]
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		p.Group, name = name[:dot], name[dot+1:]
+	}
+	if !isName(name) {
+		return nil, fmt.Errorf("bad property name %q", name)
+	}
+	p.Name = strings.ToUpper(name)
+
+	rest := line[i:]
+	for rest[0] == ';' {
+		rest = rest[1:]
+		// name=value(,value)*, or a bare TYPE value of 2.1
+		end := strings.IndexAny(rest, "=;:")
+		if end < 0 {
+			return nil, fmt.Errorf("no ':' in %q", line)
+		}
+		pname := rest[:end]
+		if !isName(pname) {
+			return nil, fmt.Errorf("bad parameter name %q", pname)
+		}
+		if rest[end] != '=' {
+			p.AddParam("TYPE", pname)
+			rest = rest[end:]
+			continue
+		}
+		rest = rest[end+1:]
+		for {
+			var v string
+			if strings.HasPrefix(rest, `"`) {
+				q := strings.IndexByte(rest[1:], '"')
+				if q < 0 {
+					return nil, fmt.Errorf("unterminated quoted parameter in %q", line)
+				}
+				v, rest = rest[1:q+1], rest[q+2:]
+			} else {
+				end := strings.IndexAny(rest, ",;:")
+				if end < 0 {
+					return nil, fmt.Errorf("no ':' in %q", line)
+				}
+				v, rest = rest[:end], rest[end:]
+			}
+			p.AddParam(pname, v)
+			if rest == "" {
+				return nil, fmt.Errorf("no ':' in %q", line)
+			}
+			if rest[0] != ',' {
+				break
+			}
+			rest = rest[1:]
+		}
+		if rest[0] != ';' && rest[0] != ':' {
+			return nil, fmt.Errorf("bad parameter in %q", line)
+		}
+	}
+	p.Value = rest[1:]
+	return p, nil
+}
+
+// isName returns whether s is a property or parameter name: letters,
+// digits and dashes.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
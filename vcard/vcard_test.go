@@ -0,0 +1,147 @@
+// 2017.09.15 rjj: Tests for vCard encoding and decoding.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package vcard
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	card := &Card{Version: Version4}
+	card.Add("FN", TextValue("Homer J. Simpson, Jr; the 1st\nof his name"))
+	card.Add("ADR", StructuredValue("", "", "742 Evergreen Terrace", "Springfield", "", "", ""), "TYPE", "home", "LABEL", "742 Evergreen Terrace; Springfield")
+	card.Add("NOTE", TextValue(strings.Repeat("ü", 50)))
+
+	var b bytes.Buffer
+	if err := NewEncoder(&b).Encode(card); err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		`FN:Homer J. Simpson\, Jr\; the 1st\nof his name` + "\r\n" +
+		`ADR;LABEL="742 Evergreen Terrace; Springfield";TYPE=home:;;742 Evergreen Te` + "\r\n" +
+		` rrace;Springfield;;;` + "\r\n" +
+		"NOTE:" + strings.Repeat("ü", 35) + "\r\n" +
+		" " + strings.Repeat("ü", 15) + "\r\n" +
+		"END:VCARD\r\n"
+	if got := b.String(); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+	for _, line := range strings.Split(b.String(), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+
+	// And back
+	got, err := NewDecoder(&b).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Line != 1 {
+		t.Errorf("got line %d, want 1", got.Line)
+	}
+	got.Line = 0
+	if !reflect.DeepEqual(got, card) {
+		t.Errorf("decoded: got %+v, want %+v", got, card)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	const vcf = "BEGIN:VCARD\n" +
+		"VERSION:3.0\n" +
+		"item1.EMAIL;type=INTERNET;type=pref:bart@example.com\n" +
+		"N:Simpson;Bart;;;\n" +
+		"NOTE:folded with a tab\n" +
+		"\tand a space\n" +
+		"  here\n" +
+		"CATEGORIES:family,kids\\, pranksters\n" +
+		"END:VCARD\n" +
+		"\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:3.0\n" +
+		"FN Lisa Simpson\n" +
+		"END:VCARD\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:2.1\n" +
+		"TEL;HOME;VOICE:555-0100\n" +
+		"END:VCARD\n" +
+		"junk\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:4.0\n" +
+		"FN:Maggie\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:5.0\n" +
+		"END:VCARD\n" +
+		"BEGIN:VCARD\n" +
+		"VERSION:4.0\n" +
+		"FN:Abe\n"
+
+	d := NewDecoder(strings.NewReader(vcf))
+	var got []string
+	for {
+		card, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err, ok := err.(*SyntaxError); ok {
+			got = append(got, err.Error())
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, card.Version)
+		switch card.Line {
+		case 1:
+			email := card.Preferred("email")
+			if email.Group != "item1" || email.Text() != "bart@example.com" || !email.HasType("pref") {
+				t.Errorf("email: got %+v", email)
+			}
+			if n := card.Preferred("N").Components(); !reflect.DeepEqual(n, []string{"Simpson", "Bart", "", "", ""}) {
+				t.Errorf("N: got %q", n)
+			}
+			if note := card.Preferred("NOTE").Text(); note != "folded with a taband a space here" {
+				t.Errorf("NOTE: got %q", note)
+			}
+			if tags := card.Preferred("CATEGORIES").List(); !reflect.DeepEqual(tags, []string{"family", "kids, pranksters"}) {
+				t.Errorf("CATEGORIES: got %q", tags)
+			}
+		case 15:
+			if tel := card.Preferred("TEL"); !tel.HasType("HOME") || !tel.HasType("voice") {
+				t.Errorf("2.1 TEL: got %+v", tel)
+			}
+		}
+	}
+	want := []string{
+		"3.0",
+		`vcard: line 13: no ':' in "FN Lisa Simpson"`,
+		"2.1",
+		"vcard: line 19: expected BEGIN:VCARD",
+		"vcard: line 20: no END:VCARD before the next BEGIN:VCARD",
+		`vcard: line 23: unsupported VERSION "5.0"`,
+		"vcard: line 26: no END:VCARD",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestPreferred(t *testing.T) {
+	card := &Card{Version: Version4}
+	card.Add("TEL", "1")
+	card.Add("TEL", "2", "PREF", "2")
+	card.Add("TEL", "3", "PREF", "1")
+	if got := card.Preferred("TEL").Value; got != "3" {
+		t.Errorf("got %q, want 3", got)
+	}
+	if got := card.Preferred("EMAIL"); got != nil {
+		t.Errorf("got %v, want none", got)
+	}
+}
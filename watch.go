@@ -0,0 +1,204 @@
+// 2017.09.13 rjj: Telling watchers about the contacts that change, for the gRPC WatchContacts stream
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ChangeType is what happened to a contact, see ContactChange.
+type ChangeType int
+
+const (
+	ContactAdded ChangeType = iota + 1
+	ContactUpdated
+	ContactDeleted
+)
+
+// ContactChange is a contact added, updated or deleted, as it is after the
+// change, or was before a delete.
+type ContactChange struct {
+	Type    ChangeType
+	Contact *Contact
+}
+
+// watchBuffer is how many changes a watcher may fall behind by before it is
+// dropped.
+const watchBuffer = 64
+
+// journalSize is how many of the latest changes are kept for Changes.
+const journalSize = 4096
+
+// Revision is a point in the changes made through a WatchedDatabase, see Changes.
+type Revision struct {
+	// Epoch tells the WatchedDatabases apart, e.g. before and after a restart.
+	Epoch int64
+	// Seq counts the changes.
+	Seq int64
+}
+
+func (r Revision) String() string {
+	return fmt.Sprintf("%d-%d", r.Epoch, r.Seq)
+}
+
+// ParseRevision parses the String of a Revision.
+func ParseRevision(s string) (Revision, error) {
+	var r Revision
+	if n, err := fmt.Sscanf(s, "%d-%d", &r.Epoch, &r.Seq); err != nil || n != 2 || r.String() != s {
+		return r, fmt.Errorf("contacts: bad revision %q", s)
+	}
+	return r, nil
+}
+
+// WatchedDatabase is a ContactDatabase that tells its watchers about the
+// contacts added, updated and deleted through it, and keeps the latest
+// changes for Changes. Only changes made in this process are seen, app.yaml
+// runs a single instance.
+type WatchedDatabase struct {
+	ContactDatabase
+
+	mu       sync.Mutex
+	watchers map[chan ContactChange]string // to the user ID watched, "" for all
+	revision Revision
+	journal  []ContactChange // the latest changes, the last one at revision.Seq
+}
+
+// Ensure WatchedDatabase conforms to the ContactDatabase interface.
+var _ ContactDatabase = &WatchedDatabase{}
+
+// NewWatchedDatabase returns db, watched.
+func NewWatchedDatabase(db ContactDatabase) *WatchedDatabase {
+	return &WatchedDatabase{
+		ContactDatabase: db,
+		watchers:        map[chan ContactChange]string{},
+		revision:        Revision{Epoch: time.Now().UnixNano()},
+	}
+}
+
+// Revision returns the revision of the latest change.
+func (db *WatchedDatabase) Revision() Revision {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.revision
+}
+
+// Changes returns the changes to the contacts created by userID, or of all
+// users for "", made after the revision since, oldest first, and the revision
+// of the latest change. ok is false when the changes since are no longer
+// all kept, or since is of another WatchedDatabase: the caller must start
+// over from the list of contacts.
+func (db *WatchedDatabase) Changes(userID string, since Revision) (changes []ContactChange, latest Revision, ok bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	latest = db.revision
+	oldest := latest.Seq - int64(len(db.journal))
+	if since.Epoch != latest.Epoch || since.Seq > latest.Seq || since.Seq < oldest {
+		return nil, latest, false
+	}
+	for _, c := range db.journal[since.Seq-oldest:] {
+		if userID == "" || userID == c.Contact.CreatedByID {
+			changes = append(changes, c)
+		}
+	}
+	return changes, latest, true
+}
+
+// Watch returns the changes to the contacts created by userID, or of all
+// users for "", from now on. The channel is closed by stop, or when the
+// watcher falls behind by more than a few dozen changes.
+func (db *WatchedDatabase) Watch(userID string) (changes <-chan ContactChange, stop func()) {
+	ch := make(chan ContactChange, watchBuffer)
+	db.mu.Lock()
+	db.watchers[ch] = userID
+	db.mu.Unlock()
+
+	return ch, func() {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		if _, ok := db.watchers[ch]; ok {
+			delete(db.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish journals the change, and sends it to the watchers of the contact's user.
+func (db *WatchedDatabase) publish(t ChangeType, c *Contact) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.revision.Seq++
+	if len(db.journal) == journalSize {
+		db.journal = append(db.journal[:0], db.journal[1:]...)
+	}
+	db.journal = append(db.journal, ContactChange{Type: t, Contact: c})
+
+	for ch, userID := range db.watchers {
+		if userID != "" && userID != c.CreatedByID {
+			continue
+		}
+		select {
+		case ch <- ContactChange{Type: t, Contact: c}:
+		default:
+			log.Printf("Contact watcher of %q fell behind, dropping it", userID)
+			delete(db.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishStored publishes the contact id as stored, with the dates the
+// database sets.
+func (db *WatchedDatabase) publishStored(t ChangeType, id int64) {
+	c, err := db.ContactDatabase.GetContact(id)
+	if err != nil {
+		log.Printf("Could not get changed contact %d for the watchers: %v", id, err)
+		return
+	}
+	db.publish(t, c)
+}
+
+// AddContact saves a given contact, assigning it a new ID.
+func (db *WatchedDatabase) AddContact(b *Contact) (int64, error) {
+	id, err := db.ContactDatabase.AddContact(b)
+	if err == nil {
+		db.publishStored(ContactAdded, id)
+	}
+	return id, err
+}
+
+// AddContacts saves the given contacts, assigning them new IDs in order.
+func (db *WatchedDatabase) AddContacts(cs []*Contact) ([]int64, error) {
+	ids, err := db.ContactDatabase.AddContacts(cs)
+	if err == nil {
+		for _, id := range ids {
+			db.publishStored(ContactAdded, id)
+		}
+	}
+	return ids, err
+}
+
+// UpdateContact updates the entry for a given contact.
+func (db *WatchedDatabase) UpdateContact(b *Contact) error {
+	err := db.ContactDatabase.UpdateContact(b)
+	if err == nil {
+		db.publishStored(ContactUpdated, b.ID)
+	}
+	return err
+}
+
+// DeleteContact removes a given contact by its ID.
+func (db *WatchedDatabase) DeleteContact(id int64) error {
+	// Who to tell, the contact is gone afterwards
+	c, getErr := db.ContactDatabase.GetContact(id)
+	err := db.ContactDatabase.DeleteContact(id)
+	if err == nil && getErr == nil {
+		db.publish(ContactDeleted, c)
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+// 2017.09.13 rjj: Tests for watching contact changes
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package contacts
+
+import "testing"
+
+func TestWatchedDatabase(t *testing.T) {
+	db := NewWatchedDatabase(NewMemoryDB())
+	mine, stopMine := db.Watch("homer")
+	all, stopAll := db.Watch("")
+	defer stopAll()
+
+	id, err := db.AddContact(&Contact{FirstName: "Bart", CreatedByID: "homer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateContact(&Contact{ID: id, FirstName: "Bart", LastName: "Simpson", CreatedByID: "homer"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddContact(&Contact{FirstName: "Ned", CreatedByID: "ned"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteContact(id); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		t    ChangeType
+		name string
+	}{{ContactAdded, "Bart "}, {ContactUpdated, "Bart Simpson"}, {ContactDeleted, "Bart Simpson"}}
+	for _, w := range want {
+		c := <-mine
+		if got := c.Contact.FirstName + " " + c.Contact.LastName; c.Type != w.t || got != w.name {
+			t.Errorf("got %v %q, want %v %q", c.Type, got, w.t, w.name)
+		}
+	}
+	if len(mine) != 0 {
+		t.Errorf("got %d more changes, want none of other users", len(mine))
+	}
+	if got, want := len(all), 4; got != want {
+		t.Errorf("all users: got %d changes, want %d", got, want)
+	}
+	stopMine()
+	if _, ok := <-mine; ok {
+		t.Error("got a change after stop")
+	}
+}
+
+func TestWatchFallingBehind(t *testing.T) {
+	db := NewWatchedDatabase(NewMemoryDB())
+	changes, stop := db.Watch("")
+	defer stop()
+	for i := 0; i <= watchBuffer; i++ {
+		db.AddContact(&Contact{FirstName: "Homer"})
+	}
+	n := 0
+	for range changes {
+		n++
+	}
+	if n != watchBuffer {
+		t.Errorf("got %d changes before the channel closed, want %d", n, watchBuffer)
+	}
+}
+
+func TestWatchAddContacts(t *testing.T) {
+	db := NewWatchedDatabase(NewMemoryDB())
+	changes, stop := db.Watch("homer")
+	defer stop()
+
+	ids, err := db.AddContacts([]*Contact{
+		{FirstName: "Bart", CreatedByID: "homer"},
+		{FirstName: "Lisa", CreatedByID: "homer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("got IDs %v, want [1 2]", ids)
+	}
+	for _, want := range []string{"Bart", "Lisa"} {
+		if c := <-changes; c.Type != ContactAdded || c.Contact.FirstName != want || c.Contact.CreatedDate == "" {
+			t.Errorf("got %v %+v, want %s added", c.Type, c.Contact, want)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	db := NewWatchedDatabase(NewMemoryDB())
+	start := db.Revision()
+	id, _ := db.AddContact(&Contact{FirstName: "Bart", CreatedByID: "homer"})
+	db.AddContact(&Contact{FirstName: "Ned", CreatedByID: "ned"})
+	middle := db.Revision()
+	db.DeleteContact(id)
+
+	changes, latest, ok := db.Changes("homer", start)
+	if !ok || len(changes) != 2 || changes[0].Type != ContactAdded || changes[1].Type != ContactDeleted {
+		t.Errorf("got %v %v, want Bart added and deleted", changes, ok)
+	}
+	if latest != db.Revision() || latest.Seq != start.Seq+3 {
+		t.Errorf("got revision %v, want %v", latest, db.Revision())
+	}
+	if changes, _, ok := db.Changes("", middle); !ok || len(changes) != 1 {
+		t.Errorf("since the middle: got %v %v, want the delete", changes, ok)
+	}
+	if changes, _, ok := db.Changes("", latest); !ok || len(changes) != 0 {
+		t.Errorf("since the latest: got %v %v, want none", changes, ok)
+	}
+
+	if r, err := ParseRevision(latest.String()); err != nil || r != latest {
+		t.Errorf("got %v %v, want %v", r, err, latest)
+	}
+	other := NewWatchedDatabase(NewMemoryDB())
+	if _, _, ok := other.Changes("", latest); ok {
+		t.Error("got the changes of another database")
+	}
+	for i := 0; i <= journalSize; i++ {
+		db.AddContact(&Contact{FirstName: "Homer"})
+	}
+	if _, _, ok := db.Changes("", latest); ok {
+		t.Error("got changes no longer kept")
+	}
+	if _, _, ok := db.Changes("", db.Revision()); !ok {
+		t.Error("got no changes since the latest")
+	}
+}
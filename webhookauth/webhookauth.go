@@ -0,0 +1,147 @@
+// 2017.08.30 rjj: Authentication of incoming webhook calls.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package webhookauth verifies that a webhook call comes from the platform
+// we configured, e.g. API.AI, and not from anyone who found the URL.
+//
+// API.AI fulfillment settings support basic authentication and custom
+// headers, either (or both) can be required. Callers that can sign the
+// request body may also be required to send an HMAC-SHA256 signature.
+package webhookauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DefaultSignatureHeader carries the body signature when
+// Config.SignatureHeader is not set.
+const DefaultSignatureHeader = "X-Hub-Signature"
+
+// Errors returned by Verify.
+var (
+	ErrBasicAuth = errors.New("webhookauth: missing or wrong basic auth credentials")
+	ErrHeader    = errors.New("webhookauth: missing or wrong secret header")
+	ErrSignature = errors.New("webhookauth: missing or wrong body signature")
+)
+
+// Errors returned by Validate.
+var (
+	ErrBasicAuthConfig = errors.New("webhookauth: basic auth needs both a username and a password")
+	ErrHeaderConfig    = errors.New("webhookauth: the secret header needs both a name and a value")
+)
+
+// Config says which checks a request must pass. Empty fields disable the
+// corresponding check.
+type Config struct {
+	// Username and Password for basic authentication.
+	Username, Password string
+
+	// HeaderName must be present with value HeaderValue.
+	HeaderName, HeaderValue string
+
+	// HMACSecret is the key for the HMAC-SHA256 signature of the request
+	// body, sent hex encoded (optionally prefixed with "sha256=") in
+	// SignatureHeader.
+	HMACSecret      []byte
+	SignatureHeader string
+}
+
+// Enabled reports whether c requires any check at all.
+func (c *Config) Enabled() bool {
+	return c != nil && (c.Username != "" || c.Password != "" || c.HeaderName != "" || c.HeaderValue != "" ||
+		len(c.HMACSecret) > 0)
+}
+
+// Validate reports checks that are only half configured, e.g. a password
+// without a username, which would otherwise let anyone with the username in.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if (c.Username == "") != (c.Password == "") {
+		return ErrBasicAuthConfig
+	}
+	if (c.HeaderName == "") != (c.HeaderValue == "") {
+		return ErrHeaderConfig
+	}
+	return nil
+}
+
+// Verify checks r against c. If a body signature is required, the body is
+// read and replaced, so handlers can still read it.
+func (c *Config) Verify(r *http.Request) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if c.Username != "" || c.Password != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !equal(user, c.Username) || !equal(pass, c.Password) {
+			return ErrBasicAuth
+		}
+	}
+
+	if c.HeaderName != "" || c.HeaderValue != "" {
+		if !equal(r.Header.Get(c.HeaderName), c.HeaderValue) {
+			return ErrHeader
+		}
+	}
+
+	if len(c.HMACSecret) > 0 {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		header := c.SignatureHeader
+		if header == "" {
+			header = DefaultSignatureHeader
+		}
+		got, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(header), "sha256="))
+		if err != nil || !hmac.Equal(got, Sign(c.HMACSecret, body)) {
+			return ErrSignature
+		}
+	}
+
+	return nil
+}
+
+// Wrap returns a handler that responds 401 Unauthorized to requests that
+// fail Verify, and passes the others on to h.
+func (c *Config) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Verify(r); err != nil {
+			log.Printf("Webhook call from %s rejected: %v", r.RemoteAddr, err)
+			if c.Username != "" || c.Password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="webhook"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Sign returns the HMAC-SHA256 of body with secret.
+func Sign(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// equal compares secrets in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
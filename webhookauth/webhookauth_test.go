@@ -0,0 +1,124 @@
+// 2017.08.30 rjj: Tests for webhook authentication.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package webhookauth
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const body = `{"result":{"metadata":{"intentName":"number_of_contacts"}}}`
+
+func newRequest() *http.Request {
+	return httptest.NewRequest("POST", "/contactsWebhook", strings.NewReader(body))
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	sig := "sha256=" + hex.EncodeToString(Sign(secret, []byte(body)))
+
+	tests := []struct {
+		name  string
+		cfg   *Config
+		setup func(r *http.Request)
+		want  error
+	}{
+		{"disabled", nil, func(r *http.Request) {}, nil},
+		{"basic ok", &Config{Username: "apiai", Password: "pw"},
+			func(r *http.Request) { r.SetBasicAuth("apiai", "pw") }, nil},
+		{"basic wrong", &Config{Username: "apiai", Password: "pw"},
+			func(r *http.Request) { r.SetBasicAuth("apiai", "nope") }, ErrBasicAuth},
+		{"basic missing", &Config{Username: "apiai", Password: "pw"},
+			func(r *http.Request) {}, ErrBasicAuth},
+		{"header ok", &Config{HeaderName: "X-Webhook-Secret", HeaderValue: "abc"},
+			func(r *http.Request) { r.Header.Set("X-Webhook-Secret", "abc") }, nil},
+		{"header wrong", &Config{HeaderName: "X-Webhook-Secret", HeaderValue: "abc"},
+			func(r *http.Request) { r.Header.Set("X-Webhook-Secret", "abd") }, ErrHeader},
+		{"hmac ok", &Config{HMACSecret: secret},
+			func(r *http.Request) { r.Header.Set(DefaultSignatureHeader, sig) }, nil},
+		{"hmac without prefix", &Config{HMACSecret: secret},
+			func(r *http.Request) { r.Header.Set(DefaultSignatureHeader, strings.TrimPrefix(sig, "sha256=")) }, nil},
+		{"hmac wrong", &Config{HMACSecret: []byte("other")},
+			func(r *http.Request) { r.Header.Set(DefaultSignatureHeader, sig) }, ErrSignature},
+		{"hmac missing", &Config{HMACSecret: secret},
+			func(r *http.Request) {}, ErrSignature},
+		{"password only", &Config{Password: "pw"},
+			func(r *http.Request) { r.SetBasicAuth("anyone", "nope") }, ErrBasicAuth},
+	}
+	for _, tt := range tests {
+		r := newRequest()
+		tt.setup(r)
+		if got := tt.cfg.Verify(r); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		cfg  *Config
+		want error
+	}{
+		{nil, nil},
+		{&Config{Username: "apiai", Password: "pw"}, nil},
+		{&Config{HeaderName: "X-Webhook-Secret", HeaderValue: "abc"}, nil},
+		{&Config{Password: "pw"}, ErrBasicAuthConfig},
+		{&Config{Username: "apiai"}, ErrBasicAuthConfig},
+		{&Config{HeaderName: "X-Webhook-Secret"}, ErrHeaderConfig},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Validate(); got != tt.want {
+			t.Errorf("%+v: got %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyKeepsBody(t *testing.T) {
+	secret := []byte("s3cret")
+	r := newRequest()
+	r.Header.Set(DefaultSignatureHeader, hex.EncodeToString(Sign(secret, []byte(body))))
+
+	if err := (&Config{HMACSecret: secret}).Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != body {
+		t.Errorf("got body %q, want %q", got, body)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	cfg := &Config{Username: "apiai", Password: "pw"}
+	ran := false
+	h := cfg.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ran = true
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newRequest())
+	if got, want := w.Code, http.StatusUnauthorized; got != want {
+		t.Errorf("got status %d, want %d", got, want)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("want WWW-Authenticate header")
+	}
+	if ran {
+		t.Error("handler ran for unauthenticated request")
+	}
+
+	r := newRequest()
+	r.SetBasicAuth("apiai", "pw")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if !ran {
+		t.Error("handler did not run for authenticated request")
+	}
+}
@@ -0,0 +1,350 @@
+// 2017.09.02 rjj: Replay of webhook conversations.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package webhooksim replays API.AI webhook conversations against an
+// http.Handler in-process, so intents can be tested without a running app,
+// API.AI or Cloud SQL.
+//
+// A Script is a list of turns, each one a webhook request: either a complete
+// request file, like the ones in manual-testing/, or a shorthand giving the
+// intent, query and parameters. Like API.AI, the simulator keeps the output
+// contexts of each response and sends them with the following turns until
+// their lifespan runs out. The transcript of a run can be compared against a
+// golden file.
+package webhooksim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPath is the webhook URL path requests are sent to.
+const DefaultPath = "/contactsWebhook"
+
+// Script is a conversation to replay.
+type Script struct {
+	// Lang and SessionID are used for shorthand turns.
+	Lang      string `json:"lang"`
+	SessionID string `json:"sessionId"`
+
+	// Seed names a file of data the caller loads before the run, relative
+	// to the script. The simulator itself doesn't read it.
+	Seed string `json:"seed"`
+
+	// Time is the clock the caller should use for the run, so responses
+	// mentioning it are repeatable.
+	Time time.Time `json:"time"`
+
+	Turns []Turn `json:"turns"`
+
+	// path the script was loaded from.
+	path string
+}
+
+// Turn is one webhook request.
+type Turn struct {
+	// Request is a file with a complete request, relative to the script.
+	// The contexts carried from earlier turns replace those of the same
+	// name in the file.
+	Request string `json:"request"`
+
+	// Shorthand for a request, used when Request is empty.
+	Intent     string            `json:"intent"`
+	Query      string            `json:"query"`
+	Parameters map[string]string `json:"parameters"`
+	// Google adds an Actions on Google originalRequest, with the surface
+	// Capabilities and input Arguments (e.g. OPTION for a list selection).
+	Google       bool              `json:"google"`
+	Capabilities []string          `json:"capabilities"`
+	Arguments    map[string]string `json:"arguments"`
+	// SlotFilling marks the request as made while the agent is still
+	// prompting for required parameters (webhookForSlotFillingUsed).
+	SlotFilling bool `json:"slotFilling"`
+}
+
+// Load reads a script file.
+func Load(path string) (*Script, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Script
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("webhooksim: %s: %v", path, err)
+	}
+	sc.path = path
+	return &sc, nil
+}
+
+// Path returns name relative to the directory of the script.
+func (sc *Script) Path(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(sc.path), name)
+}
+
+// GoldenPath returns the golden file of the script: its path with ".golden"
+// in place of the extension.
+func (sc *Script) GoldenPath() string {
+	return strings.TrimSuffix(sc.path, filepath.Ext(sc.path)) + ".golden"
+}
+
+// context is an API.AI context, as sent in result.contexts and returned in
+// contextOut.
+type context struct {
+	Name       string                 `json:"name"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Lifespan   int                    `json:"lifespan"`
+}
+
+// Simulator sends the turns of a script to Handler.
+type Simulator struct {
+	Handler http.Handler
+	// Path defaults to DefaultPath.
+	Path string
+	// Header is added to every request, e.g. for webhook authentication.
+	Header http.Header
+}
+
+// Run replays sc and returns its transcript. Responses other than 200 OK
+// are part of the transcript, not errors.
+func (s *Simulator) Run(sc *Script) ([]byte, error) {
+	var out bytes.Buffer
+	active := map[string]*context{}
+
+	for i, turn := range sc.Turns {
+		req, err := sc.request(turn)
+		if err != nil {
+			return nil, fmt.Errorf("webhooksim: turn %d: %v", i+1, err)
+		}
+		sent := withContexts(req, active)
+
+		body, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		path := s.Path
+		if path == "" {
+			path = DefaultPath
+		}
+		r := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, vs := range s.Header {
+			r.Header[k] = vs
+		}
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, r)
+
+		fmt.Fprintf(&out, "=== turn %d: %s\n", i+1, describe(turn))
+		if len(sent) > 0 {
+			fmt.Fprintf(&out, "--- contexts: %s\n", strings.Join(sent, ", "))
+		}
+		fmt.Fprintf(&out, "--- status %d\n", w.Code)
+		out.Write(format(w.Body.Bytes()))
+		out.WriteString("\n")
+
+		// Contexts live for lifespan requests, then the response sets or
+		// clears them.
+		for name, c := range active {
+			if c.Lifespan--; c.Lifespan <= 0 {
+				delete(active, name)
+			}
+		}
+		var resp struct {
+			ContextOut []context `json:"contextOut"`
+		}
+		if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &resp) == nil {
+			for _, c := range resp.ContextOut {
+				name := strings.ToLower(c.Name)
+				if c.Lifespan <= 0 {
+					delete(active, name)
+					continue
+				}
+				c := c
+				c.Name = name
+				active[name] = &c
+			}
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// request returns the webhook request for turn, as generic JSON.
+func (sc *Script) request(turn Turn) (map[string]interface{}, error) {
+	req := map[string]interface{}{}
+	if turn.Request != "" {
+		b, err := ioutil.ReadFile(sc.Path(turn.Request))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &req); err != nil {
+			return nil, fmt.Errorf("%s: %v", turn.Request, err)
+		}
+		return req, nil
+	}
+
+	if turn.Intent == "" {
+		return nil, fmt.Errorf("need a request file or an intent")
+	}
+	params := map[string]interface{}{}
+	for k, v := range turn.Parameters {
+		params[k] = v
+	}
+	lang := sc.Lang
+	if lang == "" {
+		lang = "en"
+	}
+	sessionID := sc.SessionID
+	if sessionID == "" {
+		sessionID = "webhooksim"
+	}
+	req["lang"] = lang
+	req["sessionId"] = sessionID
+	metadata := map[string]interface{}{
+		"intentName":  turn.Intent,
+		"webhookUsed": "true",
+	}
+	if turn.SlotFilling {
+		metadata["webhookForSlotFillingUsed"] = "true"
+	}
+	req["result"] = map[string]interface{}{
+		"resolvedQuery":    turn.Query,
+		"parameters":       params,
+		"metadata":         metadata,
+		"actionIncomplete": turn.SlotFilling,
+	}
+	req["status"] = map[string]interface{}{"code": 200, "errorType": "success"}
+
+	if turn.Google {
+		var caps []interface{}
+		for _, c := range turn.Capabilities {
+			caps = append(caps, map[string]interface{}{"name": c})
+		}
+		var args []interface{}
+		for _, name := range sortedKeys(turn.Arguments) {
+			args = append(args, map[string]interface{}{"name": name, "textValue": turn.Arguments[name]})
+		}
+		req["originalRequest"] = map[string]interface{}{
+			"source": "google",
+			"data": map[string]interface{}{
+				"inputs":  []interface{}{map[string]interface{}{"arguments": args}},
+				"surface": map[string]interface{}{"capabilities": caps},
+				"user":    map[string]interface{}{"locale": lang},
+			},
+		}
+	}
+	return req, nil
+}
+
+// withContexts puts the active contexts in req's result.contexts, replacing
+// those of the same name, and returns the names sent.
+func withContexts(req map[string]interface{}, active map[string]*context) []string {
+	if len(active) == 0 {
+		return nil
+	}
+	result, _ := req["result"].(map[string]interface{})
+	if result == nil {
+		result = map[string]interface{}{}
+		req["result"] = result
+	}
+
+	var contexts []interface{}
+	if old, ok := result["contexts"].([]interface{}); ok {
+		for _, c := range old {
+			m, _ := c.(map[string]interface{})
+			name, _ := m["name"].(string)
+			if _, replaced := active[strings.ToLower(name)]; !replaced {
+				contexts = append(contexts, c)
+			}
+		}
+	}
+	var names []string
+	for name := range active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		contexts = append(contexts, active[name])
+	}
+	result["contexts"] = contexts
+	return names
+}
+
+// describe summarizes a turn for the transcript.
+func describe(turn Turn) string {
+	if turn.Request != "" {
+		return turn.Request
+	}
+	s := turn.Intent
+	if turn.Query != "" {
+		s += fmt.Sprintf(" %q", turn.Query)
+	}
+	return s
+}
+
+// htmlEscapes undoes the HTML escaping of encoding/json, so SSML reads well
+// in transcripts. The result is still valid JSON.
+var htmlEscapes = strings.NewReplacer(`\u003c`, "<", `\u003e`, ">", `\u0026`, "&")
+
+// format indents JSON bodies, other bodies are kept as is.
+func format(body []byte) []byte {
+	var b bytes.Buffer
+	if err := json.Indent(&b, body, "", "  "); err != nil {
+		return append(bytes.TrimRight(body, "\n"), '\n')
+	}
+	return append([]byte(htmlEscapes.Replace(strings.TrimRight(b.String(), "\n"))), '\n')
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CompareGolden compares got with the golden file at path. With update, it
+// writes got to path instead.
+func CompareGolden(path string, got []byte, update bool) error {
+	if update {
+		return ioutil.WriteFile(path, got, 0644)
+	}
+	want, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("webhooksim: no golden file %s, run with -update to create it", path)
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(got, want) {
+		return nil
+	}
+
+	gotLines := strings.Split(string(got), "\n")
+	wantLines := strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			return fmt.Errorf("webhooksim: %s:%d differs\n got: %s\nwant: %s", path, i+1, g, w)
+		}
+	}
+	return fmt.Errorf("webhooksim: %s differs", path)
+}
@@ -0,0 +1,159 @@
+// 2017.09.02 rjj: Tests for the webhook conversation simulator.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package webhooksim
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// echoHandler answers with the intent and the contexts it received, and
+// sets the contexts named in the "set" parameter ("name:lifespan").
+func echoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Result struct {
+				Parameters map[string]string `json:"parameters"`
+				Contexts   []context         `json:"contexts"`
+				Metadata   struct {
+					IntentName string `json:"intentName"`
+				} `json:"metadata"`
+			} `json:"result"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range req.Result.Contexts {
+			got = append(got, c.Name+"="+c.Parameters["id"].(string))
+		}
+		resp := map[string]interface{}{"speech": req.Result.Metadata.IntentName + " " + strings.Join(got, ",")}
+		if set := req.Result.Parameters["set"]; set != "" {
+			var out []context
+			for _, s := range strings.Split(set, ",") {
+				parts := strings.SplitN(s, ":", 2)
+				lifespan := 0
+				if parts[1] != "0" {
+					lifespan = len(parts[1])
+				}
+				out = append(out, context{Name: parts[0], Parameters: map[string]interface{}{"id": req.Result.Metadata.IntentName}, Lifespan: lifespan})
+			}
+			resp["contextOut"] = out
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+func writeScript(t *testing.T, dir string, sc string) *Script {
+	path := filepath.Join(dir, "script.json")
+	if err := ioutil.WriteFile(path, []byte(sc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestContextsCarryForward(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooksim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Lifespans are given in unary ("11" is 2) to keep the handler simple.
+	sc := writeScript(t, dir, `{"turns": [
+		{"intent": "one", "parameters": {"set": "Short:1,long:111"}},
+		{"intent": "two"},
+		{"intent": "three", "parameters": {"set": "long:0"}},
+		{"intent": "four"}
+	]}`)
+
+	out, err := (&Simulator{Handler: echoHandler(t)}).Run(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"speech": "one "`,
+		`"speech": "two long=one,short=one"`,
+		`"speech": "three long=one"`,
+		`"speech": "four "`,
+		"--- contexts: long, short\n",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("transcript does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRequestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooksim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	req := `{"result": {"metadata": {"intentName": "from_file"},
+		"contexts": [{"name": "current_contact", "parameters": {"id": "file"}, "lifespan": 5}]}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "req.json"), []byte(req), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc := writeScript(t, dir, `{"turns": [
+		{"request": "req.json"},
+		{"intent": "set", "parameters": {"set": "current_contact:1"}},
+		{"request": "req.json"}
+	]}`)
+
+	out, err := (&Simulator{Handler: echoHandler(t)}).Run(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"=== turn 1: req.json\n",
+		`"speech": "from_file current_contact=file"`,
+		`"speech": "from_file current_contact=set"`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("transcript does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhooksim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "script.golden")
+
+	if err := CompareGolden(path, []byte("a\nb\n"), false); err == nil {
+		t.Error("missing golden file: want error")
+	}
+	if err := CompareGolden(path, []byte("a\nb\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompareGolden(path, []byte("a\nb\n"), false); err != nil {
+		t.Errorf("same transcript: %v", err)
+	}
+	err = CompareGolden(path, []byte("a\nc\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "script.golden:2 differs") {
+		t.Errorf("got %v, want a difference on line 2", err)
+	}
+}
+
+func TestGoldenPath(t *testing.T) {
+	sc := &Script{path: filepath.Join("testdata", "find_contact.json")}
+	if got, want := sc.GoldenPath(), filepath.Join("testdata", "find_contact.golden"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}